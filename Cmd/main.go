@@ -1,39 +1,126 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"UbicaBus/UbicaBusBackend/application"
+	"UbicaBus/UbicaBusBackend/domain"
+	"UbicaBus/UbicaBusBackend/infrastructure/app"
+	"UbicaBus/UbicaBusBackend/infrastructure/config"
 	"UbicaBus/UbicaBusBackend/infrastructure/delivery"
 	"UbicaBus/UbicaBusBackend/infrastructure/persistence"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "ruta a un archivo de configuración YAML (opcional)")
+	flag.Parse()
+
+	// Carga la configuración desde el archivo (si se indica) y las variables de entorno
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Configuración inválida: %v", err)
+	}
+
 	log.Print("El servidor está corriendo!")
 
 	// Inicializa la conexión a la base de datos
-	client, err := persistence.InitDB()
+	client, err := persistence.InitDB(cfg.Mongo)
 	if err != nil {
 		log.Fatalf("Error al conectar a la base de datos: %v", err)
 	}
 
-	// Selecciona la base de datos 'Development'
-	db := client.Database("Development")
+	// Selecciona la base de datos configurada
+	db := client.Database(cfg.Mongo.Database)
+
+	// Clave para firmar los access tokens; Validate exige que esté definida.
+	jwtSecret := []byte(cfg.Auth.JWTSecret)
 
-	// Crear el servicio de usuario
-	userService := application.NewUserService(db)
+	// Algoritmo para las nuevas contraseñas (argon2id por defecto o bcrypt).
+	// Los hashes antiguos se migran al algoritmo actual en el siguiente login.
+	hasher, err := domain.NewPasswordHasher(cfg.Auth.PasswordHashAlgo)
+	if err != nil {
+		log.Fatalf("Error en la configuración de contraseñas: %v", err)
+	}
 
-	// Crear el servicio de rutas
-	routeService := application.NewRouteService(db)
+	// Las eliminaciones en cascada y otras operaciones de varios documentos
+	// usan transacciones, que un servidor standalone no admite
+	txOK, err := persistence.SupportsTransactions(context.Background(), client)
+	if err != nil {
+		log.Fatalf("Error al consultar el tipo de servidor MongoDB: %v", err)
+	}
+	if !txOK {
+		if !cfg.Mongo.AllowStandalone {
+			log.Fatal("MongoDB no admite transacciones (¿servidor standalone?): usa un replica set o define MONGO_ALLOW_STANDALONE=true")
+		}
+		log.Print("ADVERTENCIA: MongoDB no admite transacciones; las operaciones de varios documentos se ejecutarán sin atomicidad")
+	}
 
-	companyService := application.NewCompanyService(db)
+	// Convierte los documentos con formatos anteriores (p. ej. ubicaciones
+	// {lat, lng} a GeoJSON) antes de crear los índices que dependen de ellos
+	if err := persistence.Migrate(context.Background(), db); err != nil {
+		log.Fatalf("Error al migrar la base de datos: %v", err)
+	}
 
-	roleService := application.NewRoleService(db)
+	// Índices de los listados paginados y de las consultas geoespaciales
+	if err := persistence.EnsureIndexes(context.Background(), db); err != nil {
+		log.Fatalf("Error al crear los índices: %v", err)
+	}
 
-	busService := application.NewBusService(db)
+	// Índices TTL que eliminan el historial según la retención configurada
+	if err := persistence.EnsureRetention(context.Background(), db, cfg.Retention.Raw, cfg.Retention.Rollups); err != nil {
+		log.Fatalf("Error al configurar la retención: %v", err)
+	}
 
-	busLocation := application.NewBusLocationService(db)
+	// Repositorios de MongoDB para cada entidad
+	repos := persistence.NewRepositories(db, cfg.Mongo.AllowStandalone)
 
-	// Iniciar servidor con los servicios de usuario y rutas
-	delivery.StartServer(userService, routeService, companyService, roleService, busService, busLocation)
+	svc := delivery.NewServices(repos, hasher, jwtSecret, cfg.Auth.AccessTokenTTL, cfg.Auth.RefreshTokenTTL, cfg.Retention, cfg.Heartbeat, cfg.Commands)
+
+	// Crea el super-admin configurado si no existe, para poder administrar una
+	// base de datos sin usuarios o con roles anteriores sin permisos
+	if cfg.Bootstrap.AdminUser != "" {
+		created, err := svc.Users.BootstrapAdmin(context.Background(), cfg.Bootstrap.AdminUser, cfg.Bootstrap.AdminPassword)
+		if err != nil {
+			log.Fatalf("Error al crear el usuario super-admin: %v", err)
+		}
+		if created {
+			log.Printf("Usuario super-admin %q creado", cfg.Bootstrap.AdminUser)
+		}
+	}
+
+	// Carga en memoria la última posición de cada bus para /fleet/live
+	if err := svc.Fleet.Rebuild(context.Background()); err != nil {
+		log.Fatalf("Error al cargar la flota en vivo: %v", err)
+	}
+
+	// Carga el último estado de conectividad de cada bus para /buses/:id/status
+	if err := svc.Connectivity.Restore(context.Background()); err != nil {
+		log.Fatalf("Error al cargar la conectividad de los buses: %v", err)
+	}
+
+	// Crea el API HTTP, el broker MQTT y el hub de WebSockets
+	server, err := app.New(cfg, svc)
+	if err != nil {
+		_ = persistence.CloseDB(context.Background())
+		log.Fatalf("Error al iniciar la aplicación: %v", err)
+	}
+
+	server.AddReadinessCheck("mongo", func(ctx context.Context) (map[string]any, error) {
+		return nil, client.Ping(ctx, readpref.Primary())
+	})
+	server.OnShutdown(persistence.CloseDB)
+
+	// SIGINT/SIGTERM (p. ej. un redeploy en Koyeb) inician el apagado ordenado
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Run(ctx); err != nil {
+		log.Fatalf("Error en la aplicación: %v", err)
+	}
 }
@@ -0,0 +1,265 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// DefaultAccessTokenTTL es la vigencia por defecto de los access tokens.
+	DefaultAccessTokenTTL = 15 * time.Minute
+	// DefaultRefreshTokenTTL es la vigencia por defecto de los refresh tokens.
+	DefaultRefreshTokenTTL = 7 * 24 * time.Hour
+
+	tokenIssuer = "ubicabus"
+)
+
+// AccessClaims son los datos que viajan firmados dentro del access token.
+type AccessClaims struct {
+	UserID     string `json:"uid"`
+	RolID      string `json:"rol"`
+	CompaniaID string `json:"compania"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair es la respuesta de login y refresh.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// AuthService maneja la autenticación de usuarios y la emisión de tokens.
+type AuthService struct {
+	Users      domain.UserRepository
+	Roles      domain.RoleRepository
+	Tokens     domain.RefreshTokenRepository
+	Hasher     domain.PasswordHasher
+	Secret     []byte
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// NewAuthService crea una nueva instancia de AuthService.
+// Si algún TTL es cero se usan los valores por defecto.
+func NewAuthService(
+	users domain.UserRepository,
+	roles domain.RoleRepository,
+	tokens domain.RefreshTokenRepository,
+	hasher domain.PasswordHasher,
+	secret []byte,
+	accessTTL, refreshTTL time.Duration,
+) *AuthService {
+	if accessTTL <= 0 {
+		accessTTL = DefaultAccessTokenTTL
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTokenTTL
+	}
+	return &AuthService{Users: users, Roles: roles, Tokens: tokens, Hasher: hasher, Secret: secret, AccessTTL: accessTTL, RefreshTTL: refreshTTL}
+}
+
+// Login valida nombre y contraseña y emite un par de tokens.
+// Si el hash almacenado usa un algoritmo o parámetros antiguos se regenera
+// con el algoritmo actual aprovechando que se conoce la contraseña.
+func (s *AuthService) Login(nombre, password string) (*TokenPair, error) {
+	if nombre == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	ctx := context.TODO()
+
+	user, err := s.Users.GetByNombre(ctx, nombre)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			// Se calcula un hash igualmente para no revelar por tiempo de
+			// respuesta si el usuario existe.
+			_, _ = s.Hasher.Hash(password)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	ok, needsRehash, err := s.Hasher.Verify(password, user.Password)
+	if err != nil {
+		log.Printf("Error al verificar la contraseña del usuario %s: %v", user.ID.Hex(), err)
+		return nil, ErrInvalidCredentials
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		if hashed, err := s.Hasher.Hash(password); err != nil {
+			log.Printf("Error al regenerar el hash del usuario %s: %v", user.ID.Hex(), err)
+		} else if err := s.Users.UpdatePassword(ctx, user.ID, hashed); err != nil {
+			log.Printf("Error al guardar el nuevo hash del usuario %s: %v", user.ID.Hex(), err)
+		}
+	}
+
+	return s.issueTokens(ctx, user)
+}
+
+// Refresh rota un refresh token: revoca el recibido y emite un par nuevo.
+// Si se presenta un token ya revocado se asume que fue robado y se revocan
+// todos los tokens del usuario.
+func (s *AuthService) Refresh(refreshToken string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidToken
+	}
+	ctx := context.TODO()
+	hash := hashRefreshToken(refreshToken)
+
+	// El ID del token sucesor se reserva antes para enlazarlo desde el revocado.
+	nextID := primitive.NewObjectID()
+	old, err := s.Tokens.Revoke(ctx, hash, nextID)
+	if err != nil {
+		if !errors.Is(err, domain.ErrNotFound) {
+			return nil, err
+		}
+		// Token inexistente, expirado o reutilizado.
+		if prev, findErr := s.Tokens.GetByHash(ctx, hash); findErr == nil && prev.RevokedAt != nil {
+			_ = s.Tokens.RevokeAllForUser(ctx, prev.UserID)
+		}
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.Users.GetByID(ctx, old.UserID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	return s.issueTokensWithID(ctx, user, nextID)
+}
+
+// Logout revoca el refresh token recibido.
+func (s *AuthService) Logout(refreshToken string) error {
+	if refreshToken == "" {
+		return ErrInvalidToken
+	}
+	if _, err := s.Tokens.Revoke(context.TODO(), hashRefreshToken(refreshToken), primitive.NilObjectID); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return ErrInvalidToken
+		}
+		return err
+	}
+	return nil
+}
+
+// ParseAccessToken valida la firma y vigencia de un access token y retorna sus claims.
+func (s *AuthService) ParseAccessToken(token string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return s.Secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !parsed.Valid {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// UserForClaims carga el usuario autenticado y su rol vigentes.
+// Ambos se leen en cada petición para que borrar al usuario o cambiarle el
+// rol o la compañía aplique sin esperar a que expire el access token.
+// Retorna ErrInvalidToken si el usuario ya no existe y ErrForbidden si su rol
+// no existe.
+func (s *AuthService) UserForClaims(claims *AccessClaims) (*domain.User, *domain.Role, error) {
+	ctx := context.TODO()
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+	user, err := s.Users.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, err
+	}
+	role, err := s.Roles.GetByID(ctx, user.RolID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, nil, ErrForbidden
+		}
+		return nil, nil, err
+	}
+	return user, role, nil
+}
+
+func (s *AuthService) issueTokens(ctx context.Context, user *domain.User) (*TokenPair, error) {
+	return s.issueTokensWithID(ctx, user, primitive.NewObjectID())
+}
+
+func (s *AuthService) issueTokensWithID(ctx context.Context, user *domain.User, refreshID primitive.ObjectID) (*TokenPair, error) {
+	now := time.Now()
+
+	claims := AccessClaims{
+		UserID:     user.ID.Hex(),
+		RolID:      user.RolID.Hex(),
+		CompaniaID: user.Compania.Hex(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   user.ID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.AccessTTL)),
+		},
+	}
+	access, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := newRefreshTokenValue()
+	if err != nil {
+		return nil, err
+	}
+	rt := &domain.RefreshToken{
+		ID:        refreshID,
+		UserID:    user.ID,
+		TokenHash: hashRefreshToken(refresh),
+		ExpiresAt: now.Add(s.RefreshTTL),
+	}
+	if err := s.Tokens.Create(ctx, rt); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.AccessTTL.Seconds()),
+	}, nil
+}
+
+// newRefreshTokenValue genera un valor aleatorio opaco de 256 bits.
+func newRefreshTokenValue() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashRefreshToken calcula el hash con el que se guarda un refresh token.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
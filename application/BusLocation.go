@@ -3,80 +3,280 @@ package application
 import (
 	"context"
 	"errors"
+	"sort"
+	"time"
 
 	"UbicaBus/UbicaBusBackend/domain"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Límites de las consultas de historial de localizaciones.
+const (
+	DefaultHistoryWindow = 24 * time.Hour
+	DefaultHistoryPoints = 1000
+	MaxHistoryPoints     = 10000
+	MaxAreaWindow        = 24 * time.Hour
+)
+
+// Radio en metros de la búsqueda de buses cercanos.
+const (
+	DefaultNearbyRadius = 1000.0
+	MaxNearbyRadius     = 50000.0
 )
 
 // BusLocationService maneja la lógica de negocio relacionada con las localizaciones de buses.
-// Trabaja directamente con las funciones de dominio para crear, obtener y eliminar localizaciones.
 type BusLocationService struct {
-	DB *mongo.Database
+	Locations domain.BusLocationRepository
+	Rollups   domain.BusLocationRollupRepository
+	Buses     domain.BusRepository
+	Fleet     *FleetService
+
+	// RawRetention es cuánto se conservan las localizaciones originales; el
+	// historial anterior se lee de los resúmenes por minuto. Cero indica que
+	// se conservan siempre.
+	RawRetention time.Duration
+	// NearbyMaxAge es la antigüedad máxima de la última posición de un bus
+	// para que NearbyBuses lo tenga en cuenta. Cero indica sin límite.
+	NearbyMaxAge time.Duration
 }
 
-// NewBusLocationService crea una nueva instancia de BusLocationService
-func NewBusLocationService(db *mongo.Database) *BusLocationService {
-	return &BusLocationService{DB: db}
+// NewBusLocationService crea una nueva instancia de BusLocationService. Cada
+// localización registrada actualiza también la flota en vivo.
+func NewBusLocationService(
+	locations domain.BusLocationRepository,
+	rollups domain.BusLocationRollupRepository,
+	buses domain.BusRepository,
+	fleet *FleetService,
+	rawRetention, nearbyMaxAge time.Duration,
+) *BusLocationService {
+	return &BusLocationService{
+		Locations: locations, Rollups: rollups, Buses: buses, Fleet: fleet,
+		RawRetention: rawRetention, NearbyMaxAge: nearbyMaxAge,
+	}
 }
 
-// GetAllBusLocations obtiene todas las localizaciones de buses de la base de datos.
-func (s *BusLocationService) GetAllBusLocations() ([]domain.BusLocation, error) {
-	return domain.GetAllBusLocations(context.TODO(), s.DB)
+// GetAllBusLocations obtiene una página de las localizaciones de buses visibles en el Scope.
+func (s *BusLocationService) GetAllBusLocations(scope domain.Scope, p ListParams) (*domain.Page[domain.BusLocation], error) {
+	q, err := p.query(domain.BusLocationFields)
+	if err != nil {
+		return nil, err
+	}
+	return s.Locations.List(context.TODO(), scope, q)
 }
 
-// GetBusLocationsByBusID obtiene todas las localizaciones asociadas a un bus.
-func (s *BusLocationService) GetBusLocationsByBusID(busIDHex string) ([]domain.BusLocation, error) {
+// GetBusLocationHistory retorna el recorrido de un bus del Scope entre from y
+// to en orden cronológico, reducido a como mucho maxPoints puntos. Los valores
+// cero toman los valores por defecto: to es el momento actual, from es
+// DefaultHistoryWindow antes de to y maxPoints es DefaultHistoryPoints.
+// Los tramos anteriores a RawRetention se leen de los resúmenes por minuto.
+func (s *BusLocationService) GetBusLocationHistory(scope domain.Scope, busIDHex string, from, to time.Time, maxPoints int) (*domain.LocationHistory, error) {
 	if busIDHex == "" {
-		return nil, errors.New("busID es obligatorio")
+		return nil, domain.NewValidationError("busID es obligatorio")
 	}
 	busID, err := primitive.ObjectIDFromHex(busIDHex)
 	if err != nil {
-		return nil, errors.New("busID inválido")
+		return nil, domain.NewValidationError("busID inválido")
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-DefaultHistoryWindow)
+	}
+	if !from.Before(to) {
+		return nil, ErrInvalidTimeRange
+	}
+	if maxPoints == 0 {
+		maxPoints = DefaultHistoryPoints
+	}
+	if maxPoints < 2 || maxPoints > MaxHistoryPoints {
+		return nil, ErrInvalidMaxPoints.WithDetails(map[string]any{"min": 2, "max": MaxHistoryPoints})
+	}
+
+	ctx := context.TODO()
+	if _, err := s.Buses.GetByID(ctx, scope, busID); err != nil {
+		return nil, notFoundAs(err, ErrBusNotFound)
+	}
+	return s.history(ctx, scope, busID, from, to, maxPoints)
+}
+
+// history lee el recorrido de las localizaciones originales, de los
+// resúmenes o de ambos si el intervalo cruza el límite de retención.
+func (s *BusLocationService) history(ctx context.Context, scope domain.Scope, busID primitive.ObjectID, from, to time.Time, maxPoints int) (*domain.LocationHistory, error) {
+	if s.Rollups == nil || s.RawRetention <= 0 {
+		return s.Locations.History(ctx, scope, busID, from, to, maxPoints)
+	}
+	cutoff := time.Now().Add(-s.RawRetention)
+	if !from.Before(cutoff) {
+		return s.Locations.History(ctx, scope, busID, from, to, maxPoints)
+	}
+	if !to.After(cutoff) {
+		return s.Rollups.History(ctx, scope, busID, from, to, maxPoints)
+	}
+
+	old, err := s.Rollups.History(ctx, scope, busID, from, cutoff.Add(-time.Nanosecond), maxPoints)
+	if err != nil {
+		return nil, err
+	}
+	recent, err := s.Locations.History(ctx, scope, busID, cutoff, to, maxPoints)
+	if err != nil {
+		return nil, err
+	}
+	sampler := domain.NewSampler[domain.BusLocation](int64(len(old.Points)+len(recent.Points)), maxPoints)
+	for _, bl := range old.Points {
+		sampler.Add(bl)
+	}
+	for _, bl := range recent.Points {
+		sampler.Add(bl)
+	}
+	return &domain.LocationHistory{BusID: busID, From: from, To: to, Total: old.Total + recent.Total, Points: sampler.Points()}, nil
+}
+
+// GetBusLocationsInArea obtiene una página, en orden cronológico, de las
+// localizaciones de todos los buses del Scope registradas dentro de box entre
+// from y to. El intervalo no puede superar MaxAreaWindow.
+func (s *BusLocationService) GetBusLocationsInArea(scope domain.Scope, box domain.BoundingBox, from, to time.Time, p ListParams) (*domain.Page[domain.BusLocation], error) {
+	if !box.Valid() {
+		return nil, ErrInvalidBoundingBox
+	}
+	if !from.Before(to) || to.Sub(from) > MaxAreaWindow {
+		return nil, ErrInvalidTimeRange.WithDetails(map[string]any{"max_horas": MaxAreaWindow.Hours()})
+	}
+
+	p.Sort = "created_at"
+	q, err := p.query(domain.BusLocationFields)
+	if err != nil {
+		return nil, err
+	}
+	q.Filters = append(q.Filters,
+		domain.Filter{Field: "created_at", Op: domain.OpGte, Value: from},
+		domain.Filter{Field: "created_at", Op: domain.OpLte, Value: to},
+		domain.Filter{Field: "localizacion", Op: domain.OpWithin, Value: box},
+	)
+	return s.Locations.List(context.TODO(), scope, q)
+}
+
+// NearbyBuses retorna los buses del Scope cuya última localización está a
+// menos de radius metros del punto (lat, lng), del más cercano al más lejano.
+// Un radio cero toma DefaultNearbyRadius. Las posiciones se toman de la flota
+// en vivo, descartando las más antiguas que NearbyMaxAge, y los buses se
+// cargan en una sola consulta.
+func (s *BusLocationService) NearbyBuses(scope domain.Scope, lat, lng, radius float64) ([]domain.NearbyBus, error) {
+	center := domain.Location{Lat: lat, Lng: lng}
+	if !center.Valid() {
+		return nil, ErrInvalidCoordinates
+	}
+	if radius == 0 {
+		radius = DefaultNearbyRadius
+	}
+	if radius < 0 || radius > MaxNearbyRadius {
+		return nil, ErrInvalidRadius.WithDetails(map[string]any{"max": MaxNearbyRadius})
+	}
+
+	nearby := make([]domain.NearbyBus, 0)
+	var ids []primitive.ObjectID
+	now := time.Now()
+	for _, p := range s.Fleet.LiveFleet(scope) {
+		if s.NearbyMaxAge > 0 && now.Sub(p.At) > s.NearbyMaxAge {
+			continue
+		}
+		if d := center.DistanceTo(p.Localizacion); d <= radius {
+			nearby = append(nearby, domain.NearbyBus{Location: p.BusLocation(), Distance: d})
+			ids = append(ids, p.BusID)
+		}
+	}
+	if len(nearby) == 0 {
+		return nearby, nil
+	}
+
+	buses, err := s.Buses.GetByIDs(context.TODO(), scope, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]domain.Bus, len(buses))
+	for _, b := range buses {
+		byID[b.ID] = b
 	}
-	return domain.GetBusLocationsByBusID(context.TODO(), s.DB, busID)
+	found := nearby[:0]
+	for _, n := range nearby {
+		// Se omiten los buses que ya no son visibles en el Scope.
+		if bus, ok := byID[n.Location.BusID]; ok {
+			n.Bus = bus
+			found = append(found, n)
+		}
+	}
+	nearby = found
+	sort.Slice(nearby, func(i, j int) bool { return nearby[i].Distance < nearby[j].Distance })
+	return nearby, nil
 }
 
-// RegisterBusLocation crea una nueva localización para un bus.
+// RegisterBusLocation crea una nueva localización para un bus del Scope.
+// La localización hereda la compañía del bus. La velocidad (km/h) y el rumbo
+// (grados) son opcionales.
 func (s *BusLocationService) RegisterBusLocation(
+	scope domain.Scope,
 	busIDHex string,
 	lat, lng float64,
+	velocidad, rumbo *float64,
 ) (primitive.ObjectID, error) {
-	// Validaciones básicas
-	if busIDHex == "" {
-		return primitive.NilObjectID, errors.New("busID es obligatorio")
-	}
-	busID, err := primitive.ObjectIDFromHex(busIDHex)
+	bl, err := newBusLocation(busIDHex, lat, lng, velocidad, rumbo)
 	if err != nil {
-		return primitive.NilObjectID, errors.New("busID inválido")
+		return primitive.NilObjectID, err
 	}
 
-	// Construir la entidad de dominio
-	bl := &domain.BusLocation{
-		BusID: busID,
-		Localizacion: domain.Location{
-			Lat: lat,
-			Lng: lng,
-		},
+	ctx := context.TODO()
+
+	// Verificar que el bus exista dentro del Scope
+	bus, err := s.Buses.GetByID(ctx, scope, bl.BusID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return primitive.NilObjectID, ErrUnknownBus
+		}
+		return primitive.NilObjectID, err
 	}
+	bl.CompaniaID = bus.CompaniaID
 
-	// Llamar a la función de dominio para insertar
-	if err := domain.CrearBusLocation(context.TODO(), s.DB, bl); err != nil {
+	// Insertar en la base de datos
+	if err := s.Locations.Create(ctx, bl); err != nil {
 		return primitive.NilObjectID, err
 	}
+	s.Fleet.Update(*bl)
 
 	return bl.ID, nil
 }
 
+// newBusLocation valida los datos de una posición y construye la
+// localización, todavía sin compañía ni metadatos.
+func newBusLocation(busIDHex string, lat, lng float64, velocidad, rumbo *float64) (*domain.BusLocation, error) {
+	if busIDHex == "" {
+		return nil, domain.NewValidationError("busID es obligatorio")
+	}
+	busID, err := primitive.ObjectIDFromHex(busIDHex)
+	if err != nil {
+		return nil, domain.NewValidationError("busID inválido")
+	}
+	loc := domain.Location{Lat: lat, Lng: lng}
+	if !loc.Valid() {
+		return nil, ErrInvalidCoordinates
+	}
+	if velocidad != nil && *velocidad < 0 {
+		return nil, domain.NewValidationError("la velocidad no puede ser negativa")
+	}
+	if rumbo != nil && (*rumbo < 0 || *rumbo >= 360) {
+		return nil, domain.NewValidationError("el rumbo debe estar entre 0 y 360 grados")
+	}
+	return &domain.BusLocation{BusID: busID, Localizacion: loc, Velocidad: velocidad, Rumbo: rumbo}, nil
+}
+
 // DeleteBusLocation elimina una localización por su ID.
-func (s *BusLocationService) DeleteBusLocation(idHex string) error {
+func (s *BusLocationService) DeleteBusLocation(scope domain.Scope, idHex string) error {
 	if idHex == "" {
-		return errors.New("ID de localización es obligatorio")
+		return domain.NewValidationError("ID de localización es obligatorio")
 	}
 	id, err := primitive.ObjectIDFromHex(idHex)
 	if err != nil {
-		return errors.New("ID de localización inválido")
+		return domain.NewValidationError("ID de localización inválido")
 	}
-	return domain.DeleteBusLocation(context.TODO(), s.DB, id)
+	return notFoundAs(s.Locations.Delete(context.TODO(), scope, id), ErrBusLocationNotFound)
 }
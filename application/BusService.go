@@ -8,59 +8,91 @@ import (
 	"UbicaBus/UbicaBusBackend/domain"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // BusService maneja la lógica de negocio relacionada con los buses.
 type BusService struct {
-	DB *mongo.Database
+	Buses    domain.BusRepository
+	Devices  domain.DeviceRepository
+	Commands domain.CommandRepository
+	Tx       domain.Transactor
+	Refs     *References
+	Fleet    *FleetService
 }
 
-// NewBusService crea una nueva instancia de BusService.
-func NewBusService(db *mongo.Database) *BusService {
-	return &BusService{DB: db}
+// NewBusService crea una nueva instancia de BusService. Los dispositivos y
+// comandos se usan al eliminar un bus o cambiarlo de compañía; al eliminarlo
+// también se retira de la flota en vivo.
+func NewBusService(
+	buses domain.BusRepository,
+	devices domain.DeviceRepository,
+	commands domain.CommandRepository,
+	tx domain.Transactor,
+	refs *References,
+	fleet *FleetService,
+) *BusService {
+	return &BusService{Buses: buses, Devices: devices, Commands: commands, Tx: tx, Refs: refs, Fleet: fleet}
 }
 
-// GetAllBuses obtiene todos los buses.
-func (s *BusService) GetAllBuses() ([]domain.Bus, error) {
-	return domain.GetAllBuses(context.TODO(), s.DB)
+// GetAllBuses obtiene una página de los buses visibles en el Scope.
+func (s *BusService) GetAllBuses(scope domain.Scope, p ListParams) (*domain.Page[domain.Bus], error) {
+	q, err := p.query(domain.BusFields)
+	if err != nil {
+		return nil, err
+	}
+	return s.Buses.List(context.TODO(), scope, q)
 }
 
 // GetBusByID retorna un bus por su ID.
-func (s *BusService) GetBusByID(idHex string) (*domain.Bus, error) {
+func (s *BusService) GetBusByID(scope domain.Scope, idHex string) (*domain.Bus, error) {
 	if idHex == "" {
-		return nil, errors.New("ID de bus es obligatorio")
+		return nil, domain.NewValidationError("ID de bus es obligatorio")
 	}
 	id, err := primitive.ObjectIDFromHex(idHex)
 	if err != nil {
-		return nil, errors.New("ID de bus inválido")
+		return nil, domain.NewValidationError("ID de bus inválido")
 	}
-	return domain.GetBusByID(context.TODO(), s.DB, id)
+	bus, err := s.Buses.GetByID(context.TODO(), scope, id)
+	return bus, notFoundAs(err, ErrBusNotFound)
 }
 
 // SearchBusesByPlaca busca buses por placa exacta.
-func (s *BusService) SearchBusesByPlaca(placa string) ([]domain.Bus, error) {
+func (s *BusService) SearchBusesByPlaca(scope domain.Scope, placa string) ([]domain.Bus, error) {
 	if placa == "" {
-		return nil, errors.New("la placa es obligatoria")
+		return nil, domain.NewValidationError("la placa es obligatoria")
 	}
-	return domain.GetBusesByPlaca(context.TODO(), s.DB, placa)
+	return s.Buses.GetByPlaca(context.TODO(), scope, placa)
 }
 
-// RegisterBus crea un nuevo bus.
+// RegisterBus crea un nuevo bus en la compañía indicada (o en la del Scope).
+// El conductor y la ruta deben existir y pertenecer a la misma compañía, y el
+// rol del conductor debe conceder domain.PermBusesDrive.
 func (s *BusService) RegisterBus(
-	placa, conductorIDHex, rutaIDHex string,
+	scope domain.Scope,
+	placa, conductorIDHex, rutaIDHex, companiaIDHex string,
 	fechaInicio, fechaFin time.Time,
 ) (primitive.ObjectID, error) {
 	if placa == "" || conductorIDHex == "" || rutaIDHex == "" {
-		return primitive.NilObjectID, errors.New("placa, conductor y ruta son obligatorios")
+		return primitive.NilObjectID, domain.NewValidationError("placa, conductor y ruta son obligatorios")
 	}
 	condID, err := primitive.ObjectIDFromHex(conductorIDHex)
 	if err != nil {
-		return primitive.NilObjectID, errors.New("ID de conductor inválido")
+		return primitive.NilObjectID, domain.NewValidationError("ID de conductor inválido")
 	}
 	rutaID, err := primitive.ObjectIDFromHex(rutaIDHex)
 	if err != nil {
-		return primitive.NilObjectID, errors.New("ID de ruta inválido")
+		return primitive.NilObjectID, domain.NewValidationError("ID de ruta inválido")
+	}
+	ctx := context.TODO()
+	companiaID, err := s.Refs.Compania(ctx, scope, companiaIDHex)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if err := s.Refs.Driver(ctx, companiaID, condID); err != nil {
+		return primitive.NilObjectID, err
+	}
+	if err := s.Refs.Route(ctx, companiaID, rutaID); err != nil {
+		return primitive.NilObjectID, err
 	}
 	bus := domain.Bus{
 		Placa:       placa,
@@ -68,24 +100,29 @@ func (s *BusService) RegisterBus(
 		RutaID:      rutaID,
 		FechaInicio: fechaInicio,
 		FechaFin:    fechaFin,
+		CompaniaID:  companiaID,
 	}
-	if err := domain.CrearBus(context.TODO(), s.DB, &bus); err != nil {
+	if err := s.Buses.Create(ctx, &bus); err != nil {
 		return primitive.NilObjectID, err
 	}
 	return bus.ID, nil
 }
 
-// EditBus actualiza un bus existente.
+// EditBus actualiza un bus existente dentro del Scope. Si cambian el
+// conductor, la ruta o la compañía se validan de nuevo las referencias del bus
+// resultante. Un bus con dispositivos activos no se puede cambiar de compañía
+// (ErrBusHasDevices): sus credenciales MQTT seguirían publicando en la otra.
 func (s *BusService) EditBus(
-	idHex, placa, conductorIDHex, rutaIDHex string,
+	scope domain.Scope,
+	idHex, placa, conductorIDHex, rutaIDHex, companiaIDHex string,
 	fechaInicio, fechaFin *time.Time,
 ) (*domain.Bus, error) {
 	if idHex == "" {
-		return nil, errors.New("ID de bus es obligatorio")
+		return nil, domain.NewValidationError("ID de bus es obligatorio")
 	}
 	id, err := primitive.ObjectIDFromHex(idHex)
 	if err != nil {
-		return nil, errors.New("ID de bus inválido")
+		return nil, domain.NewValidationError("ID de bus inválido")
 	}
 	b := &domain.Bus{ID: id}
 	if placa != "" {
@@ -95,14 +132,14 @@ func (s *BusService) EditBus(
 		if cid, err := primitive.ObjectIDFromHex(conductorIDHex); err == nil {
 			b.ConductorID = cid
 		} else {
-			return nil, errors.New("ID de conductor inválido")
+			return nil, domain.NewValidationError("ID de conductor inválido")
 		}
 	}
 	if rutaIDHex != "" {
 		if rid, err := primitive.ObjectIDFromHex(rutaIDHex); err == nil {
 			b.RutaID = rid
 		} else {
-			return nil, errors.New("ID de ruta inválido")
+			return nil, domain.NewValidationError("ID de ruta inválido")
 		}
 	}
 	if fechaInicio != nil {
@@ -111,17 +148,132 @@ func (s *BusService) EditBus(
 	if fechaFin != nil {
 		b.FechaFin = *fechaFin
 	}
-	return domain.EditarBus(context.TODO(), s.DB, b)
+	ctx := context.TODO()
+	if companiaIDHex != "" {
+		companiaID, err := s.Refs.Compania(ctx, scope, companiaIDHex)
+		if err != nil {
+			return nil, err
+		}
+		b.CompaniaID = companiaID
+	}
+	var updated *domain.Bus
+	err = s.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.checkReferences(ctx, scope, b); err != nil {
+			return err
+		}
+		updated, err = s.Buses.Update(ctx, scope, b)
+		return notFoundAs(err, ErrBusNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
 }
 
-// DeleteBus elimina un bus por su ID.
-func (s *BusService) DeleteBus(idHex string) error {
+// DeleteBus elimina un bus por su ID dentro del Scope y vence sus comandos
+// abiertos. Si el bus tiene dispositivos activos retorna ErrBusHasDevices.
+func (s *BusService) DeleteBus(scope domain.Scope, idHex string) error {
 	if idHex == "" {
-		return errors.New("ID de bus es obligatorio")
+		return domain.NewValidationError("ID de bus es obligatorio")
 	}
 	id, err := primitive.ObjectIDFromHex(idHex)
 	if err != nil {
-		return errors.New("ID de bus inválido")
+		return domain.NewValidationError("ID de bus inválido")
+	}
+	err = s.Tx.WithTransaction(context.TODO(), func(ctx context.Context) error {
+		if _, err := s.Buses.GetByID(ctx, scope, id); err != nil {
+			return notFoundAs(err, ErrBusNotFound)
+		}
+		if err := s.checkNoDevices(ctx, id); err != nil {
+			return err
+		}
+		if err := s.Buses.Delete(ctx, scope, id); err != nil {
+			return notFoundAs(err, ErrBusNotFound)
+		}
+		return s.expireCommands(ctx, id)
+	})
+	if err != nil {
+		return err
+	}
+	s.Fleet.Remove(id)
+	return nil
+}
+
+// checkNoDevices retorna ErrBusHasDevices si el bus tiene dispositivos
+// activos. Los dados de baja ya no se pueden conectar y no cuentan.
+func (s *BusService) checkNoDevices(ctx context.Context, busID primitive.ObjectID) error {
+	devices, err := s.Devices.List(ctx, domain.GlobalScope(), domain.ListQuery{
+		Filters: []domain.Filter{
+			{Field: "bus_id", Op: domain.OpEq, Value: busID},
+			{Field: "estado", Op: domain.OpEq, Value: domain.DeviceActive},
+		},
+		Limit: 1,
+	})
+	if err != nil {
+		return err
+	}
+	if *devices.Total > 0 {
+		return ErrBusHasDevices.WithDetails(map[string]any{"dispositivos": *devices.Total})
+	}
+	return nil
+}
+
+// expireCommands marca como vencidos los comandos abiertos del bus, que ya no
+// tienen a quién entregarse.
+func (s *BusService) expireCommands(ctx context.Context, busID primitive.ObjectID) error {
+	cmds, err := s.Commands.Open(ctx, busID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, cmd := range cmds {
+		_, err := s.Commands.Resolve(ctx, busID, cmd.ID, domain.CommandExpired, "el bus fue eliminado", now)
+		// Los que ya vencieron los marca la revisión periódica.
+		if err != nil && !errors.Is(err, domain.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkReferences valida el conductor y la ruta que tendrá el bus tras aplicar
+// los cambios de b y, si cambia de compañía, que no tenga dispositivos
+// activos. Los campos vacíos de b conservan el valor actual.
+func (s *BusService) checkReferences(ctx context.Context, scope domain.Scope, b *domain.Bus) error {
+	if b.ConductorID.IsZero() && b.RutaID.IsZero() && b.CompaniaID.IsZero() {
+		return nil
+	}
+	current, err := s.Buses.GetByID(ctx, scope, b.ID)
+	if err != nil {
+		return notFoundAs(err, ErrBusNotFound)
+	}
+
+	companiaID, conductorID, rutaID := current.CompaniaID, current.ConductorID, current.RutaID
+	if !b.CompaniaID.IsZero() {
+		companiaID = b.CompaniaID
+	}
+	if !b.ConductorID.IsZero() {
+		conductorID = b.ConductorID
+	}
+	if !b.RutaID.IsZero() {
+		rutaID = b.RutaID
+	}
+	companyChanged := companiaID != current.CompaniaID
+	if companyChanged {
+		if err := s.checkNoDevices(ctx, b.ID); err != nil {
+			return err
+		}
+	}
+
+	if !conductorID.IsZero() && (companyChanged || conductorID != current.ConductorID) {
+		if err := s.Refs.Driver(ctx, companiaID, conductorID); err != nil {
+			return err
+		}
+	}
+	if !rutaID.IsZero() && (companyChanged || rutaID != current.RutaID) {
+		if err := s.Refs.Route(ctx, companiaID, rutaID); err != nil {
+			return err
+		}
 	}
-	return domain.DeleteBus(context.TODO(), s.DB, id)
+	return nil
 }
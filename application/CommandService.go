@@ -0,0 +1,418 @@
+package application
+
+import (
+	"context"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Límites de los parámetros de los comandos.
+const (
+	MinReportInterval    = 1    // segundos
+	MaxReportInterval    = 3600 // segundos
+	MaxDisplayMessageLen = 160  // caracteres
+	maxCommandResultLen  = 500  // caracteres del mensaje de confirmación que se guardan
+)
+
+// commandExpireTimeout es el plazo de cada pasada de vencimiento de comandos.
+const commandExpireTimeout = 30 * time.Second
+
+// Cola de confirmaciones recibidas de los dispositivos.
+const (
+	commandAckQueueSize = 1000             // confirmaciones pendientes como máximo
+	commandAckTimeout   = 10 * time.Second // plazo para guardar cada confirmación
+)
+
+// ErrAckQueueFull se retorna cuando la cola de confirmaciones está llena y la
+// confirmación se descarta. El dispositivo recibirá el comando de nuevo al
+// reconectarse.
+var ErrAckQueueFull = domain.NewError(domain.KindInternal, "ack_queue_full", "la cola de confirmaciones de comandos está llena")
+
+// commandAck es una confirmación encolada con QueueAck.
+type commandAck struct {
+	busID   primitive.ObjectID
+	id      primitive.ObjectID
+	ok      bool
+	message string
+}
+
+// CommandOptions configura el CommandService.
+type CommandOptions struct {
+	TTL            time.Duration // plazo para que un dispositivo confirme el comando
+	ExpireInterval time.Duration // cada cuánto se marcan como vencidos los comandos
+}
+
+// CommandPublisher publica un comando en el tópico de comandos de su bus.
+// Retorna false si ningún dispositivo del bus está suscrito para recibirlo.
+type CommandPublisher interface {
+	PublishCommand(cmd domain.Command) (bool, error)
+}
+
+// CommandService envía comandos a los dispositivos de los buses y sigue su
+// confirmación. Un comando se publica al crearlo si hay algún dispositivo del
+// bus suscrito y, mientras siga abierto, cada vez que un dispositivo se
+// suscribe a su tópico de comandos (entrega al menos una vez: el dispositivo
+// descarta los IDs repetidos). Los comandos que nadie confirma dentro de TTL
+// vencen: dejan de entregarse y de aceptar confirmaciones, se muestran como
+// vencidos y una revisión cada ExpireInterval los marca así en el repositorio.
+// Las confirmaciones de los dispositivos se encolan con QueueAck y se guardan
+// en segundo plano, junto con esa revisión.
+type CommandService struct {
+	Commands domain.CommandRepository
+	Buses    domain.BusRepository
+	opts     CommandOptions
+
+	mu        sync.RWMutex
+	publisher CommandPublisher
+
+	acks chan commandAck
+
+	runOnce  sync.Once
+	running  atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// NewCommandService crea un CommandService sin publicador: hasta que se
+// llame a SetPublisher los comandos quedan pendientes. Llama a Run para
+// iniciar el vencimiento periódico y el guardado de las confirmaciones, y a
+// Stop para detenerlos.
+func NewCommandService(commands domain.CommandRepository, buses domain.BusRepository, opts CommandOptions) *CommandService {
+	return &CommandService{
+		Commands: commands,
+		Buses:    buses,
+		opts:     opts,
+		acks:     make(chan commandAck, commandAckQueueSize),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// SetPublisher define cómo se publican los comandos (p. ej. el broker MQTT).
+func (s *CommandService) SetPublisher(p CommandPublisher) {
+	s.mu.Lock()
+	s.publisher = p
+	s.mu.Unlock()
+}
+
+// SendCommand valida y guarda un comando para los dispositivos de un bus del
+// Scope y lo publica si hay alguno suscrito. Si no, queda pendiente hasta que
+// se reconecten.
+func (s *CommandService) SendCommand(scope domain.Scope, busIDHex, tipo string, params map[string]any) (*domain.Command, error) {
+	params, err := validateCommand(tipo, params)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.TODO()
+	bus, err := s.bus(ctx, scope, busIDHex)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cmd := domain.Command{
+		BusID:      bus.ID,
+		CompaniaID: bus.CompaniaID,
+		Tipo:       tipo,
+		Params:     params,
+		Estado:     domain.CommandPending,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(s.opts.TTL),
+	}
+	if err := s.Commands.Create(ctx, &cmd); err != nil {
+		return nil, err
+	}
+	if sent, at := s.publish(ctx, cmd); sent {
+		cmd.Estado = domain.CommandSent
+		cmd.Intentos++
+		cmd.SentAt = &at
+	}
+	return &cmd, nil
+}
+
+// Deliver publica los comandos abiertos y no vencidos del bus, en orden de
+// creación. Se llama cuando un dispositivo del bus se suscribe a su tópico
+// de comandos.
+func (s *CommandService) Deliver(ctx context.Context, busID primitive.ObjectID) error {
+	cmds, err := s.Commands.Open(ctx, busID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, cmd := range cmds {
+		if cmd.Expired(now) {
+			continue
+		}
+		if sent, _ := s.publish(ctx, cmd); !sent {
+			// El dispositivo se desconectó; el resto se entregará al volver.
+			break
+		}
+	}
+	return nil
+}
+
+// publish publica el comando y, si algún dispositivo lo recibió, lo marca
+// como enviado. Los errores se registran: el comando sigue abierto y se
+// reintentará con la próxima suscripción.
+func (s *CommandService) publish(ctx context.Context, cmd domain.Command) (bool, time.Time) {
+	s.mu.RLock()
+	p := s.publisher
+	s.mu.RUnlock()
+	if p == nil {
+		return false, time.Time{}
+	}
+
+	sent, err := p.PublishCommand(cmd)
+	if err != nil {
+		log.Printf("Comandos: error al publicar el comando %s del bus %s: %v", cmd.ID.Hex(), cmd.BusID.Hex(), err)
+		return false, time.Time{}
+	}
+	if !sent {
+		return false, time.Time{}
+	}
+	at := time.Now()
+	if err := s.Commands.MarkSent(ctx, cmd.ID, at); err != nil {
+		log.Printf("Comandos: error al marcar como enviado el comando %s: %v", cmd.ID.Hex(), err)
+	}
+	return true, at
+}
+
+// Acknowledge registra la confirmación de un comando por parte de un
+// dispositivo del bus: ok indica si lo ejecutó y message es el detalle que
+// reporta. Un comando inexistente, de otro bus, ya cerrado o vencido retorna
+// ErrCommandNotFound.
+func (s *CommandService) Acknowledge(ctx context.Context, busID primitive.ObjectID, idHex string, ok bool, message string) (*domain.Command, error) {
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return nil, domain.NewValidationError("ID de comando inválido")
+	}
+	estado := domain.CommandAcked
+	if !ok {
+		estado = domain.CommandFailed
+	}
+	message = strings.TrimSpace(message)
+	if utf8.RuneCountInString(message) > maxCommandResultLen {
+		message = string([]rune(message)[:maxCommandResultLen])
+	}
+	cmd, err := s.Commands.Resolve(ctx, busID, id, estado, message, time.Now())
+	return cmd, notFoundAs(err, ErrCommandNotFound)
+}
+
+// QueueAck encola la confirmación de un comando por parte de un dispositivo
+// del bus para registrarla con Acknowledge en segundo plano, sin esperar a la
+// base de datos. Retorna ErrAckQueueFull si no hay espacio en la cola.
+func (s *CommandService) QueueAck(busID primitive.ObjectID, idHex string, ok bool, message string) error {
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return domain.NewValidationError("ID de comando inválido")
+	}
+	select {
+	case s.acks <- commandAck{busID: busID, id: id, ok: ok, message: message}:
+		return nil
+	default:
+		return ErrAckQueueFull
+	}
+}
+
+// saveAck registra una confirmación encolada. Los errores se registran: una
+// confirmación rechazada (p. ej. de un comando ya cerrado) no se reintenta.
+func (s *CommandService) saveAck(a commandAck) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandAckTimeout)
+	defer cancel()
+	cmd, err := s.Acknowledge(ctx, a.busID, a.id.Hex(), a.ok, a.message)
+	if err != nil {
+		log.Printf("Comandos: confirmación del comando %s del bus %s rechazada: %v", a.id.Hex(), a.busID.Hex(), err)
+		return
+	}
+	log.Printf("Comandos: comando %s confirmado como %s", cmd.ID.Hex(), cmd.Estado)
+}
+
+// GetBusCommands obtiene una página de los comandos de un bus del Scope, por
+// defecto del más reciente al más antiguo.
+func (s *CommandService) GetBusCommands(scope domain.Scope, busIDHex string, p ListParams) (*domain.Page[domain.Command], error) {
+	ctx := context.TODO()
+	bus, err := s.bus(ctx, scope, busIDHex)
+	if err != nil {
+		return nil, err
+	}
+	if p.Sort == "" {
+		p.Sort = "-created_at"
+	}
+	q, err := p.query(domain.CommandFields)
+	if err != nil {
+		return nil, err
+	}
+	q.Filters = append(q.Filters, domain.Filter{Field: "bus_id", Op: domain.OpEq, Value: bus.ID})
+
+	page, err := s.Commands.List(ctx, scope, q)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range page.Items {
+		expireView(&page.Items[i], now)
+	}
+	return page, nil
+}
+
+// GetCommand retorna un comando de un bus del Scope.
+func (s *CommandService) GetCommand(scope domain.Scope, busIDHex, idHex string) (*domain.Command, error) {
+	ctx := context.TODO()
+	bus, err := s.bus(ctx, scope, busIDHex)
+	if err != nil {
+		return nil, err
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return nil, domain.NewValidationError("ID de comando inválido")
+	}
+
+	cmd, err := s.Commands.GetByID(ctx, scope, id)
+	if err != nil {
+		return nil, notFoundAs(err, ErrCommandNotFound)
+	}
+	if cmd.BusID != bus.ID {
+		return nil, ErrCommandNotFound
+	}
+	expireView(cmd, time.Now())
+	return cmd, nil
+}
+
+// expireView muestra como vencido un comando que venció y que la revisión
+// periódica aún no ha marcado.
+func expireView(cmd *domain.Command, now time.Time) {
+	if cmd.Expired(now) {
+		cmd.Estado = domain.CommandExpired
+	}
+}
+
+// Expire marca como vencidos los comandos abiertos que vencieron antes de
+// now y retorna cuántos eran.
+func (s *CommandService) Expire(ctx context.Context, now time.Time) (int64, error) {
+	return s.Commands.Expire(ctx, now)
+}
+
+// Run guarda las confirmaciones encoladas y marca los comandos vencidos cada
+// ExpireInterval hasta que se llame a Stop. Solo la primera llamada tiene
+// efecto.
+func (s *CommandService) Run() {
+	s.runOnce.Do(s.loop)
+}
+
+func (s *CommandService) loop() {
+	defer close(s.stopped)
+	s.running.Store(true)
+	defer s.running.Store(false)
+
+	ticker := time.NewTicker(s.opts.ExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), commandExpireTimeout)
+			n, err := s.Expire(ctx, now)
+			cancel()
+			if err != nil {
+				log.Printf("Comandos: error al marcar los comandos vencidos: %v", err)
+			} else if n > 0 {
+				log.Printf("Comandos: %d vencidos", n)
+			}
+		case a := <-s.acks:
+			s.saveAck(a)
+		case <-s.stop:
+			// Guarda las confirmaciones que quedan en la cola.
+			for {
+				select {
+				case a := <-s.acks:
+					s.saveAck(a)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Stop detiene el vencimiento periódico y espera a que se guarden las
+// confirmaciones encoladas, o a que venza ctx. Es seguro llamarlo más de una
+// vez, aunque Run no se haya llamado.
+func (s *CommandService) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	go s.Run()
+
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Running indica si el vencimiento periódico y el guardado de las
+// confirmaciones están en ejecución.
+func (s *CommandService) Running() bool {
+	return s.running.Load()
+}
+
+// bus busca un bus del Scope por su ID.
+func (s *CommandService) bus(ctx context.Context, scope domain.Scope, idHex string) (*domain.Bus, error) {
+	if idHex == "" {
+		return nil, domain.NewValidationError("ID de bus es obligatorio")
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return nil, domain.NewValidationError("ID de bus inválido")
+	}
+	bus, err := s.Buses.GetByID(ctx, scope, id)
+	if err != nil {
+		return nil, notFoundAs(err, ErrBusNotFound)
+	}
+	return bus, nil
+}
+
+// validateCommand verifica los parámetros de cada tipo de comando y retorna
+// los que se guardan, normalizados.
+func validateCommand(tipo string, params map[string]any) (map[string]any, error) {
+	invalid := func(msg string) error {
+		return ErrInvalidCommandParams.WithDetails(map[string]any{"tipo": tipo, "motivo": msg})
+	}
+
+	switch tipo {
+	case domain.CommandSetInterval:
+		// Los números del JSON llegan como float64.
+		seconds, ok := params["seconds"].(float64)
+		if !ok || len(params) != 1 || seconds != math.Trunc(seconds) || seconds < MinReportInterval || seconds > MaxReportInterval {
+			return nil, invalid("'seconds' debe ser un entero entre 1 y 3600")
+		}
+		return map[string]any{"seconds": int(seconds)}, nil
+
+	case domain.CommandDisplayMessage:
+		text, ok := params["text"].(string)
+		text = strings.TrimSpace(text)
+		if !ok || len(params) != 1 || text == "" || utf8.RuneCountInString(text) > MaxDisplayMessageLen {
+			return nil, invalid("'text' es obligatorio y admite hasta 160 caracteres")
+		}
+		return map[string]any{"text": text}, nil
+
+	case domain.CommandReboot:
+		if len(params) != 0 {
+			return nil, invalid("no admite parámetros")
+		}
+		return nil, nil
+	}
+
+	return nil, ErrUnknownCommandType.WithDetails(map[string]any{
+		"tipo":       tipo,
+		"permitidos": []string{domain.CommandSetInterval, domain.CommandReboot, domain.CommandDisplayMessage},
+	})
+}
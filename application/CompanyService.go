@@ -2,72 +2,115 @@ package application
 
 import (
     "context"
-    "errors"
 
     "UbicaBus/UbicaBusBackend/domain"
 
     "go.mongodb.org/mongo-driver/bson/primitive"
-    "go.mongodb.org/mongo-driver/mongo"
 )
 
 // CompanyService maneja la lógica de negocio relacionada con compañias.
 type CompanyService struct {
-    DB *mongo.Database
+    Companies    domain.CompanyRepository
+    Users        domain.UserRepository
+    Buses        domain.BusRepository
+    Routes       domain.RouteRepository
+    Locations    domain.BusLocationRepository
+    Rollups      domain.BusLocationRollupRepository
+    Devices      domain.DeviceRepository
+    Connectivity domain.ConnectivityEventRepository
+    Commands     domain.CommandRepository
+    Tx           domain.Transactor
+    Fleet        *FleetService
 }
 
-// NewCompanyService crea una nueva instancia de CompanyService.
-func NewCompanyService(db *mongo.Database) *CompanyService {
-    return &CompanyService{DB: db}
+// NewCompanyService crea una nueva instancia de CompanyService. Además de las
+// compañías recibe los repositorios de las entidades que pertenecen a una
+// compañía, necesarios para eliminarla, y la flota en vivo de la que se retiran
+// sus buses.
+func NewCompanyService(
+    companies domain.CompanyRepository,
+    users domain.UserRepository,
+    buses domain.BusRepository,
+    routes domain.RouteRepository,
+    locations domain.BusLocationRepository,
+    rollups domain.BusLocationRollupRepository,
+    devices domain.DeviceRepository,
+    connectivity domain.ConnectivityEventRepository,
+    commands domain.CommandRepository,
+    tx domain.Transactor,
+    fleet *FleetService,
+) *CompanyService {
+    return &CompanyService{
+        Companies:    companies,
+        Users:        users,
+        Buses:        buses,
+        Routes:       routes,
+        Locations:    locations,
+        Rollups:      rollups,
+        Devices:      devices,
+        Connectivity: connectivity,
+        Commands:     commands,
+        Tx:           tx,
+        Fleet:        fleet,
+    }
 }
 
-// GetAllCompanies obtiene todas las compañias.
-func (s *CompanyService) GetAllCompanies() ([]domain.Company, error) {
-    return domain.GetAllCompanies(context.TODO(), s.DB)
+// GetAllCompanies obtiene una página de las compañias visibles en el Scope.
+func (s *CompanyService) GetAllCompanies(scope domain.Scope, p ListParams) (*domain.Page[domain.Company], error) {
+    q, err := p.query(domain.CompanyFields)
+    if err != nil {
+        return nil, err
+    }
+    return s.Companies.List(context.TODO(), scope, q)
 }
 
 // GetCompanyByID busca una compañía por su ID.
-func (s *CompanyService) GetCompanyByID(idHex string) (*domain.Company, error) {
+func (s *CompanyService) GetCompanyByID(scope domain.Scope, idHex string) (*domain.Company, error) {
     if idHex == "" {
-        return nil, errors.New("ID de compañía es obligatorio")
+        return nil, domain.NewValidationError("ID de compañía es obligatorio")
     }
     id, err := primitive.ObjectIDFromHex(idHex)
     if err != nil {
-        return nil, errors.New("ID de compañía inválido")
+        return nil, domain.NewValidationError("ID de compañía inválido")
     }
-    return domain.GetCompanyByID(context.TODO(), s.DB, id)
+    comp, err := s.Companies.GetByID(context.TODO(), scope, id)
+    return comp, notFoundAs(err, ErrCompanyNotFound)
 }
 
 // SearchCompaniesByName busca compañías por nombre exacto.
-func (s *CompanyService) SearchCompaniesByName(nombre string) ([]domain.Company, error) {
+func (s *CompanyService) SearchCompaniesByName(scope domain.Scope, nombre string) ([]domain.Company, error) {
     if nombre == "" {
-        return nil, errors.New("el nombre de la compañía es obligatorio")
+        return nil, domain.NewValidationError("el nombre de la compañía es obligatorio")
     }
-    return domain.GetCompaniesByName(context.TODO(), s.DB, nombre)
+    return s.Companies.GetByName(context.TODO(), scope, nombre)
 }
 
-// RegisterCompany crea una nueva compañía.
-func (s *CompanyService) RegisterCompany(nombre, descripcion string) (primitive.ObjectID, error) {
+// RegisterCompany crea una nueva compañía. Solo disponible para super-admin.
+func (s *CompanyService) RegisterCompany(scope domain.Scope, nombre, descripcion string) (primitive.ObjectID, error) {
+    if err := requireAllCompanies(scope); err != nil {
+        return primitive.NilObjectID, err
+    }
     if nombre == "" {
-        return primitive.NilObjectID, errors.New("el nombre es obligatorio")
+        return primitive.NilObjectID, domain.NewValidationError("el nombre es obligatorio")
     }
     comp := domain.Company{
         Nombre:      nombre,
         Descripcion: descripcion,
     }
-    if err := domain.CrearCompania(context.TODO(), s.DB, &comp); err != nil {
+    if err := s.Companies.Create(context.TODO(), &comp); err != nil {
         return primitive.NilObjectID, err
     }
     return comp.ID, nil
 }
 
-// EditCompany actualiza una compañía existente.
-func (s *CompanyService) EditCompany(idHex, nombre, descripcion string) (*domain.Company, error) {
+// EditCompany actualiza una compañía existente dentro del Scope.
+func (s *CompanyService) EditCompany(scope domain.Scope, idHex, nombre, descripcion string) (*domain.Company, error) {
     if idHex == "" {
-        return nil, errors.New("ID de compañía es obligatorio")
+        return nil, domain.NewValidationError("ID de compañía es obligatorio")
     }
     id, err := primitive.ObjectIDFromHex(idHex)
     if err != nil {
-        return nil, errors.New("ID de compañía inválido")
+        return nil, domain.NewValidationError("ID de compañía inválido")
     }
     comp := &domain.Company{ID: id}
     if nombre != "" {
@@ -76,17 +119,81 @@ func (s *CompanyService) EditCompany(idHex, nombre, descripcion string) (*domain
     if descripcion != "" {
         comp.Descripcion = descripcion
     }
-    return domain.EditarCompania(context.TODO(), s.DB, comp)
+    updated, err := s.Companies.Update(context.TODO(), scope, comp)
+    return updated, notFoundAs(err, ErrCompanyNotFound)
 }
 
-// DeleteCompany elimina una compañía por su ID.
-func (s *CompanyService) DeleteCompany(idHex string) error {
+// DeleteCompany elimina una compañía por su ID. Solo disponible para super-admin.
+// Si la compañía tiene usuarios, buses, rutas o dispositivos retorna
+// ErrCompanyInUse, salvo que cascade sea true, en cuyo caso se eliminan
+// también esos datos y las localizaciones y eventos de conectividad de sus
+// buses. Todo ocurre en una misma transacción.
+func (s *CompanyService) DeleteCompany(scope domain.Scope, idHex string, cascade bool) error {
+    if err := requireAllCompanies(scope); err != nil {
+        return err
+    }
     if idHex == "" {
-        return errors.New("ID de compañía es obligatorio")
+        return domain.NewValidationError("ID de compañía es obligatorio")
     }
     id, err := primitive.ObjectIDFromHex(idHex)
     if err != nil {
-        return errors.New("ID de compañía inválido")
+        return domain.NewValidationError("ID de compañía inválido")
+    }
+
+    err = s.Tx.WithTransaction(context.TODO(), func(ctx context.Context) error {
+        if _, err := s.Companies.GetByID(ctx, scope, id); err != nil {
+            return notFoundAs(err, ErrCompanyNotFound)
+        }
+
+        users, err := s.Users.Search(ctx, domain.GlobalScope(), domain.UserFilter{CompaniaID: id})
+        if err != nil {
+            return err
+        }
+        // Solo interesan los totales, no los documentos.
+        count := domain.ListQuery{Limit: 1}
+        buses, err := s.Buses.List(ctx, domain.CompanyScope(id), count)
+        if err != nil {
+            return err
+        }
+        routes, err := s.Routes.List(ctx, domain.CompanyScope(id), count)
+        if err != nil {
+            return err
+        }
+        devices, err := s.Devices.List(ctx, domain.CompanyScope(id), count)
+        if err != nil {
+            return err
+        }
+
+        if int64(len(users))+*buses.Total+*routes.Total+*devices.Total > 0 {
+            if !cascade {
+                return ErrCompanyInUse.WithDetails(map[string]any{
+                    "usuarios":     len(users),
+                    "buses":        *buses.Total,
+                    "rutas":        *routes.Total,
+                    "dispositivos": *devices.Total,
+                })
+            }
+            for _, deleteByCompania := range []func(context.Context, primitive.ObjectID) (int64, error){
+                s.Locations.DeleteByCompania,
+                s.Rollups.DeleteByCompania,
+                s.Connectivity.DeleteByCompania,
+                s.Commands.DeleteByCompania,
+                s.Devices.DeleteByCompania,
+                s.Buses.DeleteByCompania,
+                s.Routes.DeleteByCompania,
+                s.Users.DeleteByCompania,
+            } {
+                if _, err := deleteByCompania(ctx, id); err != nil {
+                    return err
+                }
+            }
+        }
+
+        return notFoundAs(s.Companies.Delete(ctx, id), ErrCompanyNotFound)
+    })
+    if err != nil {
+        return err
     }
-    return domain.DeleteCompany(context.TODO(), s.DB, id)
+    s.Fleet.RemoveCompany(id)
+    return nil
 }
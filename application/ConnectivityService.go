@@ -0,0 +1,339 @@
+package application
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// connectivityWriteTimeout es el plazo para guardar un evento de conectividad.
+const connectivityWriteTimeout = 10 * time.Second
+
+// connectivityStatusEvents es el número de eventos recientes que incluye
+// BusStatus.
+const connectivityStatusEvents = 10
+
+// ConnectivityOptions configura el ConnectivityService.
+type ConnectivityOptions struct {
+	StaleAfter    time.Duration // inactividad tras la que un bus conectado pasa a stale
+	OfflineAfter  time.Duration // inactividad tras la que pasa a offline aunque siga conectado
+	CheckInterval time.Duration // cada cuánto se revisa la inactividad de los buses
+}
+
+// BusStatus es el estado de conectividad de un bus junto con sus últimos
+// cambios de estado, del más reciente al más antiguo.
+type BusStatus struct {
+	domain.BusConnectivity
+	Events []domain.ConnectivityEvent
+}
+
+// busHeartbeat es el estado de conectividad de un bus en memoria.
+type busHeartbeat struct {
+	companiaID primitive.ObjectID
+	estado     string
+	since      time.Time
+	lastSeen   time.Time
+	devices    int
+	// restored indica que el estado se cargó de los eventos guardados y que
+	// aún no se ha conectado ningún dispositivo desde el arranque.
+	restored bool
+}
+
+// ConnectivityService sigue la conectividad de cada bus a partir de las
+// conexiones y desconexiones de sus dispositivos al broker MQTT y de las
+// posiciones que publican. Un bus sin dispositivos conectados está offline;
+// uno conectado pasa a stale tras StaleAfter sin actividad y a offline tras
+// OfflineAfter. Cada cambio de estado se guarda como un
+// domain.ConnectivityEvent y se notifica a las funciones registradas con
+// OnTransition. Es seguro para uso concurrente.
+type ConnectivityService struct {
+	Events domain.ConnectivityEventRepository
+	Buses  domain.BusRepository
+	opts   ConnectivityOptions
+
+	mu    sync.Mutex
+	buses map[primitive.ObjectID]*busHeartbeat
+
+	listenersMu sync.RWMutex
+	listeners   []func(domain.ConnectivityEvent)
+	writes      sync.WaitGroup // eventos pendientes de guardar
+
+	runOnce  sync.Once
+	running  atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// NewConnectivityService crea un ConnectivityService vacío; llama a Restore
+// para cargar el último estado guardado, a Run para iniciar la revisión
+// periódica de la inactividad y a Stop para detenerla.
+func NewConnectivityService(events domain.ConnectivityEventRepository, buses domain.BusRepository, opts ConnectivityOptions) *ConnectivityService {
+	return &ConnectivityService{
+		Events:  events,
+		Buses:   buses,
+		opts:    opts,
+		buses:   map[primitive.ObjectID]*busHeartbeat{},
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// OnTransition registra una función que se llama con cada cambio de estado
+// (p. ej. para reenviarlo a WebSockets). No debe bloquear.
+func (s *ConnectivityService) OnTransition(fn func(domain.ConnectivityEvent)) {
+	s.listenersMu.Lock()
+	s.listeners = append(s.listeners, fn)
+	s.listenersMu.Unlock()
+}
+
+// Restore carga el último estado guardado de cada bus. Los buses que estaban
+// online o stale conservan su estado durante StaleAfter, para dar tiempo a
+// que sus dispositivos se reconecten tras un reinicio; si no lo hacen, pasan
+// a offline.
+func (s *ConnectivityService) Restore(ctx context.Context) error {
+	latest, err := s.Events.Latest(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	buses := make(map[primitive.ObjectID]*busHeartbeat, len(latest))
+	for _, e := range latest {
+		buses[e.BusID] = &busHeartbeat{
+			companiaID: e.CompaniaID,
+			estado:     e.Estado,
+			since:      e.At,
+			lastSeen:   now,
+			restored:   e.Estado != domain.ConnectivityOffline,
+		}
+	}
+
+	s.mu.Lock()
+	s.buses = buses
+	s.mu.Unlock()
+	return nil
+}
+
+// Connected registra que un dispositivo del bus se conectó en at.
+func (s *ConnectivityService) Connected(busID, companiaID primitive.ObjectID, at time.Time) {
+	s.update(busID, at, func(b *busHeartbeat) {
+		b.companiaID = companiaID
+		b.devices++
+		b.restored = false
+		b.seen(at)
+	})
+}
+
+// Disconnected registra que un dispositivo del bus se desconectó en at.
+func (s *ConnectivityService) Disconnected(busID primitive.ObjectID, at time.Time) {
+	s.update(busID, at, func(b *busHeartbeat) {
+		if b.devices > 0 {
+			b.devices--
+		}
+	})
+}
+
+// Seen registra una posición del bus recibida en at.
+func (s *ConnectivityService) Seen(busID, companiaID primitive.ObjectID, at time.Time) {
+	s.update(busID, at, func(b *busHeartbeat) {
+		b.companiaID = companiaID
+		b.seen(at)
+	})
+}
+
+func (b *busHeartbeat) seen(at time.Time) {
+	if at.After(b.lastSeen) {
+		b.lastSeen = at
+	}
+}
+
+// update aplica apply al estado del bus y emite el cambio de estado que
+// resulte, si lo hay.
+func (s *ConnectivityService) update(busID primitive.ObjectID, now time.Time, apply func(*busHeartbeat)) {
+	s.mu.Lock()
+	b, ok := s.buses[busID]
+	if !ok {
+		b = &busHeartbeat{estado: domain.ConnectivityOffline}
+		s.buses[busID] = b
+	}
+	apply(b)
+	e, changed := s.transition(busID, b, now)
+	s.mu.Unlock()
+
+	if changed {
+		s.emit(e)
+	}
+}
+
+// Sweep revisa la inactividad de todos los buses en now y emite los cambios
+// de estado. Retorna cuántos hubo.
+func (s *ConnectivityService) Sweep(now time.Time) int {
+	var events []domain.ConnectivityEvent
+	s.mu.Lock()
+	for id, b := range s.buses {
+		if e, changed := s.transition(id, b, now); changed {
+			events = append(events, e)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, e := range events {
+		s.emit(e)
+	}
+	return len(events)
+}
+
+// transition calcula el estado del bus en now y, si cambió, lo actualiza y
+// retorna el evento correspondiente. Se llama con s.mu tomado.
+func (s *ConnectivityService) transition(busID primitive.ObjectID, b *busHeartbeat, now time.Time) (domain.ConnectivityEvent, bool) {
+	estado := s.state(b, now)
+	if estado == b.estado {
+		return domain.ConnectivityEvent{}, false
+	}
+	e := domain.ConnectivityEvent{
+		BusID:      busID,
+		CompaniaID: b.companiaID,
+		Estado:     estado,
+		Anterior:   b.estado,
+		At:         now,
+	}
+	b.estado = estado
+	b.since = now
+	return e, true
+}
+
+func (s *ConnectivityService) state(b *busHeartbeat, now time.Time) string {
+	idle := now.Sub(b.lastSeen)
+	switch {
+	case b.devices == 0 && b.restored && idle < s.opts.StaleAfter:
+		return b.estado
+	case b.devices == 0, idle >= s.opts.OfflineAfter:
+		return domain.ConnectivityOffline
+	case idle >= s.opts.StaleAfter:
+		return domain.ConnectivityStale
+	default:
+		return domain.ConnectivityOnline
+	}
+}
+
+// emit notifica el cambio de estado y lo guarda sin bloquear a quien llama.
+func (s *ConnectivityService) emit(e domain.ConnectivityEvent) {
+	log.Printf("Conectividad: bus %s pasó de %s a %s", e.BusID.Hex(), e.Anterior, e.Estado)
+
+	s.listenersMu.RLock()
+	for _, fn := range s.listeners {
+		fn(e)
+	}
+	s.listenersMu.RUnlock()
+
+	s.writes.Add(1)
+	go func() {
+		defer s.writes.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), connectivityWriteTimeout)
+		defer cancel()
+		if err := s.Events.Create(ctx, &e); err != nil {
+			log.Printf("Conectividad: error al guardar el evento del bus %s: %v", e.BusID.Hex(), err)
+		}
+	}()
+}
+
+// BusStatus retorna el estado de conectividad de un bus del Scope, según la
+// última revisión, y sus últimos cambios de estado.
+func (s *ConnectivityService) BusStatus(scope domain.Scope, idHex string) (*BusStatus, error) {
+	if idHex == "" {
+		return nil, domain.NewValidationError("ID de bus es obligatorio")
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return nil, domain.NewValidationError("ID de bus inválido")
+	}
+	ctx := context.TODO()
+	bus, err := s.Buses.GetByID(ctx, scope, id)
+	if err != nil {
+		return nil, notFoundAs(err, ErrBusNotFound)
+	}
+
+	// Un bus que no se ha visto desde el arranque está offline.
+	status := &BusStatus{BusConnectivity: domain.BusConnectivity{BusID: id, CompaniaID: bus.CompaniaID, Estado: domain.ConnectivityOffline}}
+	s.mu.Lock()
+	if b, ok := s.buses[id]; ok {
+		status.Estado = b.estado
+		status.Devices = b.devices
+		if !b.since.IsZero() {
+			since := b.since
+			status.Since = &since
+		}
+		if !b.lastSeen.IsZero() && !b.restored {
+			lastSeen := b.lastSeen
+			status.LastSeen = &lastSeen
+		}
+	}
+	s.mu.Unlock()
+
+	page, err := s.Events.List(ctx, scope, domain.ListQuery{
+		Filters: []domain.Filter{{Field: "bus_id", Op: domain.OpEq, Value: id}},
+		Sort:    "at",
+		Desc:    true,
+		Limit:   connectivityStatusEvents,
+	})
+	if err != nil {
+		return nil, err
+	}
+	status.Events = page.Items
+	return status, nil
+}
+
+// Run revisa la inactividad de los buses cada CheckInterval hasta que se
+// llame a Stop. Solo la primera llamada tiene efecto.
+func (s *ConnectivityService) Run() {
+	s.runOnce.Do(s.loop)
+}
+
+func (s *ConnectivityService) loop() {
+	defer close(s.stopped)
+	s.running.Store(true)
+	defer s.running.Store(false)
+
+	ticker := time.NewTicker(s.opts.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.Sweep(now)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Stop detiene la revisión periódica y espera a que se guarden los eventos
+// pendientes, o a que venza ctx. Es seguro llamarlo más de una vez, aunque
+// Run no se haya llamado.
+func (s *ConnectivityService) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	go s.Run()
+
+	done := make(chan struct{})
+	go func() {
+		<-s.stopped
+		s.writes.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Running indica si la revisión periódica está en ejecución.
+func (s *ConnectivityService) Running() bool {
+	return s.running.Load()
+}
@@ -0,0 +1,332 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// deviceSeenInterval es cada cuánto se guarda como mucho el last_seen de un
+// dispositivo que sigue publicando.
+const deviceSeenInterval = time.Minute
+
+// DeviceSecret es un dispositivo junto con el secreto en claro de sus
+// credenciales, que solo se muestra al registrarlo o al rotarlas.
+type DeviceSecret struct {
+	domain.Device
+	Secret string
+}
+
+// DeviceService administra los rastreadores GPS: su registro, sus
+// credenciales MQTT, el bus al que están asignados y su baja. También
+// verifica las credenciales cuando se conectan al broker.
+type DeviceService struct {
+	Devices domain.DeviceRepository
+	Buses   domain.BusRepository
+
+	mu       sync.RWMutex
+	onRevoke []func(clientID string)
+
+	seenMu sync.Mutex
+	seen   map[primitive.ObjectID]time.Time // último last_seen guardado
+}
+
+// NewDeviceService crea una nueva instancia de DeviceService.
+func NewDeviceService(devices domain.DeviceRepository, buses domain.BusRepository) *DeviceService {
+	return &DeviceService{Devices: devices, Buses: buses, seen: map[primitive.ObjectID]time.Time{}}
+}
+
+// OnRevoke registra una función que se llama con el client_id de un
+// dispositivo cuya sesión MQTT deja de ser válida: al rotar sus credenciales,
+// reasignarlo a otro bus o darlo de baja (p. ej. para desconectarlo del broker).
+func (s *DeviceService) OnRevoke(fn func(clientID string)) {
+	s.mu.Lock()
+	s.onRevoke = append(s.onRevoke, fn)
+	s.mu.Unlock()
+}
+
+func (s *DeviceService) revoke(clientID string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, fn := range s.onRevoke {
+		fn(clientID)
+	}
+}
+
+// GetAllDevices obtiene una página de los dispositivos visibles en el Scope.
+func (s *DeviceService) GetAllDevices(scope domain.Scope, p ListParams) (*domain.Page[domain.Device], error) {
+	q, err := p.query(domain.DeviceFields)
+	if err != nil {
+		return nil, err
+	}
+	return s.Devices.List(context.TODO(), scope, q)
+}
+
+// GetDeviceByID retorna un dispositivo por su ID.
+func (s *DeviceService) GetDeviceByID(scope domain.Scope, idHex string) (*domain.Device, error) {
+	return s.device(context.TODO(), scope, idHex)
+}
+
+// RegisterDevice registra un dispositivo asignado a un bus del Scope y emite
+// sus credenciales. El dispositivo toma la compañía del bus.
+func (s *DeviceService) RegisterDevice(scope domain.Scope, serial, imei, firmware, busIDHex string) (*DeviceSecret, error) {
+	serial = strings.TrimSpace(serial)
+	if serial == "" || busIDHex == "" {
+		return nil, domain.NewValidationError("serial y bus son obligatorios")
+	}
+	if err := validateIMEI(imei); err != nil {
+		return nil, err
+	}
+	ctx := context.TODO()
+	bus, err := s.busInScope(ctx, scope, busIDHex)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, err := randomToken(9, hex.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+	secret, hash, err := newDeviceSecret()
+	if err != nil {
+		return nil, err
+	}
+	d := domain.Device{
+		Serial:     serial,
+		IMEI:       imei,
+		Firmware:   firmware,
+		Estado:     domain.DeviceActive,
+		BusID:      bus.ID,
+		CompaniaID: bus.CompaniaID,
+		ClientID:   "dev-" + clientID,
+		SecretHash: hash,
+	}
+	if err := s.Devices.Create(ctx, &d); err != nil {
+		return nil, conflictAs(err, ErrDeviceExists)
+	}
+	return &DeviceSecret{Device: d, Secret: secret}, nil
+}
+
+// EditDevice actualiza el serial, el IMEI o la versión de firmware de un
+// dispositivo activo. Los campos vacíos conservan su valor.
+func (s *DeviceService) EditDevice(scope domain.Scope, idHex, serial, imei, firmware string) (*domain.Device, error) {
+	if err := validateIMEI(imei); err != nil {
+		return nil, err
+	}
+	ctx := context.TODO()
+	current, err := s.activeDevice(ctx, scope, idHex)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := s.Devices.Update(ctx, scope, &domain.Device{
+		ID:       current.ID,
+		Serial:   strings.TrimSpace(serial),
+		IMEI:     imei,
+		Firmware: firmware,
+	})
+	return updated, conflictAs(notFoundAs(err, ErrDeviceNotFound), ErrDeviceExists)
+}
+
+// RotateCredentials emite un secreto nuevo para un dispositivo activo. El
+// client_id se conserva; el secreto anterior deja de ser válido y la sesión
+// MQTT abierta se cierra.
+func (s *DeviceService) RotateCredentials(scope domain.Scope, idHex string) (*DeviceSecret, error) {
+	ctx := context.TODO()
+	current, err := s.activeDevice(ctx, scope, idHex)
+	if err != nil {
+		return nil, err
+	}
+	secret, hash, err := newDeviceSecret()
+	if err != nil {
+		return nil, err
+	}
+	updated, err := s.Devices.Update(ctx, scope, &domain.Device{ID: current.ID, SecretHash: hash})
+	if err != nil {
+		return nil, notFoundAs(err, ErrDeviceNotFound)
+	}
+	s.revoke(updated.ClientID)
+	return &DeviceSecret{Device: *updated, Secret: secret}, nil
+}
+
+// ReassignDevice asigna un dispositivo activo a otro bus del Scope, cuya
+// compañía pasa a ser la del dispositivo. La sesión MQTT abierta se cierra
+// para que el dispositivo se vuelva a conectar con los tópicos del bus nuevo.
+func (s *DeviceService) ReassignDevice(scope domain.Scope, idHex, busIDHex string) (*domain.Device, error) {
+	if busIDHex == "" {
+		return nil, domain.NewValidationError("ID de bus es obligatorio")
+	}
+	ctx := context.TODO()
+	current, err := s.activeDevice(ctx, scope, idHex)
+	if err != nil {
+		return nil, err
+	}
+	bus, err := s.busInScope(ctx, scope, busIDHex)
+	if err != nil {
+		return nil, err
+	}
+	if bus.ID == current.BusID {
+		return current, nil
+	}
+	updated, err := s.Devices.Update(ctx, scope, &domain.Device{ID: current.ID, BusID: bus.ID, CompaniaID: bus.CompaniaID})
+	if err != nil {
+		return nil, notFoundAs(err, ErrDeviceNotFound)
+	}
+	s.revoke(updated.ClientID)
+	return updated, nil
+}
+
+// DecommissionDevice da de baja un dispositivo: conserva su registro, pero
+// ya no se puede conectar ni modificar. La sesión MQTT abierta se cierra.
+func (s *DeviceService) DecommissionDevice(scope domain.Scope, idHex string) (*domain.Device, error) {
+	ctx := context.TODO()
+	current, err := s.activeDevice(ctx, scope, idHex)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	updated, err := s.Devices.Update(ctx, scope, &domain.Device{
+		ID:               current.ID,
+		Estado:           domain.DeviceDecommissioned,
+		DecommissionedAt: &now,
+	})
+	if err != nil {
+		return nil, notFoundAs(err, ErrDeviceNotFound)
+	}
+	s.revoke(updated.ClientID)
+	return updated, nil
+}
+
+// Authenticate verifica el client_id y el secreto de un dispositivo. Retorna
+// el dispositivo, con la compañía actual de su bus, o
+// ErrInvalidDeviceCredentials si no existe, el secreto no coincide, está dado
+// de baja o su bus ya no existe.
+func (s *DeviceService) Authenticate(ctx context.Context, clientID, secret string) (*domain.Device, error) {
+	d, err := s.Devices.GetByClientID(ctx, clientID)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, ErrInvalidDeviceCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(d.SecretHash), []byte(hashDeviceSecret(secret))) != 1 || !d.Active() {
+		return nil, ErrInvalidDeviceCredentials
+	}
+
+	bus, err := s.Buses.GetByID(ctx, domain.GlobalScope(), d.BusID)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, ErrInvalidDeviceCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.CompaniaID = bus.CompaniaID
+	return d, nil
+}
+
+// Seen registra que el dispositivo se vio en at. Para no escribir con cada
+// mensaje, solo se guarda si pasó deviceSeenInterval desde la última vez.
+func (s *DeviceService) Seen(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	s.seenMu.Lock()
+	if last, ok := s.seen[id]; ok && at.Sub(last) < deviceSeenInterval {
+		s.seenMu.Unlock()
+		return nil
+	}
+	s.seen[id] = at
+	s.seenMu.Unlock()
+	return s.Devices.Touch(ctx, id, at)
+}
+
+// device busca un dispositivo del Scope por su ID en hexadecimal.
+func (s *DeviceService) device(ctx context.Context, scope domain.Scope, idHex string) (*domain.Device, error) {
+	if idHex == "" {
+		return nil, domain.NewValidationError("ID de dispositivo es obligatorio")
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return nil, domain.NewValidationError("ID de dispositivo inválido")
+	}
+	d, err := s.Devices.GetByID(ctx, scope, id)
+	return d, notFoundAs(err, ErrDeviceNotFound)
+}
+
+// activeDevice es como device, pero retorna ErrDeviceDecommissioned si el
+// dispositivo está dado de baja.
+func (s *DeviceService) activeDevice(ctx context.Context, scope domain.Scope, idHex string) (*domain.Device, error) {
+	d, err := s.device(ctx, scope, idHex)
+	if err != nil {
+		return nil, err
+	}
+	if !d.Active() {
+		return nil, ErrDeviceDecommissioned
+	}
+	return d, nil
+}
+
+func (s *DeviceService) busInScope(ctx context.Context, scope domain.Scope, busIDHex string) (*domain.Bus, error) {
+	id, err := primitive.ObjectIDFromHex(busIDHex)
+	if err != nil {
+		return nil, domain.NewValidationError("ID de bus inválido")
+	}
+	bus, err := s.Buses.GetByID(ctx, scope, id)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, ErrUnknownBus
+	}
+	return bus, err
+}
+
+// validateIMEI verifica que el IMEI, si viene, tenga 15 dígitos.
+func validateIMEI(imei string) error {
+	if imei == "" {
+		return nil
+	}
+	if len(imei) != 15 || strings.Trim(imei, "0123456789") != "" {
+		return domain.NewValidationError("el IMEI debe tener 15 dígitos")
+	}
+	return nil
+}
+
+// conflictAs sustituye un domain.ErrConflict genérico por el error específico
+// de la entidad; cualquier otro error se retorna sin cambios.
+func conflictAs(err, target error) error {
+	if errors.Is(err, domain.ErrConflict) {
+		return target
+	}
+	return err
+}
+
+// newDeviceSecret genera un secreto aleatorio y su hash.
+func newDeviceSecret() (secret, hash string, err error) {
+	secret, err = randomToken(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, hashDeviceSecret(secret), nil
+}
+
+// randomToken genera n bytes aleatorios codificados con encode.
+func randomToken(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
+
+// hashDeviceSecret calcula el hash con el que se guarda el secreto de un
+// dispositivo. Los secretos son aleatorios de 256 bits, por lo que basta un
+// hash rápido, como con los refresh tokens.
+func hashDeviceSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,91 @@
+package application
+
+import (
+	"errors"
+
+	"UbicaBus/UbicaBusBackend/domain"
+)
+
+var (
+	// ErrInvalidCredentials se retorna cuando el nombre o la contraseña no coinciden.
+	ErrInvalidCredentials = domain.NewError(domain.KindUnauthorized, "invalid_credentials", "credenciales inválidas")
+	// ErrInvalidToken se retorna cuando un token no es válido, expiró o fue revocado.
+	ErrInvalidToken = domain.NewError(domain.KindUnauthorized, "invalid_token", "token inválido o expirado")
+	// ErrInvalidDeviceCredentials se retorna cuando un dispositivo se conecta con
+	// un client_id inexistente, un secreto incorrecto, estando dado de baja o
+	// asignado a un bus eliminado.
+	ErrInvalidDeviceCredentials = domain.NewError(domain.KindUnauthorized, "invalid_device_credentials", "credenciales de dispositivo inválidas")
+	// ErrForbidden se retorna cuando el rol del usuario no concede el permiso requerido.
+	ErrForbidden = domain.ErrForbidden
+
+	// Errores de recurso inexistente con un código propio de cada entidad.
+	ErrUserNotFound        = domain.NewNotFoundError("user_not_found", "usuario no encontrado")
+	ErrRoleNotFound        = domain.NewNotFoundError("role_not_found", "rol no encontrado")
+	ErrCompanyNotFound     = domain.NewNotFoundError("company_not_found", "compañía no encontrada")
+	ErrBusNotFound         = domain.NewNotFoundError("bus_not_found", "bus no encontrado")
+	ErrRouteNotFound       = domain.NewNotFoundError("route_not_found", "ruta no encontrada")
+	ErrBusLocationNotFound = domain.NewNotFoundError("bus_location_not_found", "localización no encontrada")
+	ErrDeviceNotFound      = domain.NewNotFoundError("device_not_found", "dispositivo no encontrado")
+	ErrCommandNotFound     = domain.NewNotFoundError("command_not_found", "comando no encontrado")
+
+	// Errores de validación de referencias a entidades inexistentes.
+	ErrUnknownBus       = domain.NewError(domain.KindValidation, "unknown_bus", "bus_id no existe")
+	ErrUnknownRole      = domain.NewError(domain.KindValidation, "unknown_role", "el rol no existe")
+	ErrUnknownCompany   = domain.NewError(domain.KindValidation, "unknown_company", "la compañía no existe")
+	ErrUnknownRoute     = domain.NewError(domain.KindValidation, "unknown_route", "la ruta no existe")
+	ErrUnknownConductor = domain.NewError(domain.KindValidation, "unknown_conductor", "el conductor no existe")
+	ErrNotADriver       = domain.NewError(domain.KindValidation, "not_a_driver", "el usuario no tiene un rol de conductor")
+
+	// Errores de eliminación de entidades que otras siguen referenciando.
+	ErrCompanyInUse = domain.NewConflictError("company_in_use", "la compañía tiene usuarios, buses, rutas o dispositivos")
+	ErrRoleInUse    = domain.NewConflictError("role_in_use", "el rol está asignado a usuarios")
+	// ErrRouteInUse se retorna al eliminar una ruta que tiene buses asignados.
+	ErrRouteInUse = domain.NewConflictError("route_in_use", "la ruta tiene buses asignados")
+	// ErrDriverInUse se retorna al cambiar la compañía de un conductor con buses
+	// asignados o darle un rol que no permite conducir.
+	ErrDriverInUse = domain.NewConflictError("driver_in_use", "el usuario conduce buses")
+	// ErrRoleHasDrivers se retorna al quitar domain.PermBusesDrive a un rol cuyos
+	// usuarios conducen buses.
+	ErrRoleHasDrivers = domain.NewConflictError("role_has_drivers", "usuarios con el rol conducen buses")
+	// ErrBusHasDevices se retorna al eliminar un bus o cambiarlo de compañía
+	// mientras tiene dispositivos activos, que deben darse de baja antes.
+	ErrBusHasDevices = domain.NewConflictError("bus_has_devices", "el bus tiene dispositivos activos")
+
+	// Errores de los parámetros de paginación, orden y filtros de los listados.
+	ErrInvalidLimit  = domain.NewError(domain.KindValidation, "invalid_limit", "el parámetro 'limit' debe ser un entero entre 1 y el máximo")
+	ErrInvalidSort   = domain.NewError(domain.KindValidation, "invalid_sort", "no se puede ordenar por ese campo")
+	ErrInvalidCursor = domain.NewError(domain.KindValidation, "invalid_cursor", "el cursor 'after' no es válido para este listado")
+	ErrUnknownFilter = domain.NewError(domain.KindValidation, "unknown_filter", "no se puede filtrar por ese campo")
+	ErrInvalidFilter = domain.NewError(domain.KindValidation, "invalid_filter", "valor u operador de filtro inválido")
+
+	// Errores de los parámetros de las consultas de historial de localizaciones.
+	ErrInvalidTimeRange   = domain.NewError(domain.KindValidation, "invalid_time_range", "el intervalo de tiempo no es válido")
+	ErrInvalidMaxPoints   = domain.NewError(domain.KindValidation, "invalid_max_points", "el parámetro 'max_points' está fuera de rango")
+	ErrInvalidBoundingBox = domain.NewError(domain.KindValidation, "invalid_bounding_box", "el área no es válida")
+
+	// ErrInvalidCoordinates se retorna cuando una latitud o longitud está fuera de rango.
+	ErrInvalidCoordinates = domain.NewError(domain.KindValidation, "invalid_coordinates", "latitud o longitud fuera de rango")
+	// ErrInvalidRadius se retorna cuando el radio de búsqueda está fuera de rango.
+	ErrInvalidRadius = domain.NewError(domain.KindValidation, "invalid_radius", "el parámetro 'radius' está fuera de rango")
+
+	// ErrUserExists se retorna al registrar o renombrar un usuario con un nombre ya usado.
+	ErrUserExists = domain.NewConflictError("user_exists", "el nombre de usuario ya existe")
+	// ErrDeviceExists se retorna al registrar o editar un dispositivo con un serial ya usado.
+	ErrDeviceExists = domain.NewConflictError("device_exists", "ya existe un dispositivo con ese serial")
+	// ErrDeviceDecommissioned se retorna al modificar un dispositivo dado de baja.
+	ErrDeviceDecommissioned = domain.NewConflictError("device_decommissioned", "el dispositivo está dado de baja")
+
+	// ErrUnknownCommandType se retorna al enviar un comando de un tipo no soportado.
+	ErrUnknownCommandType = domain.NewError(domain.KindValidation, "unknown_command_type", "tipo de comando desconocido")
+	// ErrInvalidCommandParams se retorna cuando los parámetros no corresponden al tipo de comando.
+	ErrInvalidCommandParams = domain.NewError(domain.KindValidation, "invalid_command_params", "parámetros de comando inválidos")
+)
+
+// notFoundAs sustituye un domain.ErrNotFound genérico por el error específico
+// de la entidad; cualquier otro error se retorna sin cambios.
+func notFoundAs(err, target error) error {
+	if errors.Is(err, domain.ErrNotFound) {
+		return target
+	}
+	return err
+}
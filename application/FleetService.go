@@ -0,0 +1,113 @@
+package application
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FleetService mantiene en memoria la última posición de cada bus para
+// consultar la flota en vivo sin ir a la base de datos. Se actualiza con cada
+// localización registrada y se reconstruye desde el repositorio al arrancar.
+// Es seguro para uso concurrente.
+type FleetService struct {
+	Locations domain.BusLocationRepository
+	Buses     domain.BusRepository
+
+	mu        sync.RWMutex
+	positions map[primitive.ObjectID]domain.LivePosition
+}
+
+// NewFleetService crea un FleetService vacío; llama a Rebuild para cargar las
+// posiciones guardadas.
+func NewFleetService(locations domain.BusLocationRepository, buses domain.BusRepository) *FleetService {
+	return &FleetService{Locations: locations, Buses: buses, positions: map[primitive.ObjectID]domain.LivePosition{}}
+}
+
+// Rebuild reemplaza el contenido de la caché por la última localización
+// guardada de cada bus. Se omiten los buses eliminados, cuyas localizaciones
+// se conservan en el historial.
+func (s *FleetService) Rebuild(ctx context.Context) error {
+	latest, err := s.Locations.Latest(ctx, domain.GlobalScope())
+	if err != nil {
+		return err
+	}
+	ids := make([]primitive.ObjectID, 0, len(latest))
+	for _, bl := range latest {
+		ids = append(ids, bl.BusID)
+	}
+	buses, err := s.Buses.GetByIDs(ctx, domain.GlobalScope(), ids)
+	if err != nil {
+		return err
+	}
+	exists := make(map[primitive.ObjectID]bool, len(buses))
+	for _, b := range buses {
+		exists[b.ID] = true
+	}
+	positions := make(map[primitive.ObjectID]domain.LivePosition, len(latest))
+	for _, bl := range latest {
+		if exists[bl.BusID] {
+			positions[bl.BusID] = domain.NextLivePosition(nil, bl)
+		}
+	}
+
+	s.mu.Lock()
+	s.positions = positions
+	s.mu.Unlock()
+	return nil
+}
+
+// Update registra una nueva localización. Las que llegan con una fecha
+// anterior a la posición actual del bus se ignoran.
+func (s *FleetService) Update(bl domain.BusLocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.positions[bl.BusID]
+	if !ok {
+		s.positions[bl.BusID] = domain.NextLivePosition(nil, bl)
+		return
+	}
+	if bl.CreatedAt.Before(prev.At) {
+		return
+	}
+	s.positions[bl.BusID] = domain.NextLivePosition(&prev, bl)
+}
+
+// Remove quita un bus de la caché (p. ej. al eliminarlo).
+func (s *FleetService) Remove(busID primitive.ObjectID) {
+	s.mu.Lock()
+	delete(s.positions, busID)
+	s.mu.Unlock()
+}
+
+// RemoveCompany quita de la caché todos los buses de una compañía.
+func (s *FleetService) RemoveCompany(companiaID primitive.ObjectID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, p := range s.positions {
+		if p.CompaniaID == companiaID {
+			delete(s.positions, id)
+		}
+	}
+}
+
+// LiveFleet retorna la última posición de cada bus visible en el Scope,
+// ordenadas por ID de bus.
+func (s *FleetService) LiveFleet(scope domain.Scope) []domain.LivePosition {
+	s.mu.RLock()
+	fleet := make([]domain.LivePosition, 0, len(s.positions))
+	for _, p := range s.positions {
+		if scope.Allows(p.CompaniaID) {
+			fleet = append(fleet, p)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(fleet, func(i, j int) bool { return fleet[i].BusID.Hex() < fleet[j].BusID.Hex() })
+	return fleet
+}
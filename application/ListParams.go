@@ -0,0 +1,124 @@
+package application
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Límites del tamaño de página de los listados.
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+// ListParams son los parámetros de un listado tal como llegan del API:
+//   - Limit: tamaño de página (por defecto DefaultListLimit, máximo MaxListLimit).
+//   - After: cursor "next" de la página anterior.
+//   - Sort: campo de orden, con prefijo "-" para orden descendente.
+//   - Filters: filtros por campo. La clave es el campo (igualdad) o, en los
+//     campos de fecha, "campo[gte]" / "campo[lte]" para un rango.
+type ListParams struct {
+	Limit   string
+	After   string
+	Sort    string
+	Filters map[string]string
+}
+
+// query valida los parámetros contra los campos de la entidad y construye la
+// domain.ListQuery correspondiente.
+func (p ListParams) query(fields domain.ListFields) (domain.ListQuery, error) {
+	q := domain.ListQuery{Limit: DefaultListLimit}
+
+	if p.Limit != "" {
+		n, err := strconv.Atoi(p.Limit)
+		if err != nil || n < 1 || n > MaxListLimit {
+			return q, ErrInvalidLimit.WithDetails(map[string]any{"max": MaxListLimit})
+		}
+		q.Limit = n
+	}
+
+	if p.Sort != "" {
+		field := strings.TrimPrefix(p.Sort, "-")
+		if f, ok := fields[field]; field != "_id" && (!ok || !f.Sortable) {
+			return q, ErrInvalidSort.WithDetails(map[string]any{"campo": field, "permitidos": sortableFields(fields)})
+		}
+		q.Sort = field
+		q.Desc = field != p.Sort
+	}
+
+	if p.After != "" {
+		c, err := domain.ParseCursor(p.After)
+		if err != nil || !c.ValidFor(q, fields) {
+			return q, ErrInvalidCursor
+		}
+		q.After = c
+	}
+
+	keys := make([]string, 0, len(p.Filters))
+	for k := range p.Filters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f, err := parseFilter(fields, key, p.Filters[key])
+		if err != nil {
+			return q, err
+		}
+		q.Filters = append(q.Filters, f)
+	}
+	return q, nil
+}
+
+// parseFilter convierte un parámetro de filtro ("campo" o "campo[op]") y su
+// valor en un domain.Filter con el tipo del campo.
+func parseFilter(fields domain.ListFields, key, raw string) (domain.Filter, error) {
+	name, op := key, domain.OpEq
+	if base, rest, ok := strings.Cut(key, "["); ok && strings.HasSuffix(rest, "]") {
+		name, op = base, domain.FilterOp(strings.TrimSuffix(rest, "]"))
+	}
+	field, ok := fields[name]
+	if !ok {
+		return domain.Filter{}, ErrUnknownFilter.WithDetails(map[string]any{"campo": name})
+	}
+	if op != domain.OpEq && (field.Type != domain.FieldTime || (op != domain.OpGte && op != domain.OpLte)) {
+		return domain.Filter{}, ErrInvalidFilter.WithDetails(map[string]any{"campo": name, "operador": string(op)})
+	}
+
+	f := domain.Filter{Field: name, Op: op}
+	switch field.Type {
+	case domain.FieldID:
+		id, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return f, ErrInvalidFilter.WithDetails(map[string]any{"campo": name, "valor": raw})
+		}
+		f.Value = id
+	case domain.FieldTime:
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return f, ErrInvalidFilter.WithDetails(map[string]any{"campo": name, "valor": raw})
+		}
+		f.Value = t
+	default:
+		f.Value = raw
+	}
+	return f, nil
+}
+
+// sortableFields retorna, en orden alfabético, los campos por los que se
+// puede ordenar.
+func sortableFields(fields domain.ListFields) []string {
+	out := []string{"_id"}
+	for name, f := range fields {
+		if f.Sortable {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
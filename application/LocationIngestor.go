@@ -0,0 +1,282 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrIngestQueueFull se retorna cuando la cola de ingesta está llena y la
+// posición se descarta.
+var ErrIngestQueueFull = domain.NewError(domain.KindInternal, "ingest_queue_full", "la cola de ingesta de posiciones está llena")
+
+// ErrIngestClosed se retorna al ingresar una posición después de Close.
+var ErrIngestClosed = domain.NewError(domain.KindInternal, "ingest_closed", "la ingesta de posiciones está detenida")
+
+// IngestOptions configura el LocationIngestor.
+type IngestOptions struct {
+	QueueSize     int           // posiciones pendientes de guardar como máximo
+	BatchSize     int           // posiciones por InsertMany
+	FlushInterval time.Duration // espera máxima antes de guardar un lote incompleto
+	BusCacheTTL   time.Duration // vigencia de la comprobación de existencia de un bus
+	DBTimeout     time.Duration // plazo de cada consulta o InsertMany
+}
+
+// IngestStats son las métricas del LocationIngestor.
+type IngestStats struct {
+	Queued    int   // posiciones en cola
+	Capacity  int   // tamaño de la cola
+	Accepted  int64 // posiciones encoladas
+	Rejected  int64 // posiciones inválidas o de buses inexistentes
+	Dropped   int64 // posiciones descartadas por cola llena
+	Persisted int64 // posiciones guardadas
+	Failed    int64 // posiciones de lotes que no se pudieron guardar
+	Batches   int64 // lotes escritos
+	BusCache  int   // buses en la caché de existencia
+}
+
+// busCacheEntry guarda el resultado de buscar un bus: su compañía o, si no
+// existe, found en false para no repetir la consulta en cada paquete.
+type busCacheEntry struct {
+	companiaID primitive.ObjectID
+	found      bool
+	expires    time.Time
+}
+
+// LocationIngestor recibe las posiciones de los dispositivos y las guarda en
+// segundo plano: Ingest valida la posición, actualiza la flota en vivo y la
+// encola sin esperar a la base de datos; Run agrupa la cola en lotes que se
+// escriben con InsertMany al llenarse o al vencer FlushInterval. Si la cola
+// está llena la posición se descarta y se cuenta en las métricas.
+type LocationIngestor struct {
+	locations domain.BusLocationRepository
+	buses     domain.BusRepository
+	fleet     *FleetService
+	opts      IngestOptions
+
+	// closeMu protege el envío a queue frente a su cierre en Close.
+	closeMu sync.RWMutex
+	closed  bool
+	queue   chan domain.BusLocation
+
+	runOnce sync.Once
+	running atomic.Bool
+	stopped chan struct{}
+
+	cacheMu  sync.Mutex
+	busCache map[primitive.ObjectID]busCacheEntry
+
+	accepted, rejected, dropped   atomic.Int64
+	persisted, failed, batchCount atomic.Int64
+}
+
+// NewLocationIngestor crea un LocationIngestor. Hay que llamar a Run para
+// empezar a guardar las posiciones y a Close para vaciar la cola al apagar.
+func NewLocationIngestor(locations domain.BusLocationRepository, buses domain.BusRepository, fleet *FleetService, opts IngestOptions) *LocationIngestor {
+	return &LocationIngestor{
+		locations: locations,
+		buses:     buses,
+		fleet:     fleet,
+		opts:      opts,
+		queue:     make(chan domain.BusLocation, opts.QueueSize),
+		stopped:   make(chan struct{}),
+		busCache:  make(map[primitive.ObjectID]busCacheEntry),
+	}
+}
+
+// Ingest valida una posición de un bus del Scope, actualiza la flota en vivo
+// y la encola para guardarla. Retorna la localización construida (con ID y
+// CreatedAt) o ErrIngestQueueFull si no hay espacio en la cola.
+func (i *LocationIngestor) Ingest(scope domain.Scope, busIDHex string, lat, lng float64, velocidad, rumbo *float64) (*domain.BusLocation, error) {
+	bl, err := newBusLocation(busIDHex, lat, lng, velocidad, rumbo)
+	if err != nil {
+		i.rejected.Add(1)
+		return nil, err
+	}
+	if bl.CompaniaID, err = i.busCompania(scope, bl.BusID); err != nil {
+		i.rejected.Add(1)
+		return nil, err
+	}
+	bl.ID = primitive.NewObjectID()
+	bl.CreatedAt = time.Now()
+
+	i.closeMu.RLock()
+	defer i.closeMu.RUnlock()
+	if i.closed {
+		return nil, ErrIngestClosed
+	}
+	select {
+	case i.queue <- *bl:
+		i.accepted.Add(1)
+	default:
+		i.dropped.Add(1)
+		return nil, ErrIngestQueueFull
+	}
+	i.fleet.Update(*bl)
+	return bl, nil
+}
+
+// ResolveBus verifica, con la caché de buses, que el bus exista dentro del
+// Scope. Retorna su ID o ErrUnknownBus.
+func (i *LocationIngestor) ResolveBus(scope domain.Scope, busIDHex string) (primitive.ObjectID, error) {
+	busID, err := primitive.ObjectIDFromHex(busIDHex)
+	if err != nil {
+		return primitive.NilObjectID, domain.NewValidationError("busID inválido")
+	}
+	if _, err := i.busCompania(scope, busID); err != nil {
+		return primitive.NilObjectID, err
+	}
+	return busID, nil
+}
+
+// busCompania retorna la compañía de un bus del Scope, consultando el
+// repositorio solo si el resultado no está en la caché o ya venció. La caché
+// es global; el Scope se comprueba sobre la compañía guardada.
+func (i *LocationIngestor) busCompania(scope domain.Scope, busID primitive.ObjectID) (primitive.ObjectID, error) {
+	now := time.Now()
+	i.cacheMu.Lock()
+	e, ok := i.busCache[busID]
+	i.cacheMu.Unlock()
+
+	if !ok || now.After(e.expires) {
+		ctx, cancel := context.WithTimeout(context.Background(), i.opts.DBTimeout)
+		defer cancel()
+		bus, err := i.buses.GetByID(ctx, domain.GlobalScope(), busID)
+		switch {
+		case err == nil:
+			e = busCacheEntry{companiaID: bus.CompaniaID, found: true}
+		case errors.Is(err, domain.ErrNotFound):
+			e = busCacheEntry{found: false}
+		default:
+			return primitive.NilObjectID, err
+		}
+		e.expires = now.Add(i.opts.BusCacheTTL)
+
+		i.cacheMu.Lock()
+		i.busCache[busID] = e
+		i.cacheMu.Unlock()
+	}
+	if !e.found || !scope.Allows(e.companiaID) {
+		return primitive.NilObjectID, ErrUnknownBus
+	}
+	return e.companiaID, nil
+}
+
+// pruneBusCache elimina las entradas vencidas de la caché de buses, para que
+// los IDs inexistentes no la hagan crecer sin límite.
+func (i *LocationIngestor) pruneBusCache() {
+	now := time.Now()
+	i.cacheMu.Lock()
+	defer i.cacheMu.Unlock()
+	for id, e := range i.busCache {
+		if now.After(e.expires) {
+			delete(i.busCache, id)
+		}
+	}
+}
+
+// Run guarda las posiciones encoladas hasta que se llame a Close y la cola
+// quede vacía. Solo la primera llamada tiene efecto.
+func (i *LocationIngestor) Run() {
+	i.runOnce.Do(i.loop)
+}
+
+func (i *LocationIngestor) loop() {
+	defer close(i.stopped)
+	i.running.Store(true)
+	defer i.running.Store(false)
+
+	ticker := time.NewTicker(i.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]domain.BusLocation, 0, i.opts.BatchSize)
+	for {
+		select {
+		case bl, ok := <-i.queue:
+			if !ok {
+				i.flush(batch)
+				return
+			}
+			batch = append(batch, bl)
+			if len(batch) >= i.opts.BatchSize {
+				i.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				i.flush(batch)
+				batch = batch[:0]
+			}
+			i.pruneBusCache()
+		}
+	}
+}
+
+// flush escribe un lote. Si falla, las posiciones se descartan y se cuentan
+// como fallidas; siguen disponibles en la flota en vivo.
+func (i *LocationIngestor) flush(batch []domain.BusLocation) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), i.opts.DBTimeout)
+	defer cancel()
+
+	i.batchCount.Add(1)
+	if err := i.locations.CreateMany(ctx, batch); err != nil {
+		log.Printf("Ingesta: error al guardar un lote de %d posiciones: %v", len(batch), err)
+		i.failed.Add(int64(len(batch)))
+		return
+	}
+	i.persisted.Add(int64(len(batch)))
+}
+
+// Close deja de aceptar posiciones y espera a que se guarde lo que queda en
+// la cola, o a que venza ctx. Si Run no se llegó a llamar, vacía la cola
+// igualmente. Es seguro llamarlo más de una vez.
+func (i *LocationIngestor) Close(ctx context.Context) error {
+	i.closeMu.Lock()
+	if !i.closed {
+		i.closed = true
+		close(i.queue)
+	}
+	i.closeMu.Unlock()
+
+	go i.Run()
+
+	select {
+	case <-i.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Running indica si el proceso de escritura está en ejecución.
+func (i *LocationIngestor) Running() bool {
+	return i.running.Load()
+}
+
+// Stats retorna las métricas actuales de la ingesta.
+func (i *LocationIngestor) Stats() IngestStats {
+	i.cacheMu.Lock()
+	cached := len(i.busCache)
+	i.cacheMu.Unlock()
+	return IngestStats{
+		Queued:    len(i.queue),
+		Capacity:  cap(i.queue),
+		Accepted:  i.accepted.Load(),
+		Rejected:  i.rejected.Load(),
+		Dropped:   i.dropped.Load(),
+		Persisted: i.persisted.Load(),
+		Failed:    i.failed.Load(),
+		Batches:   i.batchCount.Load(),
+		BusCache:  cached,
+	}
+}
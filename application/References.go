@@ -0,0 +1,82 @@
+package application
+
+import (
+	"context"
+	"errors"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// References valida que las entidades a las que apunta otra (rol, compañía,
+// ruta, conductor) existan antes de crearla o editarla.
+type References struct {
+	Users     domain.UserRepository
+	Roles     domain.RoleRepository
+	Companies domain.CompanyRepository
+	Routes    domain.RouteRepository
+}
+
+// NewReferences crea una nueva instancia de References.
+func NewReferences(users domain.UserRepository, roles domain.RoleRepository, companies domain.CompanyRepository, routes domain.RouteRepository) *References {
+	return &References{Users: users, Roles: roles, Companies: companies, Routes: routes}
+}
+
+// Company verifica que la compañía exista.
+func (r *References) Company(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.Companies.GetByID(ctx, domain.GlobalScope(), id)
+	return unknownAs(err, ErrUnknownCompany)
+}
+
+// Compania resuelve la compañía de un recurso nuevo o editado igual que
+// resolveCompania y, si se indicó de forma explícita, verifica que exista.
+func (r *References) Compania(ctx context.Context, scope domain.Scope, companiaIDHex string) (primitive.ObjectID, error) {
+	id, err := resolveCompania(scope, companiaIDHex)
+	if err != nil || companiaIDHex == "" {
+		return id, err
+	}
+	return id, r.Company(ctx, id)
+}
+
+// Role verifica que el rol exista y lo retorna.
+func (r *References) Role(ctx context.Context, id primitive.ObjectID) (*domain.Role, error) {
+	role, err := r.Roles.GetByID(ctx, id)
+	return role, unknownAs(err, ErrUnknownRole)
+}
+
+// Route verifica que la ruta exista y pertenezca a la compañía indicada.
+func (r *References) Route(ctx context.Context, companiaID, id primitive.ObjectID) error {
+	_, err := r.Routes.GetByID(ctx, domain.CompanyScope(companiaID), id)
+	return unknownAs(err, ErrUnknownRoute)
+}
+
+// Driver verifica que el usuario exista, pertenezca a la compañía indicada y
+// que su rol conceda domain.PermBusesDrive.
+func (r *References) Driver(ctx context.Context, companiaID, id primitive.ObjectID) error {
+	user, err := r.Users.GetByID(ctx, id)
+	if err != nil {
+		return unknownAs(err, ErrUnknownConductor)
+	}
+	if user.Compania != companiaID {
+		return ErrUnknownConductor
+	}
+	role, err := r.Roles.GetByID(ctx, user.RolID)
+	if err != nil {
+		return unknownAs(err, ErrNotADriver)
+	}
+	if !role.HasPermission(domain.PermBusesDrive) {
+		return ErrNotADriver
+	}
+	return nil
+}
+
+// unknownAs sustituye un domain.ErrNotFound por el error de validación
+// indicado: que una referencia no exista es un error en los datos de entrada,
+// no un recurso inexistente.
+func unknownAs(err, target error) error {
+	if errors.Is(err, domain.ErrNotFound) {
+		return target
+	}
+	return err
+}
@@ -4,72 +4,95 @@ package application
 
 import (
 	"context"
-	"errors"
+	"fmt"
 
 	"UbicaBus/UbicaBusBackend/domain"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // RoleService maneja la lógica de negocio relacionada con los roles.
 type RoleService struct {
-	DB *mongo.Database
+	Roles domain.RoleRepository
+	Users domain.UserRepository
+	Buses domain.BusRepository
+	Tx    domain.Transactor
 }
 
 // NewRoleService crea una nueva instancia de RoleService.
-func NewRoleService(db *mongo.Database) *RoleService {
-	return &RoleService{DB: db}
+func NewRoleService(roles domain.RoleRepository, users domain.UserRepository, buses domain.BusRepository, tx domain.Transactor) *RoleService {
+	return &RoleService{Roles: roles, Users: users, Buses: buses, Tx: tx}
 }
 
-// GetAllRoles obtiene todos los roles.
-func (s *RoleService) GetAllRoles() ([]domain.Role, error) {
-	return domain.GetAllRoles(context.TODO(), s.DB)
+// GetAllRoles obtiene una página de los roles.
+func (s *RoleService) GetAllRoles(p ListParams) (*domain.Page[domain.Role], error) {
+	q, err := p.query(domain.RoleFields)
+	if err != nil {
+		return nil, err
+	}
+	return s.Roles.List(context.TODO(), q)
 }
 
 // GetRoleByID busca un rol por su ID.
 func (s *RoleService) GetRoleByID(idHex string) (*domain.Role, error) {
 	if idHex == "" {
-		return nil, errors.New("ID de rol es obligatorio")
+		return nil, domain.NewValidationError("ID de rol es obligatorio")
 	}
 	id, err := primitive.ObjectIDFromHex(idHex)
 	if err != nil {
-		return nil, errors.New("ID de rol inválido")
+		return nil, domain.NewValidationError("ID de rol inválido")
 	}
-	return domain.GetRoleByID(context.TODO(), s.DB, id)
+	role, err := s.Roles.GetByID(context.TODO(), id)
+	return role, notFoundAs(err, ErrRoleNotFound)
 }
 
 // SearchRolesByName busca roles por nombre exacto.
 func (s *RoleService) SearchRolesByName(nombre string) ([]domain.Role, error) {
 	if nombre == "" {
-		return nil, errors.New("el nombre del rol es obligatorio")
+		return nil, domain.NewValidationError("el nombre del rol es obligatorio")
 	}
-	return domain.GetRolesByName(context.TODO(), s.DB, nombre)
+	return s.Roles.GetByName(context.TODO(), nombre)
 }
 
-// RegisterRole crea un nuevo rol.
-func (s *RoleService) RegisterRole(nombre, descripcion string) (primitive.ObjectID, error) {
+// RegisterRole crea un nuevo rol. Los roles se comparten entre compañías,
+// por lo que solo un super-admin puede crearlos.
+func (s *RoleService) RegisterRole(scope domain.Scope, nombre, descripcion string, permisos []string) (primitive.ObjectID, error) {
+	if err := requireAllCompanies(scope); err != nil {
+		return primitive.NilObjectID, err
+	}
 	if nombre == "" {
-		return primitive.NilObjectID, errors.New("el nombre es obligatorio")
+		return primitive.NilObjectID, domain.NewValidationError("el nombre es obligatorio")
+	}
+	if err := validatePermisos(permisos); err != nil {
+		return primitive.NilObjectID, err
+	}
+	if permisos == nil {
+		permisos = []string{}
 	}
 	r := domain.Role{
 		Nombre:      nombre,
 		Descripcion: descripcion,
+		Permisos:    permisos,
 	}
-	if err := domain.CrearRole(context.TODO(), s.DB, &r); err != nil {
+	if err := s.Roles.Create(context.TODO(), &r); err != nil {
 		return primitive.NilObjectID, err
 	}
 	return r.ID, nil
 }
 
-// EditRole actualiza un rol existente.
-func (s *RoleService) EditRole(idHex, nombre, descripcion string) (*domain.Role, error) {
+// EditRole actualiza un rol existente. Si permisos es nil se conservan los actuales.
+// Solo disponible para super-admin. No se puede quitar domain.PermBusesDrive
+// a un rol cuyos usuarios conducen buses (ErrRoleHasDrivers).
+func (s *RoleService) EditRole(scope domain.Scope, idHex, nombre, descripcion string, permisos []string) (*domain.Role, error) {
+	if err := requireAllCompanies(scope); err != nil {
+		return nil, err
+	}
 	if idHex == "" {
-		return nil, errors.New("ID de rol es obligatorio")
+		return nil, domain.NewValidationError("ID de rol es obligatorio")
 	}
 	id, err := primitive.ObjectIDFromHex(idHex)
 	if err != nil {
-		return nil, errors.New("ID de rol inválido")
+		return nil, domain.NewValidationError("ID de rol inválido")
 	}
 	r := &domain.Role{ID: id}
 	if nombre != "" {
@@ -78,17 +101,101 @@ func (s *RoleService) EditRole(idHex, nombre, descripcion string) (*domain.Role,
 	if descripcion != "" {
 		r.Descripcion = descripcion
 	}
-	return domain.EditarRole(context.TODO(), s.DB, r)
+	if permisos != nil {
+		if err := validatePermisos(permisos); err != nil {
+			return nil, err
+		}
+		r.Permisos = permisos
+	}
+
+	var updated *domain.Role
+	err = s.Tx.WithTransaction(context.TODO(), func(ctx context.Context) error {
+		if permisos != nil && !r.HasPermission(domain.PermBusesDrive) {
+			if err := s.checkNoDrivers(ctx, id); err != nil {
+				return err
+			}
+		}
+		var err error
+		updated, err = s.Roles.Update(ctx, r)
+		return notFoundAs(err, ErrRoleNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// checkNoDrivers retorna ErrRoleHasDrivers si algún usuario con el rol
+// conduce buses.
+func (s *RoleService) checkNoDrivers(ctx context.Context, id primitive.ObjectID) error {
+	users, err := s.Users.Search(ctx, domain.GlobalScope(), domain.UserFilter{RolID: id})
+	if err != nil || len(users) == 0 {
+		return err
+	}
+	ids := make([]primitive.ObjectID, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	n, err := s.Buses.CountByConductor(ctx, ids)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrRoleHasDrivers.WithDetails(map[string]any{"buses": n})
+	}
+	return nil
 }
 
-// DeleteRole elimina un rol por su ID.
-func (s *RoleService) DeleteRole(idHex string) error {
+// DeleteRole elimina un rol por su ID. Solo disponible para super-admin.
+// Si hay usuarios con el rol retorna ErrRoleInUse, salvo que cascade sea
+// true, en cuyo caso se eliminan esos usuarios y se desasignan de los buses
+// que conducían. Todo ocurre en una misma transacción.
+func (s *RoleService) DeleteRole(scope domain.Scope, idHex string, cascade bool) error {
+	if err := requireAllCompanies(scope); err != nil {
+		return err
+	}
 	if idHex == "" {
-		return errors.New("ID de rol es obligatorio")
+		return domain.NewValidationError("ID de rol es obligatorio")
 	}
 	id, err := primitive.ObjectIDFromHex(idHex)
 	if err != nil {
-		return errors.New("ID de rol inválido")
+		return domain.NewValidationError("ID de rol inválido")
+	}
+
+	return s.Tx.WithTransaction(context.TODO(), func(ctx context.Context) error {
+		if _, err := s.Roles.GetByID(ctx, id); err != nil {
+			return notFoundAs(err, ErrRoleNotFound)
+		}
+
+		users, err := s.Users.Search(ctx, domain.GlobalScope(), domain.UserFilter{RolID: id})
+		if err != nil {
+			return err
+		}
+		if len(users) > 0 {
+			if !cascade {
+				return ErrRoleInUse.WithDetails(map[string]any{"usuarios": len(users)})
+			}
+			for _, u := range users {
+				if _, err := s.Buses.UnassignConductor(ctx, u.ID); err != nil {
+					return err
+				}
+			}
+			if _, err := s.Users.DeleteByRol(ctx, id); err != nil {
+				return err
+			}
+		}
+
+		return notFoundAs(s.Roles.Delete(ctx, id), ErrRoleNotFound)
+	})
+}
+
+// validatePermisos verifica que todos los permisos sean reconocidos.
+func validatePermisos(permisos []string) error {
+	for _, p := range permisos {
+		if !domain.IsValidPermission(p) {
+			return domain.NewError(domain.KindValidation, "unknown_permission", fmt.Sprintf("permiso desconocido: %s", p)).
+				WithDetails(map[string]any{"permiso": p})
+		}
 	}
-	return domain.DeleteRole(context.TODO(), s.DB, id)
+	return nil
 }
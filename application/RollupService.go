@@ -0,0 +1,235 @@
+package application
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+)
+
+// rollupChunk es el tramo de localizaciones que se recorre en cada consulta,
+// y rollupBatch el número de resúmenes que se guardan en cada Upsert.
+const (
+	rollupChunk = time.Hour
+	rollupBatch = 500
+)
+
+// RollupOptions configura el RollupService.
+type RollupOptions struct {
+	Interval  time.Duration // cada cuánto se compactan las posiciones nuevas
+	Lag       time.Duration // margen para las posiciones que llegan con retraso
+	MaxPoints int           // puntos conservados por bus y minuto
+}
+
+// RollupService compacta periódicamente las localizaciones en resúmenes por
+// bus y minuto, que se conservan después de que el índice TTL elimine las
+// originales. Cada pasada procesa los minutos completos desde la anterior;
+// las posiciones que llegan con más retraso que Lag no entran en el resumen.
+type RollupService struct {
+	locations domain.BusLocationRepository
+	rollups   domain.BusLocationRollupRepository
+	opts      RollupOptions
+
+	// next es el primer minuto sin compactar; cero hasta la primera pasada.
+	mu   sync.Mutex
+	next time.Time
+
+	runOnce  sync.Once
+	running  atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// NewRollupService crea un RollupService. Hay que llamar a Run para iniciar
+// las pasadas periódicas y a Stop para detenerlas.
+func NewRollupService(locations domain.BusLocationRepository, rollups domain.BusLocationRollupRepository, opts RollupOptions) *RollupService {
+	return &RollupService{
+		locations: locations,
+		rollups:   rollups,
+		opts:      opts,
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// RollupOnce compacta las localizaciones de los minutos completos anteriores
+// a now - Lag que aún no se hayan compactado. Retorna cuántos resúmenes guardó.
+func (s *RollupService) RollupOnce(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start, err := s.start(ctx)
+	if err != nil || start.IsZero() {
+		return 0, err
+	}
+	end := now.Add(-s.opts.Lag).Truncate(time.Minute)
+
+	saved := 0
+	for from := start; from.Before(end); from = from.Add(rollupChunk) {
+		to := from.Add(rollupChunk)
+		if to.After(end) {
+			to = end
+		}
+		n, err := s.rollupRange(ctx, from, to)
+		saved += n
+		if err != nil {
+			return saved, err
+		}
+		s.next = to
+	}
+	return saved, nil
+}
+
+// start retorna el primer minuto sin compactar: el siguiente al último
+// resumen guardado o, si no hay ninguno, el de la localización más antigua.
+// Retorna el tiempo cero si no hay localizaciones.
+func (s *RollupService) start(ctx context.Context) (time.Time, error) {
+	if !s.next.IsZero() {
+		return s.next, nil
+	}
+	last, err := s.rollups.LastMinute(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !last.IsZero() {
+		return last.Add(time.Minute), nil
+	}
+	page, err := s.locations.List(ctx, domain.GlobalScope(), domain.ListQuery{Sort: "created_at", Limit: 1})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(page.Items) == 0 {
+		return time.Time{}, nil
+	}
+	return page.Items[0].CreatedAt.Truncate(time.Minute), nil
+}
+
+// rollupRange resume las localizaciones de [from, to), que Scan entrega
+// agrupadas por bus y en orden cronológico.
+func (s *RollupService) rollupRange(ctx context.Context, from, to time.Time) (int, error) {
+	var (
+		group []domain.BusLocation
+		batch []domain.BusLocationRollup
+		saved int
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.rollups.Upsert(ctx, batch); err != nil {
+			return err
+		}
+		saved += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	closeGroup := func() error {
+		if len(group) == 0 {
+			return nil
+		}
+		batch = append(batch, domain.NewBusLocationRollup(group, s.opts.MaxPoints))
+		group = group[:0]
+		if len(batch) >= rollupBatch {
+			return flush()
+		}
+		return nil
+	}
+
+	err := s.locations.Scan(ctx, from, to, func(bl domain.BusLocation) error {
+		if len(group) > 0 {
+			prev := group[0]
+			if prev.BusID != bl.BusID || !prev.CreatedAt.Truncate(time.Minute).Equal(bl.CreatedAt.Truncate(time.Minute)) {
+				if err := closeGroup(); err != nil {
+					return err
+				}
+			}
+		}
+		group = append(group, bl)
+		return nil
+	})
+	if err != nil {
+		return saved, err
+	}
+	if err := closeGroup(); err != nil {
+		return saved, err
+	}
+	return saved, flush()
+}
+
+// Run ejecuta una pasada cada Interval hasta que se llame a Stop. Solo la
+// primera llamada tiene efecto.
+func (s *RollupService) Run() {
+	s.runOnce.Do(s.loop)
+}
+
+func (s *RollupService) loop() {
+	defer close(s.stopped)
+	s.running.Store(true)
+	defer s.running.Store(false)
+
+	select {
+	case <-s.stop:
+		return
+	default:
+	}
+
+	// Stop cancela la pasada en curso; lo ya guardado no se repite.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		s.runPass(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// runPass ejecuta una pasada con un plazo de Interval, de modo que una
+// pasada larga (p. ej. la primera) continúa en la siguiente.
+func (s *RollupService) runPass(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, s.opts.Interval)
+	defer cancel()
+	n, err := s.RollupOnce(ctx, time.Now())
+	if err != nil {
+		log.Printf("Resúmenes de localizaciones: error tras guardar %d: %v", n, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Resúmenes de localizaciones: %d guardados", n)
+	}
+}
+
+// Stop detiene las pasadas periódicas y espera a que termine la que esté en
+// curso, o a que venza ctx. Es seguro llamarlo más de una vez, aunque Run no
+// se haya llamado.
+func (s *RollupService) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	go s.Run()
+
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Running indica si las pasadas periódicas están en ejecución.
+func (s *RollupService) Running() bool {
+	return s.running.Load()
+}
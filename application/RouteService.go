@@ -2,41 +2,54 @@ package application
 
 import (
 	"context"
-	"errors"
 
 	"UbicaBus/UbicaBusBackend/domain"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // RouteService maneja la lógica de negocio relacionada con las rutas.
-// Ahora trabaja directamente con las funciones de dominio para crear y editar rutas.
 type RouteService struct {
-	DB *mongo.Database
+	Routes domain.RouteRepository
+	Buses  domain.BusRepository
+	Refs   *References
+	Tx     domain.Transactor
 }
 
 // NewRouteService crea una nueva instancia de RouteService
-func NewRouteService(db *mongo.Database) *RouteService {
-	return &RouteService{DB: db}
+func NewRouteService(routes domain.RouteRepository, buses domain.BusRepository, refs *References, tx domain.Transactor) *RouteService {
+	return &RouteService{Routes: routes, Buses: buses, Refs: refs, Tx: tx}
 }
 
-// GetAllRoutes obtiene todas las rutas de la base de datos.
-func (s *RouteService) GetAllRoutes() ([]domain.Route, error) {
-	// Asumimos que existe un método en dominio para listar rutas.
-	// Si no, se podría implementar usando s.DB.Collection("ruta").Find(...)
-	return domain.GetAllRoutes(context.TODO(), s.DB)
+// GetAllRoutes obtiene una página de las rutas visibles en el Scope.
+func (s *RouteService) GetAllRoutes(scope domain.Scope, p ListParams) (*domain.Page[domain.Route], error) {
+	q, err := p.query(domain.RouteFields)
+	if err != nil {
+		return nil, err
+	}
+	return s.Routes.List(context.TODO(), scope, q)
 }
 
-// RegisterRoute crea una nueva ruta con los datos proporcionados.
+// RegisterRoute crea una nueva ruta con los datos proporcionados en la
+// compañía indicada (o en la del Scope).
 func (s *RouteService) RegisterRoute(
-	nombre, descripcion, modoTransporte string,
+	scope domain.Scope,
+	nombre, descripcion, modoTransporte, companiaIDHex string,
 	origenLat, origenLng, destinoLat, destinoLng float64,
 	waypoints []domain.Waypoint,
 ) (primitive.ObjectID, error) {
 	// Validaciones básicas
 	if nombre == "" || modoTransporte == "" {
-		return primitive.NilObjectID, errors.New("nombre y modo de transporte son obligatorios")
+		return primitive.NilObjectID, domain.NewValidationError("nombre y modo de transporte son obligatorios")
+	}
+	origen := domain.Location{Lat: origenLat, Lng: origenLng}
+	destino := domain.Location{Lat: destinoLat, Lng: destinoLng}
+	if err := validateRoutePoints(&origen, &destino, waypoints); err != nil {
+		return primitive.NilObjectID, err
+	}
+	companiaID, err := s.Refs.Compania(context.TODO(), scope, companiaIDHex)
+	if err != nil {
+		return primitive.NilObjectID, err
 	}
 
 	// Construir la entidad de dominio
@@ -44,38 +57,38 @@ func (s *RouteService) RegisterRoute(
 		Nombre:         nombre,
 		Descripcion:    descripcion,
 		ModoTransporte: modoTransporte,
-		Origen: domain.Location{
-			Lat: origenLat,
-			Lng: origenLng,
-		},
-		Destino: domain.Location{
-			Lat: destinoLat,
-			Lng: destinoLng,
-		},
-		Waypoints: waypoints,
+		Origen:         origen,
+		Destino:        destino,
+		Waypoints:      waypoints,
+		CompaniaID:     companiaID,
 	}
 
 	// Insertar en la base de datos
-	if err := domain.CrearRoute(context.TODO(), s.DB, &route); err != nil {
+	if err := s.Routes.Create(context.TODO(), &route); err != nil {
 		return primitive.NilObjectID, err
 	}
 
 	return route.ID, nil
 }
 
-// EditRoute actualiza una ruta existente con los campos proporcionados.
+// EditRoute actualiza una ruta existente del Scope con los campos proporcionados.
+// Si cambia la compañía de una ruta con buses asignados retorna ErrRouteInUse.
 func (s *RouteService) EditRoute(
-	idHex, nombre, descripcion, modoTransporte string,
+	scope domain.Scope,
+	idHex, nombre, descripcion, modoTransporte, companiaIDHex string,
 	origen *domain.Location, destino *domain.Location,
 	waypoints []domain.Waypoint,
 ) (*domain.Route, error) {
 	// Validar ID
 	if idHex == "" {
-		return nil, errors.New("ID de ruta es obligatorio")
+		return nil, domain.NewValidationError("ID de ruta es obligatorio")
 	}
 	id, err := primitive.ObjectIDFromHex(idHex)
 	if err != nil {
-		return nil, errors.New("ID de ruta inválido")
+		return nil, domain.NewValidationError("ID de ruta inválido")
+	}
+	if err := validateRoutePoints(origen, destino, waypoints); err != nil {
+		return nil, err
 	}
 
 	// Preparar entidad con solo los campos a actualizar
@@ -98,9 +111,36 @@ func (s *RouteService) EditRoute(
 	if len(waypoints) > 0 {
 		r.Waypoints = waypoints
 	}
+	if companiaIDHex != "" {
+		companiaID, err := s.Refs.Compania(context.TODO(), scope, companiaIDHex)
+		if err != nil {
+			return nil, err
+		}
+		r.CompaniaID = companiaID
+	}
 
-	// Llamar a la función de dominio para actualizar
-	updated, err := domain.EditarRoute(context.TODO(), s.DB, r)
+	// Actualizar en la base de datos
+	var updated *domain.Route
+	err = s.Tx.WithTransaction(context.TODO(), func(ctx context.Context) error {
+		if !r.CompaniaID.IsZero() {
+			current, err := s.Routes.GetByID(ctx, scope, id)
+			if err != nil {
+				return notFoundAs(err, ErrRouteNotFound)
+			}
+			if current.CompaniaID != r.CompaniaID {
+				buses, err := s.Buses.CountByRuta(ctx, id)
+				if err != nil {
+					return err
+				}
+				if buses > 0 {
+					return ErrRouteInUse.WithDetails(map[string]any{"buses": buses})
+				}
+			}
+		}
+		var err error
+		updated, err = s.Routes.Update(ctx, scope, r)
+		return notFoundAs(err, ErrRouteNotFound)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -108,10 +148,74 @@ func (s *RouteService) EditRoute(
 	return updated, nil
 }
 
-func (s *RouteService) GetRoutesByName(nombre string) ([]domain.Route, error) {
+// GetRoutesByName busca rutas por nombre exacto dentro del Scope.
+func (s *RouteService) GetRoutesByName(scope domain.Scope, nombre string) ([]domain.Route, error) {
 	if nombre == "" {
-		return nil, errors.New("el nombre de la ruta es obligatorio")
+		return nil, domain.NewValidationError("el nombre de la ruta es obligatorio")
 	}
 
-	return domain.GetRoutesByName(context.TODO(), s.DB, nombre)
-}
\ No newline at end of file
+	return s.Routes.GetByName(context.TODO(), scope, nombre)
+}
+
+// GetRouteByID busca una ruta del Scope por su ID.
+func (s *RouteService) GetRouteByID(scope domain.Scope, idHex string) (*domain.Route, error) {
+	if idHex == "" {
+		return nil, domain.NewValidationError("ID de ruta es obligatorio")
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return nil, domain.NewValidationError("ID de ruta inválido")
+	}
+	route, err := s.Routes.GetByID(context.TODO(), scope, id)
+	return route, notFoundAs(err, ErrRouteNotFound)
+}
+
+// DeleteRoute elimina una ruta del Scope. Si hay buses asignados a la ruta
+// retorna ErrRouteInUse, salvo que cascade sea true, en cuyo caso primero se
+// desasigna la ruta de esos buses. Todo ocurre en una misma transacción.
+func (s *RouteService) DeleteRoute(scope domain.Scope, idHex string, cascade bool) error {
+	if idHex == "" {
+		return domain.NewValidationError("ID de ruta es obligatorio")
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return domain.NewValidationError("ID de ruta inválido")
+	}
+
+	return s.Tx.WithTransaction(context.TODO(), func(ctx context.Context) error {
+		if _, err := s.Routes.GetByID(ctx, scope, id); err != nil {
+			return notFoundAs(err, ErrRouteNotFound)
+		}
+
+		buses, err := s.Buses.CountByRuta(ctx, id)
+		if err != nil {
+			return err
+		}
+		if buses > 0 {
+			if !cascade {
+				return ErrRouteInUse.WithDetails(map[string]any{"buses": buses})
+			}
+			if _, err := s.Buses.UnassignRuta(ctx, id); err != nil {
+				return err
+			}
+		}
+
+		return notFoundAs(s.Routes.Delete(ctx, scope, id), ErrRouteNotFound)
+	})
+}
+
+// validateRoutePoints comprueba que el origen, el destino (si se indican) y
+// los waypoints de una ruta tengan coordenadas válidas.
+func validateRoutePoints(origen, destino *domain.Location, waypoints []domain.Waypoint) error {
+	for _, l := range []*domain.Location{origen, destino} {
+		if l != nil && !l.Valid() {
+			return ErrInvalidCoordinates
+		}
+	}
+	for i, w := range waypoints {
+		if !(domain.Location{Lat: w.Lat, Lng: w.Lng}).Valid() {
+			return ErrInvalidCoordinates.WithDetails(map[string]any{"waypoint": i})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package application
+
+import (
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// resolveCompania determina la compañía de un recurso nuevo o editado.
+// Los usuarios normales solo pueden usar su propia compañía; los super-admin
+// pueden indicar cualquiera. Si no se indica ninguna se usa la del Scope.
+func resolveCompania(scope domain.Scope, companiaIDHex string) (primitive.ObjectID, error) {
+	if companiaIDHex == "" {
+		if scope.CompaniaID.IsZero() {
+			return primitive.NilObjectID, domain.NewValidationError("ID de compañía es obligatorio")
+		}
+		return scope.CompaniaID, nil
+	}
+	id, err := primitive.ObjectIDFromHex(companiaIDHex)
+	if err != nil {
+		return primitive.NilObjectID, domain.NewValidationError("ID de compañía inválido")
+	}
+	if !scope.Allows(id) {
+		return primitive.NilObjectID, ErrForbidden
+	}
+	return id, nil
+}
+
+// requireAllCompanies exige un Scope de super-admin para operaciones que
+// afectan a todas las compañías.
+func requireAllCompanies(scope domain.Scope) error {
+	if !scope.AllCompanies {
+		return ErrForbidden
+	}
+	return nil
+}
@@ -7,38 +7,54 @@ import (
 	"UbicaBus/UbicaBusBackend/domain"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // UserService maneja la lógica de negocio relacionada con los usuarios.
 type UserService struct {
-	DB *mongo.Database
+	Users  domain.UserRepository
+	Buses  domain.BusRepository
+	Hasher domain.PasswordHasher
+	Refs   *References
+	Tx     domain.Transactor
 }
 
 // NewUserService crea una nueva instancia de UserService
-func NewUserService(db *mongo.Database) *UserService {
-	return &UserService{DB: db}
+func NewUserService(users domain.UserRepository, buses domain.BusRepository, hasher domain.PasswordHasher, refs *References, tx domain.Transactor) *UserService {
+	return &UserService{Users: users, Buses: buses, Hasher: hasher, Refs: refs, Tx: tx}
 }
 
 // RegisterUser registra un nuevo usuario en la base de datos.
-func (s *UserService) RegisterUser(nombre, password, rolID, companiaID string) (primitive.ObjectID, error) {
-	if nombre == "" || password == "" || rolID == "" || companiaID == "" {
-		return primitive.NilObjectID, errors.New("todos los campos son obligatorios")
+// Si no se indica compañía se usa la del Scope. caller es el rol de quien
+// registra al usuario, que debe conceder todos los permisos del rol asignado.
+func (s *UserService) RegisterUser(scope domain.Scope, caller *domain.Role, nombre, password, rolID, companiaID string) (primitive.ObjectID, error) {
+	if nombre == "" || password == "" || rolID == "" {
+		return primitive.NilObjectID, domain.NewValidationError("nombre, contraseña y rol son obligatorios")
 	}
 
 	// Convertir a ObjectID
 	rolObjID, err := primitive.ObjectIDFromHex(rolID)
 	if err != nil {
-		return primitive.NilObjectID, errors.New("ID de rol inválido")
+		return primitive.NilObjectID, domain.NewValidationError("ID de rol inválido")
 	}
 
-	companiaObjID, err := primitive.ObjectIDFromHex(companiaID)
+	if _, err := s.checkRoleAssignable(scope, caller, rolObjID); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	if err := s.checkNombreAvailable(nombre, primitive.NilObjectID); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	companiaObjID, err := s.Refs.Compania(context.TODO(), scope, companiaID)
 	if err != nil {
-		return primitive.NilObjectID, errors.New("ID de compañía inválido")
+		return primitive.NilObjectID, err
 	}
 
 	// Encriptar contraseña
-	hashedPassword := domain.HashPassword(password)
+	hashedPassword, err := s.Hasher.Hash(password)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
 
 	// Crear usuario
 	user := domain.User{
@@ -49,7 +65,7 @@ func (s *UserService) RegisterUser(nombre, password, rolID, companiaID string) (
 	}
 
 	// Insertar en la BD
-	err = domain.CrearUsuario(context.TODO(), s.DB, &user)
+	err = s.Users.Create(context.TODO(), &user)
 	if err != nil {
 		return primitive.NilObjectID, err
 	}
@@ -57,45 +73,256 @@ func (s *UserService) RegisterUser(nombre, password, rolID, companiaID string) (
 	return user.ID, nil
 }
 
-func (s *UserService) EditUser(userID, nombre, password, rolID, companiaID string) (*domain.User, error) {
+// EditUser actualiza un usuario existente dentro del Scope. Si el usuario
+// conduce buses no se le puede cambiar de compañía ni dar un rol que no
+// conceda domain.PermBusesDrive (ErrDriverInUse). Como en RegisterUser, el
+// rol caller debe conceder todos los permisos del nuevo rol.
+func (s *UserService) EditUser(scope domain.Scope, caller *domain.Role, userID, nombre, password, rolID, companiaID string) (*domain.User, error) {
 
 	if userID == "" {
-		return nil, errors.New("ID de usuario es obligatorio")
+		return nil, domain.NewValidationError("ID de usuario es obligatorio")
 	}
 
 	userObjID, err := primitive.ObjectIDFromHex(userID)
 
 	if err != nil {
-		return nil, errors.New("ID de usuario inválido")
+		return nil, domain.NewValidationError("ID de usuario inválido")
 	}
 
 	u := &domain.User{ID: userObjID}
 
 	if nombre != "" {
+		if err := s.checkNombreAvailable(nombre, userObjID); err != nil {
+			return nil, err
+		}
 		u.Nombre = nombre
 	}
 	if password != "" {
-		u.Password = domain.HashPassword(password)
+		hashed, err := s.Hasher.Hash(password)
+		if err != nil {
+			return nil, err
+		}
+		u.Password = hashed
 	}
+	var role *domain.Role
 	if rolID != "" {
 		rolObjID, err := primitive.ObjectIDFromHex(rolID)
 		if err != nil {
-			return nil, errors.New("ID de rol inválido")
+			return nil, domain.NewValidationError("ID de rol inválido")
+		}
+		if role, err = s.checkRoleAssignable(scope, caller, rolObjID); err != nil {
+			return nil, err
 		}
 		u.RolID = rolObjID
 	}
 	if companiaID != "" {
-		companiaObjID, err := primitive.ObjectIDFromHex(companiaID)
+		companiaObjID, err := s.Refs.Compania(context.TODO(), scope, companiaID)
 		if err != nil {
-			return nil, errors.New("ID de compañía inválido")
+			return nil, err
 		}
 		u.Compania = companiaObjID
 	}
 
-	updateUser, err := domain.EditarUsuario(context.TODO(), s.DB, u)
+	var updateUser *domain.User
+	err = s.Tx.WithTransaction(context.TODO(), func(ctx context.Context) error {
+		if role != nil || !u.Compania.IsZero() {
+			if err := s.checkDriverChange(ctx, scope, u, role); err != nil {
+				return err
+			}
+		}
+		updated, err := s.Users.Update(ctx, scope, u)
+		if err != nil {
+			return notFoundAs(err, ErrUserNotFound)
+		}
+		updateUser = updated
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	return updateUser, nil
 }
+
+// checkDriverChange retorna ErrDriverInUse si el cambio de compañía o de rol
+// (role, ya cargado) de u dejaría buses con un conductor de otra compañía o
+// sin permiso para conducir.
+func (s *UserService) checkDriverChange(ctx context.Context, scope domain.Scope, u *domain.User, role *domain.Role) error {
+	current, err := s.Users.GetByID(ctx, u.ID)
+	if err != nil {
+		return notFoundAs(err, ErrUserNotFound)
+	}
+	if !scope.Allows(current.Compania) {
+		return ErrUserNotFound
+	}
+	movesCompany := !u.Compania.IsZero() && u.Compania != current.Compania
+	stopsDriving := role != nil && role.ID != current.RolID && !role.HasPermission(domain.PermBusesDrive)
+	if !movesCompany && !stopsDriving {
+		return nil
+	}
+	n, err := s.Buses.CountByConductor(ctx, []primitive.ObjectID{u.ID})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrDriverInUse.WithDetails(map[string]any{"buses": n})
+	}
+	return nil
+}
+
+// GetAllUsers obtiene una página de los usuarios visibles en el Scope.
+func (s *UserService) GetAllUsers(scope domain.Scope, p ListParams) (*domain.Page[domain.User], error) {
+	q, err := p.query(domain.UserFields)
+	if err != nil {
+		return nil, err
+	}
+	return s.Users.List(context.TODO(), scope, q)
+}
+
+// GetUserByID busca un usuario del Scope por su ID.
+func (s *UserService) GetUserByID(scope domain.Scope, idHex string) (*domain.User, error) {
+	if idHex == "" {
+		return nil, domain.NewValidationError("ID de usuario es obligatorio")
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return nil, domain.NewValidationError("ID de usuario inválido")
+	}
+	user, err := s.Users.GetByID(context.TODO(), id)
+	if err != nil {
+		return nil, notFoundAs(err, ErrUserNotFound)
+	}
+	if !scope.Allows(user.Compania) {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// SearchUsers busca usuarios del Scope por nombre exacto, rol y/o compañía.
+// Se debe indicar al menos un criterio.
+func (s *UserService) SearchUsers(scope domain.Scope, nombre, rolIDHex, companiaIDHex string) ([]domain.User, error) {
+	if nombre == "" && rolIDHex == "" && companiaIDHex == "" {
+		return nil, domain.NewValidationError("indique nombre, rol_id o compania_id")
+	}
+	f := domain.UserFilter{Nombre: nombre}
+	if rolIDHex != "" {
+		id, err := primitive.ObjectIDFromHex(rolIDHex)
+		if err != nil {
+			return nil, domain.NewValidationError("ID de rol inválido")
+		}
+		f.RolID = id
+	}
+	if companiaIDHex != "" {
+		id, err := primitive.ObjectIDFromHex(companiaIDHex)
+		if err != nil {
+			return nil, domain.NewValidationError("ID de compañía inválido")
+		}
+		f.CompaniaID = id
+	}
+	return s.Users.Search(context.TODO(), scope, f)
+}
+
+// DeleteUser elimina un usuario del Scope por su ID y lo desasigna de los
+// buses que conducía, en una misma transacción.
+func (s *UserService) DeleteUser(scope domain.Scope, idHex string) error {
+	if idHex == "" {
+		return domain.NewValidationError("ID de usuario es obligatorio")
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return domain.NewValidationError("ID de usuario inválido")
+	}
+	return s.Tx.WithTransaction(context.TODO(), func(ctx context.Context) error {
+		if err := s.Users.Delete(ctx, scope, id); err != nil {
+			return notFoundAs(err, ErrUserNotFound)
+		}
+		_, err := s.Buses.UnassignConductor(ctx, id)
+		return err
+	})
+}
+
+// SuperAdminRole es el nombre del rol que crea BootstrapAdmin.
+const SuperAdminRole = "super-admin"
+
+// BootstrapAdmin garantiza un acceso inicial al API: si no existe un usuario
+// con el nombre indicado lo crea, sin compañía, con un rol que concede
+// domain.PermAll (el rol SuperAdminRole, que se crea si hace falta). Un
+// usuario existente no se modifica. Retorna si se creó el usuario.
+func (s *UserService) BootstrapAdmin(ctx context.Context, nombre, password string) (bool, error) {
+	if nombre == "" || password == "" {
+		return false, domain.NewValidationError("nombre y contraseña son obligatorios")
+	}
+	if _, err := s.Users.GetByNombre(ctx, nombre); err == nil {
+		return false, nil
+	} else if !errors.Is(err, domain.ErrNotFound) {
+		return false, err
+	}
+
+	roles, err := s.Refs.Roles.GetByName(ctx, SuperAdminRole)
+	if err != nil {
+		return false, err
+	}
+	var role *domain.Role
+	for i := range roles {
+		if roles[i].HasPermission(domain.PermAll) {
+			role = &roles[i]
+			break
+		}
+	}
+	if role == nil {
+		role = &domain.Role{
+			Nombre:      SuperAdminRole,
+			Descripcion: "Acceso total a todas las compañías",
+			Permisos:    []string{domain.PermAll},
+		}
+		if err := s.Refs.Roles.Create(ctx, role); err != nil {
+			return false, err
+		}
+	}
+
+	hashed, err := s.Hasher.Hash(password)
+	if err != nil {
+		return false, err
+	}
+	user := domain.User{Nombre: nombre, Password: hashed, RolID: role.ID}
+	if err := s.Users.Create(ctx, &user); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkRoleAssignable verifica que el rol exista y que caller conceda todos
+// sus permisos, de modo que nadie pueda dar (ni darse) permisos que no tiene.
+// Tampoco permite que un usuario sin alcance global asigne un rol de
+// super-admin, lo que le daría acceso a otras compañías. Retorna el rol.
+func (s *UserService) checkRoleAssignable(scope domain.Scope, caller *domain.Role, rolID primitive.ObjectID) (*domain.Role, error) {
+	role, err := s.Refs.Role(context.TODO(), rolID)
+	if err != nil {
+		return nil, err
+	}
+	if !scope.AllCompanies && role.HasPermission(domain.PermAllTenants) {
+		return nil, ErrForbidden
+	}
+	for _, perm := range role.Permisos {
+		if caller == nil || !caller.HasPermission(perm) {
+			return nil, ErrForbidden.WithDetails(map[string]any{"permiso": perm})
+		}
+	}
+	return role, nil
+}
+
+// checkNombreAvailable retorna ErrUserExists si otro usuario distinto de
+// self ya usa el nombre indicado.
+func (s *UserService) checkNombreAvailable(nombre string, self primitive.ObjectID) error {
+	existing, err := s.Users.GetByNombre(context.TODO(), nombre)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if existing.ID != self {
+		return ErrUserExists
+	}
+	return nil
+}
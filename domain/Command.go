@@ -0,0 +1,66 @@
+package domain
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tipos de comando que se pueden enviar a los dispositivos de un bus.
+const (
+	CommandSetInterval    = "set_interval"    // cambia el intervalo de reporte; params: seconds
+	CommandReboot         = "reboot"          // reinicia el dispositivo
+	CommandDisplayMessage = "display_message" // muestra un mensaje al conductor; params: text
+)
+
+// Estados de un comando. Un comando pendiente o enviado sigue abierto: se
+// vuelve a publicar cada vez que un dispositivo del bus se suscribe a su
+// tópico de comandos, hasta que uno lo confirme o venza.
+const (
+	CommandPending = "pending" // sin dispositivos conectados que lo reciban
+	CommandSent    = "sent"    // publicado, sin confirmar
+	CommandAcked   = "acked"   // el dispositivo lo ejecutó
+	CommandFailed  = "failed"  // el dispositivo reportó un error
+	CommandExpired = "expired" // venció sin confirmarse
+)
+
+// Command es una orden para los dispositivos de un bus, que se publica en su
+// tópico MQTT de comandos con QoS 1. Intentos cuenta las publicaciones y
+// Resultado es el mensaje con el que el dispositivo lo confirmó.
+type Command struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	BusID      primitive.ObjectID `bson:"bus_id"`
+	CompaniaID primitive.ObjectID `bson:"compania"`
+	Tipo       string             `bson:"tipo"`
+	Params     map[string]any     `bson:"params,omitempty"`
+	Estado     string             `bson:"estado"`
+	Intentos   int                `bson:"intentos"`
+	Resultado  string             `bson:"resultado,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	ExpiresAt  time.Time          `bson:"expires_at"`
+	SentAt     *time.Time         `bson:"sent_at,omitempty"`
+	AckedAt    *time.Time         `bson:"acked_at,omitempty"`
+}
+
+// Open indica si el comando aún espera la confirmación de un dispositivo.
+func (c *Command) Open() bool {
+	return c.Estado == CommandPending || c.Estado == CommandSent
+}
+
+// Expired indica si el comando sigue abierto pero su plazo venció antes de
+// now, aunque aún no se haya marcado como vencido.
+func (c *Command) Expired(now time.Time) bool {
+	return c.Open() && c.ExpiresAt.Before(now)
+}
+
+// CommandFields son los campos de Command disponibles en los listados.
+var CommandFields = ListFields{
+	"tipo":       {Type: FieldString, Sortable: true},
+	"estado":     {Type: FieldString, Sortable: true},
+	"bus_id":     {Type: FieldID},
+	"compania":   {Type: FieldID},
+	"created_at": {Type: FieldTime, Sortable: true},
+	"expires_at": {Type: FieldTime, Sortable: true},
+	"sent_at":    {Type: FieldTime},
+	"acked_at":   {Type: FieldTime},
+}
@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Estados de conectividad de un bus, según sus dispositivos conectados al
+// broker MQTT y la fecha de su última posición.
+const (
+	ConnectivityOnline  = "online"  // reporta con normalidad
+	ConnectivityStale   = "stale"   // conectado, pero sin reportar hace un tiempo
+	ConnectivityOffline = "offline" // sin dispositivos conectados o sin reportar hace demasiado
+)
+
+// ConnectivityEvent registra un cambio del estado de conectividad de un bus.
+type ConnectivityEvent struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	BusID      primitive.ObjectID `bson:"bus_id"`
+	CompaniaID primitive.ObjectID `bson:"compania"`
+	Estado     string             `bson:"estado"`
+	Anterior   string             `bson:"anterior"`
+	At         time.Time          `bson:"at"`
+}
+
+// ConnectivityEventFields son los campos de ConnectivityEvent disponibles en
+// los listados.
+var ConnectivityEventFields = ListFields{
+	"bus_id":   {Type: FieldID},
+	"compania": {Type: FieldID},
+	"estado":   {Type: FieldString, Sortable: true},
+	"at":       {Type: FieldTime, Sortable: true},
+}
+
+// BusConnectivity es el estado de conectividad actual de un bus. Since es
+// cuándo entró en ese estado, LastSeen su última actividad (una conexión o
+// una posición) y Devices el número de dispositivos conectados. Las fechas
+// son nil si el bus no se ha visto desde que arrancó el servidor.
+type BusConnectivity struct {
+	BusID      primitive.ObjectID
+	CompaniaID primitive.ObjectID
+	Estado     string
+	Since      *time.Time
+	LastSeen   *time.Time
+	Devices    int
+}
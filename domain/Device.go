@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Estados de un dispositivo. Un dispositivo dado de baja conserva su registro
+// pero ya no se puede conectar ni modificar.
+const (
+	DeviceActive         = "active"
+	DeviceDecommissioned = "decommissioned"
+)
+
+// Device es un rastreador GPS instalado en un bus. Se conecta al broker MQTT
+// con ClientID como identificador de cliente y su secreto como contraseña;
+// solo se guarda el hash del secreto. La compañía es la del bus asignado.
+type Device struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty"`
+	Serial           string             `bson:"serial"`
+	IMEI             string             `bson:"imei,omitempty"`
+	Firmware         string             `bson:"firmware,omitempty"`
+	Estado           string             `bson:"estado"`
+	BusID            primitive.ObjectID `bson:"bus_id"`
+	CompaniaID       primitive.ObjectID `bson:"compania"`
+	ClientID         string             `bson:"client_id"`
+	SecretHash       string             `bson:"secret_hash"`
+	LastSeen         *time.Time         `bson:"last_seen,omitempty"`
+	CreatedAt        time.Time          `bson:"created_at"`
+	DecommissionedAt *time.Time         `bson:"decommissioned_at,omitempty"`
+}
+
+// Active indica si el dispositivo está en servicio.
+func (d *Device) Active() bool {
+	return d.Estado == DeviceActive
+}
+
+// DeviceFields son los campos de Device disponibles en los listados.
+var DeviceFields = ListFields{
+	"serial":     {Type: FieldString, Sortable: true},
+	"imei":       {Type: FieldString},
+	"firmware":   {Type: FieldString},
+	"estado":     {Type: FieldString, Sortable: true},
+	"bus_id":     {Type: FieldID},
+	"compania":   {Type: FieldID},
+	"client_id":  {Type: FieldString},
+	"last_seen":  {Type: FieldTime},
+	"created_at": {Type: FieldTime, Sortable: true},
+}
@@ -0,0 +1,99 @@
+package domain
+
+import "errors"
+
+// ErrorKind clasifica los errores de negocio. El API HTTP traduce cada tipo a
+// un código de estado (validation → 400, not_found → 404, etc.).
+type ErrorKind string
+
+const (
+	KindValidation   ErrorKind = "validation"
+	KindNotFound     ErrorKind = "not_found"
+	KindConflict     ErrorKind = "conflict"
+	KindForbidden    ErrorKind = "forbidden"
+	KindUnauthorized ErrorKind = "unauthorized"
+	KindInternal     ErrorKind = "internal"
+)
+
+// Error es un error de negocio con un tipo y un código legible por máquinas
+// (p. ej. "bus_not_found"). Message se muestra al cliente; Err guarda la causa
+// original y solo se registra en los logs.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+	Details map[string]any
+	Err     error
+}
+
+// Errores genéricos de cada tipo. errors.Is(err, ErrNotFound) es cierto para
+// cualquier *Error de tipo KindNotFound, sea cual sea su código.
+var (
+	ErrValidation   = &Error{Kind: KindValidation, Code: string(KindValidation), Message: "datos inválidos"}
+	ErrNotFound     = &Error{Kind: KindNotFound, Code: string(KindNotFound), Message: "no encontrado"}
+	ErrConflict     = &Error{Kind: KindConflict, Code: string(KindConflict), Message: "el recurso ya existe"}
+	ErrForbidden    = &Error{Kind: KindForbidden, Code: string(KindForbidden), Message: "permiso denegado"}
+	ErrUnauthorized = &Error{Kind: KindUnauthorized, Code: string(KindUnauthorized), Message: "no autenticado"}
+	ErrInternal     = &Error{Kind: KindInternal, Code: string(KindInternal), Message: "error interno"}
+)
+
+// NewError crea un error de negocio con el tipo, código y mensaje indicados.
+func NewError(kind ErrorKind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// NewValidationError crea un error de validación de los datos de entrada.
+func NewValidationError(message string) *Error {
+	return NewError(KindValidation, string(KindValidation), message)
+}
+
+// NewNotFoundError crea un error de recurso inexistente.
+func NewNotFoundError(code, message string) *Error {
+	return NewError(KindNotFound, code, message)
+}
+
+// NewConflictError crea un error de conflicto con el estado actual (p. ej. un duplicado).
+func NewConflictError(code, message string) *Error {
+	return NewError(KindConflict, code, message)
+}
+
+// NewInternalError envuelve un error inesperado. Su causa no se expone al cliente.
+func NewInternalError(err error) *Error {
+	return &Error{Kind: KindInternal, Code: string(KindInternal), Message: ErrInternal.Message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is compara por tipo contra los errores genéricos (ErrNotFound, ...) y por
+// tipo y código contra errores específicos.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.Kind == e.Kind && (t.Code == string(t.Kind) || t.Code == e.Code)
+}
+
+// WithDetails retorna una copia del error con datos adicionales para el cliente.
+func (e *Error) WithDetails(details map[string]any) *Error {
+	cp := *e
+	cp.Details = details
+	return &cp
+}
+
+// AsError retorna el *Error contenido en err, o un error interno que lo
+// envuelve si err no es un error de negocio.
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return NewInternalError(err)
+}
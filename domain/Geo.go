@@ -0,0 +1,161 @@
+package domain
+
+import (
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Las ubicaciones se almacenan como puntos GeoJSON ({"type": "Point",
+// "coordinates": [lng, lat]}) para que MongoDB pueda indexarlas con un índice
+// 2dsphere. En Go y en el API siguen expresándose como Lat/Lng. Al leer se
+// acepta también el formato anterior ({"lat": ..., "lng": ...}).
+
+// geoPoint es la representación BSON de un punto GeoJSON. Lat y Lng solo se
+// usan al leer documentos guardados con el formato anterior.
+type geoPoint struct {
+	Type        string    `bson:"type"`
+	Coordinates []float64 `bson:"coordinates"`
+	Lat         float64   `bson:"lat,omitempty"`
+	Lng         float64   `bson:"lng,omitempty"`
+}
+
+func (p geoPoint) location() Location {
+	if p.Type == "Point" && len(p.Coordinates) == 2 {
+		return Location{Lat: p.Coordinates[1], Lng: p.Coordinates[0]}
+	}
+	return Location{Lat: p.Lat, Lng: p.Lng}
+}
+
+func newGeoPoint(l Location) geoPoint {
+	return geoPoint{Type: "Point", Coordinates: []float64{l.Lng, l.Lat}}
+}
+
+// MarshalBSON guarda la ubicación como un punto GeoJSON.
+func (l Location) MarshalBSON() ([]byte, error) {
+	return bson.Marshal(newGeoPoint(l))
+}
+
+// UnmarshalBSON lee un punto GeoJSON o una ubicación con el formato anterior.
+func (l *Location) UnmarshalBSON(data []byte) error {
+	var p geoPoint
+	if err := bson.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*l = p.location()
+	return nil
+}
+
+// waypointDoc es la representación BSON de un Waypoint: el punto GeoJSON en
+// "ubicacion", que es el campo indexado, y la descripción aparte.
+type waypointDoc struct {
+	Ubicacion   *geoPoint `bson:"ubicacion,omitempty"`
+	Descripcion string    `bson:"descripcion"`
+	Lat         float64   `bson:"lat,omitempty"`
+	Lng         float64   `bson:"lng,omitempty"`
+}
+
+// MarshalBSON guarda el waypoint con su ubicación como punto GeoJSON.
+func (w Waypoint) MarshalBSON() ([]byte, error) {
+	p := newGeoPoint(Location{Lat: w.Lat, Lng: w.Lng})
+	return bson.Marshal(waypointDoc{Ubicacion: &p, Descripcion: w.Descripcion})
+}
+
+// UnmarshalBSON lee un waypoint en el formato actual o en el anterior.
+func (w *Waypoint) UnmarshalBSON(data []byte) error {
+	var d waypointDoc
+	if err := bson.Unmarshal(data, &d); err != nil {
+		return err
+	}
+	loc := Location{Lat: d.Lat, Lng: d.Lng}
+	if d.Ubicacion != nil {
+		loc = d.Ubicacion.location()
+	}
+	*w = Waypoint{Lat: loc.Lat, Lng: loc.Lng, Descripcion: d.Descripcion}
+	return nil
+}
+
+// Valid indica si la latitud y la longitud están dentro de sus rangos.
+func (l Location) Valid() bool {
+	return l.Lat >= -90 && l.Lat <= 90 && l.Lng >= -180 && l.Lng <= 180
+}
+
+// earthRadius es el radio de la Tierra en metros que usa MongoDB en las
+// consultas esféricas.
+const earthRadius = 6378100.0
+
+// DistanceTo retorna la distancia en metros entre l y o sobre la superficie
+// terrestre (fórmula del haversine).
+func (l Location) DistanceTo(o Location) float64 {
+	lat1, lat2 := l.Lat*math.Pi/180, o.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (o.Lng - l.Lng) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// BearingTo retorna el rumbo inicial de l hacia o, en grados desde el norte
+// en sentido horario (0 a 360).
+func (l Location) BearingTo(o Location) float64 {
+	lat1, lat2 := l.Lat*math.Pi/180, o.Lat*math.Pi/180
+	dLng := (o.Lng - l.Lng) * math.Pi / 180
+	y := math.Sin(dLng) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLng)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// Valid indica si el área tiene sus esquinas en orden y dentro de rango.
+// El área no puede estar vacía ni abarcar 180° o más de longitud: MongoDB
+// rechaza los polígonos degenerados y, si un polígono ocupa más de un
+// hemisferio, toma el complementario.
+func (b BoundingBox) Valid() bool {
+	return b.MinLat < b.MaxLat && b.MinLng < b.MaxLng && b.MaxLng-b.MinLng < 180 &&
+		Location{Lat: b.MinLat, Lng: b.MinLng}.Valid() && Location{Lat: b.MaxLat, Lng: b.MaxLng}.Valid()
+}
+
+// Contains indica si la ubicación está dentro del área (bordes incluidos)
+// con el mismo criterio que $geoWithin en MongoDB: los lados del polígono de
+// Polygon son geodésicas, no paralelos, así que en áreas grandes los lados
+// norte y sur se curvan hacia el polo. En áreas del tamaño de una ciudad la
+// diferencia con el rectángulo es despreciable.
+func (b BoundingBox) Contains(l Location) bool {
+	ring := b.Polygon()
+	p := unitVector(l)
+	// El anillo recorre el área en sentido antihorario: el interior queda a
+	// la izquierda de cada lado, del lado hacia el que apunta a × b.
+	for i := 0; i < len(ring)-1; i++ {
+		a := unitVector(Location{Lat: ring[i][1], Lng: ring[i][0]})
+		c := unitVector(Location{Lat: ring[i+1][1], Lng: ring[i+1][0]})
+		if dot(cross(a, c), p) < -1e-12 {
+			return false
+		}
+	}
+	return true
+}
+
+// unitVector retorna la ubicación como vector unitario en coordenadas
+// cartesianas con el centro de la Tierra como origen.
+func unitVector(l Location) [3]float64 {
+	lat, lng := l.Lat*math.Pi/180, l.Lng*math.Pi/180
+	return [3]float64{math.Cos(lat) * math.Cos(lng), math.Cos(lat) * math.Sin(lng), math.Sin(lat)}
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+// Polygon retorna el anillo exterior del área como coordenadas GeoJSON
+// ([lng, lat], cerrado en el primer vértice).
+func (b BoundingBox) Polygon() [][]float64 {
+	return [][]float64{
+		{b.MinLng, b.MinLat},
+		{b.MaxLng, b.MinLat},
+		{b.MaxLng, b.MaxLat},
+		{b.MinLng, b.MaxLat},
+		{b.MinLng, b.MinLat},
+	}
+}
@@ -0,0 +1,148 @@
+package domain
+
+import (
+	"encoding/base64"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FieldType es el tipo de un campo por el que se puede filtrar un listado.
+type FieldType int
+
+const (
+	FieldString FieldType = iota
+	FieldID
+	FieldTime
+)
+
+// bsonType retorna el tipo BSON con el que se guardan los campos de tipo t.
+func (t FieldType) bsonType() bsontype.Type {
+	switch t {
+	case FieldID:
+		return bsontype.ObjectID
+	case FieldTime:
+		return bsontype.DateTime
+	default:
+		return bsontype.String
+	}
+}
+
+// ListField describe un campo de una entidad disponible en los listados.
+// Sortable indica si además se puede ordenar por él; solo se marcan así los
+// campos que siempre están presentes en el documento.
+type ListField struct {
+	Type     FieldType
+	Sortable bool
+}
+
+// ListFields son los campos de una entidad disponibles en los listados,
+// indexados por su nombre en el documento.
+type ListFields map[string]ListField
+
+// FilterOp es el operador de comparación de un Filter.
+type FilterOp string
+
+const (
+	OpEq  FilterOp = "eq"
+	OpGte FilterOp = "gte"
+	OpLte FilterOp = "lte"
+	// OpWithin compara un campo de tipo Location con un BoundingBox.
+	OpWithin FilterOp = "within"
+)
+
+// Filter restringe un listado a los documentos cuyo campo Field cumple
+// la comparación Op con Value.
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value any
+}
+
+// ListQuery son los parámetros de un listado paginado. Los documentos se
+// ordenan por Sort (y por _id para desempatar) y se retornan como mucho
+// Limit a partir de After. Sort vacío ordena por _id.
+type ListQuery struct {
+	Filters []Filter
+	Sort    string
+	Desc    bool
+	Limit   int
+	After   *Cursor
+}
+
+// SortField retorna el campo por el que se ordena el listado.
+func (q ListQuery) SortField() string {
+	if q.Sort == "" {
+		return "_id"
+	}
+	return q.Sort
+}
+
+// CursorAt retorna el cursor que apunta al documento doc según el orden de q.
+func (q ListQuery) CursorAt(doc bson.Raw) Cursor {
+	id, _ := doc.Lookup("_id").ObjectIDOK()
+	return Cursor{Sort: q.SortField(), Desc: q.Desc, Value: doc.Lookup(q.SortField()), ID: id}
+}
+
+// Page es una página de un listado. Total es el número de documentos que
+// cumplen los filtros (sin contar la paginación) y Next el cursor de la
+// página siguiente, vacío si no hay más. Contar puede recorrer toda la
+// colección, así que Total solo se calcula en la primera página (sin After)
+// y es nil en las siguientes.
+type Page[T any] struct {
+	Items []T
+	Total *int64
+	Next  string
+}
+
+// Cursor identifica el último documento de una página: el valor de su campo
+// de orden y su _id. Incluye el orden con el que se generó para rechazarlo si
+// se usa con otro.
+type Cursor struct {
+	Sort  string             `bson:"s"`
+	Desc  bool               `bson:"d"`
+	Value bson.RawValue      `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+// Encode serializa el cursor como una cadena opaca apta para URLs.
+func (c Cursor) Encode() string {
+	raw, err := bson.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// ValidFor indica si el cursor sirve para la ListQuery q sobre los campos
+// fields: debe tener su mismo orden y un valor del tipo del campo de orden.
+// El cursor llega del cliente sin firmar, y un valor como {"$ne": null} se
+// interpretaría en MongoDB como un operador al buscar la página siguiente.
+func (c Cursor) ValidFor(q ListQuery, fields ListFields) bool {
+	if c.Sort != q.SortField() || c.Desc != q.Desc {
+		return false
+	}
+	want := bsontype.ObjectID
+	if c.Sort != "_id" {
+		f, ok := fields[c.Sort]
+		if !ok {
+			return false
+		}
+		want = f.Type.bsonType()
+	}
+	return c.Value.Type == want && c.Value.Validate() == nil
+}
+
+// ParseCursor deserializa un cursor generado por Encode.
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c Cursor
+	if err := bson.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,232 @@
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Identificadores de algoritmo de hash de contraseñas.
+const (
+	PasswordAlgoArgon2id = "argon2id"
+	PasswordAlgoBcrypt   = "bcrypt"
+	// PasswordAlgoLegacySHA256 identifica los hashes SHA-256 sin sal de la
+	// primera versión. Solo se usa para verificar y migrar contraseñas antiguas.
+	PasswordAlgoLegacySHA256 = "sha256"
+)
+
+// ErrUnknownPasswordHash se retorna cuando el hash almacenado no corresponde a
+// ningún algoritmo conocido.
+var ErrUnknownPasswordHash = errors.New("formato de hash de contraseña desconocido")
+
+// PasswordHasher calcula y verifica hashes de contraseñas.
+// El hash resultante incluye el identificador del algoritmo, sus parámetros y
+// la sal, de modo que cada usuario guarda todo lo necesario para verificarlo.
+type PasswordHasher interface {
+	// Hash genera el hash de una contraseña con una sal aleatoria.
+	Hash(password string) (string, error)
+	// Verify compara la contraseña con el hash almacenado. needsRehash indica
+	// que la contraseña es correcta pero el hash usa un algoritmo o parámetros
+	// distintos a los actuales y debe regenerarse.
+	Verify(password, encoded string) (ok bool, needsRehash bool, err error)
+}
+
+// PasswordAlgorithm identifica el algoritmo de un hash almacenado.
+func PasswordAlgorithm(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return PasswordAlgoArgon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return PasswordAlgoBcrypt
+	case len(encoded) == sha256.Size*2 && isHex(encoded):
+		return PasswordAlgoLegacySHA256
+	default:
+		return ""
+	}
+}
+
+// NewPasswordHasher crea un hasher que genera hashes con el algoritmo indicado
+// ("argon2id" por defecto, o "bcrypt") y que verifica hashes de cualquier
+// algoritmo soportado, incluidos los SHA-256 antiguos.
+func NewPasswordHasher(algorithm string) (PasswordHasher, error) {
+	argon := NewArgon2idHasher()
+	bc := NewBcryptHasher(bcrypt.DefaultCost)
+
+	var preferred PasswordHasher
+	switch algorithm {
+	case "", PasswordAlgoArgon2id:
+		preferred = argon
+		algorithm = PasswordAlgoArgon2id
+	case PasswordAlgoBcrypt:
+		preferred = bc
+	default:
+		return nil, fmt.Errorf("algoritmo de contraseña no soportado: %s", algorithm)
+	}
+
+	return &multiHasher{
+		algorithm: algorithm,
+		preferred: preferred,
+		verifiers: map[string]PasswordHasher{
+			PasswordAlgoArgon2id:     argon,
+			PasswordAlgoBcrypt:       bc,
+			PasswordAlgoLegacySHA256: legacySHA256Hasher{},
+		},
+	}, nil
+}
+
+// multiHasher genera hashes con el algoritmo preferido y delega la
+// verificación en el algoritmo con el que se creó cada hash.
+type multiHasher struct {
+	algorithm string
+	preferred PasswordHasher
+	verifiers map[string]PasswordHasher
+}
+
+func (m *multiHasher) Hash(password string) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+func (m *multiHasher) Verify(password, encoded string) (bool, bool, error) {
+	algo := PasswordAlgorithm(encoded)
+	v, ok := m.verifiers[algo]
+	if !ok {
+		return false, false, ErrUnknownPasswordHash
+	}
+	ok, needsRehash, err := v.Verify(password, encoded)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	return true, needsRehash || algo != m.algorithm, nil
+}
+
+// Argon2idHasher implementa PasswordHasher con argon2id.
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// NewArgon2idHasher retorna un Argon2idHasher con los parámetros recomendados
+// por OWASP (19 MiB, 2 iteraciones, 1 hilo).
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      19 * 1024,
+		Iterations:  2,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// Hash genera un hash en formato PHC:
+// $argon2id$v=19$m=<memoria>,t=<iteraciones>,p=<hilos>$<sal>$<hash>
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify compara la contraseña con un hash argon2id.
+func (a *Argon2idHasher) Verify(password, encoded string) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false, ErrUnknownPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrUnknownPasswordHash
+	}
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, false, ErrUnknownPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrUnknownPasswordHash
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrUnknownPasswordHash
+	}
+
+	key := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(expected)))
+	if subtle.ConstantTimeCompare(key, expected) != 1 {
+		return false, false, nil
+	}
+	needsRehash := memory != a.Memory || iterations != a.Iterations || parallelism != a.Parallelism ||
+		uint32(len(salt)) != a.SaltLength || uint32(len(expected)) != a.KeyLength
+	return true, needsRehash, nil
+}
+
+// BcryptHasher implementa PasswordHasher con bcrypt.
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher retorna un BcryptHasher con el coste indicado.
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{Cost: cost}
+}
+
+// Hash genera un hash bcrypt; la sal y el coste quedan incluidos en el resultado.
+func (b *BcryptHasher) Hash(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// Verify compara la contraseña con un hash bcrypt.
+func (b *BcryptHasher) Verify(password, encoded string) (bool, bool, error) {
+	if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		return false, false, ErrUnknownPasswordHash
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, ErrUnknownPasswordHash
+	}
+	return true, cost != b.Cost, nil
+}
+
+// legacySHA256Hasher verifica los hashes SHA-256 sin sal de la primera versión.
+// Cualquier hash de este tipo que se verifique con éxito debe regenerarse.
+type legacySHA256Hasher struct{}
+
+func (legacySHA256Hasher) Hash(string) (string, error) {
+	return "", errors.New("SHA-256 sin sal no se admite para nuevas contraseñas")
+}
+
+func (legacySHA256Hasher) Verify(password, encoded string) (bool, bool, error) {
+	sum := sha256.Sum256([]byte(password))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(encoded))) != 1 {
+		return false, false, nil
+	}
+	return true, true, nil
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
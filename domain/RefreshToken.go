@@ -0,0 +1,19 @@
+package domain
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken representa un token de refresco emitido a un usuario.
+// Solo se guarda el hash del token; el valor en claro únicamente lo conoce el cliente.
+type RefreshToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `bson:"user_id"`
+	TokenHash  string             `bson:"token_hash"`
+	ExpiresAt  time.Time          `bson:"expires_at"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty"`
+	ReplacedBy primitive.ObjectID `bson:"replaced_by,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at"`
+}
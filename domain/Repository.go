@@ -0,0 +1,207 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Los repositorios abstraen el almacenamiento de cada entidad. Existen dos
+// implementaciones: MongoDB (infrastructure/persistence) y una en memoria
+// (infrastructure/persistence/memory) para pruebas y desarrollo local.
+//
+// Las operaciones de edición (Update) solo modifican los campos no vacíos de
+// la entidad recibida y retornan el documento resultante. Si el documento no
+// existe o no es visible en el Scope se retorna ErrNotFound; si una inserción
+// viola un índice único, ErrConflict.
+//
+// Los listados (List) aplican los filtros, el orden y la paginación de la
+// ListQuery en el almacenamiento y retornan una sola página.
+
+// UserRepository almacena los usuarios.
+type UserRepository interface {
+	Create(ctx context.Context, user *User) error
+	Update(ctx context.Context, scope Scope, user *User) (*User, error)
+	UpdatePassword(ctx context.Context, id primitive.ObjectID, hash string) error
+	GetByNombre(ctx context.Context, nombre string) (*User, error)
+	GetByID(ctx context.Context, id primitive.ObjectID) (*User, error)
+	List(ctx context.Context, scope Scope, q ListQuery) (*Page[User], error)
+	Search(ctx context.Context, scope Scope, filter UserFilter) ([]User, error)
+	Delete(ctx context.Context, scope Scope, id primitive.ObjectID) error
+	DeleteByRol(ctx context.Context, rolID primitive.ObjectID) (int64, error)
+	DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error)
+}
+
+// RoleRepository almacena los roles. Los roles se comparten entre compañías.
+type RoleRepository interface {
+	Create(ctx context.Context, role *Role) error
+	Update(ctx context.Context, role *Role) (*Role, error)
+	List(ctx context.Context, q ListQuery) (*Page[Role], error)
+	GetByID(ctx context.Context, id primitive.ObjectID) (*Role, error)
+	GetByName(ctx context.Context, nombre string) ([]Role, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// CompanyRepository almacena las compañías.
+type CompanyRepository interface {
+	Create(ctx context.Context, comp *Company) error
+	Update(ctx context.Context, scope Scope, comp *Company) (*Company, error)
+	List(ctx context.Context, scope Scope, q ListQuery) (*Page[Company], error)
+	GetByID(ctx context.Context, scope Scope, id primitive.ObjectID) (*Company, error)
+	GetByName(ctx context.Context, scope Scope, nombre string) ([]Company, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// BusRepository almacena los buses.
+type BusRepository interface {
+	Create(ctx context.Context, bus *Bus) error
+	Update(ctx context.Context, scope Scope, bus *Bus) (*Bus, error)
+	List(ctx context.Context, scope Scope, q ListQuery) (*Page[Bus], error)
+	GetByID(ctx context.Context, scope Scope, id primitive.ObjectID) (*Bus, error)
+	// GetByIDs retorna, en cualquier orden, los buses del Scope con los IDs
+	// indicados. Los que no existen se omiten.
+	GetByIDs(ctx context.Context, scope Scope, ids []primitive.ObjectID) ([]Bus, error)
+	GetByPlaca(ctx context.Context, scope Scope, placa string) ([]Bus, error)
+	Delete(ctx context.Context, scope Scope, id primitive.ObjectID) error
+	// CountByRuta cuenta los buses, de cualquier compañía, asignados a la ruta.
+	CountByRuta(ctx context.Context, rutaID primitive.ObjectID) (int64, error)
+	// CountByConductor cuenta los buses, de cualquier compañía, conducidos por
+	// alguno de los usuarios indicados.
+	CountByConductor(ctx context.Context, conductorIDs []primitive.ObjectID) (int64, error)
+	// UnassignRuta quita la ruta de todos los buses que la tienen asignada y
+	// retorna cuántos buses se modificaron.
+	UnassignRuta(ctx context.Context, rutaID primitive.ObjectID) (int64, error)
+	// UnassignConductor quita el conductor de todos los buses que lo tienen asignado.
+	UnassignConductor(ctx context.Context, conductorID primitive.ObjectID) (int64, error)
+	DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error)
+}
+
+// RouteRepository almacena las rutas.
+type RouteRepository interface {
+	Create(ctx context.Context, r *Route) error
+	Update(ctx context.Context, scope Scope, r *Route) (*Route, error)
+	List(ctx context.Context, scope Scope, q ListQuery) (*Page[Route], error)
+	GetByName(ctx context.Context, scope Scope, nombre string) ([]Route, error)
+	GetByID(ctx context.Context, scope Scope, id primitive.ObjectID) (*Route, error)
+	Delete(ctx context.Context, scope Scope, id primitive.ObjectID) error
+	DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error)
+}
+
+// BusLocationRepository almacena las posiciones reportadas por los buses.
+type BusLocationRepository interface {
+	Create(ctx context.Context, bl *BusLocation) error
+	// CreateMany inserta un lote de localizaciones. Conserva el ID y CreatedAt
+	// que ya tengan asignados (p. ej. el momento en que se recibieron).
+	CreateMany(ctx context.Context, bls []BusLocation) error
+	List(ctx context.Context, scope Scope, q ListQuery) (*Page[BusLocation], error)
+	// Latest retorna la última localización de cada bus visible en el Scope.
+	Latest(ctx context.Context, scope Scope) ([]BusLocation, error)
+	// History retorna el recorrido del bus entre from y to (ambos incluidos)
+	// reducido a como mucho maxPoints puntos.
+	History(ctx context.Context, scope Scope, busID primitive.ObjectID, from, to time.Time, maxPoints int) (*LocationHistory, error)
+	// Scan recorre las localizaciones de todos los buses con CreatedAt en
+	// [from, to), ordenadas por bus y por fecha.
+	Scan(ctx context.Context, from, to time.Time, fn func(BusLocation) error) error
+	Delete(ctx context.Context, scope Scope, id primitive.ObjectID) error
+	DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error)
+}
+
+// BusLocationRollupRepository almacena los resúmenes por minuto del historial
+// de localizaciones.
+type BusLocationRollupRepository interface {
+	// Upsert guarda los resúmenes, reemplazando los de un mismo bus y minuto.
+	Upsert(ctx context.Context, rollups []BusLocationRollup) error
+	// LastMinute retorna el minuto del resumen más reciente, o el tiempo cero
+	// si no hay ninguno.
+	LastMinute(ctx context.Context) (time.Time, error)
+	// History retorna el recorrido del bus entre from y to (ambos incluidos)
+	// a partir de los resúmenes, reducido a como mucho maxPoints puntos.
+	// Total es el número de posiciones originales de los minutos incluidos.
+	History(ctx context.Context, scope Scope, busID primitive.ObjectID, from, to time.Time, maxPoints int) (*LocationHistory, error)
+	DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error)
+}
+
+// DeviceRepository almacena los dispositivos GPS y sus credenciales MQTT.
+type DeviceRepository interface {
+	// Create inserta un dispositivo. Un serial o client_id repetido retorna
+	// ErrConflict.
+	Create(ctx context.Context, d *Device) error
+	GetByID(ctx context.Context, scope Scope, id primitive.ObjectID) (*Device, error)
+	GetByClientID(ctx context.Context, clientID string) (*Device, error)
+	List(ctx context.Context, scope Scope, q ListQuery) (*Page[Device], error)
+	// Update actualiza los campos no vacíos de d (serial, imei, firmware,
+	// estado, bus, compañía, hash del secreto y fecha de baja) y retorna el
+	// documento actualizado. Un serial repetido retorna ErrConflict.
+	Update(ctx context.Context, scope Scope, d *Device) (*Device, error)
+	// Touch registra que el dispositivo se vio en at, salvo que ya tenga una
+	// fecha posterior.
+	Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error)
+}
+
+// ConnectivityEventRepository almacena los cambios de estado de conectividad
+// de los buses.
+type ConnectivityEventRepository interface {
+	Create(ctx context.Context, e *ConnectivityEvent) error
+	List(ctx context.Context, scope Scope, q ListQuery) (*Page[ConnectivityEvent], error)
+	// Latest retorna el último evento de cada bus.
+	Latest(ctx context.Context) ([]ConnectivityEvent, error)
+	DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error)
+}
+
+// CommandRepository almacena los comandos enviados a los buses. Las
+// operaciones de cambio de estado solo afectan a comandos abiertos
+// (pendientes o enviados), de modo que una confirmación no se sobrescribe.
+type CommandRepository interface {
+	Create(ctx context.Context, c *Command) error
+	GetByID(ctx context.Context, scope Scope, id primitive.ObjectID) (*Command, error)
+	List(ctx context.Context, scope Scope, q ListQuery) (*Page[Command], error)
+	// Open retorna los comandos abiertos del bus en orden de creación.
+	Open(ctx context.Context, busID primitive.ObjectID) ([]Command, error)
+	// MarkSent registra una publicación del comando abierto en at.
+	MarkSent(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// Resolve cierra un comando abierto del bus con el estado y el resultado
+	// indicados y retorna el documento actualizado. Si no existe, es de otro
+	// bus, ya está cerrado o venció antes de at retorna ErrNotFound.
+	Resolve(ctx context.Context, busID, id primitive.ObjectID, estado, resultado string, at time.Time) (*Command, error)
+	// Expire marca como vencidos los comandos abiertos con ExpiresAt anterior
+	// a now y retorna cuántos eran.
+	Expire(ctx context.Context, now time.Time) (int64, error)
+	DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error)
+}
+
+// RefreshTokenRepository almacena los refresh tokens emitidos.
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, rt *RefreshToken) error
+	GetByHash(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	// Revoke marca como revocado un token activo (no revocado y no expirado)
+	// y retorna el documento tal como estaba antes de revocarlo. Si el token
+	// no existe o ya no está activo retorna ErrNotFound.
+	Revoke(ctx context.Context, tokenHash string, replacedBy primitive.ObjectID) (*RefreshToken, error)
+	RevokeAllForUser(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// Transactor ejecuta operaciones de varios repositorios de forma atómica.
+// Los repositorios usados dentro de fn deben recibir el ctx que fn recibe
+// para participar en la transacción.
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Repositories agrupa los repositorios de todas las entidades y el Transactor
+// que permite combinarlos.
+type Repositories struct {
+	Users         UserRepository
+	Roles         RoleRepository
+	Companies     CompanyRepository
+	Buses         BusRepository
+	Routes        RouteRepository
+	BusLocations  BusLocationRepository
+	Rollups       BusLocationRollupRepository
+	Devices       DeviceRepository
+	Connectivity  ConnectivityEventRepository
+	Commands      CommandRepository
+	RefreshTokens RefreshTokenRepository
+	Tx            Transactor
+}
@@ -1,143 +1,85 @@
 package domain
 
 import (
-	"context"
-	"log"
+	"strings"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Permisos reconocidos. Cada permiso tiene la forma "recurso:acción".
+const (
+	PermUsersRead         = "users:read"
+	PermUsersWrite        = "users:write"
+	PermRolesRead         = "roles:read"
+	PermRolesWrite        = "roles:write"
+	PermCompaniesRead     = "companies:read"
+	PermCompaniesWrite    = "companies:write"
+	PermBusesRead         = "buses:read"
+	PermBusesWrite        = "buses:write"
+	PermBusesDrive        = "buses:drive"   // permite ser conductor de un bus
+	PermBusesCommand      = "buses:command" // permite enviar comandos a los dispositivos del bus
+	PermRoutesRead        = "routes:read"
+	PermRoutesWrite       = "routes:write"
+	PermBusLocationsRead  = "buslocations:read"
+	PermBusLocationsWrite = "buslocations:write"
+	PermDevicesRead       = "devices:read"
+	PermDevicesWrite      = "devices:write"
+
+	// PermAllTenants permite ver y administrar los datos de todas las compañías (super-admin).
+	PermAllTenants = "tenants:all"
+
+	// PermAll concede todos los permisos.
+	PermAll = "*"
+)
+
+// KnownPermissions lista los permisos que se pueden asignar a un rol,
+// además de PermAll y de los comodines por recurso ("buses:*").
+var KnownPermissions = []string{
+	PermUsersRead, PermUsersWrite,
+	PermRolesRead, PermRolesWrite,
+	PermCompaniesRead, PermCompaniesWrite,
+	PermBusesRead, PermBusesWrite, PermBusesDrive, PermBusesCommand,
+	PermRoutesRead, PermRoutesWrite,
+	PermBusLocationsRead, PermBusLocationsWrite,
+	PermDevicesRead, PermDevicesWrite,
+	PermAllTenants,
+}
+
 // Role representa la entidad de rol en la base de datos.
 type Role struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty"`
 	Nombre      string             `bson:"nombre"`
 	Descripcion string             `bson:"descripcion"`
+	Permisos    []string           `bson:"permisos"`
 }
 
-// CrearRole inserta un nuevo rol en la colección "roles".
-func CrearRole(ctx context.Context, db *mongo.Database, role *Role) error {
-	role.ID = primitive.NewObjectID()
-
-	collection := db.Collection("roles")
-	_, err := collection.InsertOne(ctx, role)
-	if err != nil {
-		log.Println("Error al insertar rol:", err)
-		return err
-	}
-	return nil
-}
-
-// EditarRole actualiza los campos no vacíos de un Role existente y devuelve el documento actualizado.
-func EditarRole(ctx context.Context, db *mongo.Database, role *Role) (*Role, error) {
-	collection := db.Collection("roles")
-
-	updateFields := bson.M{}
-	if role.Nombre != "" {
-		updateFields["nombre"] = role.Nombre
-	}
-	if role.Descripcion != "" {
-		updateFields["descripcion"] = role.Descripcion
-	}
-
-	// Si no hay campos para actualizar, devolvemos el documento existente
-	if len(updateFields) == 0 {
-		var existing Role
-		if err := collection.FindOne(ctx, bson.M{"_id": role.ID}).Decode(&existing); err != nil {
-			log.Println("Rol no encontrado:", err)
-			return nil, err
-		}
-		return &existing, nil
-	}
-
-	filter := bson.M{"_id": role.ID}
-	update := bson.M{"$set": updateFields}
-	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
-
-	var updated Role
-	if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
-		if err == mongo.ErrNoDocuments {
-			log.Println("Rol no encontrado")
-		} else {
-			log.Println("Error al editar rol:", err)
-		}
-		return nil, err
-	}
-
-	return &updated, nil
-}
-
-// GetAllRoles retorna todos los roles de la colección "roles".
-func GetAllRoles(ctx context.Context, db *mongo.Database) ([]Role, error) {
-	coll := db.Collection("roles")
-	cursor, err := coll.Find(ctx, bson.M{})
-	if err != nil {
-		log.Println("Error al obtener roles:", err)
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	var out []Role
-	for cursor.Next(ctx) {
-		var r Role
-		if err := cursor.Decode(&r); err != nil {
-			log.Println("Error al decodificar rol:", err)
-			continue
+// HasPermission indica si el rol concede el permiso indicado, ya sea de forma
+// explícita, mediante el comodín del recurso ("buses:*") o mediante PermAll.
+func (r *Role) HasPermission(perm string) bool {
+	resource, _, _ := strings.Cut(perm, ":")
+	for _, p := range r.Permisos {
+		if p == PermAll || p == perm || p == resource+":*" {
+			return true
 		}
-		out = append(out, r)
 	}
-	if err := cursor.Err(); err != nil {
-		log.Println("Cursor error en roles:", err)
-		return nil, err
-	}
-	return out, nil
-}
-
-// GetRoleByID busca un rol por su ObjectID.
-func GetRoleByID(ctx context.Context, db *mongo.Database, id primitive.ObjectID) (*Role, error) {
-	coll := db.Collection("roles")
-	var r Role
-	if err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&r); err != nil {
-		log.Println("Rol no encontrado:", err)
-		return nil, err
-	}
-	return &r, nil
+	return false
 }
 
-// GetRolesByName retorna todos los roles cuyo nombre coincide exactamente.
-func GetRolesByName(ctx context.Context, db *mongo.Database, nombre string) ([]Role, error) {
-	coll := db.Collection("roles")
-	cursor, err := coll.Find(ctx, bson.M{"nombre": nombre})
-	if err != nil {
-		log.Println("Error al buscar roles por nombre:", err)
-		return nil, err
+// IsValidPermission indica si un permiso es asignable a un rol.
+func IsValidPermission(perm string) bool {
+	if perm == PermAll {
+		return true
 	}
-	defer cursor.Close(ctx)
-
-	var out []Role
-	for cursor.Next(ctx) {
-		var r Role
-		if err := cursor.Decode(&r); err != nil {
-			log.Println("Error al decodificar rol:", err)
-			continue
+	for _, known := range KnownPermissions {
+		resource, _, _ := strings.Cut(known, ":")
+		if perm == known || perm == resource+":*" {
+			return true
 		}
-		out = append(out, r)
-	}
-	if err := cursor.Err(); err != nil {
-		log.Println("Cursor error en búsqueda de roles:", err)
-		return nil, err
 	}
-	return out, nil
+	return false
 }
 
-// DeleteRole elimina un rol por su ObjectID.
-func DeleteRole(ctx context.Context, db *mongo.Database, id primitive.ObjectID) error {
-	coll := db.Collection("roles")
-	if _, err := coll.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
-		log.Println("Error al eliminar rol:", err)
-		return err
-	}
-	return nil
+// RoleFields son los campos de Role disponibles en los listados.
+var RoleFields = ListFields{
+	"nombre": {Type: FieldString, Sortable: true},
 }
@@ -0,0 +1,69 @@
+package domain
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BusLocationRollup resume las posiciones de un bus durante un minuto. Guarda
+// como mucho unos pocos puntos equiespaciados (siempre el primero y el
+// último), suficientes para conservar la forma del recorrido, y el número de
+// posiciones originales.
+type BusLocationRollup struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	BusID      primitive.ObjectID `bson:"bus_id"`
+	CompaniaID primitive.ObjectID `bson:"compania"`
+	Minuto     time.Time          `bson:"minuto"`
+	Cantidad   int64              `bson:"cantidad"`
+	Puntos     []RollupPoint      `bson:"puntos"`
+}
+
+// RollupPoint es una posición conservada en un BusLocationRollup.
+type RollupPoint struct {
+	Localizacion Location  `bson:"localizacion"`
+	At           time.Time `bson:"at"`
+	Velocidad    *float64  `bson:"velocidad,omitempty"`
+	Rumbo        *float64  `bson:"rumbo,omitempty"`
+}
+
+// NewBusLocationRollup resume las posiciones de un mismo bus y minuto,
+// ordenadas por CreatedAt, conservando como mucho maxPoints puntos.
+func NewBusLocationRollup(locations []BusLocation, maxPoints int) BusLocationRollup {
+	first := locations[0]
+	sampler := NewSampler[BusLocation](int64(len(locations)), maxPoints)
+	for _, bl := range locations {
+		sampler.Add(bl)
+	}
+	r := BusLocationRollup{
+		BusID:      first.BusID,
+		CompaniaID: first.CompaniaID,
+		Minuto:     first.CreatedAt.Truncate(time.Minute),
+		Cantidad:   int64(len(locations)),
+	}
+	for _, bl := range sampler.Points() {
+		r.Puntos = append(r.Puntos, RollupPoint{
+			Localizacion: bl.Localizacion,
+			At:           bl.CreatedAt,
+			Velocidad:    bl.Velocidad,
+			Rumbo:        bl.Rumbo,
+		})
+	}
+	return r
+}
+
+// Locations retorna los puntos del resumen como localizaciones del bus.
+func (r BusLocationRollup) Locations() []BusLocation {
+	out := make([]BusLocation, 0, len(r.Puntos))
+	for _, p := range r.Puntos {
+		out = append(out, BusLocation{
+			BusID:        r.BusID,
+			CompaniaID:   r.CompaniaID,
+			Localizacion: p.Localizacion,
+			CreatedAt:    p.At,
+			Velocidad:    p.Velocidad,
+			Rumbo:        p.Rumbo,
+		})
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scope delimita los datos visibles para quien hace una consulta.
+// Un usuario normal solo ve los datos de su compañía; un super-admin
+// (rol con PermAllTenants) ve los de todas.
+type Scope struct {
+	CompaniaID   primitive.ObjectID
+	AllCompanies bool
+}
+
+// GlobalScope retorna un Scope sin restricción de compañía. Se usa en procesos
+// internos (p. ej. la ingesta MQTT) y para los super-admin.
+func GlobalScope() Scope {
+	return Scope{AllCompanies: true}
+}
+
+// CompanyScope retorna un Scope restringido a una compañía.
+func CompanyScope(companiaID primitive.ObjectID) Scope {
+	return Scope{CompaniaID: companiaID}
+}
+
+// Allows indica si el Scope puede ver datos de la compañía indicada.
+func (s Scope) Allows(companiaID primitive.ObjectID) bool {
+	return s.AllCompanies || (!s.CompaniaID.IsZero() && s.CompaniaID == companiaID)
+}
+
+// Filter agrega al filtro la restricción por compañía sobre el campo indicado
+// ("compania" en la mayoría de colecciones, "_id" en la de compañías).
+func (s Scope) Filter(filter bson.M, field string) bson.M {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if !s.AllCompanies {
+		filter[field] = s.CompaniaID
+	}
+	return filter
+}
@@ -1,15 +1,9 @@
 package domain
 
-
 import (
-	"context"
-	"errors"
-	"log"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // BusLocation representa la entidad de localización de un bus.
@@ -17,77 +11,146 @@ type BusLocation struct {
 	ID           primitive.ObjectID `bson:"_id,omitempty"`
 	BusID        primitive.ObjectID `bson:"bus_id"`
 	Localizacion Location           `bson:"localizacion"`
+	CompaniaID   primitive.ObjectID `bson:"compania"`
 	CreatedAt    time.Time          `bson:"created_at"`
+	// Velocidad (km/h) y Rumbo (grados desde el norte en sentido horario) son
+	// opcionales: nil si el dispositivo no los reporta.
+	Velocidad *float64 `bson:"velocidad,omitempty"`
+	Rumbo     *float64 `bson:"rumbo,omitempty"`
 }
 
-// CrearBusLocation inserta una nueva localización de bus.
-// Valida que el bus exista en la colección "buses".
-func CrearBusLocation(ctx context.Context, db *mongo.Database, bl *BusLocation) error {
-	// Verificar existencia del bus
-	if err := db.Collection("buses").FindOne(ctx, bson.M{"_id": bl.BusID}).Err(); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return errors.New("bus_id no existe")
-		}
-		return err
-	}
+// BusLocationFields son los campos de BusLocation disponibles en los listados.
+var BusLocationFields = ListFields{
+	"bus_id":     {Type: FieldID},
+	"compania":   {Type: FieldID},
+	"created_at": {Type: FieldTime, Sortable: true},
+}
 
-	// Asignar metadatos
-	bl.ID = primitive.NewObjectID()
-	bl.CreatedAt = time.Now()
+// LivePosition es la última posición conocida de un bus. Velocidad y Rumbo
+// son los reportados por el dispositivo o, si no los envía, los calculados a
+// partir de la posición anterior. LocationID es el ID de la localización de
+// la que proviene.
+type LivePosition struct {
+	BusID        primitive.ObjectID
+	LocationID   primitive.ObjectID
+	CompaniaID   primitive.ObjectID
+	Localizacion Location
+	Velocidad    float64
+	Rumbo        float64
+	At           time.Time
+}
 
-	// Insertar documento
-	if _, err := db.Collection("BusLocations").InsertOne(ctx, bl); err != nil {
-		log.Println("Error al insertar bus location:", err)
-		return err
+// NextLivePosition retorna la posición en vivo tras recibir bl. prev es la
+// posición anterior del bus, o nil si no se conoce.
+func NextLivePosition(prev *LivePosition, bl BusLocation) LivePosition {
+	p := LivePosition{
+		BusID:        bl.BusID,
+		LocationID:   bl.ID,
+		CompaniaID:   bl.CompaniaID,
+		Localizacion: bl.Localizacion,
+		At:           bl.CreatedAt,
 	}
-	return nil
+	if prev != nil {
+		// Por defecto conserva el rumbo anterior, que no se puede calcular si
+		// el bus no se movió.
+		p.Rumbo = prev.Rumbo
+		if dt := bl.CreatedAt.Sub(prev.At).Seconds(); dt > 0 {
+			d := prev.Localizacion.DistanceTo(bl.Localizacion)
+			p.Velocidad = d / dt * 3.6
+			if d > 0 {
+				p.Rumbo = prev.Localizacion.BearingTo(bl.Localizacion)
+			}
+		}
+	}
+	if bl.Velocidad != nil {
+		p.Velocidad = *bl.Velocidad
+	}
+	if bl.Rumbo != nil {
+		p.Rumbo = *bl.Rumbo
+	}
+	return p
 }
 
-// GetBusLocationsByBusID retorna todas las localizaciones de un bus.
-func GetBusLocationsByBusID(ctx context.Context, db *mongo.Database, busID primitive.ObjectID) ([]BusLocation, error) {
-	cursor, err := db.Collection("BusLocations").Find(ctx, bson.M{"bus_id": busID})
-	if err != nil {
-		return nil, err
+// BusLocation retorna la posición como localización, con la velocidad y el
+// rumbo en vivo.
+func (p LivePosition) BusLocation() BusLocation {
+	velocidad, rumbo := p.Velocidad, p.Rumbo
+	return BusLocation{
+		ID:           p.LocationID,
+		BusID:        p.BusID,
+		Localizacion: p.Localizacion,
+		CompaniaID:   p.CompaniaID,
+		CreatedAt:    p.At,
+		Velocidad:    &velocidad,
+		Rumbo:        &rumbo,
 	}
-	defer cursor.Close(ctx)
+}
 
-	var out []BusLocation
-	for cursor.Next(ctx) {
-		var bl BusLocation
-		if err := cursor.Decode(&bl); err != nil {
-			log.Println("Decode error:", err)
-			continue
-		}
-		out = append(out, bl)
-	}
-	return out, cursor.Err()
+// NearbyBus es un bus cuya última posición está a Distance metros de un punto.
+type NearbyBus struct {
+	Bus      Bus
+	Location BusLocation
+	Distance float64
+}
+
+// BoundingBox es un rectángulo geográfico delimitado por sus esquinas
+// suroeste (MinLat, MinLng) y noreste (MaxLat, MaxLng).
+type BoundingBox struct {
+	MinLat, MinLng float64
+	MaxLat, MaxLng float64
+}
+
+// LocationHistory es el recorrido de un bus entre From y To, en orden
+// cronológico. Total es el número de posiciones registradas en el intervalo;
+// Points puede contener menos si el recorrido se redujo con un Sampler.
+type LocationHistory struct {
+	BusID  primitive.ObjectID
+	From   time.Time
+	To     time.Time
+	Total  int64
+	Points []BusLocation
 }
 
-// GetAllBusLocations retorna todas las localizaciones.
-func GetAllBusLocations(ctx context.Context, db *mongo.Database) ([]BusLocation, error) {
-	cursor, err := db.Collection("BusLocations").Find(ctx, bson.M{})
-	if err != nil {
-		return nil, err
+// Sampler reduce una secuencia ordenada de total puntos a como mucho max
+// puntos equiespaciados, conservando siempre el primero y el último. Los
+// puntos se agregan uno a uno con Add, de modo que la secuencia completa no
+// necesita estar en memoria.
+type Sampler[T any] struct {
+	stride int64
+	max    int
+	n      int64
+	out    []T
+	last   T
+	lastIn bool
+}
+
+// NewSampler crea un Sampler para una secuencia de total puntos.
+func NewSampler[T any](total int64, max int) *Sampler[T] {
+	stride := int64(1)
+	if max > 0 && total > int64(max) {
+		stride = (total + int64(max) - 1) / int64(max)
 	}
-	defer cursor.Close(ctx)
+	return &Sampler[T]{stride: stride, max: max}
+}
 
-	var out []BusLocation
-	for cursor.Next(ctx) {
-		var bl BusLocation
-		if err := cursor.Decode(&bl); err != nil {
-			log.Println("Decode error:", err)
-			continue
-		}
-		out = append(out, bl)
+// Add agrega el siguiente punto de la secuencia.
+func (s *Sampler[T]) Add(v T) {
+	s.lastIn = s.n%s.stride == 0 && (s.max <= 0 || len(s.out) < s.max)
+	if s.lastIn {
+		s.out = append(s.out, v)
 	}
-	return out, cursor.Err()
+	s.last = v
+	s.n++
 }
 
-// DeleteBusLocation elimina una localización por su ID.
-func DeleteBusLocation(ctx context.Context, db *mongo.Database, id primitive.ObjectID) error {
-	if _, err := db.Collection("BusLocations").DeleteOne(ctx, bson.M{"_id": id}); err != nil {
-		log.Println("Error al eliminar bus location:", err)
-		return err
+// Points retorna los puntos conservados. Si el último punto agregado no
+// quedó entre ellos, reemplaza al último conservado.
+func (s *Sampler[T]) Points() []T {
+	if s.n > 0 && !s.lastIn {
+		s.out[len(s.out)-1] = s.last
+	}
+	if s.out == nil {
+		return []T{}
 	}
-	return nil
+	return s.out
 }
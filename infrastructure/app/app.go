@@ -0,0 +1,307 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"UbicaBus/UbicaBusBackend/application"
+	"UbicaBus/UbicaBusBackend/infrastructure/config"
+	"UbicaBus/UbicaBusBackend/infrastructure/delivery"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+)
+
+// App agrupa los servidores de UbicaBus y controla su ciclo de vida:
+// el API HTTP, el broker MQTT que recibe las posiciones de los buses, la
+// ingesta que las guarda en lotes, la compactación periódica del historial, el
+// seguimiento de la conectividad de los buses, el vencimiento de los comandos
+// y el hub de WebSockets que las reenvía a los navegadores.
+type App struct {
+	cfg          *config.Config
+	hub          *delivery.Hub
+	ingest       *application.LocationIngestor
+	rollups      *application.RollupService
+	connectivity *application.ConnectivityService
+	commands     *application.CommandService
+	mqtt         *mqtt.Server
+	http         *http.Server
+	ws           *http.Server // nil si el WebSocket comparte el servidor HTTP
+
+	httpListener net.Listener
+	wsListener   net.Listener
+
+	health       *delivery.HealthHandler
+	mqttServing  atomic.Bool
+	shuttingDown atomic.Bool
+
+	closers      []func(context.Context) error
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+// New crea la aplicación y reserva todos los puertos configurados, de modo que
+// cualquier error de arranque (puerto ocupado, dirección inválida) se reporta
+// antes de empezar a servir.
+func New(cfg *config.Config, svc delivery.Services) (*App, error) {
+	a := &App{cfg: cfg, hub: delivery.NewHub(), rollups: svc.Rollups, connectivity: svc.Connectivity, commands: svc.Commands}
+	a.ingest = application.NewLocationIngestor(svc.BusLocation.Locations, svc.BusLocation.Buses, svc.Fleet, application.IngestOptions{
+		QueueSize:     cfg.Ingest.QueueSize,
+		BatchSize:     cfg.Ingest.BatchSize,
+		FlushInterval: cfg.Ingest.FlushInterval,
+		BusCacheTTL:   cfg.Ingest.BusCacheTTL,
+		DBTimeout:     cfg.Ingest.DBTimeout,
+	})
+
+	mqttServer, err := delivery.NewMQTTServer(cfg.MQTT, a.ingest, svc.Devices, svc.Connectivity, svc.Commands, a.hub)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: %w", err)
+	}
+	a.mqtt = mqttServer
+
+	a.health = delivery.NewHealthHandler(a.shuttingDown.Load)
+	a.health.AddCheck("mqtt", a.checkMQTT)
+	a.health.AddCheck("hub", a.checkHub)
+	a.health.AddCheck("ingest", a.checkIngest)
+
+	a.http = a.newHTTPServer(cfg.HTTP.Addr, delivery.NewRouter(cfg, svc, a.hub, a.health))
+	if a.httpListener, err = net.Listen("tcp", cfg.HTTP.Addr); err != nil {
+		_ = a.mqtt.Close()
+		return nil, fmt.Errorf("http: %w", err)
+	}
+
+	if cfg.WS.Addr != "" {
+		a.ws = a.newHTTPServer(cfg.WS.Addr, delivery.NewWebsocketRouter(cfg, svc.Auth, a.hub))
+		if a.wsListener, err = net.Listen("tcp", cfg.WS.Addr); err != nil {
+			_ = a.httpListener.Close()
+			_ = a.mqtt.Close()
+			return nil, fmt.Errorf("websocket: %w", err)
+		}
+	}
+
+	return a, nil
+}
+
+// AddReadinessCheck agrega una verificación a /readyz (p. ej. el ping a MongoDB).
+func (a *App) AddReadinessCheck(name string, check delivery.HealthCheck) {
+	a.health.AddCheck(name, check)
+}
+
+// OnShutdown registra una función que se ejecuta al final del apagado, una vez
+// detenidos todos los servidores (p. ej. desconectar MongoDB).
+func (a *App) OnShutdown(fn func(context.Context) error) {
+	a.closers = append(a.closers, fn)
+}
+
+// Run arranca el hub, el broker MQTT y los servidores HTTP/WebSocket, y
+// bloquea hasta que ctx se cancele (SIGTERM) o alguno de ellos falle. En ambos
+// casos realiza un apagado ordenado antes de retornar.
+func (a *App) Run(ctx context.Context) error {
+	runErr := a.serve(ctx)
+	return errors.Join(runErr, a.Shutdown())
+}
+
+func (a *App) serve(ctx context.Context) error {
+	errCh := make(chan error, 3)
+
+	go a.hub.Run()
+	go a.ingest.Run()
+	go a.rollups.Run()
+	go a.connectivity.Run()
+	go a.commands.Run()
+
+	if err := a.mqtt.Serve(); err != nil {
+		return fmt.Errorf("mqtt: %w", err)
+	}
+	a.mqttServing.Store(true)
+	log.Printf("INFO: MQTT Broker started successfully on %s.", a.cfg.MQTT.Addr)
+
+	go func() {
+		log.Printf("Iniciando servidor HTTP en %s...", a.cfg.HTTP.Addr)
+		errCh <- serve("http", a.http, a.httpListener)
+	}()
+
+	if a.ws != nil {
+		go func() {
+			log.Printf("Iniciando servidor WebSocket en %s%s...", a.cfg.WS.Addr, a.cfg.WS.Path)
+			errCh <- serve("websocket", a.ws, a.wsListener)
+		}()
+	} else {
+		log.Printf("WebSocket disponible en %s%s", a.cfg.HTTP.Addr, a.cfg.WS.Path)
+	}
+
+	select {
+	case <-ctx.Done():
+		log.Println("Señal de apagado recibida, deteniendo servidores...")
+		return nil
+	case err := <-errCh:
+		return err
+	}
+}
+
+// Shutdown detiene la aplicación en orden dentro de cfg.ShutdownTimeout:
+//  1. deja de aceptar conexiones HTTP y MQTT, drenando las peticiones HTTP en
+//     curso y esperando a que el broker termine de procesar los PUBLISH recibidos;
+//  2. guarda las posiciones que quedan en la cola de ingesta y los cambios de
+//     conectividad pendientes, detiene la compactación del historial y el
+//     vencimiento de comandos, y despide a los clientes WebSocket con un
+//     close frame;
+//  3. ejecuta las funciones registradas con OnShutdown (MongoDB).
+//
+// Es seguro llamarlo más de una vez.
+func (a *App) Shutdown() error {
+	a.shutdownOnce.Do(func() {
+		a.shuttingDown.Store(true)
+
+		ctx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+		defer cancel()
+
+		var (
+			mu   sync.Mutex
+			errs []error
+			wg   sync.WaitGroup
+		)
+		record := func(name string, err error) {
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				mu.Unlock()
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record("http", a.http.Shutdown(ctx))
+		}()
+		if a.ws != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				record("websocket", a.ws.Shutdown(ctx))
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.mqttServing.Store(false)
+			record("mqtt", closeMQTT(ctx, a.mqtt))
+		}()
+		wg.Wait()
+
+		// Con el broker cerrado ya no llegan posiciones ni confirmaciones: se
+		// vacían las colas antes de desconectar MongoDB.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record("ingest", a.ingest.Close(ctx))
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record("rollups", a.rollups.Stop(ctx))
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record("connectivity", a.connectivity.Stop(ctx))
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record("commands", a.commands.Stop(ctx))
+		}()
+		record("hub", a.hub.Shutdown(ctx))
+		wg.Wait()
+
+		for _, fn := range a.closers {
+			record("cierre", fn(ctx))
+		}
+
+		a.shutdownErr = errors.Join(errs...)
+		if a.shutdownErr != nil {
+			log.Printf("Apagado con errores: %v", a.shutdownErr)
+		} else {
+			log.Println("Apagado completado.")
+		}
+	})
+	return a.shutdownErr
+}
+
+// closeMQTT cierra el broker: deja de aceptar clientes, desconecta los
+// existentes y espera a que terminen de procesar sus paquetes, hasta que venza ctx.
+func closeMQTT(ctx context.Context, server *mqtt.Server) error {
+	done := make(chan error, 1)
+	go func() { done <- server.Close() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkMQTT verifica que el broker esté sirviendo y tenga su listener abierto.
+func (a *App) checkMQTT(context.Context) (map[string]any, error) {
+	details := map[string]any{
+		"listeners": a.mqtt.Listeners.Len(),
+		"clients":   atomic.LoadInt64(&a.mqtt.Info.ClientsConnected),
+	}
+	if !a.mqttServing.Load() || a.mqtt.Listeners.Len() == 0 {
+		return details, errors.New("broker MQTT detenido")
+	}
+	return details, nil
+}
+
+// checkHub verifica que el loop del hub de WebSockets esté en ejecución.
+func (a *App) checkHub(context.Context) (map[string]any, error) {
+	details := map[string]any{"clients": a.hub.ClientCount()}
+	if !a.hub.Running() {
+		return details, errors.New("hub de WebSockets detenido")
+	}
+	return details, nil
+}
+
+// checkIngest reporta las métricas de la ingesta de posiciones y verifica que
+// el proceso de escritura esté en ejecución.
+func (a *App) checkIngest(context.Context) (map[string]any, error) {
+	s := a.ingest.Stats()
+	details := map[string]any{
+		"queued":    s.Queued,
+		"capacity":  s.Capacity,
+		"accepted":  s.Accepted,
+		"rejected":  s.Rejected,
+		"dropped":   s.Dropped,
+		"persisted": s.Persisted,
+		"failed":    s.Failed,
+		"batches":   s.Batches,
+		"bus_cache": s.BusCache,
+	}
+	if !a.ingest.Running() {
+		return details, errors.New("ingesta de posiciones detenida")
+	}
+	return details, nil
+}
+
+func (a *App) newHTTPServer(addr string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      h,
+		ReadTimeout:  a.cfg.HTTP.ReadTimeout,
+		WriteTimeout: a.cfg.HTTP.WriteTimeout,
+		IdleTimeout:  a.cfg.HTTP.IdleTimeout,
+	}
+}
+
+// serve atiende conexiones hasta que el servidor se detiene o falla.
+func serve(name string, srv *http.Server, l net.Listener) error {
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
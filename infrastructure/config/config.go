@@ -0,0 +1,419 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config reúne toda la configuración del servidor.
+// Se carga en este orden: valores por defecto, archivo YAML opcional y
+// variables de entorno (que tienen prioridad sobre el archivo).
+type Config struct {
+	Mongo     MongoConfig     `yaml:"mongo"`
+	HTTP      HTTPConfig      `yaml:"http"`
+	MQTT      MQTTConfig      `yaml:"mqtt"`
+	WS        WSConfig        `yaml:"ws"`
+	CORS      CORSConfig      `yaml:"cors"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Ingest    IngestConfig    `yaml:"ingest"`
+	Retention RetentionConfig `yaml:"retention"`
+	Heartbeat HeartbeatConfig `yaml:"heartbeat"`
+	Commands  CommandsConfig  `yaml:"commands"`
+	Bootstrap BootstrapConfig `yaml:"bootstrap"`
+
+	// ShutdownTimeout es el plazo máximo para detener los servidores, drenar
+	// las peticiones en curso y desconectar MongoDB al recibir SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// MongoConfig configura la conexión a MongoDB. AllowStandalone permite usar
+// un servidor sin transacciones (standalone): las eliminaciones en cascada y
+// demás operaciones de varios documentos se ejecutan entonces sin atomicidad.
+// Por defecto se exige un replica set.
+type MongoConfig struct {
+	URI             string        `yaml:"uri"`
+	Database        string        `yaml:"database"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout"`
+	AllowStandalone bool          `yaml:"allow_standalone"`
+}
+
+// HTTPConfig configura el servidor HTTP.
+type HTTPConfig struct {
+	Addr         string        `yaml:"addr"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+}
+
+// MQTTConfig configura el broker MQTT embebido. Una dirección IP que falla
+// AuthMaxFailures autenticaciones dentro de AuthWindow queda bloqueada hasta
+// que venza la ventana.
+type MQTTConfig struct {
+	Addr            string        `yaml:"addr"`
+	AuthMaxFailures int           `yaml:"auth_max_failures"`
+	AuthWindow      time.Duration `yaml:"auth_window"`
+}
+
+// WSConfig configura el endpoint de WebSockets. Si Addr está vacío el
+// endpoint se sirve en el mismo servidor HTTP.
+type WSConfig struct {
+	Addr string `yaml:"addr"`
+	Path string `yaml:"path"`
+}
+
+// CORSConfig configura los orígenes permitidos para el frontend.
+type CORSConfig struct {
+	AllowOrigins []string      `yaml:"allow_origins"`
+	MaxAge       time.Duration `yaml:"max_age"`
+}
+
+// AuthConfig configura la emisión de tokens y el hash de contraseñas.
+type AuthConfig struct {
+	JWTSecret        string        `yaml:"jwt_secret"`
+	AccessTokenTTL   time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL  time.Duration `yaml:"refresh_token_ttl"`
+	PasswordHashAlgo string        `yaml:"password_hash_algo"`
+}
+
+// IngestConfig configura la ingesta de posiciones recibidas por MQTT, que se
+// encolan y se guardan en lotes.
+type IngestConfig struct {
+	QueueSize     int           `yaml:"queue_size"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	BusCacheTTL   time.Duration `yaml:"bus_cache_ttl"`
+	DBTimeout     time.Duration `yaml:"db_timeout"`
+}
+
+// RetentionConfig configura cuánto se conserva el historial de posiciones y
+// su compactación en resúmenes por bus y minuto.
+type RetentionConfig struct {
+	Raw            time.Duration `yaml:"raw"`             // antigüedad máxima de las posiciones originales
+	Rollups        time.Duration `yaml:"rollups"`         // antigüedad máxima de los resúmenes por minuto
+	RollupInterval time.Duration `yaml:"rollup_interval"` // cada cuánto se compactan las posiciones nuevas
+	RollupLag      time.Duration `yaml:"rollup_lag"`      // margen para las posiciones que llegan con retraso
+	RollupPoints   int           `yaml:"rollup_points"`   // puntos conservados por bus y minuto
+}
+
+// HeartbeatConfig configura el seguimiento de la conectividad de los buses:
+// un bus conectado pasa a stale tras StaleAfter sin conexiones ni posiciones
+// nuevas, y a offline tras OfflineAfter. La inactividad se revisa cada
+// CheckInterval.
+type HeartbeatConfig struct {
+	StaleAfter    time.Duration `yaml:"stale_after"`
+	OfflineAfter  time.Duration `yaml:"offline_after"`
+	CheckInterval time.Duration `yaml:"check_interval"`
+}
+
+// CommandsConfig configura los comandos enviados a los dispositivos: un
+// comando que ningún dispositivo confirma dentro de TTL vence y deja de
+// reenviarse. Los vencidos se marcan así cada ExpireInterval.
+type CommandsConfig struct {
+	TTL            time.Duration `yaml:"ttl"`
+	ExpireInterval time.Duration `yaml:"expire_interval"`
+}
+
+// BootstrapConfig define el usuario super-admin que se crea al arrancar si no
+// existe, para tener acceso a una base de datos sin usuarios o cuyos roles no
+// conceden permisos. Vacío no crea ninguno.
+type BootstrapConfig struct {
+	AdminUser     string `yaml:"admin_user"`
+	AdminPassword string `yaml:"admin_password"`
+}
+
+// minJWTSecretLength es la longitud mínima aceptada para JWT_SECRET.
+const minJWTSecretLength = 32
+
+// Default retorna la configuración por defecto para desarrollo local.
+func Default() *Config {
+	return &Config{
+		Mongo: MongoConfig{
+			Database:       "Development",
+			ConnectTimeout: 10 * time.Second,
+		},
+		HTTP: HTTPConfig{
+			Addr:         ":8080",
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		},
+		MQTT: MQTTConfig{
+			Addr:            ":1883",
+			AuthMaxFailures: 5,
+			AuthWindow:      time.Minute,
+		},
+		WS: WSConfig{
+			Path: "/ws",
+		},
+		CORS: CORSConfig{
+			AllowOrigins: []string{"*"},
+			MaxAge:       12 * time.Hour,
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:   15 * time.Minute,
+			RefreshTokenTTL:  7 * 24 * time.Hour,
+			PasswordHashAlgo: "argon2id",
+		},
+		Ingest: IngestConfig{
+			QueueSize:     10000,
+			BatchSize:     500,
+			FlushInterval: time.Second,
+			BusCacheTTL:   time.Minute,
+			DBTimeout:     10 * time.Second,
+		},
+		Retention: RetentionConfig{
+			Raw:            30 * 24 * time.Hour,
+			Rollups:        365 * 24 * time.Hour,
+			RollupInterval: 5 * time.Minute,
+			RollupLag:      2 * time.Minute,
+			RollupPoints:   6,
+		},
+		Heartbeat: HeartbeatConfig{
+			StaleAfter:    2 * time.Minute,
+			OfflineAfter:  10 * time.Minute,
+			CheckInterval: 15 * time.Second,
+		},
+		Commands: CommandsConfig{
+			TTL:            24 * time.Hour,
+			ExpireInterval: time.Minute,
+		},
+		ShutdownTimeout: 20 * time.Second,
+	}
+}
+
+// Load carga la configuración. Si path no está vacío se lee ese archivo YAML;
+// después se aplican las variables de entorno y se valida el resultado.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("leyendo archivo de configuración: %w", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("interpretando archivo de configuración %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv sobrescribe la configuración con las variables de entorno definidas.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	str := func(name string, dst *string) {
+		if v, ok := lookup(name); ok && v != "" {
+			*dst = v
+		}
+	}
+	var errs []error
+	dur := func(name string, dst *time.Duration) {
+		if v, ok := lookup(name); ok && v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: duración inválida %q", name, v))
+				return
+			}
+			*dst = d
+		}
+	}
+	boolean := func(name string, dst *bool) {
+		if v, ok := lookup(name); ok && v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: booleano inválido %q", name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	num := func(name string, dst *int) {
+		if v, ok := lookup(name); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: número inválido %q", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+
+	str("MONGO_URI", &c.Mongo.URI)
+	str("MONGO_DATABASE", &c.Mongo.Database)
+	dur("MONGO_CONNECT_TIMEOUT", &c.Mongo.ConnectTimeout)
+	boolean("MONGO_ALLOW_STANDALONE", &c.Mongo.AllowStandalone)
+
+	// PORT lo define la plataforma de despliegue (Koyeb); HTTP_ADDR tiene prioridad.
+	if port, ok := lookup("PORT"); ok && port != "" {
+		c.HTTP.Addr = ":" + port
+	}
+	str("HTTP_ADDR", &c.HTTP.Addr)
+	dur("HTTP_READ_TIMEOUT", &c.HTTP.ReadTimeout)
+	dur("HTTP_WRITE_TIMEOUT", &c.HTTP.WriteTimeout)
+	dur("HTTP_IDLE_TIMEOUT", &c.HTTP.IdleTimeout)
+
+	str("MQTT_ADDR", &c.MQTT.Addr)
+	num("MQTT_AUTH_MAX_FAILURES", &c.MQTT.AuthMaxFailures)
+	dur("MQTT_AUTH_WINDOW", &c.MQTT.AuthWindow)
+
+	str("WS_ADDR", &c.WS.Addr)
+	str("WS_PATH", &c.WS.Path)
+
+	if v, ok := lookup("CORS_ALLOW_ORIGINS"); ok && v != "" {
+		c.CORS.AllowOrigins = splitList(v)
+	}
+	dur("CORS_MAX_AGE", &c.CORS.MaxAge)
+
+	str("JWT_SECRET", &c.Auth.JWTSecret)
+	dur("ACCESS_TOKEN_TTL", &c.Auth.AccessTokenTTL)
+	dur("REFRESH_TOKEN_TTL", &c.Auth.RefreshTokenTTL)
+	str("PASSWORD_HASH_ALGO", &c.Auth.PasswordHashAlgo)
+
+	num("INGEST_QUEUE_SIZE", &c.Ingest.QueueSize)
+	num("INGEST_BATCH_SIZE", &c.Ingest.BatchSize)
+	dur("INGEST_FLUSH_INTERVAL", &c.Ingest.FlushInterval)
+	dur("INGEST_BUS_CACHE_TTL", &c.Ingest.BusCacheTTL)
+	dur("INGEST_DB_TIMEOUT", &c.Ingest.DBTimeout)
+
+	dur("RETENTION_RAW", &c.Retention.Raw)
+	dur("RETENTION_ROLLUPS", &c.Retention.Rollups)
+	dur("RETENTION_ROLLUP_INTERVAL", &c.Retention.RollupInterval)
+	dur("RETENTION_ROLLUP_LAG", &c.Retention.RollupLag)
+	num("RETENTION_ROLLUP_POINTS", &c.Retention.RollupPoints)
+
+	dur("HEARTBEAT_STALE_AFTER", &c.Heartbeat.StaleAfter)
+	dur("HEARTBEAT_OFFLINE_AFTER", &c.Heartbeat.OfflineAfter)
+	dur("HEARTBEAT_CHECK_INTERVAL", &c.Heartbeat.CheckInterval)
+
+	dur("COMMANDS_TTL", &c.Commands.TTL)
+	dur("COMMANDS_EXPIRE_INTERVAL", &c.Commands.ExpireInterval)
+
+	str("BOOTSTRAP_ADMIN_USER", &c.Bootstrap.AdminUser)
+	str("BOOTSTRAP_ADMIN_PASSWORD", &c.Bootstrap.AdminPassword)
+
+	dur("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+
+	return errors.Join(errs...)
+}
+
+// Validate verifica que la configuración sea utilizable y reporta todos los
+// problemas encontrados a la vez.
+func (c *Config) Validate() error {
+	var errs []error
+	required := func(name, v string) {
+		if strings.TrimSpace(v) == "" {
+			errs = append(errs, fmt.Errorf("%s es obligatorio", name))
+		}
+	}
+	positive := func(name string, d time.Duration) {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s debe ser mayor que cero", name))
+		}
+	}
+
+	required("mongo.uri (MONGO_URI)", c.Mongo.URI)
+	if c.Mongo.URI != "" && !strings.HasPrefix(c.Mongo.URI, "mongodb://") && !strings.HasPrefix(c.Mongo.URI, "mongodb+srv://") {
+		errs = append(errs, errors.New("mongo.uri debe empezar por mongodb:// o mongodb+srv://"))
+	}
+	required("mongo.database (MONGO_DATABASE)", c.Mongo.Database)
+	positive("mongo.connect_timeout", c.Mongo.ConnectTimeout)
+
+	required("http.addr (HTTP_ADDR/PORT)", c.HTTP.Addr)
+	positive("http.read_timeout", c.HTTP.ReadTimeout)
+	positive("http.write_timeout", c.HTTP.WriteTimeout)
+	positive("http.idle_timeout", c.HTTP.IdleTimeout)
+
+	required("mqtt.addr (MQTT_ADDR)", c.MQTT.Addr)
+	if c.MQTT.AuthMaxFailures <= 0 {
+		errs = append(errs, errors.New("mqtt.auth_max_failures debe ser mayor que cero"))
+	}
+	positive("mqtt.auth_window", c.MQTT.AuthWindow)
+
+	if !strings.HasPrefix(c.WS.Path, "/") {
+		errs = append(errs, errors.New("ws.path debe empezar por /"))
+	}
+	if c.WS.Addr != "" && c.WS.Addr == c.HTTP.Addr {
+		errs = append(errs, errors.New("ws.addr no puede coincidir con http.addr; déjalo vacío para compartir el servidor HTTP"))
+	}
+
+	if len(c.CORS.AllowOrigins) == 0 {
+		errs = append(errs, errors.New("cors.allow_origins no puede estar vacío"))
+	}
+
+	required("auth.jwt_secret (JWT_SECRET)", c.Auth.JWTSecret)
+	if c.Auth.JWTSecret != "" && len(c.Auth.JWTSecret) < minJWTSecretLength {
+		errs = append(errs, fmt.Errorf("auth.jwt_secret debe tener al menos %d caracteres", minJWTSecretLength))
+	}
+	positive("auth.access_token_ttl", c.Auth.AccessTokenTTL)
+	positive("auth.refresh_token_ttl", c.Auth.RefreshTokenTTL)
+	switch c.Auth.PasswordHashAlgo {
+	case "argon2id", "bcrypt":
+	default:
+		errs = append(errs, fmt.Errorf("auth.password_hash_algo no soportado: %q", c.Auth.PasswordHashAlgo))
+	}
+
+	if c.Ingest.QueueSize <= 0 || c.Ingest.BatchSize <= 0 {
+		errs = append(errs, errors.New("ingest.queue_size e ingest.batch_size deben ser mayores que cero"))
+	} else if c.Ingest.BatchSize > c.Ingest.QueueSize {
+		errs = append(errs, errors.New("ingest.batch_size no puede superar ingest.queue_size"))
+	}
+	positive("ingest.flush_interval", c.Ingest.FlushInterval)
+	positive("ingest.bus_cache_ttl", c.Ingest.BusCacheTTL)
+	positive("ingest.db_timeout", c.Ingest.DBTimeout)
+
+	positive("retention.raw", c.Retention.Raw)
+	positive("retention.rollups", c.Retention.Rollups)
+	positive("retention.rollup_interval", c.Retention.RollupInterval)
+	positive("retention.rollup_lag", c.Retention.RollupLag)
+	if c.Retention.RollupPoints < 2 {
+		errs = append(errs, errors.New("retention.rollup_points debe ser al menos 2"))
+	}
+	// Las posiciones deben compactarse antes de que las elimine el índice TTL.
+	if c.Retention.Raw <= c.Retention.RollupLag+c.Retention.RollupInterval {
+		errs = append(errs, errors.New("retention.raw debe superar retention.rollup_lag + retention.rollup_interval"))
+	}
+	if c.Retention.Rollups < c.Retention.Raw {
+		errs = append(errs, errors.New("retention.rollups no puede ser menor que retention.raw"))
+	}
+
+	positive("heartbeat.stale_after", c.Heartbeat.StaleAfter)
+	positive("heartbeat.offline_after", c.Heartbeat.OfflineAfter)
+	positive("heartbeat.check_interval", c.Heartbeat.CheckInterval)
+	if c.Heartbeat.OfflineAfter <= c.Heartbeat.StaleAfter {
+		errs = append(errs, errors.New("heartbeat.offline_after debe superar heartbeat.stale_after"))
+	}
+
+	positive("commands.ttl", c.Commands.TTL)
+	positive("commands.expire_interval", c.Commands.ExpireInterval)
+
+	if (c.Bootstrap.AdminUser == "") != (c.Bootstrap.AdminPassword == "") {
+		errs = append(errs, errors.New("bootstrap.admin_user y bootstrap.admin_password deben definirse juntos"))
+	}
+
+	positive("shutdown_timeout", c.ShutdownTimeout)
+
+	return errors.Join(errs...)
+}
+
+// splitList separa una lista separada por comas ignorando espacios y elementos vacíos.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
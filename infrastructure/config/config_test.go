@@ -0,0 +1,189 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSecret es un JWT_SECRET con la longitud mínima.
+var testSecret = strings.Repeat("k", minJWTSecretLength)
+
+// validConfig retorna una configuración por defecto que pasa Validate.
+func validConfig() *Config {
+	cfg := Default()
+	cfg.Mongo.URI = "mongodb://localhost:27017"
+	cfg.Auth.JWTSecret = testSecret
+	return cfg
+}
+
+// testEnv son las variables de entorno que usan las pruebas de Load. Se
+// vacían antes de cada caso para que no influya el entorno del proceso.
+var testEnv = []string{
+	"MONGO_URI", "MONGO_DATABASE", "PORT", "HTTP_ADDR", "HTTP_READ_TIMEOUT",
+	"MQTT_AUTH_MAX_FAILURES", "MONGO_ALLOW_STANDALONE", "CORS_ALLOW_ORIGINS", "JWT_SECRET",
+}
+
+func TestLoad(t *testing.T) {
+	const file = `
+mongo:
+  uri: "mongodb://archivo:27017"
+  database: "Archivo"
+http:
+  addr: ":7000"
+  read_timeout: 5s
+auth:
+  jwt_secret: "kkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkk"
+`
+	cases := []struct {
+		name    string
+		yaml    string // vacío: sin archivo
+		env     map[string]string
+		check   func(t *testing.T, cfg *Config)
+		wantErr string
+	}{
+		{name: "valores del archivo", yaml: file, check: func(t *testing.T, cfg *Config) {
+			if cfg.Mongo.URI != "mongodb://archivo:27017" || cfg.Mongo.Database != "Archivo" {
+				t.Errorf("mongo = %+v", cfg.Mongo)
+			}
+			if cfg.HTTP.Addr != ":7000" || cfg.HTTP.ReadTimeout != 5*time.Second {
+				t.Errorf("http = %+v", cfg.HTTP)
+			}
+			// Lo que el archivo no define conserva el valor por defecto.
+			if cfg.HTTP.WriteTimeout != Default().HTTP.WriteTimeout {
+				t.Errorf("http.write_timeout = %v", cfg.HTTP.WriteTimeout)
+			}
+		}},
+		{name: "el entorno tiene prioridad sobre el archivo", yaml: file,
+			env: map[string]string{"MONGO_DATABASE": "Entorno", "HTTP_READ_TIMEOUT": "30s", "MQTT_AUTH_MAX_FAILURES": "9"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Mongo.Database != "Entorno" || cfg.HTTP.ReadTimeout != 30*time.Second || cfg.MQTT.AuthMaxFailures != 9 {
+					t.Errorf("cfg = %+v", cfg)
+				}
+				if cfg.Mongo.URI != "mongodb://archivo:27017" {
+					t.Errorf("mongo.uri = %q", cfg.Mongo.URI)
+				}
+			}},
+		{name: "solo entorno", env: map[string]string{
+			"MONGO_URI": "mongodb://entorno:27017", "JWT_SECRET": testSecret,
+			"MONGO_ALLOW_STANDALONE": "true", "CORS_ALLOW_ORIGINS": " https://a.example, ,https://b.example",
+		}, check: func(t *testing.T, cfg *Config) {
+			if cfg.Mongo.URI != "mongodb://entorno:27017" || !cfg.Mongo.AllowStandalone {
+				t.Errorf("mongo = %+v", cfg.Mongo)
+			}
+			if got := strings.Join(cfg.CORS.AllowOrigins, " "); got != "https://a.example https://b.example" {
+				t.Errorf("cors.allow_origins = %q", got)
+			}
+		}},
+		{name: "PORT define la dirección HTTP", yaml: file, env: map[string]string{"PORT": "9000"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Addr != ":9000" {
+					t.Errorf("http.addr = %q, se esperaba :9000", cfg.HTTP.Addr)
+				}
+			}},
+		{name: "HTTP_ADDR tiene prioridad sobre PORT", yaml: file, env: map[string]string{"PORT": "9000", "HTTP_ADDR": "127.0.0.1:9100"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Addr != "127.0.0.1:9100" {
+					t.Errorf("http.addr = %q, se esperaba 127.0.0.1:9100", cfg.HTTP.Addr)
+				}
+			}},
+		{name: "duración inválida en el entorno", yaml: file, env: map[string]string{"HTTP_READ_TIMEOUT": "30"},
+			wantErr: "HTTP_READ_TIMEOUT: duración inválida"},
+		{name: "número inválido en el entorno", yaml: file, env: map[string]string{"MQTT_AUTH_MAX_FAILURES": "muchos"},
+			wantErr: "MQTT_AUTH_MAX_FAILURES: número inválido"},
+		{name: "booleano inválido en el entorno", yaml: file, env: map[string]string{"MONGO_ALLOW_STANDALONE": "quizas"},
+			wantErr: "MONGO_ALLOW_STANDALONE: booleano inválido"},
+		{name: "duración inválida en el archivo", yaml: "http:\n  read_timeout: pronto\n",
+			wantErr: "interpretando archivo de configuración"},
+		{name: "sin JWT_SECRET", yaml: "mongo:\n  uri: \"mongodb://archivo:27017\"\n",
+			wantErr: "auth.jwt_secret (JWT_SECRET) es obligatorio"},
+		{name: "JWT_SECRET corto", yaml: file, env: map[string]string{"JWT_SECRET": "corto"},
+			wantErr: "auth.jwt_secret debe tener al menos"},
+		{name: "sin MONGO_URI", env: map[string]string{"JWT_SECRET": testSecret},
+			wantErr: "mongo.uri (MONGO_URI) es obligatorio"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, name := range testEnv {
+				t.Setenv(name, "")
+			}
+			for name, v := range tc.env {
+				t.Setenv(name, v)
+			}
+			path := ""
+			if tc.yaml != "" {
+				path = filepath.Join(t.TempDir(), "config.yaml")
+				if err := os.WriteFile(path, []byte(tc.yaml), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg, err := Load(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error = %v, se esperaba %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, cfg)
+		})
+	}
+
+	t.Run("archivo inexistente", func(t *testing.T) {
+		if _, err := Load(filepath.Join(t.TempDir(), "no-existe.yaml")); err == nil || !strings.Contains(err.Error(), "leyendo archivo") {
+			t.Fatalf("error = %v", err)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("configuración válida rechazada: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{name: "JWT secret vacío", modify: func(c *Config) { c.Auth.JWTSecret = "" }, wantErr: "auth.jwt_secret (JWT_SECRET) es obligatorio"},
+		{name: "JWT secret de espacios", modify: func(c *Config) { c.Auth.JWTSecret = strings.Repeat(" ", minJWTSecretLength) }, wantErr: "auth.jwt_secret (JWT_SECRET) es obligatorio"},
+		{name: "JWT secret corto", modify: func(c *Config) { c.Auth.JWTSecret = testSecret[1:] }, wantErr: "auth.jwt_secret debe tener al menos 32 caracteres"},
+		{name: "URI de MongoDB sin esquema", modify: func(c *Config) { c.Mongo.URI = "localhost:27017" }, wantErr: "mongo.uri debe empezar por"},
+		{name: "duración cero", modify: func(c *Config) { c.HTTP.ReadTimeout = 0 }, wantErr: "http.read_timeout debe ser mayor que cero"},
+		{name: "duración negativa", modify: func(c *Config) { c.Commands.TTL = -time.Minute }, wantErr: "commands.ttl debe ser mayor que cero"},
+		{name: "algoritmo de contraseñas desconocido", modify: func(c *Config) { c.Auth.PasswordHashAlgo = "md5" }, wantErr: "auth.password_hash_algo no soportado"},
+		{name: "ruta de WebSocket relativa", modify: func(c *Config) { c.WS.Path = "ws" }, wantErr: "ws.path debe empezar por /"},
+		{name: "WebSocket en la dirección HTTP", modify: func(c *Config) { c.WS.Addr = c.HTTP.Addr }, wantErr: "ws.addr no puede coincidir con http.addr"},
+		{name: "sin orígenes CORS", modify: func(c *Config) { c.CORS.AllowOrigins = nil }, wantErr: "cors.allow_origins no puede estar vacío"},
+		{name: "lote mayor que la cola", modify: func(c *Config) { c.Ingest.BatchSize = c.Ingest.QueueSize + 1 }, wantErr: "ingest.batch_size no puede superar"},
+		{name: "retención menor que la compactación", modify: func(c *Config) { c.Retention.Raw = c.Retention.RollupInterval }, wantErr: "retention.raw debe superar"},
+		{name: "offline antes que stale", modify: func(c *Config) { c.Heartbeat.OfflineAfter = c.Heartbeat.StaleAfter }, wantErr: "heartbeat.offline_after debe superar"},
+		{name: "bootstrap sin contraseña", modify: func(c *Config) { c.Bootstrap.AdminUser = "admin" }, wantErr: "deben definirse juntos"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := validConfig()
+			tc.modify(cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("error = %v, se esperaba %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	t.Run("reporta todos los problemas", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Auth.JWTSecret = ""
+		cfg.HTTP.IdleTimeout = 0
+		err := cfg.Validate()
+		if err == nil || !strings.Contains(err.Error(), "auth.jwt_secret") || !strings.Contains(err.Error(), "http.idle_timeout") {
+			t.Fatalf("error = %v", err)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package delivery
+
+import (
+	"errors"
+	"strings"
+
+	"UbicaBus/UbicaBusBackend/application"
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey es la clave del contexto de Gin donde se guarda el usuario autenticado.
+const principalKey = "principal"
+
+var (
+	errTokenRequired = domain.NewError(domain.KindUnauthorized, "token_required", "token de acceso requerido")
+	errInvalidRole   = domain.NewError(domain.KindForbidden, "invalid_role", "rol del usuario no válido")
+)
+
+// Principal representa al usuario autenticado en la petición actual. User y
+// Role son los vigentes, que pueden diferir de los que indican los claims.
+type Principal struct {
+	Claims *application.AccessClaims
+	User   *domain.User
+	Role   *domain.Role
+}
+
+// AuthMiddleware exige un access token válido en la cabecera Authorization
+// ("Bearer <token>") y carga el usuario y el rol vigentes.
+// Responde 401 si el token falta o no es válido o si el usuario ya no existe,
+// y 403 si el rol ya no existe.
+func AuthMiddleware(authService *application.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c)
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="ubicabus"`)
+			abortWithError(c, errTokenRequired)
+			return
+		}
+		p, err := authenticate(authService, token)
+		if err != nil {
+			if errors.Is(err, application.ErrInvalidToken) {
+				c.Header("WWW-Authenticate", `Bearer realm="ubicabus", error="invalid_token"`)
+			}
+			abortWithError(c, err)
+			return
+		}
+
+		c.Set(principalKey, p)
+		c.Next()
+	}
+}
+
+// bearerToken extrae el token de la cabecera Authorization ("Bearer <token>").
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// authenticate valida el access token y carga el usuario y el rol vigentes.
+func authenticate(authService *application.AuthService, token string) (*Principal, error) {
+	claims, err := authService.ParseAccessToken(token)
+	if err != nil {
+		return nil, err
+	}
+	user, role, err := authService.UserForClaims(claims)
+	if err != nil {
+		if errors.Is(err, application.ErrForbidden) {
+			err = errInvalidRole
+		}
+		return nil, err
+	}
+	return &Principal{Claims: claims, User: user, Role: role}, nil
+}
+
+// RequirePermission exige que el rol del usuario autenticado conceda el permiso indicado.
+// Debe registrarse después de AuthMiddleware.
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := currentPrincipal(c)
+		if !ok {
+			abortWithError(c, errTokenRequired)
+			return
+		}
+		if !p.Role.HasPermission(perm) {
+			abortWithError(c, application.ErrForbidden.WithDetails(map[string]any{"permiso": perm}))
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentPrincipal retorna el usuario autenticado por AuthMiddleware.
+func currentPrincipal(c *gin.Context) (*Principal, bool) {
+	v, ok := c.Get(principalKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := v.(*Principal)
+	return p, ok
+}
+
+// scopeFrom construye el Scope del usuario autenticado: su propia compañía,
+// o todas si su rol concede PermAllTenants. Sin usuario autenticado retorna
+// un Scope vacío que no coincide con ninguna compañía.
+func scopeFrom(c *gin.Context) domain.Scope {
+	p, ok := currentPrincipal(c)
+	if !ok {
+		return domain.Scope{}
+	}
+	return p.Scope()
+}
+
+// roleFrom retorna el rol vigente del usuario autenticado, o nil si no hay.
+func roleFrom(c *gin.Context) *domain.Role {
+	p, ok := currentPrincipal(c)
+	if !ok {
+		return nil
+	}
+	return p.Role
+}
+
+// Scope retorna el Scope del usuario: su compañía vigente, o todas si su rol
+// concede PermAllTenants.
+func (p *Principal) Scope() domain.Scope {
+	return domain.Scope{
+		CompaniaID:   p.User.Compania,
+		AllCompanies: p.Role.HasPermission(domain.PermAllTenants),
+	}
+}
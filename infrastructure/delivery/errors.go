@@ -0,0 +1,83 @@
+package delivery
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorStatuses traduce cada tipo de domain.Error a un código HTTP.
+var errorStatuses = map[domain.ErrorKind]int{
+	domain.KindValidation:   http.StatusBadRequest,
+	domain.KindNotFound:     http.StatusNotFound,
+	domain.KindConflict:     http.StatusConflict,
+	domain.KindForbidden:    http.StatusForbidden,
+	domain.KindUnauthorized: http.StatusUnauthorized,
+	domain.KindInternal:     http.StatusInternalServerError,
+}
+
+// ErrorResponse es el cuerpo JSON de todas las respuestas de error del API.
+type ErrorResponse struct {
+	Error   string         `json:"error"`
+	Code    string         `json:"code"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// ErrorMiddleware escribe la respuesta de error de los handlers que
+// terminaron con abortWithError. Los errores que no son domain.Error se
+// responden como 500 sin exponer su causa, que solo se registra en el log.
+func ErrorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		e := domain.AsError(c.Errors.Last().Err)
+		status, ok := errorStatuses[e.Kind]
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		if status == http.StatusInternalServerError {
+			log.Printf("Error interno en %s %s: %v", c.Request.Method, c.FullPath(), e)
+		}
+		c.JSON(status, ErrorResponse{Error: e.Message, Code: e.Code, Details: e.Details})
+	}
+}
+
+// abortWithError detiene la cadena de handlers y deja que ErrorMiddleware
+// responda con el error.
+func abortWithError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// invalidBody envuelve un error de binding del cuerpo de la petición.
+func invalidBody(err error) error {
+	return domain.NewError(domain.KindValidation, "invalid_body", "Datos de entrada inválidos").
+		WithDetails(map[string]any{"detalle": err.Error()})
+}
+
+// invalidQuery envuelve un error de binding de los parámetros de la query string.
+func invalidQuery(err error) error {
+	return domain.NewError(domain.KindValidation, "invalid_query", "Parámetros de consulta inválidos").
+		WithDetails(map[string]any{"detalle": err.Error()})
+}
+
+// requiredParam construye el error de un parámetro obligatorio ausente.
+func requiredParam(message string) error {
+	return domain.NewError(domain.KindValidation, "missing_parameter", message)
+}
+
+// cascadeParam lee el parámetro opcional ?cascade=true|false de las eliminaciones.
+func cascadeParam(c *gin.Context) (bool, error) {
+	cascade, err := strconv.ParseBool(c.DefaultQuery("cascade", "false"))
+	if err != nil {
+		return false, domain.NewValidationError("el parámetro 'cascade' debe ser true o false")
+	}
+	return cascade, nil
+}
@@ -0,0 +1,106 @@
+package delivery
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout es el plazo máximo de cada verificación de /readyz.
+const readinessTimeout = 2 * time.Second
+
+// HealthCheck verifica un componente. Puede retornar datos adicionales que se
+// incluyen en la respuesta (p. ej. número de clientes conectados).
+type HealthCheck func(ctx context.Context) (map[string]any, error)
+
+// ComponentStatus es el estado de un componente en la respuesta de /readyz.
+type ComponentStatus struct {
+	Status    string         `json:"status"`
+	LatencyMS float64        `json:"latency_ms"`
+	Error     string         `json:"error,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// HealthHandler expone /healthz (liveness) y /readyz (readiness).
+type HealthHandler struct {
+	mu           sync.RWMutex
+	checks       map[string]HealthCheck
+	shuttingDown func() bool
+}
+
+// NewHealthHandler crea un HealthHandler. shuttingDown indica si la aplicación
+// se está deteniendo, en cuyo caso /readyz responde 503.
+func NewHealthHandler(shuttingDown func() bool) *HealthHandler {
+	return &HealthHandler{checks: make(map[string]HealthCheck), shuttingDown: shuttingDown}
+}
+
+// AddCheck registra una verificación de readiness con el nombre del componente.
+func (h *HealthHandler) AddCheck(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// LivenessHandler responde 200 mientras el proceso sea capaz de atender peticiones.
+func (h *HealthHandler) LivenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadinessHandler ejecuta todas las verificaciones en paralelo y responde 503
+// si alguna falla o si la aplicación se está deteniendo.
+func (h *HealthHandler) ReadinessHandler(c *gin.Context) {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	checks := make([]HealthCheck, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		checks[i] = h.checks[name]
+	}
+	h.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	results := make([]ComponentStatus, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			details, err := check(ctx)
+			res := ComponentStatus{
+				Status:    "ok",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+				Details:   details,
+			}
+			if err != nil {
+				res.Status = "error"
+				res.Error = err.Error()
+			}
+			results[i] = res
+		}(i, check)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	components := make(map[string]ComponentStatus, len(names))
+	for i, name := range names {
+		components[name] = results[i]
+		if results[i].Status != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	if h.shuttingDown != nil && h.shuttingDown() {
+		status, code = "shutting_down", http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, gin.H{"status": status, "components": components})
+}
@@ -7,19 +7,84 @@ import (
 
 	"UbicaBus/UbicaBusBackend/application"
 	"UbicaBus/UbicaBusBackend/domain"
+	"UbicaBus/UbicaBusBackend/infrastructure/config"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// AuthHandler maneja el login y la rotación de tokens.
+type AuthHandler struct {
+	AuthService *application.AuthService
+}
+
+type LoginReq struct {
+	Nombre   string `json:"nombre" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type RefreshTokenReq struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// NewAuthHandler crea un nuevo manejador de autenticación.
+func NewAuthHandler(as *application.AuthService) *AuthHandler {
+	return &AuthHandler{AuthService: as}
+}
+
 type BusLocationHandler struct {
 	BLService *application.BusLocationService
 }
 
 type CreateBusLocationReq struct {
-	BusID string  `json:"bus_id" binding:"required"`
-	Lat   float64 `json:"lat" binding:"required"`
-	Lng   float64 `json:"lng" binding:"required"`
+	BusID   string   `json:"bus_id" binding:"required"`
+	Lat     float64  `json:"lat" binding:"required"`
+	Lng     float64  `json:"lng" binding:"required"`
+	Speed   *float64 `json:"speed"`   // km/h, opcional
+	Heading *float64 `json:"heading"` // grados, opcional
+}
+
+// HistoryQuery son los parámetros de GET /buslocations/:bus_id. Las fechas
+// usan RFC 3339; los valores vacíos toman los valores por defecto del servicio.
+type HistoryQuery struct {
+	From      time.Time `form:"from"`
+	To        time.Time `form:"to"`
+	MaxPoints int       `form:"max_points"`
+}
+
+// LocationHistoryResp es la respuesta de GET /buslocations/:bus_id.
+type LocationHistoryResp struct {
+	BusID  primitive.ObjectID   `json:"bus_id"`
+	From   time.Time            `json:"from"`
+	To     time.Time            `json:"to"`
+	Total  int64                `json:"total"`
+	Points []domain.BusLocation `json:"points"`
+}
+
+// AreaQuery son los parámetros de GET /buslocations/area.
+type AreaQuery struct {
+	MinLat *float64  `form:"min_lat" binding:"required"`
+	MinLng *float64  `form:"min_lng" binding:"required"`
+	MaxLat *float64  `form:"max_lat" binding:"required"`
+	MaxLng *float64  `form:"max_lng" binding:"required"`
+	From   time.Time `form:"from"`
+	To     time.Time `form:"to"`
+}
+
+// NearbyQuery son los parámetros de GET /buses/nearby. El radio se indica
+// en metros; si se omite se usa el valor por defecto del servicio.
+type NearbyQuery struct {
+	Lat    *float64 `form:"lat" binding:"required"`
+	Lng    *float64 `form:"lng" binding:"required"`
+	Radius float64  `form:"radius"`
+}
+
+// NearbyBusResp es cada elemento de la respuesta de GET /buses/nearby.
+type NearbyBusResp struct {
+	Bus      domain.Bus         `json:"bus"`
+	Location domain.BusLocation `json:"location"`
+	Distance float64            `json:"distance"`
 }
 
 // NewBusLocationHandler crea nuevo BusLocationHandler
@@ -28,7 +93,9 @@ func NewBusLocationHandler(bls *application.BusLocationService) *BusLocationHand
 }
 
 type BusHandler struct {
-	BusService *application.BusService
+	BusService          *application.BusService
+	ConnectivityService *application.ConnectivityService
+	CommandService      *application.CommandService
 }
 
 type CreateBusReq struct {
@@ -37,10 +104,11 @@ type CreateBusReq struct {
 	RutaID      string    `json:"ruta_id" binding:"required"`
 	FechaInicio time.Time `json:"fecha_inicio" binding:"required"`
 	FechaFin    time.Time `json:"fecha_fin" binding:"required"`
+	CompaniaID  string    `json:"compania_id"`
 }
 
-func NewBusHandler(bs *application.BusService) *BusHandler {
-	return &BusHandler{BusService: bs}
+func NewBusHandler(bs *application.BusService, cs *application.ConnectivityService, cmds *application.CommandService) *BusHandler {
+	return &BusHandler{BusService: bs, ConnectivityService: cs, CommandService: cmds}
 }
 
 type RoleHandler struct {
@@ -48,8 +116,9 @@ type RoleHandler struct {
 }
 
 type CreateRoleReq struct {
-	Nombre      string `json:"nombre" binding:"required"`
-	Descripcion string `json:"descripcion"`
+	Nombre      string   `json:"nombre" binding:"required"`
+	Descripcion string   `json:"descripcion"`
+	Permisos    []string `json:"permisos"`
 }
 
 func NewRoleHandler(rs *application.RoleService) *RoleHandler {
@@ -81,6 +150,28 @@ type EditUserReq struct {
 	CompaniaID string `json:"compania_id"`
 }
 
+// UserResp es la representación de un usuario en las respuestas del API.
+// Nunca incluye el hash de la contraseña.
+type UserResp struct {
+	ID        primitive.ObjectID
+	Nombre    string
+	RolID     primitive.ObjectID
+	Compania  primitive.ObjectID
+	CreatedAt time.Time
+}
+
+func newUserResp(u *domain.User) UserResp {
+	return UserResp{ID: u.ID, Nombre: u.Nombre, RolID: u.RolID, Compania: u.Compania, CreatedAt: u.CreatedAt}
+}
+
+func newUserResps(users []domain.User) []UserResp {
+	out := make([]UserResp, 0, len(users))
+	for i := range users {
+		out = append(out, newUserResp(&users[i]))
+	}
+	return out
+}
+
 type RouteHandler struct {
 	RouteService *application.RouteService
 }
@@ -94,6 +185,7 @@ type CreateRouteReq struct {
 	DestinoLat     float64           `json:"destino_lat" binding:"required"`
 	DestinoLng     float64           `json:"destino_lng" binding:"required"`
 	Waypoints      []domain.Waypoint `json:"waypoints"`
+	CompaniaID     string            `json:"compania_id"`
 }
 
 // NewRouteHandler crea un nuevo manejador de rutas.
@@ -106,6 +198,53 @@ func NewUserHandler(userService *application.UserService) *UserHandler {
 	return &UserHandler{UserService: userService}
 }
 
+// LoginHandler valida las credenciales y devuelve access y refresh token.
+func (h *AuthHandler) LoginHandler(c *gin.Context) {
+	var req LoginReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, invalidBody(err))
+		return
+	}
+
+	tokens, err := h.AuthService.Login(req.Nombre, req.Password)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RefreshHandler rota el refresh token y emite un nuevo par de tokens.
+func (h *AuthHandler) RefreshHandler(c *gin.Context) {
+	var req RefreshTokenReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, invalidBody(err))
+		return
+	}
+
+	tokens, err := h.AuthService.Refresh(req.RefreshToken)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// LogoutHandler revoca el refresh token recibido.
+func (h *AuthHandler) LogoutHandler(c *gin.Context) {
+	var req RefreshTokenReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, invalidBody(err))
+		return
+	}
+
+	if err := h.AuthService.Logout(req.RefreshToken); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Sesión cerrada"})
+}
+
 // RegisterUserHandler maneja el registro de un usuario
 func (h *UserHandler) RegisterUserHandler(c *gin.Context) {
 	var request struct {
@@ -116,13 +255,13 @@ func (h *UserHandler) RegisterUserHandler(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos de entrada inválidos"})
+		abortWithError(c, invalidBody(err))
 		return
 	}
 
-	userID, err := h.UserService.RegisterUser(request.Nombre, request.Password, request.RolID, request.CompaniaID)
+	userID, err := h.UserService.RegisterUser(scopeFrom(c), roleFrom(c), request.Nombre, request.Password, request.RolID, request.CompaniaID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al registrar usuario: " + err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
@@ -135,48 +274,89 @@ func (h *UserHandler) RegisterUserHandler(c *gin.Context) {
 func (h *UserHandler) EditUser(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de usuario requerido"})
+		abortWithError(c, requiredParam("ID de usuario requerido"))
 		return
 	}
 
 	var request EditUserReq
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos de entrada inválidos"})
+		abortWithError(c, invalidBody(err))
 		return
 	}
 
-	updated, err := h.UserService.EditUser(id, request.Nombre, request.Password, request.RolID, request.CompaniaID)
+	updated, err := h.UserService.EditUser(scopeFrom(c), roleFrom(c), id, request.Nombre, request.Password, request.RolID, request.CompaniaID)
 	if err != nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "Error al editar usuario: " + err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, updated)
+	c.JSON(http.StatusOK, newUserResp(updated))
+}
+
+// GetAllUsersHandler devuelve una página de los usuarios visibles.
+func (h *UserHandler) GetAllUsersHandler(c *gin.Context) {
+	page, err := h.UserService.GetAllUsers(scopeFrom(c), listParams(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, PageResp[UserResp]{Items: newUserResps(page.Items), Total: page.Total, Next: page.Next})
+}
+
+// GetUserByIDHandler devuelve un usuario por su ID.
+func (h *UserHandler) GetUserByIDHandler(c *gin.Context) {
+	user, err := h.UserService.GetUserByID(scopeFrom(c), c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newUserResp(user))
+}
+
+// SearchUsersHandler busca usuarios por nombre, rol y/o compañía
+// (?nombre=...&rol_id=...&compania_id=...).
+func (h *UserHandler) SearchUsersHandler(c *gin.Context) {
+	users, err := h.UserService.SearchUsers(scopeFrom(c), c.Query("nombre"), c.Query("rol_id"), c.Query("compania_id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newUserResps(users))
+}
+
+// DeleteUserHandler elimina un usuario por su ID.
+func (h *UserHandler) DeleteUserHandler(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.UserService.DeleteUser(scopeFrom(c), id); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Usuario %s eliminado", id)})
 }
 
 func (h *RouteHandler) GetAllRoutesHandler(c *gin.Context) {
-	routes, err := h.RouteService.GetAllRoutes()
+	page, err := h.RouteService.GetAllRoutes(scopeFrom(c), listParams(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, routes)
+	c.JSON(http.StatusOK, newPageResp(page))
 }
 
 func (h *RouteHandler) GetRoutesByNameHandler(c *gin.Context) {
 	name := c.Query("name")
 	if name == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'name' is required"})
+		abortWithError(c, requiredParam("query parameter 'name' is required"))
 		return
 	}
 
-	routes, err := h.RouteService.GetRoutesByName(name)
+	routes, err := h.RouteService.GetRoutesByName(scopeFrom(c), name)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	if len(routes) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("no routes found with name '%s'", name)})
+		abortWithError(c, application.ErrRouteNotFound.WithDetails(map[string]any{"nombre": name}))
 		return
 	}
 	c.JSON(http.StatusOK, routes)
@@ -185,14 +365,16 @@ func (h *RouteHandler) GetRoutesByNameHandler(c *gin.Context) {
 func (h *RouteHandler) RegisterRouteHandler(c *gin.Context) {
 	var req CreateRouteReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, invalidBody(err))
 		return
 	}
 
 	id, err := h.RouteService.RegisterRoute(
+		scopeFrom(c),
 		req.Nombre,
 		req.Descripcion,
 		req.ModoTransporte,
+		req.CompaniaID,
 		req.OrigenLat,
 		req.OrigenLng,
 		req.DestinoLat,
@@ -200,7 +382,7 @@ func (h *RouteHandler) RegisterRouteHandler(c *gin.Context) {
 		req.Waypoints,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
@@ -213,13 +395,13 @@ func (h *RouteHandler) RegisterRouteHandler(c *gin.Context) {
 func (h *RouteHandler) EditRouteHandler(c *gin.Context) {
 	routeID := c.Param("id")
 	if routeID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de ruta requerido"})
+		abortWithError(c, requiredParam("ID de ruta requerido"))
 		return
 	}
 
 	var req CreateRouteReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, invalidBody(err))
 		return
 	}
 
@@ -227,39 +409,66 @@ func (h *RouteHandler) EditRouteHandler(c *gin.Context) {
 	// quita el binding:"required" de CreateRouteReq y sólo valídalos en el service.
 
 	updated, err := h.RouteService.EditRoute(
+		scopeFrom(c),
 		routeID,
 		req.Nombre,
 		req.Descripcion,
 		req.ModoTransporte,
+		req.CompaniaID,
 		&domain.Location{Lat: req.OrigenLat, Lng: req.OrigenLng},
 		&domain.Location{Lat: req.DestinoLat, Lng: req.DestinoLng},
 		req.Waypoints,
 	)
 	if err != nil {
-		status := http.StatusInternalServerError
-		// podrías distinguir ErrNotFound para 404, etc.
-		c.JSON(status, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, updated)
 }
 
+// GetRouteByIDHandler devuelve una ruta por su ID.
+func (h *RouteHandler) GetRouteByIDHandler(c *gin.Context) {
+	route, err := h.RouteService.GetRouteByID(scopeFrom(c), c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, route)
+}
+
+// DeleteRouteHandler elimina una ruta por su ID. Con ?cascade=true también
+// desasigna la ruta de los buses que la usan; sin él responde 409 si hay
+// buses asignados.
+func (h *RouteHandler) DeleteRouteHandler(c *gin.Context) {
+	id := c.Param("id")
+	cascade, err := cascadeParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := h.RouteService.DeleteRoute(scopeFrom(c), id, cascade); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Ruta %s eliminada", id)})
+}
+
 func (h *CompanyHandler) GetAllCompaniesHandler(c *gin.Context) {
-	companies, err := h.CompanyService.GetAllCompanies()
+	page, err := h.CompanyService.GetAllCompanies(scopeFrom(c), listParams(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, companies)
+	c.JSON(http.StatusOK, newPageResp(page))
 }
 
 // GetCompanyByIDHandler retorna una compañía por su ID.
 func (h *CompanyHandler) GetCompanyByIDHandler(c *gin.Context) {
 	idHex := c.Param("id")
-	comp, err := h.CompanyService.GetCompanyByID(idHex)
+	comp, err := h.CompanyService.GetCompanyByID(scopeFrom(c), idHex)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, comp)
@@ -269,12 +478,12 @@ func (h *CompanyHandler) GetCompanyByIDHandler(c *gin.Context) {
 func (h *CompanyHandler) SearchCompaniesByNameHandler(c *gin.Context) {
 	name := c.Query("name")
 	if name == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'name' is required"})
+		abortWithError(c, requiredParam("query parameter 'name' is required"))
 		return
 	}
-	companies, err := h.CompanyService.SearchCompaniesByName(name)
+	companies, err := h.CompanyService.SearchCompaniesByName(scopeFrom(c), name)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, companies)
@@ -284,12 +493,12 @@ func (h *CompanyHandler) SearchCompaniesByNameHandler(c *gin.Context) {
 func (h *CompanyHandler) RegisterCompanyHandler(c *gin.Context) {
 	var req CreateCompanyReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, invalidBody(err))
 		return
 	}
-	id, err := h.CompanyService.RegisterCompany(req.Nombre, req.Descripcion)
+	id, err := h.CompanyService.RegisterCompany(scopeFrom(c), req.Nombre, req.Descripcion)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{
@@ -303,34 +512,40 @@ func (h *CompanyHandler) EditCompanyHandler(c *gin.Context) {
 	idHex := c.Param("id")
 	var req CreateCompanyReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, invalidBody(err))
 		return
 	}
-	updated, err := h.CompanyService.EditCompany(idHex, req.Nombre, req.Descripcion)
+	updated, err := h.CompanyService.EditCompany(scopeFrom(c), idHex, req.Nombre, req.Descripcion)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, updated)
 }
 
-// DeleteCompanyHandler elimina una compañía por su ID.
+// DeleteCompanyHandler elimina una compañía por su ID. Con ?cascade=true
+// también elimina sus usuarios, buses, rutas y localizaciones.
 func (h *CompanyHandler) DeleteCompanyHandler(c *gin.Context) {
 	idHex := c.Param("id")
-	if err := h.CompanyService.DeleteCompany(idHex); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	cascade, err := cascadeParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := h.CompanyService.DeleteCompany(scopeFrom(c), idHex, cascade); err != nil {
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Compañía %s eliminada", idHex)})
 }
 
 func (h *RoleHandler) GetAllRolesHandler(c *gin.Context) {
-	roles, err := h.RoleService.GetAllRoles()
+	page, err := h.RoleService.GetAllRoles(listParams(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, roles)
+	c.JSON(http.StatusOK, newPageResp(page))
 }
 
 // GetRoleByIDHandler retorna un rol por su ID.
@@ -338,7 +553,7 @@ func (h *RoleHandler) GetRoleByIDHandler(c *gin.Context) {
 	idHex := c.Param("id")
 	role, err := h.RoleService.GetRoleByID(idHex)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, role)
@@ -348,12 +563,12 @@ func (h *RoleHandler) GetRoleByIDHandler(c *gin.Context) {
 func (h *RoleHandler) SearchRolesByNameHandler(c *gin.Context) {
 	name := c.Query("name")
 	if name == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'name' is required"})
+		abortWithError(c, requiredParam("query parameter 'name' is required"))
 		return
 	}
 	roles, err := h.RoleService.SearchRolesByName(name)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, roles)
@@ -363,12 +578,12 @@ func (h *RoleHandler) SearchRolesByNameHandler(c *gin.Context) {
 func (h *RoleHandler) RegisterRoleHandler(c *gin.Context) {
 	var req CreateRoleReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, invalidBody(err))
 		return
 	}
-	id, err := h.RoleService.RegisterRole(req.Nombre, req.Descripcion)
+	id, err := h.RoleService.RegisterRole(scopeFrom(c), req.Nombre, req.Descripcion, req.Permisos)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{
@@ -382,57 +597,183 @@ func (h *RoleHandler) EditRoleHandler(c *gin.Context) {
 	idHex := c.Param("id")
 	var req CreateRoleReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, invalidBody(err))
 		return
 	}
-	updated, err := h.RoleService.EditRole(idHex, req.Nombre, req.Descripcion)
+	updated, err := h.RoleService.EditRole(scopeFrom(c), idHex, req.Nombre, req.Descripcion, req.Permisos)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, updated)
 }
 
-// DeleteRoleHandler elimina un rol por su ID.
+// DeleteRoleHandler elimina un rol por su ID. Con ?cascade=true también
+// elimina los usuarios que lo tienen asignado.
 func (h *RoleHandler) DeleteRoleHandler(c *gin.Context) {
 	idHex := c.Param("id")
-	if err := h.RoleService.DeleteRole(idHex); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	cascade, err := cascadeParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := h.RoleService.DeleteRole(scopeFrom(c), idHex, cascade); err != nil {
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Rol %s eliminado", idHex)})
 }
 
 func (h *BusHandler) GetAllBusesHandler(c *gin.Context) {
-	buses, err := h.BusService.GetAllBuses()
+	page, err := h.BusService.GetAllBuses(scopeFrom(c), listParams(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, buses)
+	c.JSON(http.StatusOK, newPageResp(page))
 }
 
 // GetBusByIDHandler retorna un bus por su ID.
 func (h *BusHandler) GetBusByIDHandler(c *gin.Context) {
 	id := c.Param("id")
-	bus, err := h.BusService.GetBusByID(id)
+	bus, err := h.BusService.GetBusByID(scopeFrom(c), id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, bus)
 }
 
+// ConnectivityEventResp es un cambio del estado de conectividad de un bus.
+type ConnectivityEventResp struct {
+	Estado   string    `json:"estado"`
+	Anterior string    `json:"anterior"`
+	At       time.Time `json:"at"`
+}
+
+// BusStatusResp es la respuesta de GET /buses/:id/status.
+type BusStatusResp struct {
+	BusID      primitive.ObjectID      `json:"bus_id"`
+	CompaniaID primitive.ObjectID      `json:"compania"`
+	Estado     string                  `json:"estado"`
+	Since      *time.Time              `json:"since,omitempty"`
+	LastSeen   *time.Time              `json:"last_seen,omitempty"`
+	Devices    int                     `json:"devices"`
+	Events     []ConnectivityEventResp `json:"events"`
+}
+
+// GetBusStatusHandler devuelve el estado de conectividad de un bus y sus
+// últimos cambios de estado.
+func (h *BusHandler) GetBusStatusHandler(c *gin.Context) {
+	status, err := h.ConnectivityService.BusStatus(scopeFrom(c), c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	resp := BusStatusResp{
+		BusID:      status.BusID,
+		CompaniaID: status.CompaniaID,
+		Estado:     status.Estado,
+		Since:      status.Since,
+		LastSeen:   status.LastSeen,
+		Devices:    status.Devices,
+		Events:     make([]ConnectivityEventResp, 0, len(status.Events)),
+	}
+	for _, e := range status.Events {
+		resp.Events = append(resp.Events, ConnectivityEventResp{Estado: e.Estado, Anterior: e.Anterior, At: e.At})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// SendCommandReq es el cuerpo de POST /buses/:id/commands.
+type SendCommandReq struct {
+	Type   string         `json:"type" binding:"required"`
+	Params map[string]any `json:"params"`
+}
+
+// CommandResp es un comando para los dispositivos de un bus.
+type CommandResp struct {
+	ID         primitive.ObjectID `json:"id"`
+	BusID      primitive.ObjectID `json:"bus_id"`
+	CompaniaID primitive.ObjectID `json:"compania"`
+	Type       string             `json:"type"`
+	Params     map[string]any     `json:"params,omitempty"`
+	Estado     string             `json:"estado"`
+	Intentos   int                `json:"intentos"`
+	Resultado  string             `json:"resultado,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+	ExpiresAt  time.Time          `json:"expires_at"`
+	SentAt     *time.Time         `json:"sent_at,omitempty"`
+	AckedAt    *time.Time         `json:"acked_at,omitempty"`
+}
+
+func newCommandResp(cmd *domain.Command) CommandResp {
+	return CommandResp{
+		ID:         cmd.ID,
+		BusID:      cmd.BusID,
+		CompaniaID: cmd.CompaniaID,
+		Type:       cmd.Tipo,
+		Params:     cmd.Params,
+		Estado:     cmd.Estado,
+		Intentos:   cmd.Intentos,
+		Resultado:  cmd.Resultado,
+		CreatedAt:  cmd.CreatedAt,
+		ExpiresAt:  cmd.ExpiresAt,
+		SentAt:     cmd.SentAt,
+		AckedAt:    cmd.AckedAt,
+	}
+}
+
+// SendBusCommandHandler encola un comando para los dispositivos del bus y
+// responde 202: el estado indica si ya se publicó o sigue pendiente.
+func (h *BusHandler) SendBusCommandHandler(c *gin.Context) {
+	var req SendCommandReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, invalidBody(err))
+		return
+	}
+	cmd, err := h.CommandService.SendCommand(scopeFrom(c), c.Param("id"), req.Type, req.Params)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, newCommandResp(cmd))
+}
+
+// GetBusCommandsHandler devuelve una página de los comandos del bus.
+func (h *BusHandler) GetBusCommandsHandler(c *gin.Context) {
+	page, err := h.CommandService.GetBusCommands(scopeFrom(c), c.Param("id"), listParams(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	items := make([]CommandResp, 0, len(page.Items))
+	for i := range page.Items {
+		items = append(items, newCommandResp(&page.Items[i]))
+	}
+	c.JSON(http.StatusOK, PageResp[CommandResp]{Items: items, Total: page.Total, Next: page.Next})
+}
+
+// GetBusCommandHandler devuelve un comando del bus por su ID.
+func (h *BusHandler) GetBusCommandHandler(c *gin.Context) {
+	cmd, err := h.CommandService.GetCommand(scopeFrom(c), c.Param("id"), c.Param("command_id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newCommandResp(cmd))
+}
+
 // SearchBusesByPlacaHandler busca buses por placa (?placa=...).
 func (h *BusHandler) SearchBusesByPlacaHandler(c *gin.Context) {
 	placa := c.Query("placa")
 	if placa == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'placa' is required"})
+		abortWithError(c, requiredParam("query parameter 'placa' is required"))
 		return
 	}
-	buses, err := h.BusService.SearchBusesByPlaca(placa)
+	buses, err := h.BusService.SearchBusesByPlaca(scopeFrom(c), placa)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, buses)
@@ -442,18 +783,20 @@ func (h *BusHandler) SearchBusesByPlacaHandler(c *gin.Context) {
 func (h *BusHandler) RegisterBusHandler(c *gin.Context) {
 	var req CreateBusReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, invalidBody(err))
 		return
 	}
 	id, err := h.BusService.RegisterBus(
+		scopeFrom(c),
 		req.Placa,
 		req.ConductorID,
 		req.RutaID,
+		req.CompaniaID,
 		req.FechaInicio,
 		req.FechaFin,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{
@@ -467,19 +810,21 @@ func (h *BusHandler) EditBusHandler(c *gin.Context) {
 	id := c.Param("id")
 	var req CreateBusReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, invalidBody(err))
 		return
 	}
 	updated, err := h.BusService.EditBus(
+		scopeFrom(c),
 		id,
 		req.Placa,
 		req.ConductorID,
 		req.RutaID,
+		req.CompaniaID,
 		&req.FechaInicio,
 		&req.FechaFin,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, updated)
@@ -488,48 +833,99 @@ func (h *BusHandler) EditBusHandler(c *gin.Context) {
 // DeleteBusHandler elimina un bus por su ID.
 func (h *BusHandler) DeleteBusHandler(c *gin.Context) {
 	id := c.Param("id")
-	if err := h.BusService.DeleteBus(id); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := h.BusService.DeleteBus(scopeFrom(c), id); err != nil {
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Bus %s eliminado", id)})
 }
 
-// GetAllBusLocationsHandler devuelve todas las localizaciones de buses
+// GetAllBusLocationsHandler devuelve una página de las localizaciones de buses
 func (h *BusLocationHandler) GetAllBusLocationsHandler(c *gin.Context) {
-	locations, err := h.BLService.GetAllBusLocations()
+	page, err := h.BLService.GetAllBusLocations(scopeFrom(c), listParams(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, locations)
+	c.JSON(http.StatusOK, newPageResp(page))
 }
 
-// GetBusLocationsByBusIDHandler devuelve localizaciones para un bus específico
-func (h *BusLocationHandler) GetBusLocationsByBusIDHandler(c *gin.Context) {
+// GetBusLocationHistoryHandler devuelve el recorrido de un bus en orden
+// cronológico (?from=&to=&max_points=).
+func (h *BusLocationHandler) GetBusLocationHistoryHandler(c *gin.Context) {
 	busID := c.Param("bus_id")
 	if busID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "bus_id requerido"})
+		abortWithError(c, requiredParam("bus_id requerido"))
+		return
+	}
+	var q HistoryQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		abortWithError(c, invalidQuery(err))
+		return
+	}
+	history, err := h.BLService.GetBusLocationHistory(scopeFrom(c), busID, q.From, q.To, q.MaxPoints)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, LocationHistoryResp{
+		BusID:  history.BusID,
+		From:   history.From,
+		To:     history.To,
+		Total:  history.Total,
+		Points: history.Points,
+	})
+}
+
+// GetBusLocationsInAreaHandler devuelve una página de las localizaciones de
+// toda la flota dentro de un área y un intervalo de tiempo
+// (?min_lat=&min_lng=&max_lat=&max_lng=&from=&to=&limit=&after=).
+func (h *BusLocationHandler) GetBusLocationsInAreaHandler(c *gin.Context) {
+	var q AreaQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		abortWithError(c, invalidQuery(err))
+		return
+	}
+	box := domain.BoundingBox{MinLat: *q.MinLat, MinLng: *q.MinLng, MaxLat: *q.MaxLat, MaxLng: *q.MaxLng}
+	p := application.ListParams{Limit: c.Query("limit"), After: c.Query("after")}
+	page, err := h.BLService.GetBusLocationsInArea(scopeFrom(c), box, q.From, q.To, p)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newPageResp(page))
+}
+
+// NearbyBusesHandler devuelve los buses cuya última localización está dentro
+// de un radio en metros, del más cercano al más lejano (?lat=&lng=&radius=).
+func (h *BusLocationHandler) NearbyBusesHandler(c *gin.Context) {
+	var q NearbyQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		abortWithError(c, invalidQuery(err))
 		return
 	}
-	locations, err := h.BLService.GetBusLocationsByBusID(busID)
+	nearby, err := h.BLService.NearbyBuses(scopeFrom(c), *q.Lat, *q.Lng, q.Radius)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, locations)
+	resp := make([]NearbyBusResp, 0, len(nearby))
+	for _, n := range nearby {
+		resp = append(resp, NearbyBusResp{Bus: n.Bus, Location: n.Location, Distance: n.Distance})
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 // RegisterBusLocationHandler registra una nueva localización
 func (h *BusLocationHandler) RegisterBusLocationHandler(c *gin.Context) {
 	var req CreateBusLocationReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, invalidBody(err))
 		return
 	}
-	id, err := h.BLService.RegisterBusLocation(req.BusID, req.Lat, req.Lng)
+	id, err := h.BLService.RegisterBusLocation(scopeFrom(c), req.BusID, req.Lat, req.Lng, req.Speed, req.Heading)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{"message": "Localización registrada", "id": id.Hex()})
@@ -539,72 +935,387 @@ func (h *BusLocationHandler) RegisterBusLocationHandler(c *gin.Context) {
 func (h *BusLocationHandler) DeleteBusLocationHandler(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "id requerido"})
+		abortWithError(c, requiredParam("id requerido"))
 		return
 	}
-	err := h.BLService.DeleteBusLocation(id)
+	err := h.BLService.DeleteBusLocation(scopeFrom(c), id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Localización %s eliminada", id)})
 }
 
-// StartServer inicia el servidor HTTP y registra rutas con Gin
-func StartServer(userService *application.UserService, routeService *application.RouteService, companyService *application.CompanyService, roleService *application.RoleService, busService *application.BusService, busLocService *application.BusLocationService) {
+type FleetHandler struct {
+	FleetService *application.FleetService
+}
+
+// NewFleetHandler crea un nuevo FleetHandler.
+func NewFleetHandler(fs *application.FleetService) *FleetHandler {
+	return &FleetHandler{FleetService: fs}
+}
+
+// LivePositionResp es la última posición conocida de un bus en GET
+// /fleet/live. Age son los segundos transcurridos desde esa posición.
+type LivePositionResp struct {
+	BusID      primitive.ObjectID `json:"bus_id"`
+	CompaniaID primitive.ObjectID `json:"compania"`
+	Lat        float64            `json:"lat"`
+	Lng        float64            `json:"lng"`
+	Speed      float64            `json:"speed"`
+	Heading    float64            `json:"heading"`
+	At         time.Time          `json:"at"`
+	Age        float64            `json:"age"`
+}
+
+// LiveFleetResp es la respuesta de GET /fleet/live.
+type LiveFleetResp struct {
+	GeneratedAt time.Time          `json:"generated_at"`
+	Buses       []LivePositionResp `json:"buses"`
+}
+
+// LiveFleetHandler devuelve la última posición de cada bus de la flota desde
+// la caché en memoria, sin consultar la base de datos.
+func (h *FleetHandler) LiveFleetHandler(c *gin.Context) {
+	now := time.Now()
+	fleet := h.FleetService.LiveFleet(scopeFrom(c))
+	resp := LiveFleetResp{GeneratedAt: now, Buses: make([]LivePositionResp, 0, len(fleet))}
+	for _, p := range fleet {
+		resp.Buses = append(resp.Buses, LivePositionResp{
+			BusID:      p.BusID,
+			CompaniaID: p.CompaniaID,
+			Lat:        p.Localizacion.Lat,
+			Lng:        p.Localizacion.Lng,
+			Speed:      p.Velocidad,
+			Heading:    p.Rumbo,
+			At:         p.At,
+			Age:        now.Sub(p.At).Seconds(),
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+type DeviceHandler struct {
+	DeviceService *application.DeviceService
+}
+
+// CreateDeviceReq es el cuerpo de POST /devices. En PUT /devices/:id todos
+// los campos son opcionales y bus_id se ignora (ver ReassignDeviceReq).
+type CreateDeviceReq struct {
+	Serial   string `json:"serial"`
+	IMEI     string `json:"imei"`
+	Firmware string `json:"firmware"`
+	BusID    string `json:"bus_id"`
+}
+
+// ReassignDeviceReq es el cuerpo de PUT /devices/:id/bus.
+type ReassignDeviceReq struct {
+	BusID string `json:"bus_id" binding:"required"`
+}
+
+// NewDeviceHandler crea un nuevo DeviceHandler.
+func NewDeviceHandler(ds *application.DeviceService) *DeviceHandler {
+	return &DeviceHandler{DeviceService: ds}
+}
+
+// DeviceResp es un dispositivo GPS, sin el hash de su secreto.
+type DeviceResp struct {
+	ID               primitive.ObjectID `json:"id"`
+	Serial           string             `json:"serial"`
+	IMEI             string             `json:"imei,omitempty"`
+	Firmware         string             `json:"firmware,omitempty"`
+	Estado           string             `json:"estado"`
+	BusID            primitive.ObjectID `json:"bus_id"`
+	CompaniaID       primitive.ObjectID `json:"compania"`
+	ClientID         string             `json:"client_id"`
+	LastSeen         *time.Time         `json:"last_seen,omitempty"`
+	CreatedAt        time.Time          `json:"created_at"`
+	DecommissionedAt *time.Time         `json:"decommissioned_at,omitempty"`
+}
+
+func newDeviceResp(d *domain.Device) DeviceResp {
+	return DeviceResp{
+		ID:               d.ID,
+		Serial:           d.Serial,
+		IMEI:             d.IMEI,
+		Firmware:         d.Firmware,
+		Estado:           d.Estado,
+		BusID:            d.BusID,
+		CompaniaID:       d.CompaniaID,
+		ClientID:         d.ClientID,
+		LastSeen:         d.LastSeen,
+		CreatedAt:        d.CreatedAt,
+		DecommissionedAt: d.DecommissionedAt,
+	}
+}
+
+// DeviceSecretResp es la respuesta de POST /devices y de POST
+// /devices/:id/credentials. El secreto solo se devuelve en estas respuestas.
+type DeviceSecretResp struct {
+	DeviceResp
+	Secret string `json:"secret"`
+}
+
+// GetAllDevicesHandler devuelve una página de los dispositivos.
+func (h *DeviceHandler) GetAllDevicesHandler(c *gin.Context) {
+	page, err := h.DeviceService.GetAllDevices(scopeFrom(c), listParams(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	items := make([]DeviceResp, 0, len(page.Items))
+	for i := range page.Items {
+		items = append(items, newDeviceResp(&page.Items[i]))
+	}
+	c.JSON(http.StatusOK, PageResp[DeviceResp]{Items: items, Total: page.Total, Next: page.Next})
+}
+
+// GetDeviceByIDHandler devuelve un dispositivo por su ID.
+func (h *DeviceHandler) GetDeviceByIDHandler(c *gin.Context) {
+	d, err := h.DeviceService.GetDeviceByID(scopeFrom(c), c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newDeviceResp(d))
+}
+
+// RegisterDeviceHandler registra un dispositivo y devuelve sus credenciales.
+func (h *DeviceHandler) RegisterDeviceHandler(c *gin.Context) {
+	var req CreateDeviceReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, invalidBody(err))
+		return
+	}
+	d, err := h.DeviceService.RegisterDevice(scopeFrom(c), req.Serial, req.IMEI, req.Firmware, req.BusID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, DeviceSecretResp{DeviceResp: newDeviceResp(&d.Device), Secret: d.Secret})
+}
+
+// EditDeviceHandler actualiza el serial, el IMEI o el firmware de un dispositivo.
+func (h *DeviceHandler) EditDeviceHandler(c *gin.Context) {
+	var req CreateDeviceReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, invalidBody(err))
+		return
+	}
+	d, err := h.DeviceService.EditDevice(scopeFrom(c), c.Param("id"), req.Serial, req.IMEI, req.Firmware)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newDeviceResp(d))
+}
+
+// RotateCredentialsHandler emite un secreto nuevo para el dispositivo.
+func (h *DeviceHandler) RotateCredentialsHandler(c *gin.Context) {
+	d, err := h.DeviceService.RotateCredentials(scopeFrom(c), c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, DeviceSecretResp{DeviceResp: newDeviceResp(&d.Device), Secret: d.Secret})
+}
+
+// ReassignDeviceHandler asigna el dispositivo a otro bus.
+func (h *DeviceHandler) ReassignDeviceHandler(c *gin.Context) {
+	var req ReassignDeviceReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, invalidBody(err))
+		return
+	}
+	d, err := h.DeviceService.ReassignDevice(scopeFrom(c), c.Param("id"), req.BusID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newDeviceResp(d))
+}
+
+// DecommissionDeviceHandler da de baja el dispositivo.
+func (h *DeviceHandler) DecommissionDeviceHandler(c *gin.Context) {
+	d, err := h.DeviceService.DecommissionDevice(scopeFrom(c), c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newDeviceResp(d))
+}
+
+// Services agrupa los servicios de aplicación que exponen los handlers HTTP.
+type Services struct {
+	Auth         *application.AuthService
+	Users        *application.UserService
+	Routes       *application.RouteService
+	Companies    *application.CompanyService
+	Roles        *application.RoleService
+	Buses        *application.BusService
+	BusLocation  *application.BusLocationService
+	Fleet        *application.FleetService
+	Rollups      *application.RollupService
+	Devices      *application.DeviceService
+	Connectivity *application.ConnectivityService
+	Commands     *application.CommandService
+}
+
+// NewServices crea todos los servicios de aplicación sobre los repositorios
+// indicados (MongoDB en producción, memoria en pruebas). retention configura
+// el historial de localizaciones y su compactación en resúmenes por minuto,
+// heartbeat los umbrales de conectividad de los buses (un bus offline tampoco
+// cuenta en la búsqueda de buses cercanos) y commands la vigencia de los
+// comandos para los dispositivos.
+func NewServices(repos domain.Repositories, hasher domain.PasswordHasher, jwtSecret []byte, accessTTL, refreshTTL time.Duration, retention config.RetentionConfig, heartbeat config.HeartbeatConfig, commands config.CommandsConfig) Services {
+	refs := application.NewReferences(repos.Users, repos.Roles, repos.Companies, repos.Routes)
+	fleet := application.NewFleetService(repos.BusLocations, repos.Buses)
+	return Services{
+		Auth:        application.NewAuthService(repos.Users, repos.Roles, repos.RefreshTokens, hasher, jwtSecret, accessTTL, refreshTTL),
+		Users:       application.NewUserService(repos.Users, repos.Buses, hasher, refs, repos.Tx),
+		Routes:      application.NewRouteService(repos.Routes, repos.Buses, refs, repos.Tx),
+		Companies:   application.NewCompanyService(repos.Companies, repos.Users, repos.Buses, repos.Routes, repos.BusLocations, repos.Rollups, repos.Devices, repos.Connectivity, repos.Commands, repos.Tx, fleet),
+		Roles:       application.NewRoleService(repos.Roles, repos.Users, repos.Buses, repos.Tx),
+		Buses:       application.NewBusService(repos.Buses, repos.Devices, repos.Commands, repos.Tx, refs, fleet),
+		BusLocation: application.NewBusLocationService(repos.BusLocations, repos.Rollups, repos.Buses, fleet, retention.Raw, heartbeat.OfflineAfter),
+		Fleet:       fleet,
+		Rollups: application.NewRollupService(repos.BusLocations, repos.Rollups, application.RollupOptions{
+			Interval:  retention.RollupInterval,
+			Lag:       retention.RollupLag,
+			MaxPoints: retention.RollupPoints,
+		}),
+		Devices: application.NewDeviceService(repos.Devices, repos.Buses),
+		Connectivity: application.NewConnectivityService(repos.Connectivity, repos.Buses, application.ConnectivityOptions{
+			StaleAfter:    heartbeat.StaleAfter,
+			OfflineAfter:  heartbeat.OfflineAfter,
+			CheckInterval: heartbeat.CheckInterval,
+		}),
+		Commands: application.NewCommandService(repos.Commands, repos.Buses, application.CommandOptions{
+			TTL:            commands.TTL,
+			ExpireInterval: commands.ExpireInterval,
+		}),
+	}
+}
+
+// NewRouter crea el router de Gin con todas las rutas registradas.
+// Si hub no es nil y el WebSocket no tiene dirección propia, también registra
+// el endpoint de WebSocket en cfg.WS.Path, que exige un access token. Si health no es nil registra
+// /healthz y /readyz.
+func NewRouter(cfg *config.Config, svc Services, hub *Hub, health *HealthHandler) *gin.Engine {
 	r := gin.Default()
 
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"}, // Cambia según tu frontend
+		AllowOrigins:     cfg.CORS.AllowOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
+		MaxAge:           cfg.CORS.MaxAge,
 	}))
+	r.Use(ErrorMiddleware())
 
 	// Crear el manejador de usuarios
-	userHandler := NewUserHandler(userService)
-	routeHandler := NewRouteHandler(routeService)
-	companyHandler := NewCompanyHandler(companyService)
-	roleHandler := NewRoleHandler(roleService)
-	busHandler := NewBusHandler(busService)
-	busLocHandler := NewBusLocationHandler(busLocService)
+	authHandler := NewAuthHandler(svc.Auth)
+	userHandler := NewUserHandler(svc.Users)
+	routeHandler := NewRouteHandler(svc.Routes)
+	companyHandler := NewCompanyHandler(svc.Companies)
+	roleHandler := NewRoleHandler(svc.Roles)
+	busHandler := NewBusHandler(svc.Buses, svc.Connectivity, svc.Commands)
+	busLocHandler := NewBusLocationHandler(svc.BusLocation)
+	fleetHandler := NewFleetHandler(svc.Fleet)
+	deviceHandler := NewDeviceHandler(svc.Devices)
 
 	// Registrar rutas
-	r.POST("/register", userHandler.RegisterUserHandler)
-	r.PUT("/user/:id", userHandler.EditUser)
-	r.GET("/routes", routeHandler.GetAllRoutesHandler) // devuelve todas las rutas
-	r.GET("/routes/search", routeHandler.GetRoutesByNameHandler)
-	r.POST("/routes", routeHandler.RegisterRouteHandler) // Crear ruta
-	r.PUT("/routes/:id", routeHandler.EditRouteHandler)
-	r.GET("/companies", companyHandler.GetAllCompaniesHandler)
-	r.GET("/companies/search", companyHandler.SearchCompaniesByNameHandler) // ?name=...
-	r.GET("/companies/:id", companyHandler.GetCompanyByIDHandler)
-	r.POST("/companies", companyHandler.RegisterCompanyHandler)
-	r.PUT("/companies/:id", companyHandler.EditCompanyHandler)
-	r.DELETE("/companies/:id", companyHandler.DeleteCompanyHandler)
-	r.GET("/roles", roleHandler.GetAllRolesHandler)
-	r.GET("/roles/search", roleHandler.SearchRolesByNameHandler)
-	r.GET("/roles/:id", roleHandler.GetRoleByIDHandler)
-	r.POST("/roles", roleHandler.RegisterRoleHandler)
-	r.PUT("/roles/:id", roleHandler.EditRoleHandler)
-	r.DELETE("/roles/:id", roleHandler.DeleteRoleHandler)
-	r.GET("/buses", busHandler.GetAllBusesHandler)
-	r.GET("/buses/search", busHandler.SearchBusesByPlacaHandler) // ?placa=...
-	r.GET("/buses/:id", busHandler.GetBusByIDHandler)
-	r.POST("/buses", busHandler.RegisterBusHandler)
-	r.PUT("/buses/:id", busHandler.EditBusHandler)
-	r.DELETE("/buses/:id", busHandler.DeleteBusHandler)
-	r.GET("/buslocations", busLocHandler.GetAllBusLocationsHandler)
-	r.GET("/buslocations/:bus_id", busLocHandler.GetBusLocationsByBusIDHandler)
-	r.POST("/buslocations", busLocHandler.RegisterBusLocationHandler)
+	// Sondas de salud (públicas)
+	if health != nil {
+		r.GET("/healthz", health.LivenessHandler)
+		r.GET("/readyz", health.ReadinessHandler)
+	}
+
+	// Autenticación (pública)
+	authGroup := r.Group("/auth")
+	authGroup.POST("/login", authHandler.LoginHandler)
+	authGroup.POST("/refresh", authHandler.RefreshHandler)
+	authGroup.POST("/logout", authHandler.LogoutHandler)
+
+	// El resto de rutas exige un access token válido y el permiso correspondiente.
+	// Los listados (GET de la colección) se paginan con ?limit=&after=&sort=
+	// y aceptan filtros por campo (ver application.ListParams).
+	api := r.Group("/", AuthMiddleware(svc.Auth))
+
+	users := api.Group("")
+	users.POST("/register", RequirePermission(domain.PermUsersWrite), userHandler.RegisterUserHandler)
+	users.PUT("/user/:id", RequirePermission(domain.PermUsersWrite), userHandler.EditUser)
+	users.GET("/users", RequirePermission(domain.PermUsersRead), userHandler.GetAllUsersHandler)
+	users.GET("/users/search", RequirePermission(domain.PermUsersRead), userHandler.SearchUsersHandler) // ?nombre=...&rol_id=...&compania_id=...
+	users.GET("/users/:id", RequirePermission(domain.PermUsersRead), userHandler.GetUserByIDHandler)
+	users.DELETE("/users/:id", RequirePermission(domain.PermUsersWrite), userHandler.DeleteUserHandler)
+
+	routes := api.Group("/routes")
+	routes.GET("", RequirePermission(domain.PermRoutesRead), routeHandler.GetAllRoutesHandler) // devuelve todas las rutas
+	routes.GET("/search", RequirePermission(domain.PermRoutesRead), routeHandler.GetRoutesByNameHandler)
+	routes.POST("", RequirePermission(domain.PermRoutesWrite), routeHandler.RegisterRouteHandler) // Crear ruta
+	routes.GET("/:id", RequirePermission(domain.PermRoutesRead), routeHandler.GetRouteByIDHandler)
+	routes.PUT("/:id", RequirePermission(domain.PermRoutesWrite), routeHandler.EditRouteHandler)
+	routes.DELETE("/:id", RequirePermission(domain.PermRoutesWrite), routeHandler.DeleteRouteHandler) // ?cascade=true
+
+	companies := api.Group("/companies")
+	companies.GET("", RequirePermission(domain.PermCompaniesRead), companyHandler.GetAllCompaniesHandler)
+	companies.GET("/search", RequirePermission(domain.PermCompaniesRead), companyHandler.SearchCompaniesByNameHandler) // ?name=...
+	companies.GET("/:id", RequirePermission(domain.PermCompaniesRead), companyHandler.GetCompanyByIDHandler)
+	companies.POST("", RequirePermission(domain.PermCompaniesWrite), companyHandler.RegisterCompanyHandler)
+	companies.PUT("/:id", RequirePermission(domain.PermCompaniesWrite), companyHandler.EditCompanyHandler)
+	companies.DELETE("/:id", RequirePermission(domain.PermCompaniesWrite), companyHandler.DeleteCompanyHandler) // ?cascade=true
+
+	roles := api.Group("/roles")
+	roles.GET("", RequirePermission(domain.PermRolesRead), roleHandler.GetAllRolesHandler)
+	roles.GET("/search", RequirePermission(domain.PermRolesRead), roleHandler.SearchRolesByNameHandler)
+	roles.GET("/:id", RequirePermission(domain.PermRolesRead), roleHandler.GetRoleByIDHandler)
+	roles.POST("", RequirePermission(domain.PermRolesWrite), roleHandler.RegisterRoleHandler)
+	roles.PUT("/:id", RequirePermission(domain.PermRolesWrite), roleHandler.EditRoleHandler)
+	roles.DELETE("/:id", RequirePermission(domain.PermRolesWrite), roleHandler.DeleteRoleHandler) // ?cascade=true
+
+	buses := api.Group("/buses")
+	buses.GET("", RequirePermission(domain.PermBusesRead), busHandler.GetAllBusesHandler)
+	buses.GET("/search", RequirePermission(domain.PermBusesRead), busHandler.SearchBusesByPlacaHandler) // ?placa=...
+	// ?lat=&lng=&radius=; expone localizaciones, por lo que también requiere buslocations:read
+	buses.GET("/nearby", RequirePermission(domain.PermBusesRead), RequirePermission(domain.PermBusLocationsRead), busLocHandler.NearbyBusesHandler)
+	buses.GET("/:id", RequirePermission(domain.PermBusesRead), busHandler.GetBusByIDHandler)
+	buses.GET("/:id/status", RequirePermission(domain.PermBusesRead), busHandler.GetBusStatusHandler)
+	// Comandos para los dispositivos del bus; se publican por MQTT y se
+	// conservan hasta que un dispositivo los confirma o vencen.
+	buses.GET("/:id/commands", RequirePermission(domain.PermBusesRead), busHandler.GetBusCommandsHandler)
+	buses.GET("/:id/commands/:command_id", RequirePermission(domain.PermBusesRead), busHandler.GetBusCommandHandler)
+	buses.POST("/:id/commands", RequirePermission(domain.PermBusesCommand), busHandler.SendBusCommandHandler)
+	buses.POST("", RequirePermission(domain.PermBusesWrite), busHandler.RegisterBusHandler)
+	buses.PUT("/:id", RequirePermission(domain.PermBusesWrite), busHandler.EditBusHandler)
+	buses.DELETE("/:id", RequirePermission(domain.PermBusesWrite), busHandler.DeleteBusHandler)
+
+	// Rastreadores GPS; el secreto MQTT solo se devuelve al registrar y al rotar.
+	devices := api.Group("/devices")
+	devices.GET("", RequirePermission(domain.PermDevicesRead), deviceHandler.GetAllDevicesHandler)
+	devices.GET("/:id", RequirePermission(domain.PermDevicesRead), deviceHandler.GetDeviceByIDHandler)
+	devices.POST("", RequirePermission(domain.PermDevicesWrite), deviceHandler.RegisterDeviceHandler)
+	devices.PUT("/:id", RequirePermission(domain.PermDevicesWrite), deviceHandler.EditDeviceHandler)
+	devices.POST("/:id/credentials", RequirePermission(domain.PermDevicesWrite), deviceHandler.RotateCredentialsHandler)
+	devices.PUT("/:id/bus", RequirePermission(domain.PermDevicesWrite), deviceHandler.ReassignDeviceHandler)
+	devices.POST("/:id/decommission", RequirePermission(domain.PermDevicesWrite), deviceHandler.DecommissionDeviceHandler)
+
+	busLocations := api.Group("/buslocations")
+	busLocations.GET("", RequirePermission(domain.PermBusLocationsRead), busLocHandler.GetAllBusLocationsHandler)
+	busLocations.GET("/area", RequirePermission(domain.PermBusLocationsRead), busLocHandler.GetBusLocationsInAreaHandler)    // ?min_lat=&min_lng=&max_lat=&max_lng=&from=&to=
+	busLocations.GET("/:bus_id", RequirePermission(domain.PermBusLocationsRead), busLocHandler.GetBusLocationHistoryHandler) // ?from=&to=&max_points=
+	busLocations.POST("", RequirePermission(domain.PermBusLocationsWrite), busLocHandler.RegisterBusLocationHandler)
 	// Para eliminar por id de la localización, no por bus_id:
-	r.DELETE("/buslocations/:id", busLocHandler.DeleteBusLocationHandler)
+	busLocations.DELETE("/:id", RequirePermission(domain.PermBusLocationsWrite), busLocHandler.DeleteBusLocationHandler)
+
+	fleet := api.Group("/fleet")
+	fleet.GET("/live", RequirePermission(domain.PermBusLocationsRead), fleetHandler.LiveFleetHandler)
 
-	// Iniciar servidor con Gin
-	fmt.Println("Iniciando servidor en el puerto 8080...")
-	if err := r.Run(":8080"); err != nil {
-		fmt.Println("Error al iniciar el servidor:", err)
+	// WebSocket en el mismo servidor HTTP
+	if hub != nil && cfg.WS.Addr == "" {
+		r.GET(cfg.WS.Path, WebsocketHandler(hub, svc.Auth, cfg.CORS.AllowOrigins))
 	}
+
+	return r
 }
@@ -0,0 +1,2338 @@
+package delivery_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/application"
+	"UbicaBus/UbicaBusBackend/domain"
+	"UbicaBus/UbicaBusBackend/infrastructure/config"
+	"UbicaBus/UbicaBusBackend/infrastructure/delivery"
+	"UbicaBus/UbicaBusBackend/infrastructure/persistence"
+	"UbicaBus/UbicaBusBackend/infrastructure/persistence/memory"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Por defecto las pruebas usan los repositorios en memoria. Si se define
+// TEST_MONGO_URI se ejecutan contra MongoDB en una base de datos temporal que
+// se elimina al terminar.
+const testMongoURIEnv = "TEST_MONGO_URI"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testAPI levanta el router completo sobre un almacenamiento vacío con dos
+// usuarios: root (super-admin) y operador (compañía A, sin permisos de
+// administración).
+type testAPI struct {
+	t      *testing.T
+	router http.Handler
+	repos  domain.Repositories
+	svc    delivery.Services
+	hub    *delivery.Hub
+	wsURL  string
+
+	companyA primitive.ObjectID
+	companyB primitive.ObjectID
+	adminRol primitive.ObjectID
+	opRol    primitive.ObjectID
+	opID     primitive.ObjectID
+
+	rootToken string
+	opToken   string
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	ctx := context.Background()
+
+	repos := newTestRepositories(t)
+	hasher, err := domain.NewPasswordHasher(domain.PasswordAlgoBcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	svc := delivery.NewServices(repos, hasher, []byte(strings.Repeat("k", 32)), time.Minute, time.Hour, cfg.Retention, cfg.Heartbeat, cfg.Commands)
+	hub := delivery.NewHub()
+	a := &testAPI{t: t, router: delivery.NewRouter(cfg, svc, hub, nil), repos: repos, svc: svc, hub: hub}
+
+	compA := &domain.Company{Nombre: "Compañía A"}
+	compB := &domain.Company{Nombre: "Compañía B"}
+	adminRol := &domain.Role{Nombre: "admin", Permisos: []string{domain.PermAll}}
+	opRol := &domain.Role{Nombre: "operador", Permisos: []string{
+		"buses:*", "routes:*", "buslocations:*", "devices:*", domain.PermUsersRead, domain.PermUsersWrite,
+		domain.PermCompaniesRead, domain.PermRolesRead,
+	}}
+	for _, err := range []error{
+		repos.Companies.Create(ctx, compA),
+		repos.Companies.Create(ctx, compB),
+		repos.Roles.Create(ctx, adminRol),
+		repos.Roles.Create(ctx, opRol),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.companyA, a.companyB, a.adminRol, a.opRol = compA.ID, compB.ID, adminRol.ID, opRol.ID
+
+	if _, err := svc.Users.RegisterUser(domain.GlobalScope(), adminRol, "root", "root-pass", adminRol.ID.Hex(), compA.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if a.opID, err = svc.Users.RegisterUser(domain.GlobalScope(), adminRol, "operador", "op-pass", opRol.ID.Hex(), compA.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+
+	a.rootToken = a.login("root", "root-pass")
+	a.opToken = a.login("operador", "op-pass")
+	return a
+}
+
+func newTestRepositories(t *testing.T) domain.Repositories {
+	t.Helper()
+	if os.Getenv(testMongoURIEnv) == "" {
+		return memory.NewRepositories()
+	}
+	// El servidor de pruebas puede ser standalone.
+	return persistence.NewRepositories(newTestDB(t), true)
+}
+
+// newTestDB crea una base de datos temporal en el MongoDB de TEST_MONGO_URI,
+// que se elimina al terminar la prueba.
+func newTestDB(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv(testMongoURIEnv)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("conectando a %s: %v", testMongoURIEnv, err)
+	}
+	db := client.Database("ubicabus_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = db.Drop(ctx)
+		_ = client.Disconnect(ctx)
+	})
+	return db
+}
+
+func TestMigrateCompania(t *testing.T) {
+	if os.Getenv(testMongoURIEnv) == "" {
+		t.Skip("requiere " + testMongoURIEnv)
+	}
+	db := newTestDB(t)
+	ctx := context.Background()
+	companyA, companyB := primitive.NewObjectID(), primitive.NewObjectID()
+	userA, userB := primitive.NewObjectID(), primitive.NewObjectID()
+	ruta, compartida, sinBuses := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	busA, busB, sinConductor := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	location := primitive.NewObjectID()
+
+	// Documentos guardados antes de que existiera el campo compania.
+	insert := func(coll string, docs ...any) {
+		t.Helper()
+		if _, err := db.Collection(coll).InsertMany(ctx, docs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert("usuarios",
+		bson.M{"_id": userA, "nombre": "a", "compania": companyA},
+		bson.M{"_id": userB, "nombre": "b", "compania": companyB})
+	insert("ruta",
+		bson.M{"_id": ruta, "nombre": "Ruta 1"},
+		bson.M{"_id": compartida, "nombre": "Ruta 2"},
+		bson.M{"_id": sinBuses, "nombre": "Ruta 3", "compania": primitive.NilObjectID})
+	insert("buses",
+		bson.M{"_id": busA, "placa": "ABC123", "conductor": userA, "ruta": ruta},
+		bson.M{"_id": busB, "placa": "ABC124", "conductor": userB, "ruta": compartida},
+		bson.M{"_id": sinConductor, "placa": "ABC125", "ruta": compartida})
+	insert("BusLocations",
+		bson.M{"_id": location, "bus_id": busA, "localizacion": bson.M{"lat": 4.6, "lng": -74.1}})
+
+	// Es idempotente: la segunda pasada no cambia nada.
+	for i := 0; i < 2; i++ {
+		if err := persistence.Migrate(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		coll string
+		id   primitive.ObjectID
+		want primitive.ObjectID
+	}{
+		{"bus, de su conductor", "buses", busA, companyA},
+		{"bus de otra compañía", "buses", busB, companyB},
+		{"bus sin conductor", "buses", sinConductor, primitive.NilObjectID},
+		{"ruta, de sus buses", "ruta", ruta, companyA},
+		{"ruta de buses de una sola compañía resuelta", "ruta", compartida, companyB},
+		{"ruta sin buses", "ruta", sinBuses, primitive.NilObjectID},
+		{"ubicación, de su bus", "BusLocations", location, companyA},
+	} {
+		var doc struct {
+			Compania primitive.ObjectID `bson:"compania"`
+		}
+		if err := db.Collection(tc.coll).FindOne(ctx, bson.M{"_id": tc.id}).Decode(&doc); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if doc.Compania != tc.want {
+			t.Errorf("%s: compania = %s, se esperaba %s", tc.name, doc.Compania.Hex(), tc.want.Hex())
+		}
+	}
+
+	// Tras migrar, el bus y sus ubicaciones son visibles para su compañía.
+	repos := persistence.NewRepositories(db, true)
+	if _, err := repos.Buses.GetByID(ctx, domain.CompanyScope(companyA), busA); err != nil {
+		t.Errorf("bus migrado no visible para su compañía: %v", err)
+	}
+}
+
+func (a *testAPI) login(nombre, password string) string {
+	a.t.Helper()
+	w := a.do(http.MethodPost, "/auth/login", "", map[string]string{"nombre": nombre, "password": password})
+	if w.Code != http.StatusOK {
+		a.t.Fatalf("login %s: status %d: %s", nombre, w.Code, w.Body)
+	}
+	return decode[map[string]any](a.t, w)["access_token"].(string)
+}
+
+// do ejecuta una petición contra el router. body puede ser nil, un string
+// (JSON en crudo) o cualquier valor serializable a JSON.
+func (a *testAPI) do(method, path, token string, body any) *httptest.ResponseRecorder {
+	a.t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+	return w
+}
+
+// create ejecuta un POST que debe responder 201 y retorna el campo idField.
+func (a *testAPI) create(path, token string, body any, idField string) string {
+	a.t.Helper()
+	w := a.do(http.MethodPost, path, token, body)
+	if w.Code != http.StatusCreated {
+		a.t.Fatalf("POST %s: status %d, se esperaba 201: %s", path, w.Code, w.Body)
+	}
+	id, _ := decode[map[string]any](a.t, w)[idField].(string)
+	if id == "" {
+		a.t.Fatalf("POST %s: respuesta sin %q: %s", path, idField, w.Body)
+	}
+	return id
+}
+
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("respuesta no es JSON válido (%v): %s", err, w.Body)
+	}
+	return v
+}
+
+// apiCase es un caso de prueba de un endpoint. check, si no es nil, valida
+// el cuerpo de la respuesta. Las respuestas de error deben usar siempre el
+// formato de delivery.ErrorResponse.
+type apiCase struct {
+	name   string
+	method string
+	path   string
+	token  string
+	body   any
+	status int
+	check  func(t *testing.T, w *httptest.ResponseRecorder)
+}
+
+func (a *testAPI) run(cases []apiCase) {
+	a.t.Helper()
+	for _, tc := range cases {
+		a.t.Run(tc.name, func(t *testing.T) {
+			w := a.do(tc.method, tc.path, tc.token, tc.body)
+			if w.Code != tc.status {
+				t.Fatalf("%s %s: status %d, se esperaba %d: %s", tc.method, tc.path, w.Code, tc.status, w.Body)
+			}
+			if w.Code >= http.StatusBadRequest {
+				if e := decode[delivery.ErrorResponse](t, w); e.Error == "" || e.Code == "" {
+					t.Errorf("respuesta de error sin mensaje o código: %s", w.Body)
+				}
+			}
+			if tc.check != nil {
+				tc.check(t, w)
+			}
+		})
+	}
+}
+
+// field valida que el campo de un objeto JSON tenga el valor esperado.
+func field(key string, want any) func(*testing.T, *httptest.ResponseRecorder) {
+	return func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		if got := decode[map[string]any](t, w)[key]; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s = %v, se esperaba %v", key, got, want)
+		}
+	}
+}
+
+// errorCode valida el código de una respuesta de error.
+func errorCode(want string) func(*testing.T, *httptest.ResponseRecorder) {
+	return func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		if got := decode[delivery.ErrorResponse](t, w).Code; got != want {
+			t.Errorf("code = %q, se esperaba %q: %s", got, want, w.Body)
+		}
+	}
+}
+
+// items retorna los objetos de una respuesta de listado: los elementos de
+// una página (delivery.PageResp) o de un arreglo JSON.
+func items(t *testing.T, w *httptest.ResponseRecorder) []map[string]any {
+	t.Helper()
+	if strings.HasPrefix(strings.TrimSpace(w.Body.String()), "[") {
+		return decode[[]map[string]any](t, w)
+	}
+	return decode[delivery.PageResp[map[string]any]](t, w).Items
+}
+
+// noPassword valida que ningún objeto de la respuesta, o de un listado de
+// objetos, incluya el hash de la contraseña.
+func noPassword(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+	body := strings.TrimSpace(w.Body.String())
+	if strings.HasPrefix(body, "[") || strings.Contains(body, `"items":`) {
+		for _, u := range items(t, w) {
+			if _, ok := u["Password"]; ok {
+				t.Fatalf("la respuesta incluye la contraseña: %s", w.Body)
+			}
+		}
+		return
+	}
+	if _, ok := decode[map[string]any](t, w)["Password"]; ok {
+		t.Fatalf("la respuesta incluye la contraseña: %s", w.Body)
+	}
+}
+
+// length valida el número de elementos de un listado.
+func length(want int) func(*testing.T, *httptest.ResponseRecorder) {
+	return func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		if got := len(items(t, w)); got != want {
+			t.Errorf("%d elementos, se esperaban %d: %s", got, want, w.Body)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	a := newTestAPI(t)
+	var mongoErr error
+	shuttingDown := false
+	health := delivery.NewHealthHandler(func() bool { return shuttingDown })
+	health.AddCheck("mongodb", func(ctx context.Context) (map[string]any, error) { return nil, mongoErr })
+	health.AddCheck("mqtt", func(ctx context.Context) (map[string]any, error) {
+		return map[string]any{"clients": 2}, nil
+	})
+	router := delivery.NewRouter(config.Default(), a.svc, nil, health)
+
+	// Las respuestas 503 de /readyz son el informe de los componentes y no una
+	// respuesta de error, así que no se usa a.run.
+	cases := []struct {
+		name         string
+		path         string
+		mongoErr     error
+		shuttingDown bool
+		status       int
+		want         string
+		components   map[string]string
+	}{
+		{name: "liveness", path: "/healthz", status: http.StatusOK, want: "ok"},
+		{name: "readiness con todo disponible", path: "/readyz", status: http.StatusOK, want: "ok",
+			components: map[string]string{"mongodb": "ok", "mqtt": "ok"}},
+		{name: "readiness con un componente caído", path: "/readyz", mongoErr: errors.New("sin conexión"),
+			status: http.StatusServiceUnavailable, want: "unavailable", components: map[string]string{"mongodb": "error", "mqtt": "ok"}},
+		{name: "liveness no depende de los componentes", path: "/healthz", mongoErr: errors.New("sin conexión"),
+			status: http.StatusOK, want: "ok"},
+		{name: "readiness durante el apagado", path: "/readyz", shuttingDown: true,
+			status: http.StatusServiceUnavailable, want: "shutting_down"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mongoErr, shuttingDown = tc.mongoErr, tc.shuttingDown
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if w.Code != tc.status {
+				t.Fatalf("GET %s: status %d, se esperaba %d: %s", tc.path, w.Code, tc.status, w.Body)
+			}
+			resp := decode[struct {
+				Status     string                              `json:"status"`
+				Components map[string]delivery.ComponentStatus `json:"components"`
+			}](t, w)
+			if resp.Status != tc.want {
+				t.Errorf("status = %q, se esperaba %q", resp.Status, tc.want)
+			}
+			for name, want := range tc.components {
+				if got := resp.Components[name]; got.Status != want {
+					t.Errorf("componente %s: %+v, se esperaba status %q", name, got, want)
+				}
+			}
+			if tc.mongoErr != nil && tc.path == "/readyz" && resp.Components["mongodb"].Error != tc.mongoErr.Error() {
+				t.Errorf("componente mongodb sin el error de la verificación: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestAuth(t *testing.T) {
+	a := newTestAPI(t)
+
+	login := a.do(http.MethodPost, "/auth/login", "", map[string]string{"nombre": "root", "password": "root-pass"})
+	refresh, _ := decode[map[string]any](t, login)["refresh_token"].(string)
+
+	a.run([]apiCase{
+		{name: "login con contraseña incorrecta", method: http.MethodPost, path: "/auth/login",
+			body: map[string]string{"nombre": "root", "password": "otra"}, status: http.StatusUnauthorized,
+			check: errorCode("invalid_credentials")},
+		{name: "login de usuario inexistente", method: http.MethodPost, path: "/auth/login",
+			body: map[string]string{"nombre": "nadie", "password": "x"}, status: http.StatusUnauthorized},
+		{name: "login sin contraseña", method: http.MethodPost, path: "/auth/login",
+			body: map[string]string{"nombre": "root"}, status: http.StatusBadRequest, check: errorCode("invalid_body")},
+		{name: "sin token", method: http.MethodGet, path: "/buses", status: http.StatusUnauthorized,
+			check: errorCode("token_required")},
+		{name: "token inválido", method: http.MethodGet, path: "/buses", token: "x.y.z", status: http.StatusUnauthorized,
+			check: errorCode("invalid_token")},
+		{name: "permiso insuficiente", method: http.MethodPost, path: "/roles", token: a.opToken,
+			body: map[string]string{"nombre": "x"}, status: http.StatusForbidden, check: errorCode("forbidden")},
+		{name: "refresh", method: http.MethodPost, path: "/auth/refresh",
+			body: map[string]string{"refresh_token": refresh}, status: http.StatusOK},
+		{name: "refresh reutilizado", method: http.MethodPost, path: "/auth/refresh",
+			body: map[string]string{"refresh_token": refresh}, status: http.StatusUnauthorized},
+		{name: "logout con token revocado", method: http.MethodPost, path: "/auth/logout",
+			body: map[string]string{"refresh_token": refresh}, status: http.StatusUnauthorized},
+	})
+}
+
+func TestLegacyPasswordUpgrade(t *testing.T) {
+	a := newTestAPI(t)
+	ctx := context.Background()
+	// Usuario de la primera versión: SHA-256 sin sal en hexadecimal.
+	sum := sha256.Sum256([]byte("legacy-pass"))
+	legacy := &domain.User{Nombre: "legado", Password: hex.EncodeToString(sum[:]), RolID: a.opRol, Compania: a.companyA}
+	if err := a.repos.Users.Create(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+	stored := func() string {
+		t.Helper()
+		u, err := a.repos.Users.GetByNombre(ctx, "legado")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Password
+	}
+
+	a.login("legado", "legacy-pass")
+	// newTestAPI genera los hashes con bcrypt.
+	if hash := stored(); domain.PasswordAlgorithm(hash) != domain.PasswordAlgoBcrypt || !strings.HasPrefix(hash, "$2") {
+		t.Fatalf("hash tras el login = %q, se esperaba bcrypt", hash)
+	}
+	upgraded := stored()
+
+	a.login("legado", "legacy-pass")
+	a.run([]apiCase{
+		{name: "contraseña incorrecta tras migrar", method: http.MethodPost, path: "/auth/login",
+			body: map[string]string{"nombre": "legado", "password": "otra"}, status: http.StatusUnauthorized,
+			check: errorCode("invalid_credentials")},
+		{name: "el hash SHA-256 ya no sirve como contraseña", method: http.MethodPost, path: "/auth/login",
+			body: map[string]string{"nombre": "legado", "password": hex.EncodeToString(sum[:])}, status: http.StatusUnauthorized},
+	})
+	if stored() != upgraded {
+		t.Error("el hash migrado se regeneró en el segundo login")
+	}
+}
+
+func TestAuthReloadsUser(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	user := a.create("/register", a.rootToken, map[string]string{
+		"nombre": "operador2", "password": "op2-pass", "rol_id": a.opRol.Hex(), "compania_id": a.companyA.Hex(),
+	}, "user_id")
+	// El token se emite antes de los cambios y sigue vigente.
+	token := a.login("operador2", "op2-pass")
+
+	a.run([]apiCase{
+		{name: "ve su compañía", method: http.MethodGet, path: "/routes/" + ruta, token: token,
+			status: http.StatusOK},
+		{name: "mover a otra compañía", method: http.MethodPut, path: "/user/" + user, token: a.rootToken,
+			body: map[string]string{"compania_id": a.companyB.Hex()}, status: http.StatusOK},
+		{name: "deja de ver la compañía anterior", method: http.MethodGet, path: "/routes/" + ruta, token: token,
+			status: http.StatusNotFound},
+		{name: "eliminar", method: http.MethodDelete, path: "/users/" + user, token: a.rootToken,
+			status: http.StatusOK},
+		{name: "usuario eliminado", method: http.MethodGet, path: "/routes", token: token,
+			status: http.StatusUnauthorized, check: errorCode("invalid_token")},
+	})
+}
+
+func TestCompanies(t *testing.T) {
+	a := newTestAPI(t)
+	id := a.create("/companies", a.rootToken, map[string]string{"nombre": "Transportes C", "descripcion": "urbano"}, "company_id")
+	unknown := primitive.NewObjectID().Hex()
+
+	a.run([]apiCase{
+		{name: "listar como root", method: http.MethodGet, path: "/companies", token: a.rootToken,
+			status: http.StatusOK, check: length(3)},
+		{name: "listar como operador solo ve su compañía", method: http.MethodGet, path: "/companies", token: a.opToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "crear sin nombre", method: http.MethodPost, path: "/companies", token: a.rootToken,
+			body: map[string]string{"descripcion": "x"}, status: http.StatusBadRequest},
+		{name: "crear como operador", method: http.MethodPost, path: "/companies", token: a.opToken,
+			body: map[string]string{"nombre": "x"}, status: http.StatusForbidden},
+		{name: "obtener por ID", method: http.MethodGet, path: "/companies/" + id, token: a.rootToken,
+			status: http.StatusOK, check: field("Nombre", "Transportes C")},
+		{name: "obtener con ID inválido", method: http.MethodGet, path: "/companies/xyz", token: a.rootToken,
+			status: http.StatusBadRequest},
+		{name: "obtener inexistente", method: http.MethodGet, path: "/companies/" + unknown, token: a.rootToken,
+			status: http.StatusNotFound},
+		{name: "operador no ve otra compañía", method: http.MethodGet, path: "/companies/" + a.companyB.Hex(), token: a.opToken,
+			status: http.StatusNotFound},
+		{name: "buscar por nombre", method: http.MethodGet, path: "/companies/search?name=Transportes%20C", token: a.rootToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "buscar sin nombre", method: http.MethodGet, path: "/companies/search", token: a.rootToken,
+			status: http.StatusBadRequest},
+		{name: "editar", method: http.MethodPut, path: "/companies/" + id, token: a.rootToken,
+			body: map[string]string{"nombre": "Transportes D"}, status: http.StatusOK, check: field("Nombre", "Transportes D")},
+		{name: "editar conserva campos no enviados", method: http.MethodGet, path: "/companies/" + id, token: a.rootToken,
+			status: http.StatusOK, check: field("Descripcion", "urbano")},
+		{name: "editar inexistente", method: http.MethodPut, path: "/companies/" + unknown, token: a.rootToken,
+			body: map[string]string{"nombre": "x"}, status: http.StatusNotFound, check: errorCode("company_not_found")},
+		{name: "eliminar", method: http.MethodDelete, path: "/companies/" + id, token: a.rootToken,
+			status: http.StatusOK},
+		{name: "eliminada ya no existe", method: http.MethodGet, path: "/companies/" + id, token: a.rootToken,
+			status: http.StatusNotFound},
+	})
+}
+
+func TestRoles(t *testing.T) {
+	a := newTestAPI(t)
+	id := a.create("/roles", a.rootToken, map[string]any{"nombre": "conductor", "permisos": []string{domain.PermBusesRead}}, "role_id")
+	unknown := primitive.NewObjectID().Hex()
+
+	a.run([]apiCase{
+		{name: "listar", method: http.MethodGet, path: "/roles", token: a.opToken,
+			status: http.StatusOK, check: length(3)},
+		{name: "crear sin nombre", method: http.MethodPost, path: "/roles", token: a.rootToken,
+			body: map[string]any{"permisos": []string{}}, status: http.StatusBadRequest},
+		{name: "crear con JSON inválido", method: http.MethodPost, path: "/roles", token: a.rootToken,
+			body: `{"nombre":`, status: http.StatusBadRequest},
+		{name: "crear con permiso desconocido", method: http.MethodPost, path: "/roles", token: a.rootToken,
+			body: map[string]any{"nombre": "x", "permisos": []string{"naves:volar"}}, status: http.StatusBadRequest,
+			check: errorCode("unknown_permission")},
+		{name: "obtener por ID", method: http.MethodGet, path: "/roles/" + id, token: a.rootToken,
+			status: http.StatusOK, check: field("Permisos", []string{domain.PermBusesRead})},
+		{name: "obtener con ID inválido", method: http.MethodGet, path: "/roles/xyz", token: a.rootToken,
+			status: http.StatusBadRequest},
+		{name: "obtener inexistente", method: http.MethodGet, path: "/roles/" + unknown, token: a.rootToken,
+			status: http.StatusNotFound, check: errorCode("role_not_found")},
+		{name: "buscar por nombre", method: http.MethodGet, path: "/roles/search?name=conductor", token: a.rootToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "buscar sin nombre", method: http.MethodGet, path: "/roles/search", token: a.rootToken,
+			status: http.StatusBadRequest},
+		{name: "editar permisos", method: http.MethodPut, path: "/roles/" + id, token: a.rootToken,
+			body: map[string]any{"nombre": "conductor", "permisos": []string{"buses:*"}}, status: http.StatusOK,
+			check: field("Permisos", []string{"buses:*"})},
+		{name: "editar inexistente", method: http.MethodPut, path: "/roles/" + unknown, token: a.rootToken,
+			body: map[string]any{"nombre": "x"}, status: http.StatusNotFound},
+		{name: "eliminar como operador", method: http.MethodDelete, path: "/roles/" + id, token: a.opToken,
+			status: http.StatusForbidden},
+		{name: "eliminar", method: http.MethodDelete, path: "/roles/" + id, token: a.rootToken,
+			status: http.StatusOK},
+		{name: "eliminado ya no existe", method: http.MethodGet, path: "/roles/" + id, token: a.rootToken,
+			status: http.StatusNotFound},
+	})
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	a := newTestAPI(t)
+	ctx := context.Background()
+
+	created, err := a.svc.Users.BootstrapAdmin(ctx, "superadmin", "super-pass")
+	if err != nil || !created {
+		t.Fatalf("BootstrapAdmin = %v, %v; se esperaba que creara el usuario", created, err)
+	}
+	// Arrancar de nuevo, o con un usuario que ya existe, no cambia nada.
+	for _, nombre := range []string{"superadmin", "operador"} {
+		if created, err := a.svc.Users.BootstrapAdmin(ctx, nombre, "otra-pass"); err != nil || created {
+			t.Errorf("BootstrapAdmin(%q) = %v, %v; no se esperaban cambios", nombre, created, err)
+		}
+	}
+	if roles, err := a.repos.Roles.GetByName(ctx, application.SuperAdminRole); err != nil || len(roles) != 1 {
+		t.Fatalf("roles %s = %v, %v; se esperaba uno", application.SuperAdminRole, roles, err)
+	}
+
+	token := a.login("superadmin", "super-pass")
+	a.run([]apiCase{
+		{name: "el super-admin ve todas las compañías", method: http.MethodGet, path: "/companies", token: token,
+			status: http.StatusOK, check: length(2)},
+		{name: "el operador conserva su rol", method: http.MethodGet, path: "/companies", token: a.login("operador", "op-pass"),
+			status: http.StatusOK, check: length(1)},
+	})
+}
+
+// newBusReq retorna el cuerpo de creación de un bus.
+func newBusReq(placa, conductor, ruta string) map[string]any {
+	return map[string]any{
+		"placa":        placa,
+		"conductor_id": conductor,
+		"ruta_id":      ruta,
+		"fecha_inicio": "2025-01-01T00:00:00Z",
+		"fecha_fin":    "2025-12-31T00:00:00Z",
+	}
+}
+
+func TestBuses(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	id := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+
+	rutaBReq := newRouteReq("Ruta B")
+	rutaBReq["compania_id"] = a.companyB.Hex()
+	rutaB := a.create("/routes", a.rootToken, rutaBReq, "route_id")
+	conductorB := a.create("/register", a.rootToken, map[string]string{
+		"nombre": "conductor-b", "password": "x", "rol_id": a.opRol.Hex(), "compania_id": a.companyB.Hex(),
+	}, "user_id")
+	otherReq := newBusReq("XYZ999", conductorB, rutaB)
+	otherReq["compania_id"] = a.companyB.Hex()
+	other := a.create("/buses", a.rootToken, otherReq, "bus_id")
+
+	consulta := a.create("/roles", a.rootToken, map[string]any{"nombre": "consulta", "permisos": []string{domain.PermBusesRead}}, "role_id")
+	noDriver := a.create("/register", a.rootToken, map[string]string{
+		"nombre": "consulta", "password": "x", "rol_id": consulta, "compania_id": a.companyA.Hex(),
+	}, "user_id")
+	moveReq := newBusReq("XYZ999", conductorB, rutaB)
+	moveReq["compania_id"] = a.companyA.Hex()
+	unknown := primitive.NewObjectID().Hex()
+
+	a.run([]apiCase{
+		{name: "listar como root", method: http.MethodGet, path: "/buses", token: a.rootToken,
+			status: http.StatusOK, check: length(2)},
+		{name: "listar como operador", method: http.MethodGet, path: "/buses", token: a.opToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "crear sin placa", method: http.MethodPost, path: "/buses", token: a.opToken,
+			body: newBusReq("", a.opID.Hex(), ruta), status: http.StatusBadRequest},
+		{name: "crear en otra compañía", method: http.MethodPost, path: "/buses", token: a.opToken,
+			body: otherReq, status: http.StatusForbidden},
+		{name: "crear con ruta inexistente", method: http.MethodPost, path: "/buses", token: a.opToken,
+			body: newBusReq("ABC200", a.opID.Hex(), unknown), status: http.StatusBadRequest, check: errorCode("unknown_route")},
+		{name: "crear con ruta de otra compañía", method: http.MethodPost, path: "/buses", token: a.opToken,
+			body: newBusReq("ABC200", a.opID.Hex(), rutaB), status: http.StatusBadRequest, check: errorCode("unknown_route")},
+		{name: "crear con conductor inexistente", method: http.MethodPost, path: "/buses", token: a.opToken,
+			body: newBusReq("ABC200", unknown, ruta), status: http.StatusBadRequest, check: errorCode("unknown_conductor")},
+		{name: "crear con conductor de otra compañía", method: http.MethodPost, path: "/buses", token: a.opToken,
+			body: newBusReq("ABC200", conductorB, ruta), status: http.StatusBadRequest, check: errorCode("unknown_conductor")},
+		{name: "crear con conductor sin rol de conductor", method: http.MethodPost, path: "/buses", token: a.opToken,
+			body: newBusReq("ABC200", noDriver, ruta), status: http.StatusBadRequest, check: errorCode("not_a_driver")},
+		{name: "editar con ruta inexistente", method: http.MethodPut, path: "/buses/" + id, token: a.opToken,
+			body: newBusReq("ABC123", a.opID.Hex(), unknown), status: http.StatusBadRequest, check: errorCode("unknown_route")},
+		{name: "mover a otra compañía sin cambiar la ruta", method: http.MethodPut, path: "/buses/" + other, token: a.rootToken,
+			body: moveReq, status: http.StatusBadRequest, check: errorCode("unknown_conductor")},
+		{name: "obtener por ID", method: http.MethodGet, path: "/buses/" + id, token: a.opToken,
+			status: http.StatusOK, check: field("CompaniaID", a.companyA.Hex())},
+		{name: "obtener con ID inválido", method: http.MethodGet, path: "/buses/xyz", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("validation")},
+		{name: "obtener inexistente", method: http.MethodGet, path: "/buses/" + unknown, token: a.opToken,
+			status: http.StatusNotFound, check: errorCode("bus_not_found")},
+		{name: "obtener de otra compañía", method: http.MethodGet, path: "/buses/" + other, token: a.opToken,
+			status: http.StatusNotFound},
+		{name: "buscar por placa", method: http.MethodGet, path: "/buses/search?placa=ABC123", token: a.opToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "buscar sin placa", method: http.MethodGet, path: "/buses/search", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("missing_parameter")},
+		{name: "editar", method: http.MethodPut, path: "/buses/" + id, token: a.opToken,
+			body: newBusReq("ABC124", a.opID.Hex(), ruta), status: http.StatusOK, check: field("Placa", "ABC124")},
+		{name: "editar de otra compañía", method: http.MethodPut, path: "/buses/" + other, token: a.opToken,
+			body: newBusReq("ABC125", a.opID.Hex(), ruta), status: http.StatusNotFound},
+		{name: "eliminar de otra compañía", method: http.MethodDelete, path: "/buses/" + other, token: a.opToken,
+			status: http.StatusNotFound},
+		{name: "eliminar", method: http.MethodDelete, path: "/buses/" + id, token: a.opToken,
+			status: http.StatusOK},
+		{name: "eliminar de nuevo", method: http.MethodDelete, path: "/buses/" + id, token: a.opToken,
+			status: http.StatusNotFound},
+	})
+}
+
+// newRouteReq retorna el cuerpo de creación de una ruta.
+func newRouteReq(nombre string) map[string]any {
+	return map[string]any{
+		"nombre":          nombre,
+		"modo_transporte": "bus",
+		"origen_lat":      4.60,
+		"origen_lng":      -74.08,
+		"destino_lat":     4.65,
+		"destino_lng":     -74.05,
+		"waypoints":       []map[string]any{{"lat": 4.62, "lng": -74.07, "descripcion": "Parada 1"}},
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	a := newTestAPI(t)
+	id := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	unknown := primitive.NewObjectID().Hex()
+
+	missingMode := newRouteReq("Ruta 2")
+	delete(missingMode, "modo_transporte")
+
+	a.run([]apiCase{
+		{name: "listar", method: http.MethodGet, path: "/routes", token: a.opToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "crear sin modo de transporte", method: http.MethodPost, path: "/routes", token: a.opToken,
+			body: missingMode, status: http.StatusBadRequest},
+		{name: "buscar por nombre", method: http.MethodGet, path: "/routes/search?name=Ruta%201", token: a.opToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "buscar sin resultados", method: http.MethodGet, path: "/routes/search?name=Otra", token: a.opToken,
+			status: http.StatusNotFound, check: errorCode("route_not_found")},
+		{name: "buscar sin nombre", method: http.MethodGet, path: "/routes/search", token: a.opToken,
+			status: http.StatusBadRequest},
+		{name: "editar", method: http.MethodPut, path: "/routes/" + id, token: a.opToken,
+			body: newRouteReq("Ruta 1 bis"), status: http.StatusOK, check: field("Nombre", "Ruta 1 bis")},
+		{name: "editar inexistente", method: http.MethodPut, path: "/routes/" + unknown, token: a.opToken,
+			body: newRouteReq("x"), status: http.StatusNotFound},
+		{name: "otra compañía no ve la ruta", method: http.MethodGet, path: "/routes", token: a.loginCompanyB(),
+			status: http.StatusOK, check: length(0)},
+		{name: "obtener por ID", method: http.MethodGet, path: "/routes/" + id, token: a.opToken,
+			status: http.StatusOK, check: field("Nombre", "Ruta 1 bis")},
+		{name: "obtener con ID inválido", method: http.MethodGet, path: "/routes/xyz", token: a.opToken,
+			status: http.StatusBadRequest},
+		{name: "obtener inexistente", method: http.MethodGet, path: "/routes/" + unknown, token: a.opToken,
+			status: http.StatusNotFound, check: errorCode("route_not_found")},
+		{name: "eliminar inexistente", method: http.MethodDelete, path: "/routes/" + unknown, token: a.opToken,
+			status: http.StatusNotFound},
+	})
+}
+
+func TestDeleteRouteWithBuses(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	other := a.create("/routes", a.opToken, newRouteReq("Ruta 2"), "route_id")
+
+	a.run([]apiCase{
+		{name: "otra compañía no puede eliminarla", method: http.MethodDelete, path: "/routes/" + ruta, token: a.loginCompanyB(),
+			status: http.StatusNotFound},
+		{name: "eliminar con buses asignados", method: http.MethodDelete, path: "/routes/" + ruta, token: a.opToken,
+			status: http.StatusConflict, check: errorCode("route_in_use")},
+		{name: "la ruta sigue existiendo", method: http.MethodGet, path: "/routes/" + ruta, token: a.opToken,
+			status: http.StatusOK},
+		{name: "cascade inválido", method: http.MethodDelete, path: "/routes/" + ruta + "?cascade=quizas", token: a.opToken,
+			status: http.StatusBadRequest},
+		{name: "eliminar en cascada", method: http.MethodDelete, path: "/routes/" + ruta + "?cascade=true", token: a.opToken,
+			status: http.StatusOK},
+		{name: "la ruta ya no existe", method: http.MethodGet, path: "/routes/" + ruta, token: a.opToken,
+			status: http.StatusNotFound},
+		{name: "el bus queda sin ruta", method: http.MethodGet, path: "/buses/" + bus, token: a.opToken,
+			status: http.StatusOK, check: field("RutaID", primitive.NilObjectID.Hex())},
+		{name: "eliminar ruta sin buses", method: http.MethodDelete, path: "/routes/" + other, token: a.opToken,
+			status: http.StatusOK},
+	})
+}
+
+func TestMoveRouteWithBuses(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	toB := newRouteReq("Ruta 1")
+	toB["compania_id"] = a.companyB.Hex()
+	same := newRouteReq("Ruta 1 bis")
+	same["compania_id"] = a.companyA.Hex()
+
+	a.run([]apiCase{
+		{name: "mover a otra compañía con buses asignados", method: http.MethodPut, path: "/routes/" + ruta, token: a.rootToken,
+			body: toB, status: http.StatusConflict, check: errorCode("route_in_use")},
+		{name: "la ruta sigue en su compañía", method: http.MethodGet, path: "/routes/" + ruta, token: a.opToken,
+			status: http.StatusOK},
+		{name: "editar con la misma compañía", method: http.MethodPut, path: "/routes/" + ruta, token: a.rootToken,
+			body: same, status: http.StatusOK, check: field("Nombre", "Ruta 1 bis")},
+		{name: "eliminar el bus", method: http.MethodDelete, path: "/buses/" + bus, token: a.opToken,
+			status: http.StatusOK},
+		{name: "mover sin buses asignados", method: http.MethodPut, path: "/routes/" + ruta, token: a.rootToken,
+			body: toB, status: http.StatusOK, check: field("CompaniaID", a.companyB.Hex())},
+	})
+}
+
+// loginCompanyB crea un operador de la compañía B y retorna su access token.
+func (a *testAPI) loginCompanyB() string {
+	a.t.Helper()
+	a.create("/register", a.rootToken, map[string]string{
+		"nombre": "operador-b", "password": "op-b-pass", "rol_id": a.opRol.Hex(), "compania_id": a.companyB.Hex(),
+	}, "user_id")
+	return a.login("operador-b", "op-b-pass")
+}
+
+func TestBusLocations(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	id := a.create("/buslocations", a.opToken, map[string]any{"bus_id": bus, "lat": 4.6, "lng": -74.1}, "id")
+	a.create("/buslocations", a.opToken, map[string]any{"bus_id": bus, "lat": 4.7, "lng": -74.2}, "id")
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	a.run([]apiCase{
+		{name: "listar", method: http.MethodGet, path: "/buslocations", token: a.opToken,
+			status: http.StatusOK, check: length(2)},
+		{name: "listar por bus", method: http.MethodGet, path: "/buslocations?bus_id=" + bus, token: a.opToken,
+			status: http.StatusOK, check: length(2)},
+		{name: "historial del bus", method: http.MethodGet, path: "/buslocations/" + bus, token: a.opToken,
+			status: http.StatusOK, check: historyPoints(2, 2)},
+		{name: "listar con bus inválido", method: http.MethodGet, path: "/buslocations/xyz", token: a.opToken,
+			status: http.StatusBadRequest},
+		{name: "listar desde una fecha futura", method: http.MethodGet, path: "/buslocations?created_at%5Bgte%5D=" + future, token: a.opToken,
+			status: http.StatusOK, check: length(0)},
+		{name: "listar hasta una fecha futura", method: http.MethodGet, path: "/buslocations?bus_id=" + bus + "&created_at%5Blte%5D=" + future, token: a.opToken,
+			status: http.StatusOK, check: length(2)},
+		{name: "listar con fecha inválida", method: http.MethodGet, path: "/buslocations?created_at%5Bgte%5D=ayer", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_filter")},
+		{name: "crear con bus inexistente", method: http.MethodPost, path: "/buslocations", token: a.opToken,
+			body:   map[string]any{"bus_id": primitive.NewObjectID().Hex(), "lat": 4.6, "lng": -74.1},
+			status: http.StatusBadRequest, check: errorCode("unknown_bus")},
+		{name: "crear sin latitud", method: http.MethodPost, path: "/buslocations", token: a.opToken,
+			body: map[string]any{"bus_id": bus, "lng": -74.1}, status: http.StatusBadRequest},
+		{name: "otra compañía no ve las posiciones", method: http.MethodGet, path: "/buslocations", token: a.loginCompanyB(),
+			status: http.StatusOK, check: length(0)},
+		{name: "eliminar", method: http.MethodDelete, path: "/buslocations/" + id, token: a.opToken,
+			status: http.StatusOK},
+		{name: "eliminar de nuevo", method: http.MethodDelete, path: "/buslocations/" + id, token: a.opToken,
+			status: http.StatusNotFound},
+		{name: "quedan las demás", method: http.MethodGet, path: "/buslocations/" + bus, token: a.opToken,
+			status: http.StatusOK, check: historyPoints(1, 1)},
+	})
+}
+
+func TestUsers(t *testing.T) {
+	a := newTestAPI(t)
+	id := a.create("/register", a.opToken, map[string]string{
+		"nombre": "conductor1", "password": "secreto", "rol_id": a.opRol.Hex(),
+	}, "user_id")
+	unknown := primitive.NewObjectID().Hex()
+	// Un rol sin tenants:all pero con permisos que el operador no tiene.
+	gestor := a.create("/roles", a.rootToken, map[string]any{"nombre": "gestor", "permisos": []string{"companies:*", domain.PermBusesRead}}, "role_id")
+
+	a.run([]apiCase{
+		{name: "registrar con JSON inválido", method: http.MethodPost, path: "/register", token: a.opToken,
+			body: `{"nombre":`, status: http.StatusBadRequest},
+		{name: "registrar con rol de super-admin", method: http.MethodPost, path: "/register", token: a.opToken,
+			body: map[string]string{"nombre": "x", "password": "x", "rol_id": a.adminRol.Hex()}, status: http.StatusForbidden},
+		{name: "registrar en otra compañía", method: http.MethodPost, path: "/register", token: a.opToken,
+			body:   map[string]string{"nombre": "x", "password": "x", "rol_id": a.opRol.Hex(), "compania_id": a.companyB.Hex()},
+			status: http.StatusForbidden},
+		{name: "registrar nombre repetido", method: http.MethodPost, path: "/register", token: a.opToken,
+			body:   map[string]string{"nombre": "conductor1", "password": "x", "rol_id": a.opRol.Hex()},
+			status: http.StatusConflict, check: errorCode("user_exists")},
+		{name: "registrar con rol inexistente", method: http.MethodPost, path: "/register", token: a.opToken,
+			body:   map[string]string{"nombre": "x", "password": "x", "rol_id": unknown},
+			status: http.StatusBadRequest, check: errorCode("unknown_role")},
+		{name: "renombrar a un nombre usado", method: http.MethodPut, path: "/user/" + id, token: a.opToken,
+			body: map[string]string{"nombre": "operador"}, status: http.StatusConflict, check: errorCode("user_exists")},
+		{name: "editar nombre", method: http.MethodPut, path: "/user/" + id, token: a.opToken,
+			body: map[string]string{"nombre": "conductor2"}, status: http.StatusOK, check: field("Nombre", "conductor2")},
+		// Un PUT sin campos debe devolver el usuario sin cambios en lugar de
+		// intentar un $set vacío.
+		{name: "editar sin cambios", method: http.MethodPut, path: "/user/" + id, token: a.opToken,
+			body: map[string]string{}, status: http.StatusOK, check: field("Nombre", "conductor2")},
+		{name: "editar contraseña", method: http.MethodPut, path: "/user/" + id, token: a.opToken,
+			body: map[string]string{"password": "nuevo"}, status: http.StatusOK},
+		{name: "login con la nueva contraseña", method: http.MethodPost, path: "/auth/login",
+			body: map[string]string{"nombre": "conductor2", "password": "nuevo"}, status: http.StatusOK},
+		{name: "login con la contraseña anterior", method: http.MethodPost, path: "/auth/login",
+			body: map[string]string{"nombre": "conductor2", "password": "secreto"}, status: http.StatusUnauthorized},
+		{name: "editar inexistente", method: http.MethodPut, path: "/user/" + unknown, token: a.opToken,
+			body: map[string]string{"nombre": "x"}, status: http.StatusNotFound, check: errorCode("user_not_found")},
+		{name: "editar usuario de otra compañía", method: http.MethodPut, path: "/user/" + id, token: a.loginCompanyB(),
+			body: map[string]string{"nombre": "x"}, status: http.StatusNotFound},
+		{name: "escalar a super-admin", method: http.MethodPut, path: "/user/" + id, token: a.opToken,
+			body: map[string]string{"rol_id": a.adminRol.Hex()}, status: http.StatusForbidden},
+		{name: "registrar con un rol con más permisos", method: http.MethodPost, path: "/register", token: a.opToken,
+			body:   map[string]string{"nombre": "x", "password": "x", "rol_id": gestor},
+			status: http.StatusForbidden, check: field("details", map[string]any{"permiso": "companies:*"})},
+		{name: "dar un rol con más permisos", method: http.MethodPut, path: "/user/" + id, token: a.opToken,
+			body: map[string]string{"rol_id": gestor}, status: http.StatusForbidden, check: errorCode("forbidden")},
+		{name: "quien tiene los permisos sí puede darlo", method: http.MethodPut, path: "/user/" + id, token: a.rootToken,
+			body: map[string]string{"rol_id": gestor}, status: http.StatusOK, check: field("RolID", gestor)},
+	})
+}
+
+func TestUsersCRUD(t *testing.T) {
+	a := newTestAPI(t)
+	id := a.create("/register", a.opToken, map[string]string{
+		"nombre": "conductor1", "password": "secreto", "rol_id": a.opRol.Hex(),
+	}, "user_id")
+	tokenB := a.loginCompanyB()
+	userB, err := a.repos.Users.GetByNombre(context.Background(), "operador-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := primitive.NewObjectID().Hex()
+
+	a.run([]apiCase{
+		{name: "listar como root", method: http.MethodGet, path: "/users", token: a.rootToken,
+			status: http.StatusOK, check: length(4)},
+		{name: "listar como operador", method: http.MethodGet, path: "/users", token: a.opToken,
+			status: http.StatusOK, check: length(3)},
+		{name: "listar sin contraseñas", method: http.MethodGet, path: "/users", token: a.rootToken,
+			status: http.StatusOK, check: noPassword},
+		{name: "obtener por ID", method: http.MethodGet, path: "/users/" + id, token: a.opToken,
+			status: http.StatusOK, check: field("Nombre", "conductor1")},
+		{name: "obtener sin contraseña", method: http.MethodGet, path: "/users/" + id, token: a.opToken,
+			status: http.StatusOK, check: noPassword},
+		{name: "obtener con ID inválido", method: http.MethodGet, path: "/users/xyz", token: a.opToken,
+			status: http.StatusBadRequest},
+		{name: "obtener inexistente", method: http.MethodGet, path: "/users/" + unknown, token: a.opToken,
+			status: http.StatusNotFound, check: errorCode("user_not_found")},
+		{name: "obtener de otra compañía", method: http.MethodGet, path: "/users/" + userB.ID.Hex(), token: a.opToken,
+			status: http.StatusNotFound},
+		{name: "editar sin contraseña en la respuesta", method: http.MethodPut, path: "/user/" + id, token: a.opToken,
+			body: map[string]string{"password": "nuevo"}, status: http.StatusOK, check: noPassword},
+		{name: "buscar por nombre", method: http.MethodGet, path: "/users/search?nombre=conductor1", token: a.opToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "buscar por rol", method: http.MethodGet, path: "/users/search?rol_id=" + a.opRol.Hex(), token: a.rootToken,
+			status: http.StatusOK, check: length(3)},
+		{name: "buscar por rol y compañía", method: http.MethodGet,
+			path: "/users/search?rol_id=" + a.opRol.Hex() + "&compania_id=" + a.companyB.Hex(), token: a.rootToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "buscar en otra compañía", method: http.MethodGet, path: "/users/search?compania_id=" + a.companyB.Hex(), token: a.opToken,
+			status: http.StatusOK, check: length(0)},
+		{name: "buscar sin criterios", method: http.MethodGet, path: "/users/search", token: a.opToken,
+			status: http.StatusBadRequest},
+		{name: "buscar con rol inválido", method: http.MethodGet, path: "/users/search?rol_id=xyz", token: a.opToken,
+			status: http.StatusBadRequest},
+		{name: "eliminar de otra compañía", method: http.MethodDelete, path: "/users/" + id, token: tokenB,
+			status: http.StatusNotFound},
+		{name: "eliminar", method: http.MethodDelete, path: "/users/" + id, token: a.opToken,
+			status: http.StatusOK},
+		{name: "eliminar de nuevo", method: http.MethodDelete, path: "/users/" + id, token: a.opToken,
+			status: http.StatusNotFound},
+		{name: "el eliminado no puede iniciar sesión", method: http.MethodPost, path: "/auth/login",
+			body: map[string]string{"nombre": "conductor1", "password": "nuevo"}, status: http.StatusUnauthorized},
+	})
+}
+
+func TestDeleteDriver(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	conductor := a.create("/register", a.opToken, map[string]string{
+		"nombre": "conductor1", "password": "secreto", "rol_id": a.opRol.Hex(),
+	}, "user_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", conductor, ruta), "bus_id")
+	otro := a.create("/buses", a.opToken, newBusReq("ABC124", a.opID.Hex(), ruta), "bus_id")
+
+	a.run([]apiCase{
+		{name: "eliminar conductor asignado", method: http.MethodDelete, path: "/users/" + conductor, token: a.opToken,
+			status: http.StatusOK},
+		{name: "el bus queda sin conductor", method: http.MethodGet, path: "/buses/" + bus, token: a.opToken,
+			status: http.StatusOK, check: field("ConductorID", primitive.NilObjectID.Hex())},
+		{name: "los demás buses conservan el suyo", method: http.MethodGet, path: "/buses/" + otro, token: a.opToken,
+			status: http.StatusOK, check: field("ConductorID", a.opID.Hex())},
+	})
+}
+
+func TestEditDriver(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	chofer := a.create("/roles", a.rootToken, map[string]any{"nombre": "chofer", "permisos": []string{domain.PermBusesDrive}}, "role_id")
+	lector := a.create("/roles", a.rootToken, map[string]any{"nombre": "lector", "permisos": []string{domain.PermBusesRead}}, "role_id")
+	conductor := a.create("/register", a.rootToken, map[string]string{
+		"nombre": "chofer1", "password": "x", "rol_id": chofer, "compania_id": a.companyA.Hex(),
+	}, "user_id")
+	libre := a.create("/register", a.rootToken, map[string]string{
+		"nombre": "chofer2", "password": "x", "rol_id": chofer, "compania_id": a.companyA.Hex(),
+	}, "user_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", conductor, ruta), "bus_id")
+
+	a.run([]apiCase{
+		{name: "quitar el rol de conductor a quien conduce", method: http.MethodPut, path: "/user/" + conductor, token: a.opToken,
+			body: map[string]string{"rol_id": lector}, status: http.StatusConflict, check: errorCode("driver_in_use")},
+		{name: "mover de compañía a quien conduce", method: http.MethodPut, path: "/user/" + conductor, token: a.rootToken,
+			body: map[string]string{"compania_id": a.companyB.Hex()}, status: http.StatusConflict, check: errorCode("driver_in_use")},
+		{name: "cambiar a otro rol de conductor", method: http.MethodPut, path: "/user/" + conductor, token: a.opToken,
+			body: map[string]string{"rol_id": a.opRol.Hex()}, status: http.StatusOK, check: field("RolID", a.opRol.Hex())},
+		{name: "cambiar el rol de quien no conduce", method: http.MethodPut, path: "/user/" + libre, token: a.opToken,
+			body: map[string]string{"rol_id": lector}, status: http.StatusOK, check: field("RolID", lector)},
+		{name: "mover de compañía a quien no conduce", method: http.MethodPut, path: "/user/" + libre, token: a.rootToken,
+			body: map[string]string{"compania_id": a.companyB.Hex()}, status: http.StatusOK, check: field("Compania", a.companyB.Hex())},
+		{name: "volver al rol chofer", method: http.MethodPut, path: "/user/" + conductor, token: a.opToken,
+			body: map[string]string{"rol_id": chofer}, status: http.StatusOK},
+		{name: "quitar buses:drive a un rol con conductores", method: http.MethodPut, path: "/roles/" + chofer, token: a.rootToken,
+			body: map[string]any{"nombre": "chofer", "permisos": []string{domain.PermBusesRead}}, status: http.StatusConflict, check: errorCode("role_has_drivers")},
+		{name: "conservar buses:drive mediante el comodín", method: http.MethodPut, path: "/roles/" + chofer, token: a.rootToken,
+			body: map[string]any{"nombre": "chofer", "permisos": []string{"buses:*"}}, status: http.StatusOK},
+		{name: "eliminar el bus", method: http.MethodDelete, path: "/buses/" + bus, token: a.opToken,
+			status: http.StatusOK},
+		{name: "sin buses se puede quitar buses:drive", method: http.MethodPut, path: "/roles/" + chofer, token: a.rootToken,
+			body: map[string]any{"nombre": "chofer", "permisos": []string{domain.PermBusesRead}}, status: http.StatusOK},
+		{name: "sin buses se puede cambiar de compañía", method: http.MethodPut, path: "/user/" + conductor, token: a.rootToken,
+			body: map[string]string{"compania_id": a.companyB.Hex()}, status: http.StatusOK},
+	})
+}
+
+func TestDeleteReferencedCompanyAndRole(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	a.create("/buslocations", a.opToken, map[string]any{"bus_id": bus, "lat": 4.6, "lng": -74.1}, "id")
+
+	chofer := a.create("/roles", a.rootToken, map[string]any{"nombre": "chofer", "permisos": []string{domain.PermBusesDrive}}, "role_id")
+	conductor := a.create("/register", a.rootToken, map[string]string{
+		"nombre": "chofer1", "password": "x", "rol_id": chofer, "compania_id": a.companyA.Hex(),
+	}, "user_id")
+	bus2 := a.create("/buses", a.opToken, newBusReq("ABC124", conductor, ruta), "bus_id")
+
+	a.run([]apiCase{
+		{name: "registrar en compañía inexistente", method: http.MethodPost, path: "/register", token: a.rootToken,
+			body:   map[string]string{"nombre": "x", "password": "x", "rol_id": chofer, "compania_id": primitive.NewObjectID().Hex()},
+			status: http.StatusBadRequest, check: errorCode("unknown_company")},
+		{name: "eliminar rol asignado", method: http.MethodDelete, path: "/roles/" + chofer, token: a.rootToken,
+			status: http.StatusConflict, check: errorCode("role_in_use")},
+		{name: "eliminar rol en cascada", method: http.MethodDelete, path: "/roles/" + chofer + "?cascade=true", token: a.rootToken,
+			status: http.StatusOK},
+		{name: "el usuario del rol se eliminó", method: http.MethodGet, path: "/users/" + conductor, token: a.rootToken,
+			status: http.StatusNotFound},
+		{name: "el bus queda sin conductor", method: http.MethodGet, path: "/buses/" + bus2, token: a.rootToken,
+			status: http.StatusOK, check: field("ConductorID", primitive.NilObjectID.Hex())},
+		{name: "eliminar compañía con datos", method: http.MethodDelete, path: "/companies/" + a.companyA.Hex(), token: a.rootToken,
+			status: http.StatusConflict, check: errorCode("company_in_use")},
+		{name: "la compañía sigue existiendo", method: http.MethodGet, path: "/companies/" + a.companyA.Hex(), token: a.rootToken,
+			status: http.StatusOK},
+		{name: "eliminar compañía en cascada", method: http.MethodDelete, path: "/companies/" + a.companyA.Hex() + "?cascade=true", token: a.rootToken,
+			status: http.StatusOK},
+	})
+
+	ctx := context.Background()
+	if users, _ := a.repos.Users.Search(ctx, domain.GlobalScope(), domain.UserFilter{CompaniaID: a.companyA}); len(users) != 0 {
+		t.Errorf("quedan %d usuarios de la compañía eliminada", len(users))
+	}
+	count := domain.ListQuery{Limit: 1}
+	if buses, _ := a.repos.Buses.List(ctx, domain.CompanyScope(a.companyA), count); *buses.Total != 0 {
+		t.Errorf("quedan %d buses de la compañía eliminada", *buses.Total)
+	}
+	if routes, _ := a.repos.Routes.List(ctx, domain.CompanyScope(a.companyA), count); *routes.Total != 0 {
+		t.Errorf("quedan %d rutas de la compañía eliminada", *routes.Total)
+	}
+	if locs, _ := a.repos.BusLocations.List(ctx, domain.CompanyScope(a.companyA), count); *locs.Total != 0 {
+		t.Errorf("quedan %d localizaciones de la compañía eliminada", *locs.Total)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	a := newTestAPI(t)
+	for _, nombre := range []string{"Ruta C", "Ruta A", "Ruta E", "Ruta B", "Ruta D"} {
+		req := newRouteReq(nombre)
+		if nombre == "Ruta E" {
+			req["modo_transporte"] = "tren"
+		}
+		a.create("/routes", a.opToken, req, "route_id")
+	}
+	a.create("/routes", a.rootToken, map[string]any{
+		"nombre": "Ruta B1", "modo_transporte": "bus", "compania_id": a.companyB.Hex(),
+		"origen_lat": 1.0, "origen_lng": 1.0, "destino_lat": 2.0, "destino_lng": 2.0,
+	}, "route_id")
+
+	// Recorre todas las páginas siguiendo "next" y retorna los nombres.
+	walk := func(t *testing.T, query string) []string {
+		t.Helper()
+		var nombres []string
+		path := "/routes?" + query
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatal("la paginación no termina")
+			}
+			w := a.do(http.MethodGet, path, a.opToken, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s: status %d: %s", path, w.Code, w.Body)
+			}
+			page := decode[delivery.PageResp[map[string]any]](t, w)
+			// El total solo se cuenta en la primera página.
+			if first := pages == 0; first != (page.Total != nil) || first && *page.Total != 5 {
+				t.Fatalf("página %d: total = %v, se esperaba 5 solo en la primera", pages, page.Total)
+			}
+			for _, r := range page.Items {
+				nombres = append(nombres, r["Nombre"].(string))
+			}
+			if page.Next == "" {
+				return nombres
+			}
+			path = "/routes?" + query + "&after=" + page.Next
+		}
+	}
+
+	if got := strings.Join(walk(t, "limit=2"), ","); got != "Ruta C,Ruta A,Ruta E,Ruta B,Ruta D" {
+		t.Errorf("orden por defecto: %s", got)
+	}
+	if got := strings.Join(walk(t, "limit=2&sort=nombre"), ","); got != "Ruta A,Ruta B,Ruta C,Ruta D,Ruta E" {
+		t.Errorf("orden por nombre: %s", got)
+	}
+	if got := strings.Join(walk(t, "limit=3&sort=-nombre"), ","); got != "Ruta E,Ruta D,Ruta C,Ruta B,Ruta A" {
+		t.Errorf("orden descendente por nombre: %s", got)
+	}
+
+	first := decode[delivery.PageResp[map[string]any]](t, a.do(http.MethodGet, "/routes?limit=2&sort=nombre", a.opToken, nil))
+	// Un cursor fabricado cuyo valor es un operador de MongoDB.
+	_, ne, err := bson.MarshalValue(bson.M{"$ne": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := domain.Cursor{Sort: "nombre", Value: bson.RawValue{Type: bson.TypeEmbeddedDocument, Value: ne}, ID: primitive.NewObjectID()}
+
+	a.run([]apiCase{
+		{name: "filtrar por campo", method: http.MethodGet, path: "/routes?modo_transporte=tren", token: a.opToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "filtrar por otra compañía", method: http.MethodGet, path: "/routes?compania=" + a.companyB.Hex(), token: a.opToken,
+			status: http.StatusOK, check: length(0)},
+		{name: "super-admin filtra por compañía", method: http.MethodGet, path: "/routes?compania=" + a.companyB.Hex(), token: a.rootToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "limit fuera de rango", method: http.MethodGet, path: "/routes?limit=0", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_limit")},
+		{name: "limit no numérico", method: http.MethodGet, path: "/routes?limit=diez", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_limit")},
+		{name: "orden por campo no permitido", method: http.MethodGet, path: "/routes?sort=waypoints", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_sort")},
+		{name: "cursor inválido", method: http.MethodGet, path: "/routes?after=xyz", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_cursor")},
+		{name: "cursor de otro orden", method: http.MethodGet, path: "/routes?sort=-nombre&after=" + first.Next, token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_cursor")},
+		{name: "cursor con un valor de otro tipo", method: http.MethodGet, path: "/routes?sort=nombre&after=" + forged.Encode(), token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_cursor")},
+		{name: "filtro desconocido", method: http.MethodGet, path: "/routes?color=rojo", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("unknown_filter")},
+		{name: "filtro con ID inválido", method: http.MethodGet, path: "/routes?compania=xyz", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_filter")},
+		{name: "rango sobre campo que no es fecha", method: http.MethodGet, path: "/routes?nombre%5Bgte%5D=Ruta", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_filter")},
+		{name: "usuarios paginados", method: http.MethodGet, path: "/users?limit=1&sort=-nombre", token: a.opToken,
+			status: http.StatusOK, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				noPassword(t, w)
+				page := decode[delivery.PageResp[map[string]any]](t, w)
+				if len(page.Items) != 1 || page.Items[0]["Nombre"] != "root" || page.Total == nil || *page.Total != 2 || page.Next == "" {
+					t.Errorf("página inesperada: %s", w.Body)
+				}
+			}},
+	})
+}
+
+// historyPoints valida el total y el número de puntos de un historial.
+func historyPoints(total int64, points int) func(*testing.T, *httptest.ResponseRecorder) {
+	return func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		h := decode[delivery.LocationHistoryResp](t, w)
+		if h.Total != total || len(h.Points) != points {
+			t.Errorf("total %d con %d puntos, se esperaba total %d con %d puntos: %s", h.Total, len(h.Points), total, points, w.Body)
+		}
+	}
+}
+
+func TestBusLocationHistory(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	otro := a.create("/buses", a.opToken, newBusReq("ABC124", a.opID.Hex(), ruta), "bus_id")
+
+	var ids []string
+	var mid time.Time
+	for i := 0; i < 20; i++ {
+		if i == 10 {
+			// MongoDB guarda las fechas con precisión de milisegundos.
+			time.Sleep(2 * time.Millisecond)
+			mid = time.Now()
+			time.Sleep(2 * time.Millisecond)
+		}
+		ids = append(ids, a.create("/buslocations", a.opToken, map[string]any{"bus_id": bus, "lat": 4.6 + float64(i)/100, "lng": -74.1}, "id"))
+	}
+	a.create("/buslocations", a.opToken, map[string]any{"bus_id": otro, "lat": 4.6, "lng": -74.1}, "id")
+	since := url.QueryEscape(mid.UTC().Format(time.RFC3339Nano))
+
+	a.run([]apiCase{
+		{name: "recorrido completo en orden", method: http.MethodGet, path: "/buslocations/" + bus, token: a.opToken,
+			status: http.StatusOK, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				h := decode[delivery.LocationHistoryResp](t, w)
+				if h.Total != 20 || len(h.Points) != 20 {
+					t.Fatalf("total %d con %d puntos: %s", h.Total, len(h.Points), w.Body)
+				}
+				for i, p := range h.Points {
+					if p.ID.Hex() != ids[i] {
+						t.Fatalf("punto %d fuera de orden", i)
+					}
+				}
+			}},
+		{name: "reducido a max_points", method: http.MethodGet, path: "/buslocations/" + bus + "?max_points=5", token: a.opToken,
+			status: http.StatusOK, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				h := decode[delivery.LocationHistoryResp](t, w)
+				if h.Total != 20 || len(h.Points) != 5 {
+					t.Fatalf("total %d con %d puntos: %s", h.Total, len(h.Points), w.Body)
+				}
+				if h.Points[0].ID.Hex() != ids[0] || h.Points[4].ID.Hex() != ids[19] {
+					t.Errorf("el recorrido reducido debe conservar el primer y el último punto")
+				}
+			}},
+		{name: "desde una fecha", method: http.MethodGet, path: "/buslocations/" + bus + "?from=" + since, token: a.opToken,
+			status: http.StatusOK, check: historyPoints(10, 10)},
+		{name: "hasta una fecha", method: http.MethodGet, path: "/buslocations/" + bus + "?to=" + since, token: a.opToken,
+			status: http.StatusOK, check: historyPoints(10, 10)},
+		{name: "intervalo invertido", method: http.MethodGet, path: "/buslocations/" + bus + "?from=" + since + "&to=2000-01-01T00:00:00Z", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_time_range")},
+		{name: "fecha inválida", method: http.MethodGet, path: "/buslocations/" + bus + "?from=ayer", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_query")},
+		{name: "max_points fuera de rango", method: http.MethodGet, path: "/buslocations/" + bus + "?max_points=1", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_max_points")},
+		{name: "bus inexistente", method: http.MethodGet, path: "/buslocations/" + primitive.NewObjectID().Hex(), token: a.opToken,
+			status: http.StatusNotFound, check: errorCode("bus_not_found")},
+		{name: "bus de otra compañía", method: http.MethodGet, path: "/buslocations/" + bus, token: a.loginCompanyB(),
+			status: http.StatusNotFound, check: errorCode("bus_not_found")},
+	})
+}
+
+func TestLocationHistoryRollups(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	busID, _ := primitive.ObjectIDFromHex(bus)
+
+	// Posiciones anteriores a la retención de las originales (30 días por
+	// defecto): 30 en un minuto y 4 en el siguiente.
+	ctx := context.Background()
+	now := time.Now()
+	old := now.Add(-40 * 24 * time.Hour).Truncate(time.Minute)
+	var bls []domain.BusLocation
+	for i := 0; i < 34; i++ {
+		at := old.Add(time.Duration(10+i) * time.Second)
+		if i >= 30 {
+			at = old.Add(time.Minute + time.Duration(i)*time.Second)
+		}
+		bls = append(bls, domain.BusLocation{BusID: busID, CompaniaID: a.companyA, CreatedAt: at,
+			Localizacion: domain.Location{Lat: 4.6 + float64(i)/1000, Lng: -74.1}})
+	}
+	if err := a.repos.BusLocations.CreateMany(ctx, bls); err != nil {
+		t.Fatal(err)
+	}
+	a.create("/buslocations", a.opToken, map[string]any{"bus_id": bus, "lat": 4.7, "lng": -74.1}, "id")
+
+	for _, want := range []int{2, 0} {
+		n, err := a.svc.Rollups.RollupOnce(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Fatalf("resúmenes guardados: %d, se esperaban %d", n, want)
+		}
+	}
+
+	at := func(t time.Time) string { return url.QueryEscape(t.UTC().Format(time.RFC3339Nano)) }
+	path := "/buslocations/" + bus + "?from=" + at(old.Add(-24*time.Hour))
+	a.run([]apiCase{
+		{name: "historial antiguo desde los resúmenes", method: http.MethodGet, path: path + "&to=" + at(old.Add(24*time.Hour)), token: a.opToken,
+			status: http.StatusOK, check: historyPoints(34, 10)},
+		{name: "historial que combina resúmenes y posiciones recientes", method: http.MethodGet, path: path, token: a.opToken,
+			status: http.StatusOK, check: historyPoints(35, 11)},
+		{name: "resúmenes de otra compañía", method: http.MethodGet, path: path, token: a.loginCompanyB(),
+			status: http.StatusNotFound, check: errorCode("bus_not_found")},
+	})
+}
+
+func TestBusLocationsInArea(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus1 := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	bus2 := a.create("/buses", a.opToken, newBusReq("ABC124", a.opID.Hex(), ruta), "bus_id")
+
+	from := time.Now().Add(-time.Minute)
+	for _, p := range []struct {
+		bus      string
+		lat, lng float64
+	}{
+		{bus1, 4.60, -74.10}, {bus2, 4.61, -74.09}, {bus1, 4.70, -74.10}, {bus2, 5.00, -75.00}, {bus1, 4.62, -74.08},
+	} {
+		a.create("/buslocations", a.opToken, map[string]any{"bus_id": p.bus, "lat": p.lat, "lng": p.lng}, "id")
+	}
+	to := time.Now().Add(time.Minute)
+
+	window := "&from=" + url.QueryEscape(from.UTC().Format(time.RFC3339)) + "&to=" + url.QueryEscape(to.UTC().Format(time.RFC3339))
+	area := "/buslocations/area?min_lat=4.5&min_lng=-74.2&max_lat=4.65&max_lng=-74.0" + window
+
+	a.run([]apiCase{
+		{name: "posiciones dentro del área", method: http.MethodGet, path: area, token: a.opToken,
+			status: http.StatusOK, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				page := decode[delivery.PageResp[domain.BusLocation]](t, w)
+				if page.Total == nil || *page.Total != 3 || len(page.Items) != 3 {
+					t.Fatalf("%d elementos: %s", len(page.Items), w.Body)
+				}
+				for i := 1; i < len(page.Items); i++ {
+					if page.Items[i].CreatedAt.Before(page.Items[i-1].CreatedAt) {
+						t.Errorf("las posiciones no están en orden cronológico")
+					}
+				}
+			}},
+		{name: "paginado", method: http.MethodGet, path: area + "&limit=2", token: a.opToken,
+			status: http.StatusOK, check: length(2)},
+		{name: "otra compañía no ve las posiciones", method: http.MethodGet, path: area, token: a.loginCompanyB(),
+			status: http.StatusOK, check: length(0)},
+		{name: "fuera del intervalo", method: http.MethodGet, path: "/buslocations/area?min_lat=4.5&min_lng=-74.2&max_lat=4.65&max_lng=-74.0&from=2020-01-01T00:00:00Z&to=2020-01-01T12:00:00Z", token: a.opToken,
+			status: http.StatusOK, check: length(0)},
+		{name: "intervalo demasiado largo", method: http.MethodGet, path: "/buslocations/area?min_lat=4.5&min_lng=-74.2&max_lat=4.65&max_lng=-74.0&from=2020-01-01T00:00:00Z&to=2020-01-03T00:00:00Z", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_time_range")},
+		{name: "sin intervalo", method: http.MethodGet, path: "/buslocations/area?min_lat=4.5&min_lng=-74.2&max_lat=4.65&max_lng=-74.0", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_time_range")},
+		{name: "área invertida", method: http.MethodGet, path: "/buslocations/area?min_lat=4.65&min_lng=-74.2&max_lat=4.5&max_lng=-74.0" + window, token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_bounding_box")},
+		{name: "área incompleta", method: http.MethodGet, path: "/buslocations/area?min_lat=4.5&max_lat=4.65" + window, token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_query")},
+	})
+}
+
+func TestBusLocationsInLargeArea(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+
+	// Los lados norte y sur del área son geodésicas: a 120° de anchura el lado
+	// sur (40°) pasa por los 59° de latitud cerca del meridiano central y el
+	// norte (60°) por los 74°.
+	from := time.Now().Add(-time.Minute)
+	for _, p := range []struct{ lat, lng float64 }{
+		{45, 1},  // dentro del rectángulo, fuera del polígono
+		{65, 1},  // fuera del rectángulo, dentro del polígono
+		{59, 59}, // cerca de una esquina: dentro de ambos
+		{50, 61}, // fuera de ambos
+	} {
+		a.create("/buslocations", a.opToken, map[string]any{"bus_id": bus, "lat": p.lat, "lng": p.lng}, "id")
+	}
+	to := time.Now().Add(time.Minute)
+	window := "&from=" + url.QueryEscape(from.UTC().Format(time.RFC3339)) + "&to=" + url.QueryEscape(to.UTC().Format(time.RFC3339))
+
+	a.run([]apiCase{
+		{name: "lados geodésicos", method: http.MethodGet, path: "/buslocations/area?min_lat=40&min_lng=-60&max_lat=60&max_lng=60" + window, token: a.opToken,
+			status: http.StatusOK, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				var got []string
+				for _, l := range decode[delivery.PageResp[domain.BusLocation]](t, w).Items {
+					got = append(got, fmt.Sprintf("%g,%g", l.Localizacion.Lat, l.Localizacion.Lng))
+				}
+				if strings.Join(got, " ") != "65,1 59,59" {
+					t.Errorf("posiciones en el área: %v", got)
+				}
+			}},
+		{name: "área de 180° de longitud", method: http.MethodGet, path: "/buslocations/area?min_lat=40&min_lng=-90&max_lat=60&max_lng=90" + window, token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_bounding_box")},
+		{name: "área sin anchura", method: http.MethodGet, path: "/buslocations/area?min_lat=40&min_lng=10&max_lat=60&max_lng=10" + window, token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_bounding_box")},
+	})
+}
+
+func TestNearbyBuses(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus1 := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	bus2 := a.create("/buses", a.opToken, newBusReq("ABC124", a.opID.Hex(), ruta), "bus_id")
+	bus3 := a.create("/buses", a.opToken, newBusReq("ABC125", a.opID.Hex(), ruta), "bus_id")
+
+	// bus2 pasó cerca del punto, pero su última posición está lejos.
+	for _, p := range []struct {
+		bus      string
+		lat, lng float64
+	}{
+		{bus1, 4.70, -74.10}, {bus2, 4.6201, -74.08}, {bus1, 4.625, -74.08}, {bus3, 4.6205, -74.08}, {bus2, 5.00, -75.00},
+	} {
+		a.create("/buslocations", a.opToken, map[string]any{"bus_id": p.bus, "lat": p.lat, "lng": p.lng}, "id")
+	}
+	// bus4 está junto al punto, pero no reporta desde hace más de
+	// heartbeat.offline_after.
+	bus4 := a.create("/buses", a.opToken, newBusReq("ABC126", a.opID.Hex(), ruta), "bus_id")
+	bus4ID, _ := primitive.ObjectIDFromHex(bus4)
+	a.svc.Fleet.Update(domain.BusLocation{
+		BusID: bus4ID, CompaniaID: a.companyA, Localizacion: domain.Location{Lat: 4.62, Lng: -74.08},
+		CreatedAt: time.Now().Add(-config.Default().Heartbeat.OfflineAfter - time.Minute),
+	})
+
+	a.run([]apiCase{
+		{name: "buses cercanos ordenados por distancia", method: http.MethodGet, path: "/buses/nearby?lat=4.62&lng=-74.08", token: a.opToken,
+			status: http.StatusOK, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				nearby := decode[[]delivery.NearbyBusResp](t, w)
+				if len(nearby) != 2 || nearby[0].Bus.ID.Hex() != bus3 || nearby[1].Bus.ID.Hex() != bus1 {
+					t.Fatalf("buses cercanos inesperados: %s", w.Body)
+				}
+				if d := nearby[1].Distance; d < 500 || d > 600 {
+					t.Errorf("distancia de %s = %.0f m, se esperaban ~556 m", bus1, d)
+				}
+			}},
+		{name: "radio pequeño", method: http.MethodGet, path: "/buses/nearby?lat=4.62&lng=-74.08&radius=100", token: a.opToken,
+			status: http.StatusOK, check: length(1)},
+		{name: "otra compañía no ve los buses", method: http.MethodGet, path: "/buses/nearby?lat=4.62&lng=-74.08", token: a.loginCompanyB(),
+			status: http.StatusOK, check: length(0)},
+		{name: "sin coordenadas", method: http.MethodGet, path: "/buses/nearby?lat=4.62", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_query")},
+		{name: "latitud fuera de rango", method: http.MethodGet, path: "/buses/nearby?lat=95&lng=-74.08", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_coordinates")},
+		{name: "radio demasiado grande", method: http.MethodGet, path: "/buses/nearby?lat=4.62&lng=-74.08&radius=100000", token: a.opToken,
+			status: http.StatusBadRequest, check: errorCode("invalid_radius")},
+		{name: "localización fuera de rango", method: http.MethodPost, path: "/buslocations", token: a.opToken,
+			body: map[string]any{"bus_id": bus1, "lat": 4.6, "lng": -200}, status: http.StatusBadRequest, check: errorCode("invalid_coordinates")},
+	})
+}
+
+func TestLiveFleet(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus1 := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	bus2 := a.create("/buses", a.opToken, newBusReq("ABC124", a.opID.Hex(), ruta), "bus_id")
+
+	a.create("/buslocations", a.opToken, map[string]any{"bus_id": bus1, "lat": 4.60, "lng": -74.10}, "id")
+	a.create("/buslocations", a.opToken, map[string]any{"bus_id": bus1, "lat": 4.61, "lng": -74.10, "speed": 30, "heading": 90}, "id")
+	a.create("/buslocations", a.opToken, map[string]any{"bus_id": bus2, "lat": 4.70, "lng": -74.05}, "id")
+
+	fleet := func(want int) func(*testing.T, *httptest.ResponseRecorder) {
+		return func(t *testing.T, w *httptest.ResponseRecorder) {
+			t.Helper()
+			resp := decode[delivery.LiveFleetResp](t, w)
+			if len(resp.Buses) != want {
+				t.Fatalf("%d buses en la flota, se esperaban %d: %s", len(resp.Buses), want, w.Body)
+			}
+			for _, p := range resp.Buses {
+				if p.BusID.Hex() == bus1 && (p.Lat != 4.61 || p.Speed != 30 || p.Heading != 90) {
+					t.Errorf("posición de %s inesperada: %+v", bus1, p)
+				}
+				if p.Age < 0 {
+					t.Errorf("edad negativa: %+v", p)
+				}
+			}
+		}
+	}
+
+	a.run([]apiCase{
+		{name: "última posición de cada bus", method: http.MethodGet, path: "/fleet/live", token: a.opToken,
+			status: http.StatusOK, check: fleet(2)},
+		{name: "otra compañía no ve la flota", method: http.MethodGet, path: "/fleet/live", token: a.loginCompanyB(),
+			status: http.StatusOK, check: fleet(0)},
+		{name: "rumbo fuera de rango", method: http.MethodPost, path: "/buslocations", token: a.opToken,
+			body: map[string]any{"bus_id": bus1, "lat": 4.6, "lng": -74.1, "heading": 360}, status: http.StatusBadRequest, check: errorCode("validation")},
+		{name: "eliminar un bus lo retira de la flota", method: http.MethodDelete, path: "/buses/" + bus2, token: a.opToken,
+			status: http.StatusOK},
+		{name: "flota sin el bus eliminado", method: http.MethodGet, path: "/fleet/live", token: a.opToken,
+			status: http.StatusOK, check: fleet(1)},
+	})
+
+	// Al arrancar, la caché se reconstruye con lo guardado en el repositorio,
+	// sin los buses eliminados.
+	hasher, err := domain.NewPasswordHasher(domain.PasswordAlgoBcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := delivery.NewServices(a.repos, hasher, []byte(strings.Repeat("k", 32)), time.Minute, time.Hour, config.Default().Retention, config.Default().Heartbeat, config.Default().Commands)
+	if err := svc.Fleet.Rebuild(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	a.router = delivery.NewRouter(config.Default(), svc, nil, nil)
+	a.run([]apiCase{
+		{name: "flota reconstruida", method: http.MethodGet, path: "/fleet/live", token: a.opToken,
+			status: http.StatusOK, check: fleet(1)},
+	})
+}
+
+func TestLocationIngestor(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+
+	ctx := context.Background()
+	fleet := application.NewFleetService(a.repos.BusLocations, a.repos.Buses)
+	ing := application.NewLocationIngestor(a.repos.BusLocations, a.repos.Buses, fleet, application.IngestOptions{
+		QueueSize: 3, BatchSize: 2, FlushInterval: time.Hour, BusCacheTTL: time.Minute, DBTimeout: time.Second,
+	})
+	stored := func() int64 {
+		t.Helper()
+		page, err := a.repos.BusLocations.List(ctx, domain.GlobalScope(), domain.ListQuery{Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *page.Total
+	}
+
+	// Sin Run las posiciones se quedan en la cola: se guardan en lotes.
+	for n := 0; n < 3; n++ {
+		if _, err := ing.Ingest(domain.GlobalScope(), bus, 4.6, -74.1, nil, nil); err != nil {
+			t.Fatalf("posición %d: %v", n, err)
+		}
+	}
+	if _, err := ing.Ingest(domain.GlobalScope(), bus, 4.6, -74.1, nil, nil); !errors.Is(err, application.ErrIngestQueueFull) {
+		t.Errorf("con la cola llena se esperaba ErrIngestQueueFull, se obtuvo %v", err)
+	}
+	if _, err := ing.Ingest(domain.GlobalScope(), primitive.NewObjectID().Hex(), 4.6, -74.1, nil, nil); !errors.Is(err, application.ErrUnknownBus) {
+		t.Errorf("con un bus inexistente se esperaba ErrUnknownBus, se obtuvo %v", err)
+	}
+	if n := stored(); n != 0 {
+		t.Fatalf("%d posiciones guardadas antes de escribir el lote", n)
+	}
+	if live := fleet.LiveFleet(domain.GlobalScope()); len(live) != 1 {
+		t.Errorf("la flota en vivo tiene %d buses, se esperaba 1", len(live))
+	}
+
+	// Close vacía la cola antes de retornar.
+	if err := ing.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := stored(); n != 3 {
+		t.Errorf("%d posiciones guardadas al cerrar, se esperaban 3", n)
+	}
+	s := ing.Stats()
+	if s.Accepted != 3 || s.Dropped != 1 || s.Rejected != 1 || s.Persisted != 3 || s.Batches != 2 {
+		t.Errorf("métricas inesperadas: %+v", s)
+	}
+	if _, err := ing.Ingest(domain.GlobalScope(), bus, 4.6, -74.1, nil, nil); !errors.Is(err, application.ErrIngestClosed) {
+		t.Errorf("después de Close se esperaba ErrIngestClosed, se obtuvo %v", err)
+	}
+}
+
+// newTestMQTT crea un broker MQTT, sin abrir el listener, cuya ingesta usa
+// los repositorios y las credenciales de la API de prueba.
+func (a *testAPI) newTestMQTT(cfg config.MQTTConfig) (*mqtt.Server, *application.LocationIngestor, *application.FleetService) {
+	a.t.Helper()
+	fleet := application.NewFleetService(a.repos.BusLocations, a.repos.Buses)
+	ing := application.NewLocationIngestor(a.repos.BusLocations, a.repos.Buses, fleet, application.IngestOptions{
+		QueueSize: 10, BatchSize: 10, FlushInterval: time.Hour, BusCacheTTL: time.Minute, DBTimeout: time.Second,
+	})
+	cfg.Addr = "127.0.0.1:0"
+	srv, err := delivery.NewMQTTServer(cfg, ing, a.svc.Devices, a.svc.Connectivity, a.svc.Commands, a.hub)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return srv, ing, fleet
+}
+
+// registerDevice registra un dispositivo en el bus y retorna su ID, su
+// client_id y su secreto.
+func (a *testAPI) registerDevice(serial, bus string) (id, clientID, secret string) {
+	a.t.Helper()
+	w := a.do(http.MethodPost, "/devices", a.opToken, map[string]any{"serial": serial, "bus_id": bus})
+	if w.Code != http.StatusCreated {
+		a.t.Fatalf("registrando dispositivo: status %d: %s", w.Code, w.Body)
+	}
+	resp := decode[map[string]any](a.t, w)
+	id, _ = resp["id"].(string)
+	clientID, _ = resp["client_id"].(string)
+	secret, _ = resp["secret"].(string)
+	if id == "" || clientID == "" || secret == "" {
+		a.t.Fatalf("dispositivo sin credenciales: %s", w.Body)
+	}
+	return id, clientID, secret
+}
+
+// mqttConnect conecta un dispositivo MQTT 3.1.1 al broker por un net.Pipe y
+// retorna el código del CONNACK y, si se aceptó, el cliente en el broker.
+// Las respuestas posteriores del broker se descartan.
+func mqttConnect(t *testing.T, srv *mqtt.Server, clientID, password string) (byte, *mqtt.Client) {
+	t.Helper()
+	brokerSide, conn := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+	go srv.EstablishConnection("test", brokerSide)
+
+	pk := packets.Packet{
+		FixedHeader:     packets.FixedHeader{Type: packets.Connect},
+		ProtocolVersion: 4,
+		Connect: packets.ConnectParams{
+			ProtocolName:     []byte("MQTT"),
+			Clean:            true,
+			Keepalive:        60,
+			ClientIdentifier: clientID,
+			UsernameFlag:     true,
+			Username:         []byte(clientID),
+			PasswordFlag:     true,
+			Password:         []byte(password),
+		},
+	}
+	var buf bytes.Buffer
+	if err := pk.ConnectEncode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connack); err != nil {
+		t.Fatal(err)
+	}
+	if connack[3] != packets.CodeSuccess.Code {
+		return connack[3], nil
+	}
+	go io.Copy(io.Discard, conn)
+	cl, ok := srv.Clients.Get(clientID)
+	if !ok {
+		t.Fatalf("cliente %s aceptado pero no registrado en el broker", clientID)
+	}
+	return connack[3], cl
+}
+
+// eventually reintenta cond hasta que se cumpla o pase un segundo, para
+// esperar lo que el broker procesa en otra goroutine.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no se cumplió a tiempo: %s", what)
+		}
+	}
+}
+
+func TestMQTTTopics(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+
+	srv, ing, fleet := a.newTestMQTT(config.Default().MQTT)
+	_, clientID, secret := a.registerDevice("GPS-001", bus)
+	code, cl := mqttConnect(t, srv, clientID, secret)
+	if code != packets.CodeSuccess.Code {
+		t.Fatalf("conexión rechazada: código %#x", code)
+	}
+	publish := func(topic, payload string) {
+		t.Helper()
+		pk := packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish}, TopicName: topic, Payload: []byte(payload)}
+		if err := srv.InjectPacket(cl, pk); err != nil {
+			t.Fatalf("%s: %v", topic, err)
+		}
+	}
+
+	topic := func(company primitive.ObjectID, kind string) string {
+		return "ubicabus/" + company.Hex() + "/" + bus + "/" + kind
+	}
+	publish(topic(a.companyA, delivery.TopicLocation), `{"lat": 4.6, "lng": -74.1}`)
+	publish(topic(a.companyA, delivery.TopicLocation), `{"bus_id": "`+bus+`", "lat": 4.6, "lng": -74.1, "speed": 30}`)
+	publish(topic(a.companyA, delivery.TopicStatus), `{"battery": 80}`)
+	publish(topic(a.companyA, delivery.TopicEvent), `{"name": "panic"}`)
+
+	// Rechazados: bus_id distinto al del tópico y payload sin coordenadas.
+	publish(topic(a.companyA, delivery.TopicLocation), `{"bus_id": "`+primitive.NewObjectID().Hex()+`", "lat": 4.6, "lng": -74.1}`)
+	publish(topic(a.companyA, delivery.TopicLocation), `{"speed": 30}`)
+
+	// Descartados por la ACL antes de llegar a la ingesta: otra compañía y
+	// tópicos fuera del esquema.
+	publish(topic(a.companyB, delivery.TopicLocation), `{"lat": 4.6, "lng": -74.1}`)
+	publish("gps/bus/location", `{"bus_id": "`+bus+`", "lat": 4.6, "lng": -74.1}`)
+	publish(topic(a.companyA, "telemetry"), `{"lat": 4.6, "lng": -74.1}`)
+
+	if s := ing.Stats(); s.Accepted != 2 || s.Rejected != 0 {
+		t.Errorf("métricas inesperadas: %+v", s)
+	}
+	if live := fleet.LiveFleet(domain.GlobalScope()); len(live) != 1 || live[0].BusID.Hex() != bus || live[0].Velocidad != 30 {
+		t.Errorf("flota en vivo inesperada: %+v", live)
+	}
+}
+
+func TestMQTTDeviceAuth(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	otherBus := a.create("/buses", a.opToken, newBusReq("XYZ789", a.opID.Hex(), ruta), "bus_id")
+
+	cfg := config.Default().MQTT
+	cfg.AuthMaxFailures = 2
+	srv, ing, _ := a.newTestMQTT(cfg)
+	device, clientID, secret := a.registerDevice("GPS-001", bus)
+
+	code, cl := mqttConnect(t, srv, clientID, secret)
+	if code != packets.CodeSuccess.Code {
+		t.Fatalf("conexión rechazada: código %#x", code)
+	}
+
+	// ACL: solo los tópicos del propio bus.
+	own := "ubicabus/" + a.companyA.Hex() + "/" + bus + "/"
+	foreign := "ubicabus/" + a.companyA.Hex() + "/" + otherBus + "/"
+	for _, topic := range []string{own + delivery.TopicLocation, foreign + delivery.TopicLocation} {
+		pk := packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish}, TopicName: topic, Payload: []byte(`{"lat": 4.6, "lng": -74.1}`)}
+		if err := srv.InjectPacket(cl, pk); err != nil {
+			t.Fatalf("%s: %v", topic, err)
+		}
+	}
+	if s := ing.Stats(); s.Accepted != 1 {
+		t.Errorf("se esperaba solo la posición del propio bus: %+v", s)
+	}
+	for _, sub := range []struct {
+		filter string
+		ok     bool
+	}{
+		{own + delivery.TopicCommands, true},
+		{foreign + delivery.TopicCommands, false},
+		{own + delivery.TopicLocation, false},
+		{"ubicabus/#", false},
+	} {
+		pk := packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Subscribe, Qos: 1},
+			PacketID:    1,
+			Filters:     packets.Subscriptions{{Filter: sub.filter}},
+		}
+		if err := srv.InjectPacket(cl, pk); err != nil {
+			t.Fatalf("%s: %v", sub.filter, err)
+		}
+		if _, ok := cl.State.Subscriptions.Get(sub.filter); ok != sub.ok {
+			t.Errorf("suscripción a %s: %t, se esperaba %t", sub.filter, ok, sub.ok)
+		}
+	}
+
+	// Rotar las credenciales desconecta al dispositivo e invalida el secreto
+	// anterior.
+	w := a.do(http.MethodPost, "/devices/"+device+"/credentials", a.opToken, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rotando credenciales: status %d: %s", w.Code, w.Body)
+	}
+	newSecret, _ := decode[map[string]any](t, w)["secret"].(string)
+	if !cl.Closed() {
+		t.Error("el dispositivo sigue conectado tras rotar sus credenciales")
+	}
+	if code, _ := mqttConnect(t, srv, clientID, secret); code == packets.CodeSuccess.Code {
+		t.Error("conexión aceptada con el secreto anterior")
+	}
+
+	// Con el intento anterior y un secreto incorrecto se alcanzan los
+	// AuthMaxFailures fallos: la dirección queda bloqueada, incluso con
+	// credenciales válidas.
+	if code, _ := mqttConnect(t, srv, clientID, "secreto-incorrecto"); code == packets.CodeSuccess.Code {
+		t.Error("conexión aceptada con un secreto incorrecto")
+	}
+	if code, _ := mqttConnect(t, srv, clientID, newSecret); code == packets.CodeSuccess.Code {
+		t.Error("conexión aceptada desde una dirección bloqueada")
+	}
+}
+
+func TestDevices(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	otherBus := a.create("/buses", a.opToken, newBusReq("XYZ789", a.opID.Hex(), ruta), "bus_id")
+	srv, _, fleet := a.newTestMQTT(config.Default().MQTT)
+
+	device, clientID, secret := a.registerDevice("GPS-001", bus)
+	tokenB := a.loginCompanyB()
+	noSecret := func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		if strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("la respuesta expone el secreto: %s", w.Body)
+		}
+	}
+	a.run([]apiCase{
+		{name: "serial repetido", method: http.MethodPost, path: "/devices", token: a.opToken,
+			body: map[string]any{"serial": "GPS-001", "bus_id": bus}, status: http.StatusConflict, check: errorCode("device_exists")},
+		{name: "IMEI inválido", method: http.MethodPost, path: "/devices", token: a.opToken,
+			body: map[string]any{"serial": "GPS-002", "imei": "123", "bus_id": bus}, status: http.StatusBadRequest, check: errorCode("validation")},
+		{name: "sin bus", method: http.MethodPost, path: "/devices", token: a.opToken,
+			body: map[string]any{"serial": "GPS-002"}, status: http.StatusBadRequest, check: errorCode("validation")},
+		{name: "bus de otra compañía", method: http.MethodPost, path: "/devices", token: tokenB,
+			body: map[string]any{"serial": "GPS-002", "bus_id": bus}, status: http.StatusBadRequest, check: errorCode("unknown_bus")},
+		{name: "listar por bus", method: http.MethodGet, path: "/devices?bus_id=" + bus, token: a.opToken, status: http.StatusOK, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+			noSecret(t, w)
+			if got := items(t, w); len(got) != 1 || got[0]["client_id"] != clientID || got[0]["estado"] != domain.DeviceActive {
+				t.Errorf("dispositivos inesperados: %s", w.Body)
+			}
+		}},
+		{name: "otra compañía no lo ve", method: http.MethodGet, path: "/devices/" + device, token: tokenB, status: http.StatusNotFound, check: errorCode("device_not_found")},
+		{name: "editar", method: http.MethodPut, path: "/devices/" + device, token: a.opToken,
+			body: map[string]any{"imei": "356938035643809", "firmware": "2.1.0"}, status: http.StatusOK, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				noSecret(t, w)
+				d := decode[map[string]any](t, w)
+				if d["serial"] != "GPS-001" || d["imei"] != "356938035643809" || d["firmware"] != "2.1.0" {
+					t.Errorf("dispositivo inesperado: %s", w.Body)
+				}
+			}},
+	})
+
+	// Al conectarse se registra last_seen.
+	code, cl := mqttConnect(t, srv, clientID, secret)
+	if code != packets.CodeSuccess.Code {
+		t.Fatalf("conexión rechazada: código %#x", code)
+	}
+	a.run([]apiCase{
+		{name: "last_seen", method: http.MethodGet, path: "/devices/" + device, token: a.opToken, status: http.StatusOK, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+			if decode[map[string]any](t, w)["last_seen"] == nil {
+				t.Errorf("dispositivo sin last_seen tras conectarse: %s", w.Body)
+			}
+		}},
+		{name: "reasignar", method: http.MethodPut, path: "/devices/" + device + "/bus", token: a.opToken,
+			body: map[string]any{"bus_id": otherBus}, status: http.StatusOK, check: field("bus_id", otherBus)},
+	})
+
+	// La reasignación cierra la sesión; al reconectarse, las posiciones se
+	// atribuyen al bus nuevo y los tópicos del anterior quedan denegados.
+	if !cl.Closed() {
+		t.Error("el dispositivo sigue conectado tras reasignarlo")
+	}
+	if code, cl = mqttConnect(t, srv, clientID, secret); code != packets.CodeSuccess.Code {
+		t.Fatalf("reconexión rechazada: código %#x", code)
+	}
+	for _, b := range []string{bus, otherBus} {
+		pk := packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Publish},
+			TopicName:   "ubicabus/" + a.companyA.Hex() + "/" + b + "/" + delivery.TopicLocation,
+			Payload:     []byte(`{"lat": 4.6, "lng": -74.1}`),
+		}
+		if err := srv.InjectPacket(cl, pk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if live := fleet.LiveFleet(domain.GlobalScope()); len(live) != 1 || live[0].BusID.Hex() != otherBus {
+		t.Errorf("flota en vivo inesperada: %+v", live)
+	}
+
+	// La baja desconecta al dispositivo y ya no permite modificarlo.
+	a.run([]apiCase{
+		{name: "dar de baja", method: http.MethodPost, path: "/devices/" + device + "/decommission", token: a.opToken, status: http.StatusOK, check: field("estado", domain.DeviceDecommissioned)},
+		{name: "editar dado de baja", method: http.MethodPut, path: "/devices/" + device, token: a.opToken,
+			body: map[string]any{"firmware": "2.2.0"}, status: http.StatusConflict, check: errorCode("device_decommissioned")},
+		{name: "rotar dado de baja", method: http.MethodPost, path: "/devices/" + device + "/credentials", token: a.opToken, status: http.StatusConflict, check: errorCode("device_decommissioned")},
+	})
+	if !cl.Closed() {
+		t.Error("el dispositivo sigue conectado tras darlo de baja")
+	}
+	if code, _ := mqttConnect(t, srv, clientID, secret); code == packets.CodeSuccess.Code {
+		t.Error("conexión aceptada de un dispositivo dado de baja")
+	}
+}
+
+func TestBusWithDevices(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	device, _, _ := a.registerDevice("GPS-001", bus)
+
+	w := a.do(http.MethodPost, "/buses/"+bus+"/commands", a.opToken, map[string]any{"type": domain.CommandReboot})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("enviando comando: status %d: %s", w.Code, w.Body)
+	}
+	cmdID, _ := primitive.ObjectIDFromHex(decode[map[string]any](t, w)["id"].(string))
+
+	rutaBReq := newRouteReq("Ruta B")
+	rutaBReq["compania_id"] = a.companyB.Hex()
+	rutaB := a.create("/routes", a.rootToken, rutaBReq, "route_id")
+	conductorB := a.create("/register", a.rootToken, map[string]string{
+		"nombre": "conductor-b", "password": "x", "rol_id": a.opRol.Hex(), "compania_id": a.companyB.Hex(),
+	}, "user_id")
+	moveReq := newBusReq("ABC123", conductorB, rutaB)
+	moveReq["compania_id"] = a.companyB.Hex()
+	a.run([]apiCase{
+		{name: "eliminar con dispositivos activos", method: http.MethodDelete, path: "/buses/" + bus, token: a.opToken,
+			status: http.StatusConflict, check: errorCode("bus_has_devices")},
+		{name: "mover con dispositivos activos", method: http.MethodPut, path: "/buses/" + bus, token: a.rootToken,
+			body: moveReq, status: http.StatusConflict, check: errorCode("bus_has_devices")},
+		{name: "el bus sigue en su compañía", method: http.MethodGet, path: "/buses/" + bus, token: a.opToken,
+			status: http.StatusOK, check: field("CompaniaID", a.companyA.Hex())},
+		{name: "dar de baja el dispositivo", method: http.MethodPost, path: "/devices/" + device + "/decommission", token: a.opToken,
+			status: http.StatusOK},
+		{name: "mover sin dispositivos activos", method: http.MethodPut, path: "/buses/" + bus, token: a.rootToken,
+			body: moveReq, status: http.StatusOK, check: field("CompaniaID", a.companyB.Hex())},
+		{name: "eliminar sin dispositivos activos", method: http.MethodDelete, path: "/buses/" + bus, token: a.rootToken,
+			status: http.StatusOK},
+	})
+
+	cmd, err := a.svc.Commands.Commands.GetByID(context.Background(), domain.GlobalScope(), cmdID)
+	if err != nil {
+		t.Fatalf("consultando comando: %v", err)
+	}
+	if cmd.Estado != domain.CommandExpired {
+		t.Errorf("comando de un bus eliminado en estado %q", cmd.Estado)
+	}
+}
+
+func TestBusConnectivity(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	srv, _, _ := a.newTestMQTT(config.Default().MQTT)
+	hb := config.Default().Heartbeat
+	_, clientID, secret := a.registerDevice("GPS-001", bus)
+
+	status := func() map[string]any {
+		t.Helper()
+		w := a.do(http.MethodGet, "/buses/"+bus+"/status", a.opToken, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("consultando el estado: status %d: %s", w.Code, w.Body)
+		}
+		return decode[map[string]any](t, w)
+	}
+	// events retorna los estados de los cambios guardados, del más reciente
+	// al más antiguo.
+	events := func() string {
+		var estados []string
+		for _, e := range status()["events"].([]any) {
+			estados = append(estados, e.(map[string]any)["estado"].(string))
+		}
+		return strings.Join(estados, ",")
+	}
+
+	tokenB := a.loginCompanyB()
+	a.run([]apiCase{
+		{name: "sin conectar", method: http.MethodGet, path: "/buses/" + bus + "/status", token: a.opToken, status: http.StatusOK, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+			s := decode[map[string]any](t, w)
+			if s["estado"] != domain.ConnectivityOffline || s["devices"] != float64(0) || s["last_seen"] != nil || len(s["events"].([]any)) != 0 {
+				t.Errorf("estado inesperado: %s", w.Body)
+			}
+		}},
+		{name: "bus de otra compañía", method: http.MethodGet, path: "/buses/" + bus + "/status", token: tokenB, status: http.StatusNotFound, check: errorCode("bus_not_found")},
+	})
+
+	// Al conectarse el dispositivo el bus pasa a online, y el cambio solo se
+	// envía a los clientes WebSocket de su compañía.
+	wsA, wsB := a.wsConnect(a.opToken), a.wsConnect(tokenB)
+	code, cl := mqttConnect(t, srv, clientID, secret)
+	if code != packets.CodeSuccess.Code {
+		t.Fatalf("conexión rechazada: código %#x", code)
+	}
+	if msg := wsRead(t, wsA); msg["type"] != delivery.TypeConnectivity || msg["estado"] != domain.ConnectivityOnline {
+		t.Errorf("el cliente de la compañía A recibió %v", msg)
+	}
+	a.hub.Publish(a.companyB, []byte(`{"type": "test"}`))
+	if msg := wsRead(t, wsB); msg["type"] != "test" {
+		t.Errorf("el cliente de la compañía B recibió %v", msg)
+	}
+	if s := status(); s["estado"] != domain.ConnectivityOnline || s["devices"] != float64(1) || s["last_seen"] == nil {
+		t.Errorf("estado tras conectar: %v", s)
+	}
+	eventually(t, "evento online guardado", func() bool { return events() == "online" })
+
+	// Conectado pero sin reportar: stale y después offline.
+	now := time.Now()
+	if n := a.svc.Connectivity.Sweep(now.Add(hb.StaleAfter)); n != 1 {
+		t.Errorf("Sweep tras StaleAfter: %d cambios", n)
+	}
+	if s := status(); s["estado"] != domain.ConnectivityStale {
+		t.Errorf("estado tras StaleAfter: %v", s["estado"])
+	}
+	if n := a.svc.Connectivity.Sweep(now.Add(hb.OfflineAfter)); n != 1 {
+		t.Errorf("Sweep tras OfflineAfter: %d cambios", n)
+	}
+
+	// Una posición nueva lo vuelve a poner online.
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish},
+		TopicName:   "ubicabus/" + a.companyA.Hex() + "/" + bus + "/" + delivery.TopicLocation,
+		Payload:     []byte(`{"lat": 4.6, "lng": -74.1}`),
+	}
+	if err := srv.InjectPacket(cl, pk); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(); s["estado"] != domain.ConnectivityOnline {
+		t.Errorf("estado tras publicar: %v", s["estado"])
+	}
+
+	// Al desconectarse pasa a offline de inmediato.
+	srv.DisconnectClient(cl, packets.ErrAdministrativeAction)
+	eventually(t, "offline tras desconectar", func() bool { return status()["estado"] == domain.ConnectivityOffline })
+	if s := status(); s["devices"] != float64(0) {
+		t.Errorf("dispositivos tras desconectar: %v", s["devices"])
+	}
+	// Los eventos se ordenan por fecha, y los de Sweep llevan las fechas
+	// simuladas, posteriores a las demás.
+	eventually(t, "eventos guardados", func() bool { return events() == "offline,stale,offline,online,online" })
+}
+
+func TestBusCommands(t *testing.T) {
+	a := newTestAPI(t)
+	ruta := a.create("/routes", a.opToken, newRouteReq("Ruta 1"), "route_id")
+	bus := a.create("/buses", a.opToken, newBusReq("ABC123", a.opID.Hex(), ruta), "bus_id")
+	srv, _, _ := a.newTestMQTT(config.Default().MQTT)
+	// Las confirmaciones se guardan en segundo plano.
+	go a.svc.Commands.Run()
+	t.Cleanup(func() { a.svc.Commands.Stop(context.Background()) })
+	_, clientID, secret := a.registerDevice("GPS-001", bus)
+	commands := "/buses/" + bus + "/commands"
+	topic := func(kind string) string { return "ubicabus/" + a.companyA.Hex() + "/" + bus + "/" + kind }
+
+	tokenB := a.loginCompanyB()
+	a.run([]apiCase{
+		{name: "tipo desconocido", method: http.MethodPost, path: commands, token: a.opToken,
+			body: map[string]any{"type": "self_destruct"}, status: http.StatusBadRequest, check: errorCode("unknown_command_type")},
+		{name: "intervalo fuera de rango", method: http.MethodPost, path: commands, token: a.opToken,
+			body: map[string]any{"type": domain.CommandSetInterval, "params": map[string]any{"seconds": 0}}, status: http.StatusBadRequest, check: errorCode("invalid_command_params")},
+		{name: "intervalo no entero", method: http.MethodPost, path: commands, token: a.opToken,
+			body: map[string]any{"type": domain.CommandSetInterval, "params": map[string]any{"seconds": 2.5}}, status: http.StatusBadRequest, check: errorCode("invalid_command_params")},
+		{name: "mensaje vacío", method: http.MethodPost, path: commands, token: a.opToken,
+			body: map[string]any{"type": domain.CommandDisplayMessage, "params": map[string]any{"text": " "}}, status: http.StatusBadRequest, check: errorCode("invalid_command_params")},
+		{name: "reboot con parámetros", method: http.MethodPost, path: commands, token: a.opToken,
+			body: map[string]any{"type": domain.CommandReboot, "params": map[string]any{"now": true}}, status: http.StatusBadRequest, check: errorCode("invalid_command_params")},
+		{name: "bus de otra compañía", method: http.MethodPost, path: commands, token: tokenB,
+			body: map[string]any{"type": domain.CommandReboot}, status: http.StatusNotFound, check: errorCode("bus_not_found")},
+	})
+
+	send := func(body map[string]any) map[string]any {
+		t.Helper()
+		w := a.do(http.MethodPost, commands, a.opToken, body)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("enviando comando: status %d: %s", w.Code, w.Body)
+		}
+		return decode[map[string]any](t, w)
+	}
+	get := func(id string) map[string]any {
+		t.Helper()
+		w := a.do(http.MethodGet, commands+"/"+id, a.opToken, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("consultando comando: status %d: %s", w.Code, w.Body)
+		}
+		return decode[map[string]any](t, w)
+	}
+
+	// Sin dispositivos conectados el comando queda pendiente.
+	interval := send(map[string]any{"type": domain.CommandSetInterval, "params": map[string]any{"seconds": 30}})
+	intervalID := interval["id"].(string)
+	if interval["estado"] != domain.CommandPending || interval["intentos"] != float64(0) {
+		t.Errorf("comando sin dispositivos: %v", interval)
+	}
+
+	// Al suscribirse el dispositivo recibe los comandos pendientes con QoS 1.
+	received := make(chan packets.Packet, 4)
+	if err := srv.Subscribe(topic(delivery.TopicCommands), 1, func(_ *mqtt.Client, _ packets.Subscription, pk packets.Packet) {
+		received <- pk
+	}); err != nil {
+		t.Fatal(err)
+	}
+	code, cl := mqttConnect(t, srv, clientID, secret)
+	if code != packets.CodeSuccess.Code {
+		t.Fatalf("conexión rechazada: código %#x", code)
+	}
+	if err := srv.InjectPacket(cl, packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Subscribe, Qos: 1},
+		PacketID:    1,
+		Filters:     packets.Subscriptions{{Filter: topic(delivery.TopicCommands), Qos: 1}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "comando pendiente enviado", func() bool { return get(intervalID)["estado"] == domain.CommandSent })
+	select {
+	case pk := <-received:
+		var msg delivery.MQTTCommand
+		if err := json.Unmarshal(pk.Payload, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID != intervalID || msg.Type != domain.CommandSetInterval || msg.Params["seconds"] != float64(30) || pk.FixedHeader.Qos != 1 {
+			t.Errorf("comando publicado: %s (QoS %d)", pk.Payload, pk.FixedHeader.Qos)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no se publicó el comando pendiente")
+	}
+
+	// Con el dispositivo suscrito el comando se publica de inmediato.
+	message := send(map[string]any{"type": domain.CommandDisplayMessage, "params": map[string]any{"text": " Desvío por obras "}})
+	messageID := message["id"].(string)
+	if message["estado"] != domain.CommandSent || message["params"].(map[string]any)["text"] != "Desvío por obras" {
+		t.Errorf("comando con el dispositivo suscrito: %v", message)
+	}
+
+	// El dispositivo confirma los comandos en su tópico ack.
+	ack := func(payload string) {
+		t.Helper()
+		if err := srv.InjectPacket(cl, packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Publish},
+			TopicName:   topic(delivery.TopicAck),
+			Payload:     []byte(payload),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Un comando vencido no admite confirmaciones aunque la revisión
+	// periódica aún no lo haya marcado.
+	busID, _ := primitive.ObjectIDFromHex(bus)
+	stale := domain.Command{
+		BusID: busID, CompaniaID: a.companyA, Tipo: domain.CommandReboot, Estado: domain.CommandSent,
+		CreatedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(-time.Hour),
+	}
+	if err := a.repos.Commands.Create(context.Background(), &stale); err != nil {
+		t.Fatal(err)
+	}
+	ack(`{"id": "` + stale.ID.Hex() + `", "status": "ok"}`)
+	ack(`{"id": "` + intervalID + `", "status": "ok"}`)
+	// Una confirmación repetida no cambia el resultado.
+	ack(`{"id": "` + intervalID + `", "status": "error"}`)
+	ack(`{"id": "` + messageID + `", "status": "error", "message": "pantalla apagada"}`)
+	// Las confirmaciones se guardan en orden: cuando la última se guardó, las
+	// anteriores también.
+	eventually(t, "confirmaciones guardadas", func() bool { return get(messageID)["estado"] == domain.CommandFailed })
+	if c := get(intervalID); c["estado"] != domain.CommandAcked || c["acked_at"] == nil {
+		t.Errorf("comando confirmado: %v", c)
+	}
+	if c := get(messageID); c["resultado"] != "pantalla apagada" {
+		t.Errorf("comando fallido: %v", c)
+	}
+	if c := get(stale.ID.Hex()); c["estado"] != domain.CommandExpired || c["acked_at"] != nil {
+		t.Errorf("comando vencido sin marcar: %v", c)
+	}
+
+	// Un comando que nadie confirma vence.
+	srv.DisconnectClient(cl, packets.ErrAdministrativeAction)
+	reboot := send(map[string]any{"type": domain.CommandReboot})
+	if _, err := a.repos.Commands.Expire(context.Background(), time.Now().Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	a.run([]apiCase{
+		{name: "listado del más reciente al más antiguo", method: http.MethodGet, path: commands, token: a.opToken, status: http.StatusOK,
+			check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				var estados []string
+				for _, c := range items(t, w) {
+					estados = append(estados, c["estado"].(string))
+				}
+				if got := strings.Join(estados, ","); got != "expired,failed,acked,expired" {
+					t.Errorf("estados = %s", got)
+				}
+			}},
+		{name: "filtro por estado", method: http.MethodGet, path: commands + "?estado=acked", token: a.opToken, status: http.StatusOK, check: length(1)},
+		{name: "comando vencido", method: http.MethodGet, path: commands + "/" + reboot["id"].(string), token: a.opToken, status: http.StatusOK, check: field("estado", domain.CommandExpired)},
+		{name: "comando inexistente", method: http.MethodGet, path: commands + "/" + primitive.NewObjectID().Hex(), token: a.opToken, status: http.StatusNotFound, check: errorCode("command_not_found")},
+		{name: "listado de otra compañía", method: http.MethodGet, path: commands, token: tokenB, status: http.StatusNotFound, check: errorCode("bus_not_found")},
+	})
+}
+
+// dialWS abre el WebSocket de la API de prueba con las cabeceras indicadas.
+// La primera llamada arranca el hub y un servidor HTTP de prueba.
+func (a *testAPI) dialWS(query string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	a.t.Helper()
+	if a.wsURL == "" {
+		go a.hub.Run()
+		srv := httptest.NewServer(a.router)
+		a.t.Cleanup(func() {
+			_ = a.hub.Shutdown(context.Background())
+			srv.Close()
+		})
+		a.wsURL = "ws" + strings.TrimPrefix(srv.URL, "http") + config.Default().WS.Path
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(a.wsURL+query, header)
+	if err == nil {
+		a.t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+// wsConnect abre el WebSocket con el token en ?access_token= y espera a que
+// el hub lo registre.
+func (a *testAPI) wsConnect(token string) *websocket.Conn {
+	a.t.Helper()
+	clients := a.hub.ClientCount()
+	conn, resp, err := a.dialWS("?access_token="+url.QueryEscape(token), nil)
+	if err != nil {
+		a.t.Fatalf("abriendo el WebSocket: %v (%v)", err, resp)
+	}
+	eventually(a.t, "cliente WebSocket registrado", func() bool { return a.hub.ClientCount() == clients+1 })
+	return conn
+}
+
+// wsRead retorna el siguiente mensaje JSON del WebSocket.
+func wsRead(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg map[string]any
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("leyendo del WebSocket: %v", err)
+	}
+	return msg
+}
+
+func TestWebsocketScope(t *testing.T) {
+	a := newTestAPI(t)
+
+	for _, tc := range []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{name: "sin token", status: http.StatusUnauthorized},
+		{name: "token inválido", query: "?access_token=x", status: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, resp, err := a.dialWS(tc.query, nil)
+			if err == nil || resp == nil || resp.StatusCode != tc.status {
+				t.Fatalf("handshake: %v, se esperaba status %d", resp, tc.status)
+			}
+		})
+	}
+
+	t.Run("origen no permitido", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.CORS.AllowOrigins = []string{"http://localhost:3000"}
+		srv := httptest.NewServer(delivery.NewWebsocketRouter(cfg, a.svc.Auth, a.hub))
+		defer srv.Close()
+		wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + cfg.WS.Path + "?access_token=" + a.opToken
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"http://evil.example"}})
+		if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("handshake: %v, se esperaba status 403", resp)
+		}
+	})
+
+	// Cada cliente recibe solo los mensajes de su compañía; root, los de todas.
+	opA := a.wsConnect(a.opToken)
+	opB := a.wsConnect(a.loginCompanyB())
+	hdr := http.Header{"Authorization": {"Bearer " + a.rootToken}}
+	root, resp, err := a.dialWS("", hdr)
+	if err != nil {
+		t.Fatalf("abriendo el WebSocket con la cabecera Authorization: %v (%v)", err, resp)
+	}
+	eventually(t, "cliente root registrado", func() bool { return a.hub.ClientCount() == 3 })
+
+	for _, comp := range []primitive.ObjectID{a.companyB, a.companyA} {
+		data, _ := json.Marshal(map[string]any{"type": "test", "compania": comp})
+		if !a.hub.Publish(comp, data) {
+			t.Fatal("canal de broadcast lleno")
+		}
+	}
+	if msg := wsRead(t, opA); msg["compania"] != a.companyA.Hex() {
+		t.Errorf("el cliente de la compañía A recibió %v", msg)
+	}
+	if msg := wsRead(t, opB); msg["compania"] != a.companyB.Hex() {
+		t.Errorf("el cliente de la compañía B recibió %v", msg)
+	}
+	if first, second := wsRead(t, root), wsRead(t, root); first["compania"] != a.companyB.Hex() || second["compania"] != a.companyA.Hex() {
+		t.Errorf("root recibió %v y %v", first, second)
+	}
+}
+
+func TestWebsocketSlowClient(t *testing.T) {
+	a := newTestAPI(t)
+	// reader lee todo lo que recibe; stalled nunca lee y tiene un búfer de
+	// recepción pequeño para que su conexión se llene enseguida.
+	reader := a.wsConnect(a.opToken)
+	dialer := websocket.Dialer{NetDial: func(network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			err = conn.(*net.TCPConn).SetReadBuffer(4096)
+		}
+		return conn, err
+	}}
+	stalled, resp, err := dialer.Dial(a.wsURL+"?access_token="+url.QueryEscape(a.opToken), nil)
+	if err != nil {
+		t.Fatalf("abriendo el WebSocket: %v (%v)", err, resp)
+	}
+	defer stalled.Close()
+	eventually(t, "cliente lento registrado", func() bool { return a.hub.ClientCount() == 2 })
+
+	got := make(chan struct{})
+	go func() {
+		defer close(got)
+		for {
+			if _, _, err := reader.ReadMessage(); err != nil {
+				return
+			}
+			got <- struct{}{}
+		}
+	}()
+
+	// Se publica al ritmo de reader hasta llenar los búferes de la conexión
+	// de stalled y su cola en el hub.
+	data, _ := json.Marshal(map[string]any{"type": "test", "relleno": strings.Repeat("x", 64*1024)})
+	for i := 0; a.hub.ClientCount() == 2; i++ {
+		if i == 1000 {
+			t.Fatal("el cliente lento no se desconectó")
+		}
+		if !a.hub.Publish(a.companyA, data) {
+			t.Fatal("canal de broadcast lleno")
+		}
+		select {
+		case _, ok := <-got:
+			if !ok {
+				t.Fatal("el cliente que lee se desconectó")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("el cliente que lee dejó de recibir mensajes")
+		}
+	}
+	if n := a.hub.ClientCount(); n != 1 {
+		t.Fatalf("%d clientes conectados, se esperaba solo el que lee", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := a.hub.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+func TestMQTTEventsByCompany(t *testing.T) {
+	a := newTestAPI(t)
+	srv, _, _ := a.newTestMQTT(config.Default().MQTT)
+
+	// Un bus con su dispositivo en cada compañía.
+	tokenB := a.loginCompanyB()
+	opB, err := a.repos.Users.GetByNombre(context.Background(), "operador-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect := func(token, conductor, placa string) (*mqtt.Client, string) {
+		t.Helper()
+		ruta := a.create("/routes", token, newRouteReq("Ruta "+placa), "route_id")
+		bus := a.create("/buses", token, newBusReq(placa, conductor, ruta), "bus_id")
+		w := a.do(http.MethodPost, "/devices", a.rootToken, map[string]any{"serial": "GPS-" + placa, "bus_id": bus})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("registrando dispositivo: status %d: %s", w.Code, w.Body)
+		}
+		dev := decode[map[string]any](t, w)
+		code, cl := mqttConnect(t, srv, dev["client_id"].(string), dev["secret"].(string))
+		if code != packets.CodeSuccess.Code {
+			t.Fatalf("conexión rechazada: código %#x", code)
+		}
+		return cl, dev["compania"].(string) + "/" + bus
+	}
+	clA, pathA := connect(a.opToken, a.opID.Hex(), "AAA111")
+	clB, pathB := connect(tokenB, opB.ID.Hex(), "BBB222")
+
+	wsA := a.wsConnect(a.opToken)
+	// Los mensajes se reenvían en orden: si el cliente de A recibiera los de
+	// B, el primero que lee sería el de B.
+	for _, p := range []struct {
+		cl   *mqtt.Client
+		path string
+		kind string
+	}{
+		{clB, pathB, delivery.TopicEvent},
+		{clB, pathB, delivery.TopicStatus},
+		{clA, pathA, delivery.TopicEvent},
+	} {
+		pk := packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Publish},
+			TopicName:   "ubicabus/" + p.path + "/" + p.kind,
+			Payload:     []byte(`{"name": "panic"}`),
+		}
+		if err := srv.InjectPacket(p.cl, pk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if msg := wsRead(t, wsA); msg["compania"] != a.companyA.Hex() || msg["type"] != delivery.TopicEvent {
+		t.Errorf("el cliente de la compañía A recibió %v", msg)
+	}
+}
@@ -0,0 +1,39 @@
+package delivery
+
+import (
+	"UbicaBus/UbicaBusBackend/application"
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PageResp es el cuerpo JSON de los listados paginados. Next es el valor a
+// enviar en ?after= para obtener la página siguiente; se omite en la última.
+type PageResp[T any] struct {
+	Items []T    `json:"items"`
+	Total *int64 `json:"total,omitempty"`
+	Next  string `json:"next,omitempty"`
+}
+
+func newPageResp[T any](p *domain.Page[T]) PageResp[T] {
+	return PageResp[T]{Items: p.Items, Total: p.Total, Next: p.Next}
+}
+
+// listParams lee los parámetros de un listado: ?limit=, ?after=, ?sort= y,
+// como filtros, el resto de parámetros de la query string.
+func listParams(c *gin.Context) application.ListParams {
+	p := application.ListParams{
+		Limit:   c.Query("limit"),
+		After:   c.Query("after"),
+		Sort:    c.Query("sort"),
+		Filters: map[string]string{},
+	}
+	for key, values := range c.Request.URL.Query() {
+		switch key {
+		case "limit", "after", "sort":
+			continue
+		}
+		p.Filters[key] = values[0]
+	}
+	return p
+}
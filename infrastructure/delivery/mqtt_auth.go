@@ -0,0 +1,210 @@
+package delivery
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/application"
+	"UbicaBus/UbicaBusBackend/domain"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// deviceAuthTimeout es el plazo para verificar las credenciales de un
+// dispositivo al conectarse.
+const deviceAuthTimeout = 5 * time.Second
+
+// authLimiterPrune es el número de direcciones registradas a partir del cual
+// se descartan las ventanas ya vencidas.
+const authLimiterPrune = 1024
+
+// deviceSessions guarda el dispositivo autenticado de cada cliente conectado.
+// Se indexa por cliente y no por client ID porque, cuando un dispositivo se
+// reconecta, el broker desconecta la sesión anterior después de autenticar
+// la nueva.
+type deviceSessions struct {
+	mu      sync.RWMutex
+	clients map[*mqtt.Client]*domain.Device
+}
+
+func newDeviceSessions() *deviceSessions {
+	return &deviceSessions{clients: map[*mqtt.Client]*domain.Device{}}
+}
+
+// get retorna el dispositivo del cliente, o false si no se autenticó.
+func (s *deviceSessions) get(cl *mqtt.Client) (*domain.Device, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.clients[cl]
+	return d, ok
+}
+
+func (s *deviceSessions) set(cl *mqtt.Client, d *domain.Device) {
+	s.mu.Lock()
+	s.clients[cl] = d
+	s.mu.Unlock()
+}
+
+func (s *deviceSessions) delete(cl *mqtt.Client) {
+	s.mu.Lock()
+	delete(s.clients, cl)
+	s.mu.Unlock()
+}
+
+// DeviceAuthHook autentica a los dispositivos GPS con las credenciales
+// emitidas por DeviceService y limita los tópicos a los del bus asignado:
+// solo pueden publicar en los tópicos location, status, event y ack de ese
+// bus, y suscribirse a su tópico commands.
+//
+// El client ID MQTT debe ser el client_id del dispositivo y la contraseña su
+// secreto; el usuario es opcional, pero si viene debe ser el client_id.
+type DeviceAuthHook struct {
+	mqtt.HookBase
+	devices  *application.DeviceService
+	sessions *deviceSessions
+	limiter  *authLimiter
+}
+
+// newDeviceAuthHook crea el DeviceAuthHook, que registra en sessions el
+// dispositivo de cada cliente autenticado. Una dirección IP con maxFailures
+// autenticaciones fallidas dentro de window queda bloqueada hasta que vence
+// la ventana.
+func newDeviceAuthHook(devices *application.DeviceService, sessions *deviceSessions, maxFailures int, window time.Duration) *DeviceAuthHook {
+	return &DeviceAuthHook{
+		devices:  devices,
+		sessions: sessions,
+		limiter:  newAuthLimiter(maxFailures, window),
+	}
+}
+
+// ID identifica este hook.
+func (h *DeviceAuthHook) ID() string { return "device-auth-hook" }
+
+// Provides indica los eventos que este hook maneja.
+func (h *DeviceAuthHook) Provides(b byte) bool {
+	return b == mqtt.OnConnectAuthenticate || b == mqtt.OnACLCheck || b == mqtt.OnDisconnect
+}
+
+// OnConnectAuthenticate verifica las credenciales del dispositivo. Los
+// intentos fallidos se registran y, superado el límite, se rechazan sin
+// consultar la base de datos.
+func (h *DeviceAuthHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
+	ip := remoteIP(cl.Net.Remote)
+	if h.limiter.blocked(ip, time.Now()) {
+		log.Printf("MQTT [Client %s]: !!! conexión rechazada desde %s: demasiados intentos fallidos", cl.ID, cl.Net.Remote)
+		return false
+	}
+
+	fail := func(reason any) bool {
+		h.limiter.fail(ip, time.Now())
+		log.Printf("MQTT [Client %s]: !!! autenticación fallida desde %s: %v", cl.ID, cl.Net.Remote, reason)
+		return false
+	}
+	if user := string(pk.Connect.Username); user != "" && user != cl.ID {
+		return fail("el usuario no coincide con el client ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deviceAuthTimeout)
+	defer cancel()
+	dev, err := h.devices.Authenticate(ctx, cl.ID, string(pk.Connect.Password))
+	if err != nil {
+		return fail(err)
+	}
+
+	h.sessions.set(cl, dev)
+	if err := h.devices.Seen(ctx, dev.ID, time.Now()); err != nil {
+		log.Printf("MQTT [Client %s]: !!! error al registrar la conexión del dispositivo: %v", cl.ID, err)
+	}
+	log.Printf("MQTT [Client %s]: dispositivo %s autenticado para el bus %s desde %s", cl.ID, dev.Serial, dev.BusID.Hex(), cl.Net.Remote)
+	return true
+}
+
+// OnACLCheck permite publicar solo en los tópicos de dispositivo del bus
+// asignado, y leer solo su tópico commands. Los filtros con comodines no se
+// permiten.
+func (h *DeviceAuthHook) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
+	dev, ok := h.sessions.get(cl)
+	if !ok {
+		return false
+	}
+
+	if !write {
+		return topic == DeviceTopic(dev.CompaniaID, dev.BusID, TopicCommands)
+	}
+	t, ok := parseMQTTTopic(topic)
+	if !ok || t.Company != dev.CompaniaID || t.Bus != dev.BusID {
+		return false
+	}
+	switch t.Kind {
+	case TopicLocation, TopicStatus, TopicEvent, TopicAck:
+		return true
+	default:
+		return false
+	}
+}
+
+// OnDisconnect olvida el dispositivo del cliente desconectado.
+func (h *DeviceAuthHook) OnDisconnect(cl *mqtt.Client, err error, expire bool) {
+	h.sessions.delete(cl)
+}
+
+// remoteIP retorna la IP de una dirección host:puerto, o la dirección
+// completa si no tiene puerto.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// authLimiter cuenta las autenticaciones fallidas por dirección IP en
+// ventanas fijas de duración window.
+type authLimiter struct {
+	max    int
+	window time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*authFailures
+}
+
+type authFailures struct {
+	count int
+	until time.Time // fin de la ventana
+}
+
+func newAuthLimiter(max int, window time.Duration) *authLimiter {
+	return &authLimiter{max: max, window: window, failures: map[string]*authFailures{}}
+}
+
+// blocked indica si ip alcanzó el máximo de fallos en la ventana vigente.
+func (l *authLimiter) blocked(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.failures[ip]
+	return ok && f.count >= l.max && now.Before(f.until)
+}
+
+// fail registra un fallo de ip, abriendo una ventana nueva si la anterior
+// venció.
+func (l *authLimiter) fail(ip string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.failures) >= authLimiterPrune {
+		for k, f := range l.failures {
+			if !now.Before(f.until) {
+				delete(l.failures, k)
+			}
+		}
+	}
+	f, ok := l.failures[ip]
+	if !ok || !now.Before(f.until) {
+		f = &authFailures{until: now.Add(l.window)}
+		l.failures[ip] = f
+	}
+	f.count++
+}
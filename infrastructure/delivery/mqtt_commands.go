@@ -0,0 +1,139 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/application"
+	"UbicaBus/UbicaBusBackend/domain"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// commandDeliverTimeout es el plazo para reenviar los comandos abiertos de un
+// bus cuando un dispositivo se suscribe.
+const commandDeliverTimeout = 10 * time.Second
+
+// commandQoS es la QoS con la que se publican los comandos. El dispositivo
+// debe suscribirse con QoS 1 para recibirlos así.
+const commandQoS = 1
+
+// MQTTCommand es el payload publicado en ubicabus/{compañía}/{bus}/commands.
+type MQTTCommand struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	Params    map[string]any `json:"params,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+// commandPublisher publica los comandos con el cliente interno del broker.
+// Implementa application.CommandPublisher.
+type commandPublisher struct {
+	server *mqtt.Server
+}
+
+// PublishCommand publica el comando en el tópico de comandos de su bus si
+// algún dispositivo conectado está suscrito. Los comandos no se retienen en
+// el broker: los abiertos se reenvían desde la base de datos al suscribirse.
+func (p *commandPublisher) PublishCommand(cmd domain.Command) (bool, error) {
+	topic := DeviceTopic(cmd.CompaniaID, cmd.BusID, TopicCommands)
+	if !p.hasSubscribers(topic) {
+		return false, nil
+	}
+	payload, err := json.Marshal(MQTTCommand{
+		ID:        cmd.ID.Hex(),
+		Type:      cmd.Tipo,
+		Params:    cmd.Params,
+		CreatedAt: cmd.CreatedAt,
+		ExpiresAt: cmd.ExpiresAt,
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := p.server.Publish(topic, payload, false, commandQoS); err != nil {
+		return false, err
+	}
+	log.Printf("MQTT: >> comando %s (%s) publicado en '%s'", cmd.ID.Hex(), cmd.Tipo, topic)
+	return true, nil
+}
+
+// hasSubscribers indica si algún cliente conectado está suscrito al tópico.
+func (p *commandPublisher) hasSubscribers(topic string) bool {
+	for id := range p.server.Topics.Subscribers(topic).Subscriptions {
+		if cl, ok := p.server.Clients.Get(id); ok && !cl.Closed() {
+			return true
+		}
+	}
+	return false
+}
+
+// CommandHook reenvía los comandos abiertos de un bus cuando uno de sus
+// dispositivos se suscribe a su tópico de comandos, p. ej. al reconectarse.
+type CommandHook struct {
+	mqtt.HookBase
+	commands *application.CommandService
+	sessions *deviceSessions
+}
+
+func newCommandHook(commands *application.CommandService, sessions *deviceSessions) *CommandHook {
+	return &CommandHook{commands: commands, sessions: sessions}
+}
+
+// ID identifica este hook.
+func (h *CommandHook) ID() string { return "command-hook" }
+
+// Provides indica los eventos que este hook maneja.
+func (h *CommandHook) Provides(b byte) bool {
+	return b == mqtt.OnSubscribed
+}
+
+// OnSubscribed entrega los comandos abiertos si el dispositivo se suscribió
+// con éxito a su tópico de comandos. Se llama antes de enviar el SUBACK, por
+// lo que la entrega se hace en otra goroutine.
+func (h *CommandHook) OnSubscribed(cl *mqtt.Client, pk packets.Packet, reasonCodes []byte) {
+	dev, ok := h.sessions.get(cl)
+	if !ok {
+		return
+	}
+	topic := DeviceTopic(dev.CompaniaID, dev.BusID, TopicCommands)
+	for i, sub := range pk.Filters {
+		if sub.Filter != topic || i >= len(reasonCodes) || reasonCodes[i] > packets.CodeGrantedQos2.Code {
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), commandDeliverTimeout)
+			defer cancel()
+			if err := h.commands.Deliver(ctx, dev.BusID); err != nil {
+				log.Printf("MQTT [Client %s]: !!! error al entregar los comandos pendientes: %v", cl.ID, err)
+			}
+		}()
+		return
+	}
+}
+
+// handleAck procesa ubicabus/{compañía}/{bus}/ack: la confirmación de un
+// comando, con su id, status "ok" o "error" y un mensaje opcional. La
+// confirmación se encola para no bloquear al cliente mientras se guarda.
+func (h *MessageHook) handleAck(cl *mqtt.Client, dev *domain.Device, t mqttTopic, payload []byte) error {
+	var msg struct {
+		ID      string `json:"id"`
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidPayload, err)
+	}
+	if msg.ID == "" || (msg.Status != "ok" && msg.Status != "error") {
+		return fmt.Errorf("%w: id y status (\"ok\" o \"error\") son obligatorios", errInvalidPayload)
+	}
+	if err := h.commands.QueueAck(dev.BusID, msg.ID, msg.Status == "ok", msg.Message); err != nil {
+		return err
+	}
+	log.Printf("MQTT [Client %s]: confirmación del comando %s recibida", cl.ID, msg.ID)
+	return nil
+}
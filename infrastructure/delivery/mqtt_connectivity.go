@@ -0,0 +1,94 @@
+package delivery
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/application"
+	"UbicaBus/UbicaBusBackend/domain"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TypeConnectivity es el tipo de los mensajes WebSocket con los cambios de
+// conectividad de los buses.
+const TypeConnectivity = "connectivity"
+
+// ConnectivityHook informa al ConnectivityService de las conexiones y
+// desconexiones de los dispositivos. La conexión se registra una vez
+// autenticado el dispositivo, justo antes del CONNACK, ya que en OnConnect
+// aún no se sabe a qué bus pertenece.
+type ConnectivityHook struct {
+	mqtt.HookBase
+	connectivity *application.ConnectivityService
+	sessions     *deviceSessions
+
+	// El DeviceAuthHook olvida la sesión al desconectarse el cliente, por lo
+	// que el bus de cada cliente contado se guarda aquí.
+	mu      sync.Mutex
+	clients map[*mqtt.Client]primitive.ObjectID
+}
+
+func newConnectivityHook(connectivity *application.ConnectivityService, sessions *deviceSessions) *ConnectivityHook {
+	return &ConnectivityHook{
+		connectivity: connectivity,
+		sessions:     sessions,
+		clients:      map[*mqtt.Client]primitive.ObjectID{},
+	}
+}
+
+// ID identifica este hook.
+func (h *ConnectivityHook) ID() string { return "connectivity-hook" }
+
+// Provides indica los eventos que este hook maneja.
+func (h *ConnectivityHook) Provides(b byte) bool {
+	return b == mqtt.OnSessionEstablish || b == mqtt.OnDisconnect
+}
+
+// OnSessionEstablish registra la conexión del dispositivo autenticado.
+func (h *ConnectivityHook) OnSessionEstablish(cl *mqtt.Client, pk packets.Packet) {
+	dev, ok := h.sessions.get(cl)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	h.clients[cl] = dev.BusID
+	h.mu.Unlock()
+	h.connectivity.Connected(dev.BusID, dev.CompaniaID, time.Now())
+}
+
+// OnDisconnect registra la desconexión de un dispositivo contado en
+// OnSessionEstablish.
+func (h *ConnectivityHook) OnDisconnect(cl *mqtt.Client, err error, expire bool) {
+	h.mu.Lock()
+	busID, ok := h.clients[cl]
+	delete(h.clients, cl)
+	h.mu.Unlock()
+	if ok {
+		h.connectivity.Disconnected(busID, time.Now())
+	}
+}
+
+// forwardConnectivity reenvía un cambio de conectividad a los clientes
+// WebSocket de la compañía del bus sin bloquear.
+func forwardConnectivity(hub *Hub, e domain.ConnectivityEvent) {
+	data, err := json.Marshal(WSConnectivityMessage{
+		Type:       TypeConnectivity,
+		BusID:      e.BusID,
+		CompaniaID: e.CompaniaID,
+		Estado:     e.Estado,
+		Anterior:   e.Anterior,
+		At:         e.At,
+	})
+	if err != nil {
+		log.Printf("!!! ERROR al serializar el cambio de conectividad para WS: %v", err)
+		return
+	}
+	if !hub.Publish(e.CompaniaID, data) {
+		log.Printf("!!! WARNING: WS Hub broadcast channel FULL. Cambio de conectividad del bus %s NOT sent to WS.", e.BusID.Hex())
+	}
+}
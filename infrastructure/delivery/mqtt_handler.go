@@ -1,132 +1,270 @@
 package delivery
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
-	"UbicaBus/UbicaBusBackend/application" // Asegúrate que esta ruta sea correcta
+	"UbicaBus/UbicaBusBackend/application"
+	"UbicaBus/UbicaBusBackend/domain"
+	"UbicaBus/UbicaBusBackend/infrastructure/config"
 
-	mqtt "github.com/mochi-mqtt/server/v2" // Asegúrate de que go.mod apunte a v2
+	mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/listeners"
-	"github.com/mochi-mqtt/server/v2/packets" // Asegúrate de importar packets
+	"github.com/mochi-mqtt/server/v2/packets"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// Asumo que la estructura Hub está definida en otro archivo en este paquete 'delivery'
-// y tiene un canal 'broadcast chan []byte' correctamente inicializado y gestionado (ej. con un método Run).
+// Errores con los que se rechaza un PUBLISH de un dispositivo.
+var (
+	errInvalidPayload = errors.New("payload inválido")
+	errBusMismatch    = errors.New("el bus_id del payload no coincide con el del tópico")
+)
 
-// MessageHook maneja los mensajes MQTT entrantes (PUBLISH).
-// Interactúa con la lógica de negocio (DB) y el Hub de WebSockets.
+// MessageHook maneja los mensajes MQTT entrantes (PUBLISH) de los tópicos de
+// dispositivo: entrega las posiciones a la ingesta, registra las
+// confirmaciones de los comandos y reenvía posiciones, estados y eventos al
+// Hub de WebSockets. Los mensajes se atribuyen al bus
+// asignado al dispositivo que publica, no a los IDs del tópico ni del
+// payload. Los tópicos desconocidos se ignoran y el broker los procesa con
+// normalidad.
 type MessageHook struct {
-	mqtt.HookBase                                 // Requerido para ser un hook
-	blService     *application.BusLocationService // Servicio para lógica de negocio (guardar en DB)
-	hub           *Hub                            // Hub para reenvío a WebSockets
+	mqtt.HookBase                                  // Requerido para ser un hook
+	ingest        *application.LocationIngestor    // Encola las posiciones para guardarlas en lotes
+	devices       *application.DeviceService       // Registra cuándo se vio cada dispositivo
+	connectivity  *application.ConnectivityService // Registra la última posición de cada bus
+	commands      *application.CommandService      // Registra las confirmaciones de los comandos
+	sessions      *deviceSessions                  // Dispositivo autenticado de cada cliente
+	hub           *Hub                             // Hub para reenvío a WebSockets
+	router        *mqttRouter
+}
+
+// newMessageHook crea el MessageHook con un manejador por familia de tópicos.
+func newMessageHook(ingest *application.LocationIngestor, devices *application.DeviceService, connectivity *application.ConnectivityService, commands *application.CommandService, sessions *deviceSessions, hub *Hub) *MessageHook {
+	h := &MessageHook{ingest: ingest, devices: devices, connectivity: connectivity, commands: commands, sessions: sessions, hub: hub, router: newMQTTRouter()}
+	h.router.handle(TopicLocation, h.handleLocation)
+	h.router.handle(TopicStatus, h.handleDeviceMessage)
+	h.router.handle(TopicEvent, h.handleDeviceMessage)
+	h.router.handle(TopicAck, h.handleAck)
+	return h
 }
 
 // ID identifica este hook.
 func (h *MessageHook) ID() string { return "message-hook" }
 
 // Provides indica los eventos que este hook maneja.
-// Basado en el código fuente que proporcionaste, el evento para un mensaje PUBLISH entrante es mqtt.OnPublish.
-// Este hook implementa el método OnPublish.
 func (h *MessageHook) Provides(b byte) bool {
-	// *** CORRECTO SEGUN TU CODIGO FUENTE ***
 	return b&mqtt.OnPublish != 0
 }
 
-// OnPublish es llamado por el broker cuando recibe un paquete PUBLISH de un cliente.
-// Es el punto de hook para interceptar mensajes entrantes.
+// OnPublish es llamado por el broker cuando recibe un paquete PUBLISH de un
+// cliente. Los paquetes rechazados no se entregan a los suscriptores. Los
+// comandos que publica el propio broker se dejan pasar.
 func (h *MessageHook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet, error) {
-	// Log inicial del paquete PUBLISH recibido en el hook.
-	// *** CORRECCION FINAL: Acceso directo a QoS, Retain, Dup, PacketID desde pk.Packet ***
+	if cl.Net.Inline {
+		return pk, nil
+	}
 	log.Printf("MQTT [Client %s]: << RECEIVED PUBLISH (HOOK) Topic='%s' (QoS %d, Retain %t, DUP %t), PacketID=%d, Payload size=%d bytes",
-		cl.ID, pk.TopicName, pk.FixedHeader.Qos, pk.FixedHeader.Retain, pk.FixedHeader.Dup, pk.PacketID, len(pk.Payload)) // Acceso directo
+		cl.ID, pk.TopicName, pk.FixedHeader.Qos, pk.FixedHeader.Retain, pk.FixedHeader.Dup, pk.PacketID, len(pk.Payload))
 
-	// 1) Parsear el payload JSON esperado (BusID, Lat, Lng).
-	var msg struct {
-		BusID string  `json:"bus_id"`
-		Lat   float64 `json:"lat"`
-		Lng   float64 `json:"lng"`
+	t, handle, ok := h.router.route(pk.TopicName)
+	if !ok {
+		log.Printf("MQTT [Client %s]: tópico '%s' desconocido, ignorado por el hook.", cl.ID, pk.TopicName)
+		return pk, nil
 	}
-
-	// Validar si hay payload.
-	if len(pk.Payload) == 0 {
-		log.Printf("MQTT [Client %s]: PUBLISH con payload vacío en tópico '%s'. Hook ignora procesamiento JSON.", cl.ID, pk.TopicName)
-		return pk, nil // Devuelve el paquete original.
+	dev, ok := h.sessions.get(cl)
+	if !ok {
+		log.Printf("MQTT [Client %s]: !!! PUBLISH en tópico '%s' de un cliente sin dispositivo, ignorado.", cl.ID, pk.TopicName)
+		return pk, packets.CodeSuccessIgnore
+	}
+	h.seen(cl, dev)
+	if err := handle(cl, dev, t, pk.Payload); err != nil {
+		log.Printf("MQTT [Client %s]: !!! PUBLISH rechazado en tópico '%s': %v", cl.ID, pk.TopicName, err)
+		return pk, rejectPublish(cl, pk, err)
 	}
+	return pk, nil
+}
 
-	if err := json.Unmarshal(pk.Payload, &msg); err != nil {
-		// Log del error de parsing.
-		log.Printf("MQTT [Client %s]: !!! ERROR JSON Unmarshal para PUBLISH en tópico '%s': %v. Payload recibido (muestra): '%s'",
-			cl.ID, pk.TopicName, err, string(pk.Payload)) // Truncar payload si es muy largo en un log real
-		return pk, nil // Devuelve el paquete original para no detener el broker.
+// rejectPublish retorna el error con el que el broker descarta el paquete.
+// A los clientes MQTT 5 con QoS > 0 se les responde un PUBACK con el motivo;
+// al resto se les confirma el paquete sin entregarlo, para que no lo reenvíen.
+func rejectPublish(cl *mqtt.Client, pk packets.Packet, err error) error {
+	if cl.Properties.ProtocolVersion < 5 || pk.FixedHeader.Qos == 0 {
+		return packets.CodeSuccessIgnore
+	}
+	switch {
+	case errors.Is(err, application.ErrUnknownBus):
+		return packets.ErrTopicNameInvalid
+	case errors.Is(err, application.ErrCommandNotFound):
+		return packets.ErrImplementationSpecificError
+	case errors.Is(err, errInvalidPayload), errors.Is(err, errBusMismatch), errors.Is(err, domain.ErrValidation):
+		return packets.ErrPayloadFormatInvalid
+	case errors.Is(err, application.ErrIngestQueueFull):
+		return packets.ErrQuotaExceeded
+	default:
+		return packets.ErrUnspecifiedError
 	}
+}
+
+// seen registra que el dispositivo sigue activo sin bloquear el hook; el
+// DeviceService limita cuántas veces se escribe.
+func (h *MessageHook) seen(cl *mqtt.Client, dev *domain.Device) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), deviceAuthTimeout)
+		defer cancel()
+		if err := h.devices.Seen(ctx, dev.ID, time.Now()); err != nil {
+			log.Printf("MQTT [Client %s]: !!! error al registrar la actividad del dispositivo: %v", cl.ID, err)
+		}
+	}()
+}
 
-	// Log confirmando el parsing exitoso.
-	log.Printf("MQTT [Client %s]: >>> Parsed PUBLISH payload OK for BusID %s: Lat=%f, Lng=%f from topic '%s'", cl.ID, msg.BusID, msg.Lat, msg.Lng, pk.TopicName)
+// checkPayloadBus verifica que el bus_id del payload, si viene, sea el del
+// dispositivo.
+func checkPayloadBus(dev *domain.Device, busIDHex string) error {
+	if busIDHex == "" {
+		return nil
+	}
+	if id, err := primitive.ObjectIDFromHex(busIDHex); err != nil || id != dev.BusID {
+		return errBusMismatch
+	}
+	return nil
+}
 
-	// Opcional: Filtrar por tópico si necesario.
-	// const expectedTopic = "gps/bus/location" // Define el tópico que esperas
-	// if pk.TopicName != expectedTopic {
-	//     log.Printf("MQTT [Client %s]: PUBLISH en tópico '%s' ignorado por hook. Esperado '%s'.", cl.ID, pk.TopicName, expectedTopic)
-	//     return pk, nil // Pasa el paquete sin procesar por este hook.
-	// }
-	// log.Printf("MQTT [Client %s]: Processing PUBLISH from expected topic '%s'.", cl.ID, pk.TopicName)
+// handleLocation procesa ubicabus/{compañía}/{bus}/location: lat, lng y,
+// opcionalmente, la velocidad en km/h y el rumbo en grados.
+func (h *MessageHook) handleLocation(cl *mqtt.Client, dev *domain.Device, t mqttTopic, payload []byte) error {
+	var msg struct {
+		BusID   string   `json:"bus_id"`
+		Lat     *float64 `json:"lat"`
+		Lng     *float64 `json:"lng"`
+		Speed   *float64 `json:"speed"`
+		Heading *float64 `json:"heading"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidPayload, err)
+	}
+	if msg.Lat == nil || msg.Lng == nil {
+		return fmt.Errorf("%w: lat y lng son obligatorios", errInvalidPayload)
+	}
+	if err := checkPayloadBus(dev, msg.BusID); err != nil {
+		return err
+	}
 
-	// 2) Guardar la ubicación en la base de datos.
-	_, err := h.blService.RegisterBusLocation(msg.BusID, msg.Lat, msg.Lng)
+	// Encolar la ubicación para guardarla en lotes; la flota en vivo se
+	// actualiza de inmediato. No se espera a la base de datos en el hook.
+	bl, err := h.ingest.Ingest(domain.CompanyScope(dev.CompaniaID), dev.BusID.Hex(), *msg.Lat, *msg.Lng, msg.Speed, msg.Heading)
 	if err != nil {
-		log.Printf("MQTT [Client %s]: !!! ERROR al guardar ubicación de BusID %s en DB: %v", cl.ID, msg.BusID, err)
-	} else {
-		log.Printf("MQTT [Client %s]: DB save successful for BusID %s.", cl.ID, msg.BusID)
+		return err
 	}
+	h.connectivity.Seen(bl.BusID, bl.CompaniaID, bl.CreatedAt)
+	h.forward(cl, bl.CompaniaID, WSLocationMessage{
+		Type:       TopicLocation,
+		BusID:      bl.BusID,
+		CompaniaID: bl.CompaniaID,
+		Lat:        bl.Localizacion.Lat,
+		Lng:        bl.Localizacion.Lng,
+		Speed:      bl.Velocidad,
+		Heading:    bl.Rumbo,
+		At:         bl.CreatedAt,
+	})
+	return nil
+}
 
-	// 3) Reenviar a WebSockets.
-	broadcastPayload := pk.Payload // Asumiendo que el payload MQTT JSON es el mismo que necesitas para WS
-
-	select {
-	case h.hub.broadcast <- broadcastPayload:
-		log.Printf("MQTT [Client %s]: >>> Sent location of BusID %s to WS Hub broadcast channel.", cl.ID, msg.BusID)
-	default:
-		log.Printf("MQTT [Client %s]: !!! WARNING: WS Hub broadcast channel FULL. Location for BusID %s NOT sent to WS.", cl.ID, msg.BusID)
+// handleDeviceMessage procesa los tópicos status y event: el payload es un
+// objeto JSON libre que se reenvía a WebSockets tal cual.
+func (h *MessageHook) handleDeviceMessage(cl *mqtt.Client, dev *domain.Device, t mqttTopic, payload []byte) error {
+	var msg struct {
+		BusID string `json:"bus_id"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidPayload, err)
+	}
+	if err := checkPayloadBus(dev, msg.BusID); err != nil {
+		return err
+	}
+	if _, err := h.ingest.ResolveBus(domain.CompanyScope(dev.CompaniaID), dev.BusID.Hex()); err != nil {
+		return err
 	}
+	h.forward(cl, dev.CompaniaID, WSDeviceMessage{
+		Type:       t.Kind,
+		BusID:      dev.BusID,
+		CompaniaID: dev.CompaniaID,
+		Data:       payload,
+		At:         time.Now(),
+	})
+	return nil
+}
 
-	// *** FUNDAMENTAL ***: Retornar el paquete original y nil error para que el broker lo siga procesando.
-	return pk, nil
+// forward envía un mensaje de la compañía al Hub de WebSockets sin bloquear
+// el hook.
+func (h *MessageHook) forward(cl *mqtt.Client, companiaID primitive.ObjectID, msg any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("MQTT [Client %s]: !!! ERROR al serializar mensaje para WS: %v", cl.ID, err)
+		return
+	}
+	if !h.hub.Publish(companiaID, data) {
+		log.Printf("MQTT [Client %s]: !!! WARNING: WS Hub broadcast channel FULL. Message NOT sent to WS.", cl.ID)
+	}
 }
 
-// StartMQTT configura y arranca el broker MQTT con el MessageHook.
-// El parametro 'topic' se mantiene por compatibilidad con la firma anterior, pero no se usa en el hook por defecto.
-func StartMQTT(brokerAddr string, topic string, blService *application.BusLocationService, hub *Hub) {
+// NewMQTTServer configura el broker MQTT con el DeviceAuthHook, el
+// ConnectivityHook, el CommandHook, el MessageHook y su listener TCP. El listener se abre
+// aquí, de modo que un error de puerto se reporta antes de arrancar; el
+// broker empieza a aceptar clientes al llamar a Serve. Al rotar las
+// credenciales de un dispositivo, reasignarlo o darlo de baja se cierra su
+// sesión. Los cambios de conectividad de los buses se reenvían al Hub y los
+// comandos se publican con el cliente interno del broker.
+func NewMQTTServer(cfg config.MQTTConfig, ingest *application.LocationIngestor, devices *application.DeviceService, connectivity *application.ConnectivityService, commands *application.CommandService, hub *Hub) (*mqtt.Server, error) {
 	log.Println("INFO: Initializing MQTT Broker...")
-	server := mqtt.New(nil) // Configuración por defecto
+	// El cliente interno permite publicar los comandos desde el backend.
+	server := mqtt.New(&mqtt.Options{InlineClient: true})
+	sessions := newDeviceSessions()
+
+	// Solo se aceptan dispositivos registrados, limitados a su bus.
+	if err := server.AddHook(newDeviceAuthHook(devices, sessions, cfg.AuthMaxFailures, cfg.AuthWindow), nil); err != nil {
+		return nil, fmt.Errorf("registrando DeviceAuthHook: %w", err)
+	}
+	devices.OnRevoke(func(clientID string) {
+		if cl, ok := server.Clients.Get(clientID); ok {
+			log.Printf("MQTT [Client %s]: sesión del dispositivo revocada, desconectando.", clientID)
+			server.DisconnectClient(cl, packets.ErrNotAuthorized)
+		}
+	})
+
+	// Conexiones y desconexiones de los dispositivos de cada bus.
+	if err := server.AddHook(newConnectivityHook(connectivity, sessions), nil); err != nil {
+		return nil, fmt.Errorf("registrando ConnectivityHook: %w", err)
+	}
+	connectivity.OnTransition(func(e domain.ConnectivityEvent) { forwardConnectivity(hub, e) })
+
+	// Comandos para los dispositivos: se publican al enviarlos y los abiertos
+	// se reenvían cuando un dispositivo se suscribe a su tópico.
+	if err := server.AddHook(newCommandHook(commands, sessions), nil); err != nil {
+		return nil, fmt.Errorf("registrando CommandHook: %w", err)
+	}
+	commands.SetPublisher(&commandPublisher{server: server})
 
 	// Registrar el hook.
 	log.Println("INFO: Registering MessageHook...")
-	if err := server.AddHook(&MessageHook{blService: blService, hub: hub}, nil); err != nil {
-		log.Fatalf("FATAL: Error al registrar el MessageHook: %v", err)
-	} else {
-		log.Println("INFO: MessageHook registered successfully. It will intercept PUBLISH events.")
+	if err := server.AddHook(newMessageHook(ingest, devices, connectivity, commands, sessions, hub), nil); err != nil {
+		return nil, fmt.Errorf("registrando MessageHook: %w", err)
 	}
+	log.Println("INFO: MessageHook registered successfully. It will intercept PUBLISH events.")
 
 	// Añadir listener TCP.
-	log.Printf("INFO: Adding TCP listener on %s...", brokerAddr)
+	log.Printf("INFO: Adding TCP listener on %s...", cfg.Addr)
 	tcpListener := listeners.NewTCP(listeners.Config{
 		ID:      "tcp1",
-		Address: brokerAddr,
+		Address: cfg.Addr,
 	})
 	if err := server.AddListener(tcpListener); err != nil {
-		log.Fatalf("FATAL: Error al agregar listener TCP MQTT en %s: %v", brokerAddr, err)
-	} else {
-		log.Printf("INFO: Listener TCP MQTT agregado exitosamente en %s.", brokerAddr)
+		return nil, fmt.Errorf("agregando listener TCP MQTT en %s: %w", cfg.Addr, err)
 	}
+	log.Printf("INFO: Listener TCP MQTT agregado exitosamente en %s.", cfg.Addr)
 
-	// Iniciar el servidor en goroutine.
-	log.Printf("INFO: Starting MQTT broker service...")
-	go func() {
-		if err := server.Serve(); err != nil {
-			log.Fatalf("FATAL: MQTT Serve error: %v", err)
-		}
-	}()
-
-	log.Printf("INFO: MQTT Broker started successfully on %s.", brokerAddr)
+	return server, nil
 }
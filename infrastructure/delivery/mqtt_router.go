@@ -0,0 +1,84 @@
+package delivery
+
+import (
+	"strings"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Los dispositivos publican en ubicabus/{compañía}/{bus}/{tipo}, con los IDs
+// de la compañía y del bus en hexadecimal, y se suscriben a
+// ubicabus/{compañía}/{bus}/commands para recibir los comandos, que
+// confirman en ubicabus/{compañía}/{bus}/ack.
+const (
+	mqttTopicRoot = "ubicabus"
+
+	TopicLocation = "location" // posición del bus
+	TopicStatus   = "status"   // estado del dispositivo (batería, señal...)
+	TopicEvent    = "event"    // eventos puntuales (pánico, puerta abierta...)
+	TopicCommands = "commands" // órdenes del backend para el dispositivo
+	TopicAck      = "ack"      // confirmación de las órdenes por el dispositivo
+)
+
+// DeviceTopic retorna el tópico de tipo kind del bus indicado.
+func DeviceTopic(company, bus primitive.ObjectID, kind string) string {
+	return mqttTopicRoot + "/" + company.Hex() + "/" + bus.Hex() + "/" + kind
+}
+
+// mqttTopic es un tópico de dispositivo ya interpretado.
+type mqttTopic struct {
+	Company primitive.ObjectID
+	Bus     primitive.ObjectID
+	Kind    string
+}
+
+// parseMQTTTopic interpreta un tópico de dispositivo. Retorna false si el
+// tópico no sigue el esquema o alguno de los IDs es inválido.
+func parseMQTTTopic(name string) (mqttTopic, bool) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 || parts[0] != mqttTopicRoot {
+		return mqttTopic{}, false
+	}
+	company, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return mqttTopic{}, false
+	}
+	bus, err := primitive.ObjectIDFromHex(parts[2])
+	if err != nil {
+		return mqttTopic{}, false
+	}
+	return mqttTopic{Company: company, Bus: bus, Kind: parts[3]}, true
+}
+
+// mqttHandlerFunc procesa el payload de un PUBLISH en un tópico de dispositivo,
+// publicado por el dispositivo dev. Un error rechaza el paquete.
+type mqttHandlerFunc func(cl *mqtt.Client, dev *domain.Device, t mqttTopic, payload []byte) error
+
+// mqttRouter despacha cada familia de tópicos (location, status, event, ack)
+// a su manejador.
+type mqttRouter struct {
+	handlers map[string]mqttHandlerFunc
+}
+
+func newMQTTRouter() *mqttRouter {
+	return &mqttRouter{handlers: map[string]mqttHandlerFunc{}}
+}
+
+// handle registra el manejador de una familia de tópicos.
+func (r *mqttRouter) handle(kind string, fn mqttHandlerFunc) {
+	r.handlers[kind] = fn
+}
+
+// route retorna el tópico interpretado y su manejador, o false si el tópico
+// es desconocido.
+func (r *mqttRouter) route(name string) (mqttTopic, mqttHandlerFunc, bool) {
+	t, ok := parseMQTTTopic(name)
+	if !ok {
+		return mqttTopic{}, nil, false
+	}
+	fn, ok := r.handlers[t.Kind]
+	return t, fn, ok
+}
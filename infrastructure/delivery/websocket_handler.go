@@ -2,24 +2,114 @@
 package delivery
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/application"
+	"UbicaBus/UbicaBusBackend/domain"
+	"UbicaBus/UbicaBusBackend/infrastructure/config"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+// WSLocationMessage es el mensaje que reciben los clientes WebSocket por cada
+// posición aceptada de un dispositivo.
+type WSLocationMessage struct {
+	Type       string             `json:"type"` // "location"
+	BusID      primitive.ObjectID `json:"bus_id"`
+	CompaniaID primitive.ObjectID `json:"compania"`
+	Lat        float64            `json:"lat"`
+	Lng        float64            `json:"lng"`
+	Speed      *float64           `json:"speed,omitempty"`
+	Heading    *float64           `json:"heading,omitempty"`
+	At         time.Time          `json:"at"`
+}
+
+// WSDeviceMessage es el mensaje que reciben los clientes WebSocket por cada
+// estado o evento de un dispositivo. Data es el payload MQTT original.
+type WSDeviceMessage struct {
+	Type       string             `json:"type"` // "status" o "event"
+	BusID      primitive.ObjectID `json:"bus_id"`
+	CompaniaID primitive.ObjectID `json:"compania"`
+	Data       json.RawMessage    `json:"data"`
+	At         time.Time          `json:"at"`
+}
+
+// WSConnectivityMessage es el mensaje que reciben los clientes WebSocket
+// cuando cambia el estado de conectividad de un bus.
+type WSConnectivityMessage struct {
+	Type       string             `json:"type"` // "connectivity"
+	BusID      primitive.ObjectID `json:"bus_id"`
+	CompaniaID primitive.ObjectID `json:"compania"`
+	Estado     string             `json:"estado"`
+	Anterior   string             `json:"anterior"`
+	At         time.Time          `json:"at"`
+}
+
+// wsTokenParam es el parámetro de consulta con el access token. Los
+// navegadores no permiten enviar cabeceras en el handshake de WebSocket.
+const wsTokenParam = "access_token"
 
-// WebsocketHandler registra la conexión en el hub.
-func WebsocketHandler(hub *Hub) gin.HandlerFunc {
+// newUpgrader crea un Upgrader que solo acepta los orígenes permitidos por
+// CORS ("*" permite cualquiera). Las peticiones sin cabecera Origin, que no
+// vienen de un navegador, se aceptan.
+func newUpgrader(origins []string) *websocket.Upgrader {
+	return &websocket.Upgrader{CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, o := range origins {
+			if o == "*" || strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// WebsocketHandler autentica al usuario con un access token, en la cabecera
+// Authorization o en ?access_token=, y registra la conexión en el hub con su
+// Scope: solo recibe los mensajes de su compañía, o de todas con
+// PermAllTenants. Requiere el permiso buslocations:read.
+func WebsocketHandler(hub *Hub, authService *application.AuthService, origins []string) gin.HandlerFunc {
+	upgrader := newUpgrader(origins)
 	return func(c *gin.Context) {
+		token, ok := bearerToken(c)
+		if !ok {
+			token = strings.TrimSpace(c.Query(wsTokenParam))
+		}
+		if token == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="ubicabus"`)
+			abortWithError(c, errTokenRequired)
+			return
+		}
+		p, err := authenticate(authService, token)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if !p.Role.HasPermission(domain.PermBusLocationsRead) {
+			abortWithError(c, application.ErrForbidden.WithDetails(map[string]any{"permiso": domain.PermBusLocationsRead}))
+			return
+		}
+
 		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
-			c.String(http.StatusInternalServerError, "WebSocket error: %v", err)
+			// Upgrade ya respondió con el error (p. ej. 403 por el origen).
+			return
+		}
+		if !hub.Register(conn, p.Scope()) {
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "servidor detenido")
+			_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWriteWait))
+			conn.Close()
 			return
 		}
-		hub.register <- conn
-		defer func() { hub.unregister <- conn }()
+		defer hub.Unregister(conn)
 
 		// Simplemente mantenemos viva la conexión:
 		for {
@@ -29,3 +119,12 @@ func WebsocketHandler(hub *Hub) gin.HandlerFunc {
 		}
 	}
 }
+
+// NewWebsocketRouter crea un router que solo sirve el endpoint de WebSocket.
+// Se usa cuando el WebSocket se configura en una dirección distinta a la del API.
+func NewWebsocketRouter(cfg *config.Config, authService *application.AuthService, hub *Hub) *gin.Engine {
+	r := gin.Default()
+	r.Use(ErrorMiddleware())
+	r.GET(cfg.WS.Path, WebsocketHandler(hub, authService, cfg.CORS.AllowOrigins))
+	return r
+}
@@ -2,51 +2,225 @@
 package delivery
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
 
 	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// Hub mantiene el conjunto de conexiones activas y un canal de broadcast.
+// Hub mantiene el conjunto de conexiones activas, cada una con el Scope del
+// usuario que la abrió, y un canal de broadcast. Cada mensaje pertenece a una
+// compañía y solo se envía a las conexiones cuyo Scope la permite.
+//
+// Cada conexión tiene su propia cola de salida y una goroutine que escribe en
+// ella, de modo que un cliente lento no retrasa a los demás: si su cola se
+// llena, se le desconecta.
 type Hub struct {
-	clients    map[*websocket.Conn]bool
-	broadcast  chan []byte
-	register   chan *websocket.Conn
+	clients    map[*websocket.Conn]*hubClient
+	broadcast  chan hubMessage
+	register   chan *hubClient
 	unregister chan *websocket.Conn
 	mu         sync.Mutex
+
+	done     chan struct{} // se cierra para pedir que el loop termine
+	stopped  chan struct{} // se cierra cuando el loop terminó
+	stopOnce sync.Once
+	runOnce  sync.Once
+	running  atomic.Bool
+}
+
+// hubMessage es un mensaje para los clientes de una compañía.
+type hubMessage struct {
+	companiaID primitive.ObjectID
+	data       []byte
+}
+
+// hubClient es una conexión junto con el Scope de su usuario y su cola de
+// salida, que se cierra al retirarla del hub.
+type hubClient struct {
+	conn  *websocket.Conn
+	scope domain.Scope
+	send  chan []byte
 }
 
+// hubBroadcastBuffer es la capacidad del canal de broadcast. Permite absorber
+// ráfagas de mensajes MQTT sin bloquear el hook del broker.
+const hubBroadcastBuffer = 256
+
+// Límites de la escritura a cada cliente.
+const (
+	clientSendBuffer = 64               // mensajes pendientes por cliente antes de desconectarlo
+	writeWait        = 10 * time.Second // plazo para escribir cada mensaje
+	closeWriteWait   = time.Second      // plazo para enviar el close frame
+)
+
 // NewHub crea un nuevo Hub.
 func NewHub() *Hub {
 	return &Hub{
-		clients:    make(map[*websocket.Conn]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *websocket.Conn),
+		clients:    make(map[*websocket.Conn]*hubClient),
+		broadcast:  make(chan hubMessage, hubBroadcastBuffer),
+		register:   make(chan *hubClient),
 		unregister: make(chan *websocket.Conn),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
 	}
 }
 
-// Run arranca el loop del hub.
+// Run arranca el loop del hub. Termina cuando se llama a Shutdown.
 func (h *Hub) Run() {
+	started := false
+	h.runOnce.Do(func() { started = true })
+	if !started {
+		return
+	}
+	defer close(h.stopped)
+	h.running.Store(true)
+	defer h.running.Store(false)
+
 	for {
 		select {
-		case conn := <-h.register:
+		case cl := <-h.register:
 			h.mu.Lock()
-			h.clients[conn] = true
+			h.clients[cl.conn] = cl
 			h.mu.Unlock()
+			go cl.writeLoop()
 		case conn := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[conn]; ok {
-				delete(h.clients, conn)
-				conn.Close()
+			if cl, ok := h.clients[conn]; ok {
+				h.remove(cl)
 			}
 			h.mu.Unlock()
 		case msg := <-h.broadcast:
 			h.mu.Lock()
-			for c := range h.clients {
-				_ = c.WriteMessage(websocket.TextMessage, msg)
+			for _, cl := range h.clients {
+				if !cl.scope.Allows(msg.companiaID) {
+					continue
+				}
+				select {
+				case cl.send <- msg.data:
+				default:
+					// El cliente no lee al ritmo de los mensajes.
+					h.remove(cl)
+				}
 			}
 			h.mu.Unlock()
+		case <-h.done:
+			h.closeAll()
+			return
+		}
+	}
+}
+
+// Running indica si el loop del hub está en ejecución.
+func (h *Hub) Running() bool {
+	return h.running.Load()
+}
+
+// ClientCount retorna el número de clientes WebSocket conectados.
+func (h *Hub) ClientCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// Register agrega una conexión al hub; solo recibirá los mensajes de las
+// compañías que permite scope. Retorna false si el hub se está deteniendo.
+func (h *Hub) Register(conn *websocket.Conn, scope domain.Scope) bool {
+	select {
+	case h.register <- &hubClient{conn: conn, scope: scope, send: make(chan []byte, clientSendBuffer)}:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// Publish encola un mensaje de la compañía indicada sin bloquear. Retorna
+// false si el canal de broadcast está lleno y el mensaje se descartó.
+func (h *Hub) Publish(companiaID primitive.ObjectID, data []byte) bool {
+	select {
+	case h.broadcast <- hubMessage{companiaID: companiaID, data: data}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Unregister retira una conexión del hub y la cierra.
+func (h *Hub) Unregister(conn *websocket.Conn) {
+	select {
+	case h.unregister <- conn:
+	case <-h.done:
+		// El hub ya cerró (o está cerrando) todas las conexiones.
+	}
+}
+
+// Shutdown envía un close frame (1001 Going Away) a todos los clientes,
+// cierra sus conexiones y detiene el loop. Espera a que el loop termine o a
+// que venza ctx.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.stopOnce.Do(func() { close(h.done) })
+
+	started := true
+	h.runOnce.Do(func() { started = false })
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-h.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// remove retira al cliente del hub, cierra su cola y su conexión. Debe
+// llamarse con h.mu tomado.
+func (h *Hub) remove(cl *hubClient) {
+	delete(h.clients, cl.conn)
+	close(cl.send)
+	cl.conn.Close()
+}
+
+// closeAll despide a todos los clientes con un close frame y los desconecta.
+// Los close frames se envían en paralelo para que un cliente que no lee no
+// retrase al resto.
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "servidor detenido")
+	var wg sync.WaitGroup
+	for _, cl := range h.clients {
+		wg.Add(1)
+		go func(c *websocket.Conn) {
+			defer wg.Done()
+			_ = c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWriteWait))
+		}(cl.conn)
+	}
+	wg.Wait()
+	for _, cl := range h.clients {
+		h.remove(cl)
+	}
+}
+
+// writeLoop escribe en la conexión los mensajes de la cola del cliente hasta
+// que se cierra. Si una escritura falla o vence writeWait cierra la
+// conexión; el handler del WebSocket lo detecta al leer y la retira del hub.
+func (cl *hubClient) writeLoop() {
+	for data := range cl.send {
+		_ = cl.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := cl.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			cl.conn.Close()
+			// Vacía la cola hasta que el hub la cierre.
+			for range cl.send {
+			}
+			return
 		}
 	}
 }
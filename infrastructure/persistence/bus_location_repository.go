@@ -0,0 +1,180 @@
+package persistence
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BusLocationRepository implementa domain.BusLocationRepository sobre la colección "BusLocations".
+type BusLocationRepository struct {
+	coll *mongo.Collection
+}
+
+// NewBusLocationRepository crea un BusLocationRepository.
+func NewBusLocationRepository(db *mongo.Database) *BusLocationRepository {
+	return &BusLocationRepository{coll: db.Collection(busLocationsCollection)}
+}
+
+// Create inserta una nueva localización de bus. La compañía debe venir
+// asignada por el servicio a partir del bus.
+func (r *BusLocationRepository) Create(ctx context.Context, bl *domain.BusLocation) error {
+	// Asignar metadatos
+	bl.ID = primitive.NewObjectID()
+	bl.CreatedAt = time.Now()
+
+	// Insertar documento
+	if _, err := r.coll.InsertOne(ctx, bl); err != nil {
+		log.Println("Error al insertar bus location:", err)
+		return mongoError(err)
+	}
+	return nil
+}
+
+// CreateMany inserta un lote de localizaciones con un único InsertMany no
+// ordenado, de modo que un documento inválido no impide guardar el resto.
+func (r *BusLocationRepository) CreateMany(ctx context.Context, bls []domain.BusLocation) error {
+	if len(bls) == 0 {
+		return nil
+	}
+	now := time.Now()
+	docs := make([]interface{}, len(bls))
+	for i := range bls {
+		if bls[i].ID.IsZero() {
+			bls[i].ID = primitive.NewObjectID()
+		}
+		if bls[i].CreatedAt.IsZero() {
+			bls[i].CreatedAt = now
+		}
+		docs[i] = bls[i]
+	}
+	if _, err := r.coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil {
+		log.Println("Error al insertar lote de bus locations:", err)
+		return mongoError(err)
+	}
+	return nil
+}
+
+// List retorna una página de las localizaciones visibles en el Scope.
+func (r *BusLocationRepository) List(ctx context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.BusLocation], error) {
+	return findPage[domain.BusLocation](ctx, r.coll, scope.Filter(bson.M{}, "compania"), q, "bus locations")
+}
+
+// Latest retorna la última localización de cada bus visible en el Scope. El
+// orden coincide (invertido) con el índice {bus_id, created_at}, de modo que
+// MongoDB puede tomar la primera de cada grupo sin recorrer todo el historial.
+// Solo se usa al arrancar, para reconstruir la flota en vivo; se permite usar
+// disco por si el historial no cabe en el límite de memoria de la agregación.
+func (r *BusLocationRepository) Latest(ctx context.Context, scope domain.Scope) ([]domain.BusLocation, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: scope.Filter(bson.M{}, "compania")}},
+		{{Key: "$sort", Value: bson.D{{Key: "bus_id", Value: -1}, {Key: "created_at", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$bus_id", "doc": bson.M{"$first": "$$ROOT"}}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$doc"}}},
+	}
+	cursor, err := r.coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		log.Println("Error al obtener las últimas localizaciones:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	locations := make([]domain.BusLocation, 0)
+	if err := cursor.All(ctx, &locations); err != nil {
+		log.Println("Error al decodificar las últimas localizaciones:", err)
+		return nil, err
+	}
+	return locations, nil
+}
+
+// History retorna el recorrido del bus entre from y to ordenado por
+// created_at. Primero cuenta las posiciones del intervalo para calcular cada
+// cuántas se conserva una, y luego las recorre con un cursor sin cargarlas
+// todas en memoria.
+func (r *BusLocationRepository) History(ctx context.Context, scope domain.Scope, busID primitive.ObjectID, from, to time.Time, maxPoints int) (*domain.LocationHistory, error) {
+	filter := scope.Filter(bson.M{
+		"bus_id":     busID,
+		"created_at": bson.M{"$gte": from, "$lte": to},
+	}, "compania")
+
+	total, err := r.coll.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println("Error al contar el historial del bus:", err)
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.coll.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println("Error al obtener el historial del bus:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sampler := domain.NewSampler[domain.BusLocation](total, maxPoints)
+	for cursor.Next(ctx) {
+		var bl domain.BusLocation
+		if err := cursor.Decode(&bl); err != nil {
+			log.Println("Decode error:", err)
+			continue
+		}
+		sampler.Add(bl)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return &domain.LocationHistory{BusID: busID, From: from, To: to, Total: total, Points: sampler.Points()}, nil
+}
+
+// Scan recorre las localizaciones con created_at en [from, to) ordenadas por
+// bus y fecha. El orden puede requerir ordenar en disco si el intervalo es grande.
+func (r *BusLocationRepository) Scan(ctx context.Context, from, to time.Time, fn func(domain.BusLocation) error) error {
+	filter := bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "bus_id", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetAllowDiskUse(true)
+	cursor, err := r.coll.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println("Error al recorrer bus locations:", err)
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var bl domain.BusLocation
+		if err := cursor.Decode(&bl); err != nil {
+			log.Println("Decode error:", err)
+			continue
+		}
+		if err := fn(bl); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Delete elimina una localización por su ID dentro del Scope.
+// Retorna domain.ErrNotFound si no existe o pertenece a otra compañía.
+func (r *BusLocationRepository) Delete(ctx context.Context, scope domain.Scope, id primitive.ObjectID) error {
+	res, err := r.coll.DeleteOne(ctx, scope.Filter(bson.M{"_id": id}, "compania"))
+	if err != nil {
+		log.Println("Error al eliminar bus location:", err)
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteByCompania elimina todas las localizaciones de la compañía indicada.
+func (r *BusLocationRepository) DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return deleteMany(ctx, r.coll, bson.M{"compania": companiaID}, "bus locations")
+}
@@ -0,0 +1,130 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BusLocationRollupRepository implementa domain.BusLocationRollupRepository
+// sobre la colección "BusLocationRollups".
+type BusLocationRollupRepository struct {
+	coll *mongo.Collection
+}
+
+// NewBusLocationRollupRepository crea un BusLocationRollupRepository.
+func NewBusLocationRollupRepository(db *mongo.Database) *BusLocationRollupRepository {
+	return &BusLocationRollupRepository{coll: db.Collection(rollupsCollection)}
+}
+
+// Upsert reemplaza (o inserta) el resumen de cada bus y minuto con un único
+// BulkWrite no ordenado.
+func (r *BusLocationRollupRepository) Upsert(ctx context.Context, rollups []domain.BusLocationRollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(rollups))
+	for _, ru := range rollups {
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"bus_id": ru.BusID, "minuto": ru.Minuto}).
+			SetReplacement(ru).
+			SetUpsert(true))
+	}
+	if _, err := r.coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		log.Println("Error al guardar resúmenes de bus locations:", err)
+		return mongoError(err)
+	}
+	return nil
+}
+
+// LastMinute retorna el minuto del resumen más reciente.
+func (r *BusLocationRollupRepository) LastMinute(ctx context.Context) (time.Time, error) {
+	var ru domain.BusLocationRollup
+	opts := options.FindOne().SetSort(bson.D{{Key: "minuto", Value: -1}}).SetProjection(bson.M{"minuto": 1})
+	err := r.coll.FindOne(ctx, bson.M{}, opts).Decode(&ru)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ru.Minuto, nil
+}
+
+// History retorna el recorrido del bus a partir de los resúmenes de los
+// minutos entre from y to. Primero cuenta los puntos para calcular cada
+// cuántos se conserva uno, y luego recorre los resúmenes con un cursor.
+func (r *BusLocationRollupRepository) History(ctx context.Context, scope domain.Scope, busID primitive.ObjectID, from, to time.Time, maxPoints int) (*domain.LocationHistory, error) {
+	filter := scope.Filter(bson.M{
+		"bus_id": busID,
+		"minuto": bson.M{"$gte": from.Truncate(time.Minute), "$lte": to},
+	}, "compania")
+
+	var counts struct {
+		Puntos   int64 `bson:"puntos"`
+		Cantidad int64 `bson:"cantidad"`
+	}
+	cursor, err := r.coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      nil,
+			"puntos":   bson.M{"$sum": bson.M{"$size": "$puntos"}},
+			"cantidad": bson.M{"$sum": "$cantidad"},
+		}}},
+	})
+	if err != nil {
+		log.Println("Error al contar los resúmenes del bus:", err)
+		return nil, err
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&counts); err != nil {
+			cursor.Close(ctx)
+			return nil, err
+		}
+	}
+	cursor.Close(ctx)
+
+	history := &domain.LocationHistory{BusID: busID, From: from, To: to, Total: counts.Cantidad, Points: []domain.BusLocation{}}
+	if counts.Puntos == 0 {
+		return history, nil
+	}
+
+	cursor, err = r.coll.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "minuto", Value: 1}}))
+	if err != nil {
+		log.Println("Error al obtener los resúmenes del bus:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sampler := domain.NewSampler[domain.BusLocation](counts.Puntos, maxPoints)
+	for cursor.Next(ctx) {
+		var ru domain.BusLocationRollup
+		if err := cursor.Decode(&ru); err != nil {
+			log.Println("Decode error:", err)
+			continue
+		}
+		for _, bl := range ru.Locations() {
+			if !bl.CreatedAt.Before(from) && !bl.CreatedAt.After(to) {
+				sampler.Add(bl)
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	history.Points = sampler.Points()
+	return history, nil
+}
+
+// DeleteByCompania elimina todos los resúmenes de la compañía indicada.
+func (r *BusLocationRollupRepository) DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return deleteMany(ctx, r.coll, bson.M{"compania": companiaID}, "bus location rollups")
+}
@@ -0,0 +1,208 @@
+package persistence
+
+import (
+	"context"
+	"log"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BusRepository implementa domain.BusRepository sobre la colección "buses".
+type BusRepository struct {
+	coll *mongo.Collection
+}
+
+// NewBusRepository crea un BusRepository.
+func NewBusRepository(db *mongo.Database) *BusRepository {
+	return &BusRepository{coll: db.Collection(busesCollection)}
+}
+
+// Create inserta un nuevo documento en la colección "buses".
+func (r *BusRepository) Create(ctx context.Context, bus *domain.Bus) error {
+	bus.ID = primitive.NewObjectID()
+
+	_, err := r.coll.InsertOne(ctx, bus)
+	if err != nil {
+		log.Println("Error al insertar bus:", err)
+		return mongoError(err)
+	}
+	return nil
+}
+
+// Update actualiza los campos no vacíos de un Bus existente dentro del Scope
+// y retorna el documento actualizado.
+func (r *BusRepository) Update(ctx context.Context, scope domain.Scope, bus *domain.Bus) (*domain.Bus, error) {
+	updateFields := bson.M{}
+
+	if bus.Placa != "" {
+		updateFields["placa"] = bus.Placa
+	}
+	if !bus.ConductorID.IsZero() {
+		updateFields["conductor"] = bus.ConductorID
+	}
+	if !bus.RutaID.IsZero() {
+		updateFields["ruta"] = bus.RutaID
+	}
+	if !bus.FechaInicio.IsZero() {
+		updateFields["fecha_inicio"] = bus.FechaInicio
+	}
+	if !bus.FechaFin.IsZero() {
+		updateFields["fecha_fin"] = bus.FechaFin
+	}
+	if !bus.CompaniaID.IsZero() {
+		updateFields["compania"] = bus.CompaniaID
+	}
+
+	// Si no hay nada que actualizar, devolvemos el documento existente
+	if len(updateFields) == 0 {
+		var existing domain.Bus
+		if err := r.coll.FindOne(ctx, scope.Filter(bson.M{"_id": bus.ID}, "compania")).Decode(&existing); err != nil {
+			log.Println("Bus no encontrado:", err)
+			return nil, mongoError(err)
+		}
+		return &existing, nil
+	}
+
+	filter := scope.Filter(bson.M{"_id": bus.ID}, "compania")
+	update := bson.M{"$set": updateFields}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated domain.Bus
+	if err := r.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		if err == mongo.ErrNoDocuments {
+			log.Println("Bus no encontrado")
+		} else {
+			log.Println("Error al editar bus:", err)
+		}
+		return nil, mongoError(err)
+	}
+
+	return &updated, nil
+}
+
+// List retorna una página de los buses visibles en el Scope.
+func (r *BusRepository) List(ctx context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.Bus], error) {
+	return findPage[domain.Bus](ctx, r.coll, scope.Filter(bson.M{}, "compania"), q, "buses")
+}
+
+// GetByID busca un bus por su ObjectID dentro del Scope.
+func (r *BusRepository) GetByID(ctx context.Context, scope domain.Scope, id primitive.ObjectID) (*domain.Bus, error) {
+	var b domain.Bus
+	if err := r.coll.FindOne(ctx, scope.Filter(bson.M{"_id": id}, "compania")).Decode(&b); err != nil {
+		log.Println("Bus no encontrado:", err)
+		return nil, mongoError(err)
+	}
+	return &b, nil
+}
+
+// GetByIDs retorna los buses del Scope con los IDs indicados en una sola consulta.
+func (r *BusRepository) GetByIDs(ctx context.Context, scope domain.Scope, ids []primitive.ObjectID) ([]domain.Bus, error) {
+	out := make([]domain.Bus, 0, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	cursor, err := r.coll.Find(ctx, scope.Filter(bson.M{"_id": bson.M{"$in": ids}}, "compania"))
+	if err != nil {
+		log.Println("Error al buscar buses por ID:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &out); err != nil {
+		log.Println("Error al decodificar buses:", err)
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetByPlaca retorna todos los buses cuya placa coincide exactamente.
+func (r *BusRepository) GetByPlaca(ctx context.Context, scope domain.Scope, placa string) ([]domain.Bus, error) {
+	cursor, err := r.coll.Find(ctx, scope.Filter(bson.M{"placa": placa}, "compania"))
+	if err != nil {
+		log.Println("Error al buscar buses por placa:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []domain.Bus
+	for cursor.Next(ctx) {
+		var b domain.Bus
+		if err := cursor.Decode(&b); err != nil {
+			log.Println("Error al decodificar bus:", err)
+			continue
+		}
+		out = append(out, b)
+	}
+	if err := cursor.Err(); err != nil {
+		log.Println("Cursor error en búsqueda de buses:", err)
+		return nil, err
+	}
+	return out, nil
+}
+
+// Delete elimina un bus por su ObjectID dentro del Scope.
+// Retorna domain.ErrNotFound si no existe o pertenece a otra compañía.
+func (r *BusRepository) Delete(ctx context.Context, scope domain.Scope, id primitive.ObjectID) error {
+	res, err := r.coll.DeleteOne(ctx, scope.Filter(bson.M{"_id": id}, "compania"))
+	if err != nil {
+		log.Println("Error al eliminar bus:", err)
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+// CountByRuta cuenta los buses de cualquier compañía que tienen asignada la ruta.
+func (r *BusRepository) CountByRuta(ctx context.Context, rutaID primitive.ObjectID) (int64, error) {
+	n, err := r.coll.CountDocuments(ctx, bson.M{"ruta": rutaID})
+	if err != nil {
+		log.Println("Error al contar buses por ruta:", err)
+		return 0, err
+	}
+	return n, nil
+}
+
+// CountByConductor cuenta los buses de cualquier compañía conducidos por alguno de los usuarios.
+func (r *BusRepository) CountByConductor(ctx context.Context, conductorIDs []primitive.ObjectID) (int64, error) {
+	if len(conductorIDs) == 0 {
+		return 0, nil
+	}
+	n, err := r.coll.CountDocuments(ctx, bson.M{"conductor": bson.M{"$in": conductorIDs}})
+	if err != nil {
+		log.Println("Error al contar buses por conductor:", err)
+		return 0, err
+	}
+	return n, nil
+}
+
+// UnassignRuta elimina el campo "ruta" de todos los buses que tienen asignada la ruta.
+func (r *BusRepository) UnassignRuta(ctx context.Context, rutaID primitive.ObjectID) (int64, error) {
+	res, err := r.coll.UpdateMany(ctx, bson.M{"ruta": rutaID}, bson.M{"$unset": bson.M{"ruta": ""}})
+	if err != nil {
+		log.Println("Error al desasignar ruta de los buses:", err)
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// UnassignConductor elimina el campo "conductor" de todos los buses que lo tienen asignado.
+func (r *BusRepository) UnassignConductor(ctx context.Context, conductorID primitive.ObjectID) (int64, error) {
+	res, err := r.coll.UpdateMany(ctx, bson.M{"conductor": conductorID}, bson.M{"$unset": bson.M{"conductor": ""}})
+	if err != nil {
+		log.Println("Error al desasignar conductor de los buses:", err)
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// DeleteByCompania elimina todos los buses de la compañía indicada.
+func (r *BusRepository) DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return deleteMany(ctx, r.coll, bson.M{"compania": companiaID}, "buses")
+}
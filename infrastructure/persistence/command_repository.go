@@ -0,0 +1,119 @@
+package persistence
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// openCommand es el filtro de los comandos pendientes o enviados.
+var openCommand = bson.M{"$in": bson.A{domain.CommandPending, domain.CommandSent}}
+
+// CommandRepository implementa domain.CommandRepository sobre la colección "commands".
+type CommandRepository struct {
+	coll *mongo.Collection
+}
+
+// NewCommandRepository crea un CommandRepository.
+func NewCommandRepository(db *mongo.Database) *CommandRepository {
+	return &CommandRepository{coll: db.Collection(commandsCollection)}
+}
+
+// Create inserta un comando.
+func (r *CommandRepository) Create(ctx context.Context, c *domain.Command) error {
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+	if _, err := r.coll.InsertOne(ctx, c); err != nil {
+		log.Println("Error al insertar comando:", err)
+		return mongoError(err)
+	}
+	return nil
+}
+
+// GetByID busca un comando por su ObjectID dentro del Scope.
+func (r *CommandRepository) GetByID(ctx context.Context, scope domain.Scope, id primitive.ObjectID) (*domain.Command, error) {
+	var c domain.Command
+	if err := r.coll.FindOne(ctx, scope.Filter(bson.M{"_id": id}, "compania")).Decode(&c); err != nil {
+		return nil, mongoError(err)
+	}
+	return &c, nil
+}
+
+// List retorna una página de los comandos visibles en el Scope.
+func (r *CommandRepository) List(ctx context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.Command], error) {
+	return findPage[domain.Command](ctx, r.coll, scope.Filter(bson.M{}, "compania"), q, "comandos")
+}
+
+// Open retorna los comandos abiertos del bus usando el índice {bus_id, created_at}.
+func (r *CommandRepository) Open(ctx context.Context, busID primitive.ObjectID) ([]domain.Command, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := r.coll.Find(ctx, bson.M{"bus_id": busID, "estado": openCommand}, opts)
+	if err != nil {
+		log.Println("Error al buscar los comandos abiertos:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	cmds := make([]domain.Command, 0)
+	if err := cursor.All(ctx, &cmds); err != nil {
+		log.Println("Error al decodificar los comandos abiertos:", err)
+		return nil, err
+	}
+	return cmds, nil
+}
+
+// MarkSent pasa el comando a enviado e incrementa sus intentos, salvo que ya
+// esté cerrado.
+func (r *CommandRepository) MarkSent(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	update := bson.M{
+		"$set": bson.M{"estado": domain.CommandSent, "sent_at": at},
+		"$inc": bson.M{"intentos": 1},
+	}
+	if _, err := r.coll.UpdateOne(ctx, bson.M{"_id": id, "estado": openCommand}, update); err != nil {
+		log.Println("Error al marcar el comando como enviado:", err)
+		return err
+	}
+	return nil
+}
+
+// Resolve cierra un comando abierto del bus y retorna el documento actualizado.
+func (r *CommandRepository) Resolve(ctx context.Context, busID, id primitive.ObjectID, estado, resultado string, at time.Time) (*domain.Command, error) {
+	set := bson.M{"estado": estado, "acked_at": at}
+	if resultado != "" {
+		set["resultado"] = resultado
+	}
+	filter := bson.M{"_id": id, "bus_id": busID, "estado": openCommand, "expires_at": bson.M{"$gte": at}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated domain.Command
+	if err := r.coll.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}, opts).Decode(&updated); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println("Error al cerrar el comando:", err)
+		}
+		return nil, mongoError(err)
+	}
+	return &updated, nil
+}
+
+// Expire marca como vencidos los comandos abiertos con expires_at anterior a now.
+func (r *CommandRepository) Expire(ctx context.Context, now time.Time) (int64, error) {
+	filter := bson.M{"estado": openCommand, "expires_at": bson.M{"$lt": now}}
+	res, err := r.coll.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"estado": domain.CommandExpired}})
+	if err != nil {
+		log.Println("Error al vencer comandos:", err)
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// DeleteByCompania elimina todos los comandos de la compañía indicada.
+func (r *CommandRepository) DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return deleteMany(ctx, r.coll, bson.M{"compania": companiaID}, "comandos")
+}
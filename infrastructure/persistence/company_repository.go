@@ -0,0 +1,131 @@
+package persistence
+
+import (
+	"context"
+	"log"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CompanyRepository implementa domain.CompanyRepository sobre la colección "Companias".
+// El Scope se aplica sobre el propio "_id" de la compañía.
+type CompanyRepository struct {
+	coll *mongo.Collection
+}
+
+// NewCompanyRepository crea un CompanyRepository.
+func NewCompanyRepository(db *mongo.Database) *CompanyRepository {
+	return &CompanyRepository{coll: db.Collection(companiesCollection)}
+}
+
+// Create inserta una nueva compañía en la colección "Companias".
+func (r *CompanyRepository) Create(ctx context.Context, comp *domain.Company) error {
+	comp.ID = primitive.NewObjectID()
+
+	_, err := r.coll.InsertOne(ctx, comp)
+	if err != nil {
+		log.Println("Error al insertar compañía:", err)
+		return mongoError(err)
+	}
+	return nil
+}
+
+// Update actualiza los campos no vacíos de una Company existente y devuelve el documento actualizado.
+func (r *CompanyRepository) Update(ctx context.Context, scope domain.Scope, comp *domain.Company) (*domain.Company, error) {
+	updateFields := bson.M{}
+	if comp.Nombre != "" {
+		updateFields["nombre"] = comp.Nombre
+	}
+	if comp.Descripcion != "" {
+		updateFields["descripcion"] = comp.Descripcion
+	}
+
+	// Si no hay campos para actualizar, devolvemos el documento existente
+	if len(updateFields) == 0 {
+		var existing domain.Company
+		if err := r.coll.FindOne(ctx, scope.Filter(bson.M{"_id": comp.ID}, "_id")).Decode(&existing); err != nil {
+			log.Println("Compañía no encontrada:", err)
+			return nil, mongoError(err)
+		}
+		return &existing, nil
+	}
+
+	filter := scope.Filter(bson.M{"_id": comp.ID}, "_id")
+	update := bson.M{"$set": updateFields}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated domain.Company
+	if err := r.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		if err == mongo.ErrNoDocuments {
+			log.Println("Compañía no encontrada")
+		} else {
+			log.Println("Error al editar compañía:", err)
+		}
+		return nil, mongoError(err)
+	}
+
+	return &updated, nil
+}
+
+// List retorna una página de las compañías de la colección "Companias" visibles en el Scope.
+func (r *CompanyRepository) List(ctx context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.Company], error) {
+	return findPage[domain.Company](ctx, r.coll, scope.Filter(bson.M{}, "_id"), q, "compañías")
+}
+
+// GetByID busca una compañía por su ObjectID dentro del Scope.
+func (r *CompanyRepository) GetByID(ctx context.Context, scope domain.Scope, id primitive.ObjectID) (*domain.Company, error) {
+	var comp domain.Company
+	if err := r.coll.FindOne(ctx, scope.Filter(bson.M{"_id": id}, "_id")).Decode(&comp); err != nil {
+		if err == mongo.ErrNoDocuments {
+			log.Println("Compañía no encontrada:", err)
+		}
+		return nil, mongoError(err)
+	}
+	return &comp, nil
+}
+
+// GetByName retorna todas las compañías cuyo nombre coincide exactamente.
+func (r *CompanyRepository) GetByName(ctx context.Context, scope domain.Scope, nombre string) ([]domain.Company, error) {
+	filter := scope.Filter(bson.M{"nombre": nombre}, "_id")
+	cursor, err := r.coll.Find(ctx, filter)
+	if err != nil {
+		log.Println("Error al buscar compañías por nombre:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var companies []domain.Company
+	for cursor.Next(ctx) {
+		var comp domain.Company
+		if err := cursor.Decode(&comp); err != nil {
+			log.Println("Error al decodificar compañía:", err)
+			continue
+		}
+		companies = append(companies, comp)
+	}
+	if err := cursor.Err(); err != nil {
+		log.Println("Cursor error en búsqueda de compañías:", err)
+		return nil, err
+	}
+
+	return companies, nil
+}
+
+// Delete elimina una compañía por su ObjectID.
+// Retorna domain.ErrNotFound si no existe.
+func (r *CompanyRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		log.Println("Error al eliminar compañía:", err)
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package persistence
+
+import (
+	"context"
+	"log"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ConnectivityEventRepository implementa domain.ConnectivityEventRepository
+// sobre la colección "connectivity_events".
+type ConnectivityEventRepository struct {
+	coll *mongo.Collection
+}
+
+// NewConnectivityEventRepository crea un ConnectivityEventRepository.
+func NewConnectivityEventRepository(db *mongo.Database) *ConnectivityEventRepository {
+	return &ConnectivityEventRepository{coll: db.Collection(connectivityCollection)}
+}
+
+// Create inserta un evento de conectividad.
+func (r *ConnectivityEventRepository) Create(ctx context.Context, e *domain.ConnectivityEvent) error {
+	if e.ID.IsZero() {
+		e.ID = primitive.NewObjectID()
+	}
+	if _, err := r.coll.InsertOne(ctx, e); err != nil {
+		log.Println("Error al insertar evento de conectividad:", err)
+		return err
+	}
+	return nil
+}
+
+// List retorna una página de los eventos visibles en el Scope.
+func (r *ConnectivityEventRepository) List(ctx context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.ConnectivityEvent], error) {
+	return findPage[domain.ConnectivityEvent](ctx, r.coll, scope.Filter(bson.M{}, "compania"), q, "eventos de conectividad")
+}
+
+// Latest retorna el último evento de cada bus. Como en las localizaciones, el
+// orden coincide (invertido) con el índice {bus_id, at}.
+func (r *ConnectivityEventRepository) Latest(ctx context.Context) ([]domain.ConnectivityEvent, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "bus_id", Value: -1}, {Key: "at", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$bus_id", "doc": bson.M{"$first": "$$ROOT"}}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$doc"}}},
+	}
+	cursor, err := r.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println("Error al obtener los últimos eventos de conectividad:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := make([]domain.ConnectivityEvent, 0)
+	if err := cursor.All(ctx, &events); err != nil {
+		log.Println("Error al decodificar los últimos eventos de conectividad:", err)
+		return nil, err
+	}
+	return events, nil
+}
+
+// DeleteByCompania elimina todos los eventos de la compañía indicada.
+func (r *ConnectivityEventRepository) DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return deleteMany(ctx, r.coll, bson.M{"compania": companiaID}, "eventos de conectividad")
+}
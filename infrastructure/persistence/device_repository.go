@@ -0,0 +1,126 @@
+package persistence
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeviceRepository implementa domain.DeviceRepository sobre la colección "devices".
+type DeviceRepository struct {
+	coll *mongo.Collection
+}
+
+// NewDeviceRepository crea un DeviceRepository.
+func NewDeviceRepository(db *mongo.Database) *DeviceRepository {
+	return &DeviceRepository{coll: db.Collection(devicesCollection)}
+}
+
+// Create inserta un dispositivo. Un serial o client_id repetido retorna
+// domain.ErrConflict.
+func (r *DeviceRepository) Create(ctx context.Context, d *domain.Device) error {
+	if d.ID.IsZero() {
+		d.ID = primitive.NewObjectID()
+	}
+	d.CreatedAt = time.Now()
+
+	if _, err := r.coll.InsertOne(ctx, d); err != nil {
+		log.Println("Error al insertar dispositivo:", err)
+		return mongoError(err)
+	}
+	return nil
+}
+
+// GetByID busca un dispositivo por su ObjectID dentro del Scope.
+func (r *DeviceRepository) GetByID(ctx context.Context, scope domain.Scope, id primitive.ObjectID) (*domain.Device, error) {
+	var d domain.Device
+	if err := r.coll.FindOne(ctx, scope.Filter(bson.M{"_id": id}, "compania")).Decode(&d); err != nil {
+		return nil, mongoError(err)
+	}
+	return &d, nil
+}
+
+// GetByClientID busca un dispositivo por su client_id.
+func (r *DeviceRepository) GetByClientID(ctx context.Context, clientID string) (*domain.Device, error) {
+	var d domain.Device
+	if err := r.coll.FindOne(ctx, bson.M{"client_id": clientID}).Decode(&d); err != nil {
+		return nil, mongoError(err)
+	}
+	return &d, nil
+}
+
+// List retorna una página de los dispositivos visibles en el Scope.
+func (r *DeviceRepository) List(ctx context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.Device], error) {
+	return findPage[domain.Device](ctx, r.coll, scope.Filter(bson.M{}, "compania"), q, "dispositivos")
+}
+
+// Update actualiza los campos no vacíos de un dispositivo existente dentro
+// del Scope y retorna el documento actualizado.
+func (r *DeviceRepository) Update(ctx context.Context, scope domain.Scope, d *domain.Device) (*domain.Device, error) {
+	updateFields := bson.M{}
+
+	if d.Serial != "" {
+		updateFields["serial"] = d.Serial
+	}
+	if d.IMEI != "" {
+		updateFields["imei"] = d.IMEI
+	}
+	if d.Firmware != "" {
+		updateFields["firmware"] = d.Firmware
+	}
+	if d.Estado != "" {
+		updateFields["estado"] = d.Estado
+	}
+	if !d.BusID.IsZero() {
+		updateFields["bus_id"] = d.BusID
+	}
+	if !d.CompaniaID.IsZero() {
+		updateFields["compania"] = d.CompaniaID
+	}
+	if d.SecretHash != "" {
+		updateFields["secret_hash"] = d.SecretHash
+	}
+	if d.DecommissionedAt != nil {
+		updateFields["decommissioned_at"] = d.DecommissionedAt
+	}
+
+	filter := scope.Filter(bson.M{"_id": d.ID}, "compania")
+	var updated domain.Device
+	if len(updateFields) == 0 {
+		if err := r.coll.FindOne(ctx, filter).Decode(&updated); err != nil {
+			return nil, mongoError(err)
+		}
+		return &updated, nil
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := r.coll.FindOneAndUpdate(ctx, filter, bson.M{"$set": updateFields}, opts).Decode(&updated); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println("Error al editar dispositivo:", err)
+		}
+		return nil, mongoError(err)
+	}
+	return &updated, nil
+}
+
+// Touch actualiza last_seen con $max, de modo que una fecha anterior no
+// sobrescribe una más reciente.
+func (r *DeviceRepository) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	if _, err := r.coll.UpdateByID(ctx, id, bson.M{"$max": bson.M{"last_seen": at}}); err != nil {
+		log.Println("Error al actualizar last_seen del dispositivo:", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteByCompania elimina todos los dispositivos de la compañía indicada.
+func (r *DeviceRepository) DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return deleteMany(ctx, r.coll, bson.M{"compania": companiaID}, "dispositivos")
+}
@@ -0,0 +1,76 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// listIndexes son los índices que usan los listados paginados y el historial
+// de localizaciones: el filtro por compañía (Scope) o por bus seguido de los
+// campos de orden más comunes. Los índices 2dsphere permiten las consultas
+// geoespaciales sobre las localizaciones y los waypoints de las rutas. El
+// índice sobre created_at de las localizaciones lo crea EnsureRetention con TTL.
+var listIndexes = map[string][]bson.D{
+	busLocationsCollection: {
+		{{Key: "compania", Value: 1}, {Key: "created_at", Value: 1}},
+		{{Key: "bus_id", Value: 1}, {Key: "created_at", Value: 1}},
+		{{Key: "localizacion", Value: "2dsphere"}},
+	},
+	busesCollection: {{{Key: "compania", Value: 1}, {Key: "placa", Value: 1}}},
+	usersCollection: {{{Key: "compania", Value: 1}, {Key: "nombre", Value: 1}}},
+	routesCollection: {
+		{{Key: "compania", Value: 1}, {Key: "nombre", Value: 1}},
+		{{Key: "waypoints.ubicacion", Value: "2dsphere"}},
+	},
+	devicesCollection: {
+		{{Key: "compania", Value: 1}, {Key: "serial", Value: 1}},
+		{{Key: "bus_id", Value: 1}},
+	},
+	connectivityCollection: {
+		{{Key: "bus_id", Value: 1}, {Key: "at", Value: 1}},
+		{{Key: "compania", Value: 1}, {Key: "at", Value: 1}},
+	},
+	commandsCollection: {
+		{{Key: "bus_id", Value: 1}, {Key: "created_at", Value: 1}},
+		{{Key: "compania", Value: 1}, {Key: "created_at", Value: 1}},
+		{{Key: "estado", Value: 1}, {Key: "expires_at", Value: 1}},
+	},
+}
+
+// uniqueIndexes son los índices únicos: cada bus tiene un solo resumen por
+// minuto y cada dispositivo un serial y un client_id distintos.
+var uniqueIndexes = map[string][]bson.D{
+	rollupsCollection: {{{Key: "bus_id", Value: 1}, {Key: "minuto", Value: 1}}},
+	devicesCollection: {
+		{{Key: "serial", Value: 1}},
+		{{Key: "client_id", Value: 1}},
+	},
+}
+
+// EnsureIndexes crea los índices de las colecciones si no existen. Es
+// idempotente, por lo que se puede llamar en cada arranque.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	for coll, keys := range listIndexes {
+		models := make([]mongo.IndexModel, 0, len(keys))
+		for _, k := range keys {
+			models = append(models, mongo.IndexModel{Keys: k})
+		}
+		if _, err := db.Collection(coll).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("índices de %s: %w", coll, err)
+		}
+	}
+	for coll, keys := range uniqueIndexes {
+		models := make([]mongo.IndexModel, 0, len(keys))
+		for _, k := range keys {
+			models = append(models, mongo.IndexModel{Keys: k, Options: options.Index().SetUnique(true)})
+		}
+		if _, err := db.Collection(coll).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("índices únicos de %s: %w", coll, err)
+		}
+	}
+	return nil
+}
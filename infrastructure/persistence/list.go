@@ -0,0 +1,115 @@
+package persistence
+
+import (
+	"context"
+	"log"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// findPage ejecuta un listado paginado sobre coll. base es el filtro propio
+// del repositorio (normalmente el del Scope), al que se suman los filtros de
+// la ListQuery. El orden y el límite se delegan a MongoDB; se pide un
+// documento de más para saber si existe una página siguiente. El total solo
+// se cuenta en la primera página.
+func findPage[T any](ctx context.Context, coll *mongo.Collection, base bson.M, q domain.ListQuery, what string) (*domain.Page[T], error) {
+	filter := listFilter(base, q.Filters)
+	page := &domain.Page[T]{Items: make([]T, 0)}
+	if q.After == nil {
+		total, err := coll.CountDocuments(ctx, filter)
+		if err != nil {
+			log.Printf("Error al contar %s: %v", what, err)
+			return nil, err
+		}
+		page.Total = &total
+	} else {
+		filter = bson.M{"$and": bson.A{filter, afterFilter(q)}}
+	}
+	opts := options.Find().SetSort(listSort(q)).SetLimit(int64(q.Limit) + 1)
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Error al listar %s: %v", what, err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var last bson.Raw
+	for n := 0; cursor.Next(ctx); n++ {
+		if n == q.Limit {
+			page.Next = q.CursorAt(last).Encode()
+			break
+		}
+		// cursor.Current solo es válido hasta la siguiente llamada a Next.
+		last = append(last[:0], cursor.Current...)
+
+		var v T
+		if err := cursor.Decode(&v); err != nil {
+			log.Printf("Error al decodificar %s: %v", what, err)
+			continue
+		}
+		page.Items = append(page.Items, v)
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("Cursor error en %s: %v", what, err)
+		return nil, err
+	}
+	return page, nil
+}
+
+// listFilter combina el filtro base con los filtros de un listado.
+func listFilter(base bson.M, filters []domain.Filter) bson.M {
+	and := bson.A{base}
+	for _, f := range filters {
+		switch f.Op {
+		case domain.OpGte:
+			and = append(and, bson.M{f.Field: bson.M{"$gte": f.Value}})
+		case domain.OpLte:
+			and = append(and, bson.M{f.Field: bson.M{"$lte": f.Value}})
+		case domain.OpWithin:
+			// MongoDB une los vértices del polígono con geodésicas, igual que
+			// BoundingBox.Contains en el repositorio en memoria.
+			box, _ := f.Value.(domain.BoundingBox)
+			and = append(and, bson.M{f.Field: bson.M{"$geoWithin": bson.M{
+				"$geometry": bson.M{"type": "Polygon", "coordinates": bson.A{box.Polygon()}},
+			}}})
+		default:
+			and = append(and, bson.M{f.Field: f.Value})
+		}
+	}
+	return bson.M{"$and": and}
+}
+
+// listSort ordena por el campo de la ListQuery y desempata por _id en el
+// mismo sentido, de modo que el orden sea total y el cursor no repita ni
+// salte documentos.
+func listSort(q domain.ListQuery) bson.D {
+	dir := 1
+	if q.Desc {
+		dir = -1
+	}
+	sort := bson.D{{Key: q.SortField(), Value: dir}}
+	if q.SortField() != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: dir})
+	}
+	return sort
+}
+
+// afterFilter selecciona los documentos posteriores al cursor de la ListQuery
+// en el orden de listSort.
+func afterFilter(q domain.ListQuery) bson.M {
+	op := "$gt"
+	if q.Desc {
+		op = "$lt"
+	}
+	if q.SortField() == "_id" {
+		return bson.M{"_id": bson.M{op: q.After.ID}}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{q.Sort: bson.M{op: q.After.Value}},
+		bson.M{q.Sort: q.After.Value, "_id": bson.M{op: q.After.ID}},
+	}}
+}
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BusLocationRepository implementa domain.BusLocationRepository en memoria.
+type BusLocationRepository struct {
+	t *table[domain.BusLocation]
+}
+
+// NewBusLocationRepository crea un BusLocationRepository vacío.
+func NewBusLocationRepository() *BusLocationRepository {
+	return &BusLocationRepository{t: newTable(func(bl *domain.BusLocation) primitive.ObjectID { return bl.ID }, nil)}
+}
+
+func (r *BusLocationRepository) Create(_ context.Context, bl *domain.BusLocation) error {
+	bl.ID = primitive.NewObjectID()
+	bl.CreatedAt = time.Now()
+	r.t.insert(*bl)
+	return nil
+}
+
+func (r *BusLocationRepository) CreateMany(_ context.Context, bls []domain.BusLocation) error {
+	now := time.Now()
+	for i := range bls {
+		if bls[i].ID.IsZero() {
+			bls[i].ID = primitive.NewObjectID()
+		}
+		if bls[i].CreatedAt.IsZero() {
+			bls[i].CreatedAt = now
+		}
+		r.t.insert(bls[i])
+	}
+	return nil
+}
+
+func (r *BusLocationRepository) List(_ context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.BusLocation], error) {
+	return r.t.page(locationInScope(scope), q)
+}
+
+func (r *BusLocationRepository) Latest(_ context.Context, scope domain.Scope) ([]domain.BusLocation, error) {
+	latest := map[primitive.ObjectID]domain.BusLocation{}
+	for _, bl := range r.t.list(locationInScope(scope)) {
+		if cur, ok := latest[bl.BusID]; !ok || bl.CreatedAt.After(cur.CreatedAt) {
+			latest[bl.BusID] = bl
+		}
+	}
+	locations := make([]domain.BusLocation, 0, len(latest))
+	for _, bl := range latest {
+		locations = append(locations, bl)
+	}
+	return locations, nil
+}
+
+func (r *BusLocationRepository) History(_ context.Context, scope domain.Scope, busID primitive.ObjectID, from, to time.Time, maxPoints int) (*domain.LocationHistory, error) {
+	inScope := locationInScope(scope)
+	points := r.t.list(func(bl *domain.BusLocation) bool {
+		return bl.BusID == busID && inScope(bl) && !bl.CreatedAt.Before(from) && !bl.CreatedAt.After(to)
+	})
+	sort.SliceStable(points, func(i, j int) bool { return points[i].CreatedAt.Before(points[j].CreatedAt) })
+
+	sampler := domain.NewSampler[domain.BusLocation](int64(len(points)), maxPoints)
+	for _, bl := range points {
+		sampler.Add(bl)
+	}
+	return &domain.LocationHistory{BusID: busID, From: from, To: to, Total: int64(len(points)), Points: sampler.Points()}, nil
+}
+
+func (r *BusLocationRepository) Scan(_ context.Context, from, to time.Time, fn func(domain.BusLocation) error) error {
+	points := r.t.list(func(bl *domain.BusLocation) bool {
+		return !bl.CreatedAt.Before(from) && bl.CreatedAt.Before(to)
+	})
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].BusID != points[j].BusID {
+			return points[i].BusID.Hex() < points[j].BusID.Hex()
+		}
+		return points[i].CreatedAt.Before(points[j].CreatedAt)
+	})
+	for _, bl := range points {
+		if err := fn(bl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *BusLocationRepository) Delete(_ context.Context, scope domain.Scope, id primitive.ObjectID) error {
+	if !r.t.deleteFirst(r.t.byID(id, locationInScope(scope))) {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *BusLocationRepository) DeleteByCompania(_ context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return int64(r.t.deleteAll(locationInScope(domain.CompanyScope(companiaID)))), nil
+}
+
+func locationInScope(scope domain.Scope) func(*domain.BusLocation) bool {
+	return func(bl *domain.BusLocation) bool { return scope.Allows(bl.CompaniaID) }
+}
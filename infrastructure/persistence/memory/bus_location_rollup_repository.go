@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BusLocationRollupRepository implementa domain.BusLocationRollupRepository en memoria.
+type BusLocationRollupRepository struct {
+	t *table[domain.BusLocationRollup]
+}
+
+// NewBusLocationRollupRepository crea un BusLocationRollupRepository vacío.
+func NewBusLocationRollupRepository() *BusLocationRollupRepository {
+	return &BusLocationRollupRepository{t: newTable(func(r *domain.BusLocationRollup) primitive.ObjectID { return r.ID }, cloneRollup)}
+}
+
+func (r *BusLocationRollupRepository) Upsert(_ context.Context, rollups []domain.BusLocationRollup) error {
+	for _, ru := range rollups {
+		same := func(cur *domain.BusLocationRollup) bool {
+			return cur.BusID == ru.BusID && cur.Minuto.Equal(ru.Minuto)
+		}
+		_, _, ok := r.t.updateFirst(same, func(cur *domain.BusLocationRollup) {
+			id := cur.ID
+			*cur = cloneRollup(ru)
+			cur.ID = id
+		})
+		if !ok {
+			ru.ID = primitive.NewObjectID()
+			r.t.insert(ru)
+		}
+	}
+	return nil
+}
+
+func (r *BusLocationRollupRepository) LastMinute(_ context.Context) (time.Time, error) {
+	var last time.Time
+	for _, ru := range r.t.list(all[domain.BusLocationRollup]) {
+		if ru.Minuto.After(last) {
+			last = ru.Minuto
+		}
+	}
+	return last, nil
+}
+
+func (r *BusLocationRollupRepository) History(_ context.Context, scope domain.Scope, busID primitive.ObjectID, from, to time.Time, maxPoints int) (*domain.LocationHistory, error) {
+	start := from.Truncate(time.Minute)
+	rollups := r.t.list(func(ru *domain.BusLocationRollup) bool {
+		return ru.BusID == busID && scope.Allows(ru.CompaniaID) && !ru.Minuto.Before(start) && !ru.Minuto.After(to)
+	})
+	sort.SliceStable(rollups, func(i, j int) bool { return rollups[i].Minuto.Before(rollups[j].Minuto) })
+
+	var total, count int64
+	for _, ru := range rollups {
+		total += ru.Cantidad
+		count += int64(len(ru.Puntos))
+	}
+	sampler := domain.NewSampler[domain.BusLocation](count, maxPoints)
+	for _, ru := range rollups {
+		for _, bl := range ru.Locations() {
+			if !bl.CreatedAt.Before(from) && !bl.CreatedAt.After(to) {
+				sampler.Add(bl)
+			}
+		}
+	}
+	return &domain.LocationHistory{BusID: busID, From: from, To: to, Total: total, Points: sampler.Points()}, nil
+}
+
+func (r *BusLocationRollupRepository) DeleteByCompania(_ context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return int64(r.t.deleteAll(func(ru *domain.BusLocationRollup) bool { return ru.CompaniaID == companiaID })), nil
+}
+
+func cloneRollup(ru domain.BusLocationRollup) domain.BusLocationRollup {
+	ru.Puntos = append([]domain.RollupPoint(nil), ru.Puntos...)
+	return ru
+}
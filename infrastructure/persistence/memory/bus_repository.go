@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"context"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BusRepository implementa domain.BusRepository en memoria.
+type BusRepository struct {
+	t *table[domain.Bus]
+}
+
+// NewBusRepository crea un BusRepository vacío.
+func NewBusRepository() *BusRepository {
+	return &BusRepository{t: newTable(func(b *domain.Bus) primitive.ObjectID { return b.ID }, nil)}
+}
+
+func (r *BusRepository) Create(_ context.Context, bus *domain.Bus) error {
+	bus.ID = primitive.NewObjectID()
+	r.t.insert(*bus)
+	return nil
+}
+
+func (r *BusRepository) Update(_ context.Context, scope domain.Scope, bus *domain.Bus) (*domain.Bus, error) {
+	_, updated, ok := r.t.updateFirst(r.t.byID(bus.ID, busInScope(scope)), func(existing *domain.Bus) {
+		if bus.Placa != "" {
+			existing.Placa = bus.Placa
+		}
+		if !bus.ConductorID.IsZero() {
+			existing.ConductorID = bus.ConductorID
+		}
+		if !bus.RutaID.IsZero() {
+			existing.RutaID = bus.RutaID
+		}
+		if !bus.FechaInicio.IsZero() {
+			existing.FechaInicio = bus.FechaInicio
+		}
+		if !bus.FechaFin.IsZero() {
+			existing.FechaFin = bus.FechaFin
+		}
+		if !bus.CompaniaID.IsZero() {
+			existing.CompaniaID = bus.CompaniaID
+		}
+	})
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &updated, nil
+}
+
+func (r *BusRepository) List(_ context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.Bus], error) {
+	return r.t.page(busInScope(scope), q)
+}
+
+func (r *BusRepository) GetByID(_ context.Context, scope domain.Scope, id primitive.ObjectID) (*domain.Bus, error) {
+	b, ok := r.t.first(r.t.byID(id, busInScope(scope)))
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &b, nil
+}
+
+func (r *BusRepository) GetByIDs(_ context.Context, scope domain.Scope, ids []primitive.ObjectID) ([]domain.Bus, error) {
+	want := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	inScope := busInScope(scope)
+	return r.t.list(func(b *domain.Bus) bool { return want[b.ID] && inScope(b) }), nil
+}
+
+func (r *BusRepository) GetByPlaca(_ context.Context, scope domain.Scope, placa string) ([]domain.Bus, error) {
+	inScope := busInScope(scope)
+	return r.t.list(func(b *domain.Bus) bool { return b.Placa == placa && inScope(b) }), nil
+}
+
+func (r *BusRepository) Delete(_ context.Context, scope domain.Scope, id primitive.ObjectID) error {
+	if !r.t.deleteFirst(r.t.byID(id, busInScope(scope))) {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *BusRepository) CountByRuta(_ context.Context, rutaID primitive.ObjectID) (int64, error) {
+	return int64(len(r.t.list(busOnRuta(rutaID)))), nil
+}
+
+func (r *BusRepository) CountByConductor(_ context.Context, conductorIDs []primitive.ObjectID) (int64, error) {
+	ids := make(map[primitive.ObjectID]bool, len(conductorIDs))
+	for _, id := range conductorIDs {
+		ids[id] = true
+	}
+	return int64(len(r.t.list(func(b *domain.Bus) bool { return ids[b.ConductorID] }))), nil
+}
+
+func (r *BusRepository) UnassignRuta(_ context.Context, rutaID primitive.ObjectID) (int64, error) {
+	n := r.t.updateAll(busOnRuta(rutaID), func(b *domain.Bus) { b.RutaID = primitive.NilObjectID })
+	return int64(n), nil
+}
+
+func (r *BusRepository) UnassignConductor(_ context.Context, conductorID primitive.ObjectID) (int64, error) {
+	n := r.t.updateAll(func(b *domain.Bus) bool { return b.ConductorID == conductorID }, func(b *domain.Bus) {
+		b.ConductorID = primitive.NilObjectID
+	})
+	return int64(n), nil
+}
+
+func (r *BusRepository) DeleteByCompania(_ context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return int64(r.t.deleteAll(busInScope(domain.CompanyScope(companiaID)))), nil
+}
+
+func busInScope(scope domain.Scope) func(*domain.Bus) bool {
+	return func(b *domain.Bus) bool { return scope.Allows(b.CompaniaID) }
+}
+
+func busOnRuta(rutaID primitive.ObjectID) func(*domain.Bus) bool {
+	return func(b *domain.Bus) bool { return b.RutaID == rutaID }
+}
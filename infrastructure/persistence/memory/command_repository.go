@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CommandRepository implementa domain.CommandRepository en memoria.
+type CommandRepository struct {
+	t *table[domain.Command]
+}
+
+// NewCommandRepository crea un CommandRepository vacío.
+func NewCommandRepository() *CommandRepository {
+	return &CommandRepository{t: newTable(func(c *domain.Command) primitive.ObjectID { return c.ID }, cloneCommand)}
+}
+
+func (r *CommandRepository) Create(_ context.Context, c *domain.Command) error {
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+	r.t.insert(*c)
+	return nil
+}
+
+func (r *CommandRepository) GetByID(_ context.Context, scope domain.Scope, id primitive.ObjectID) (*domain.Command, error) {
+	c, ok := r.t.first(r.t.byID(id, commandInScope(scope)))
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &c, nil
+}
+
+func (r *CommandRepository) List(_ context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.Command], error) {
+	return r.t.page(commandInScope(scope), q)
+}
+
+func (r *CommandRepository) Open(_ context.Context, busID primitive.ObjectID) ([]domain.Command, error) {
+	cmds := r.t.list(func(c *domain.Command) bool { return c.BusID == busID && c.Open() })
+	sort.SliceStable(cmds, func(i, j int) bool { return cmds[i].CreatedAt.Before(cmds[j].CreatedAt) })
+	return cmds, nil
+}
+
+func (r *CommandRepository) MarkSent(_ context.Context, id primitive.ObjectID, at time.Time) error {
+	r.t.updateFirst(func(c *domain.Command) bool { return c.ID == id && c.Open() }, func(c *domain.Command) {
+		c.Estado = domain.CommandSent
+		c.SentAt = &at
+		c.Intentos++
+	})
+	return nil
+}
+
+func (r *CommandRepository) Resolve(_ context.Context, busID, id primitive.ObjectID, estado, resultado string, at time.Time) (*domain.Command, error) {
+	_, after, ok := r.t.updateFirst(func(c *domain.Command) bool { return c.ID == id && c.BusID == busID && c.Open() && !c.Expired(at) }, func(c *domain.Command) {
+		c.Estado = estado
+		c.AckedAt = &at
+		if resultado != "" {
+			c.Resultado = resultado
+		}
+	})
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &after, nil
+}
+
+func (r *CommandRepository) Expire(_ context.Context, now time.Time) (int64, error) {
+	n := r.t.updateAll(func(c *domain.Command) bool { return c.Open() && c.ExpiresAt.Before(now) }, func(c *domain.Command) {
+		c.Estado = domain.CommandExpired
+	})
+	return int64(n), nil
+}
+
+func (r *CommandRepository) DeleteByCompania(_ context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return int64(r.t.deleteAll(commandInScope(domain.CompanyScope(companiaID)))), nil
+}
+
+func commandInScope(scope domain.Scope) func(*domain.Command) bool {
+	return func(c *domain.Command) bool { return scope.Allows(c.CompaniaID) }
+}
+
+func cloneCommand(c domain.Command) domain.Command {
+	if c.Params != nil {
+		params := make(map[string]any, len(c.Params))
+		for k, v := range c.Params {
+			params[k] = v
+		}
+		c.Params = params
+	}
+	return c
+}
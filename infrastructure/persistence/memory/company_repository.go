@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"context"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CompanyRepository implementa domain.CompanyRepository en memoria.
+// El Scope se aplica sobre el propio ID de la compañía.
+type CompanyRepository struct {
+	t *table[domain.Company]
+}
+
+// NewCompanyRepository crea un CompanyRepository vacío.
+func NewCompanyRepository() *CompanyRepository {
+	return &CompanyRepository{t: newTable(func(c *domain.Company) primitive.ObjectID { return c.ID }, nil)}
+}
+
+func (r *CompanyRepository) Create(_ context.Context, comp *domain.Company) error {
+	comp.ID = primitive.NewObjectID()
+	r.t.insert(*comp)
+	return nil
+}
+
+func (r *CompanyRepository) Update(_ context.Context, scope domain.Scope, comp *domain.Company) (*domain.Company, error) {
+	_, updated, ok := r.t.updateFirst(r.t.byID(comp.ID, companyInScope(scope)), func(existing *domain.Company) {
+		if comp.Nombre != "" {
+			existing.Nombre = comp.Nombre
+		}
+		if comp.Descripcion != "" {
+			existing.Descripcion = comp.Descripcion
+		}
+	})
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &updated, nil
+}
+
+func (r *CompanyRepository) List(_ context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.Company], error) {
+	return r.t.page(companyInScope(scope), q)
+}
+
+func (r *CompanyRepository) GetByID(_ context.Context, scope domain.Scope, id primitive.ObjectID) (*domain.Company, error) {
+	comp, ok := r.t.first(r.t.byID(id, companyInScope(scope)))
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &comp, nil
+}
+
+func (r *CompanyRepository) GetByName(_ context.Context, scope domain.Scope, nombre string) ([]domain.Company, error) {
+	inScope := companyInScope(scope)
+	return r.t.list(func(c *domain.Company) bool { return c.Nombre == nombre && inScope(c) }), nil
+}
+
+func (r *CompanyRepository) Delete(_ context.Context, id primitive.ObjectID) error {
+	if !r.t.deleteFirst(r.t.byID(id, nil)) {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func companyInScope(scope domain.Scope) func(*domain.Company) bool {
+	return func(c *domain.Company) bool { return scope.Allows(c.ID) }
+}
@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"context"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConnectivityEventRepository implementa domain.ConnectivityEventRepository en memoria.
+type ConnectivityEventRepository struct {
+	t *table[domain.ConnectivityEvent]
+}
+
+// NewConnectivityEventRepository crea un ConnectivityEventRepository vacío.
+func NewConnectivityEventRepository() *ConnectivityEventRepository {
+	return &ConnectivityEventRepository{t: newTable(func(e *domain.ConnectivityEvent) primitive.ObjectID { return e.ID }, nil)}
+}
+
+func (r *ConnectivityEventRepository) Create(_ context.Context, e *domain.ConnectivityEvent) error {
+	if e.ID.IsZero() {
+		e.ID = primitive.NewObjectID()
+	}
+	r.t.insert(*e)
+	return nil
+}
+
+func (r *ConnectivityEventRepository) List(_ context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.ConnectivityEvent], error) {
+	return r.t.page(connectivityInScope(scope), q)
+}
+
+func (r *ConnectivityEventRepository) Latest(_ context.Context) ([]domain.ConnectivityEvent, error) {
+	latest := map[primitive.ObjectID]domain.ConnectivityEvent{}
+	for _, e := range r.t.list(connectivityInScope(domain.GlobalScope())) {
+		if cur, ok := latest[e.BusID]; !ok || !e.At.Before(cur.At) {
+			latest[e.BusID] = e
+		}
+	}
+	events := make([]domain.ConnectivityEvent, 0, len(latest))
+	for _, e := range latest {
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (r *ConnectivityEventRepository) DeleteByCompania(_ context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return int64(r.t.deleteAll(connectivityInScope(domain.CompanyScope(companiaID)))), nil
+}
+
+func connectivityInScope(scope domain.Scope) func(*domain.ConnectivityEvent) bool {
+	return func(e *domain.ConnectivityEvent) bool { return scope.Allows(e.CompaniaID) }
+}
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeviceRepository implementa domain.DeviceRepository en memoria.
+type DeviceRepository struct {
+	t *table[domain.Device]
+}
+
+// NewDeviceRepository crea un DeviceRepository vacío.
+func NewDeviceRepository() *DeviceRepository {
+	return &DeviceRepository{t: newTable(func(d *domain.Device) primitive.ObjectID { return d.ID }, nil)}
+}
+
+func (r *DeviceRepository) Create(_ context.Context, d *domain.Device) error {
+	if _, exists := r.t.first(func(o *domain.Device) bool { return o.Serial == d.Serial || o.ClientID == d.ClientID }); exists {
+		return domain.ErrConflict
+	}
+	if d.ID.IsZero() {
+		d.ID = primitive.NewObjectID()
+	}
+	d.CreatedAt = time.Now()
+	r.t.insert(*d)
+	return nil
+}
+
+func (r *DeviceRepository) GetByID(_ context.Context, scope domain.Scope, id primitive.ObjectID) (*domain.Device, error) {
+	d, ok := r.t.first(r.t.byID(id, deviceInScope(scope)))
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &d, nil
+}
+
+func (r *DeviceRepository) GetByClientID(_ context.Context, clientID string) (*domain.Device, error) {
+	d, ok := r.t.first(func(d *domain.Device) bool { return d.ClientID == clientID })
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &d, nil
+}
+
+func (r *DeviceRepository) List(_ context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.Device], error) {
+	return r.t.page(deviceInScope(scope), q)
+}
+
+func (r *DeviceRepository) Update(_ context.Context, scope domain.Scope, d *domain.Device) (*domain.Device, error) {
+	if d.Serial != "" {
+		if _, exists := r.t.first(func(o *domain.Device) bool { return o.Serial == d.Serial && o.ID != d.ID }); exists {
+			return nil, domain.ErrConflict
+		}
+	}
+	_, updated, ok := r.t.updateFirst(r.t.byID(d.ID, deviceInScope(scope)), func(existing *domain.Device) {
+		if d.Serial != "" {
+			existing.Serial = d.Serial
+		}
+		if d.IMEI != "" {
+			existing.IMEI = d.IMEI
+		}
+		if d.Firmware != "" {
+			existing.Firmware = d.Firmware
+		}
+		if d.Estado != "" {
+			existing.Estado = d.Estado
+		}
+		if !d.BusID.IsZero() {
+			existing.BusID = d.BusID
+		}
+		if !d.CompaniaID.IsZero() {
+			existing.CompaniaID = d.CompaniaID
+		}
+		if d.SecretHash != "" {
+			existing.SecretHash = d.SecretHash
+		}
+		if d.DecommissionedAt != nil {
+			existing.DecommissionedAt = d.DecommissionedAt
+		}
+	})
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &updated, nil
+}
+
+func (r *DeviceRepository) Touch(_ context.Context, id primitive.ObjectID, at time.Time) error {
+	r.t.updateFirst(r.t.byID(id, nil), func(d *domain.Device) {
+		if d.LastSeen == nil || d.LastSeen.Before(at) {
+			d.LastSeen = &at
+		}
+	})
+	return nil
+}
+
+func (r *DeviceRepository) DeleteByCompania(_ context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return int64(r.t.deleteAll(deviceInScope(domain.CompanyScope(companiaID)))), nil
+}
+
+func deviceInScope(scope domain.Scope) func(*domain.Device) bool {
+	return func(d *domain.Device) bool { return scope.Allows(d.CompaniaID) }
+}
@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"sort"
+	"strings"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// row es un documento de un listado junto con su representación BSON, que
+// se usa para filtrar y ordenar por nombre de campo igual que en MongoDB.
+type row[T any] struct {
+	v   T
+	doc bson.Raw
+}
+
+// page retorna una página de los documentos que cumplen match y los filtros
+// de q, en el orden de q y a partir de su cursor.
+func (t *table[T]) page(match func(*T) bool, q domain.ListQuery) (*domain.Page[T], error) {
+	var rows []row[T]
+	for _, v := range t.list(match) {
+		doc, err := bson.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if matchesFilters(doc, q.Filters) {
+			rows = append(rows, row[T]{v: v, doc: doc})
+		}
+	}
+
+	field := q.SortField()
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compareKeys(rows[i].doc, lookup(rows[j].doc, field), rows[j].doc.Lookup("_id"), field, q.Desc) < 0
+	})
+
+	start := 0
+	if q.After != nil {
+		id := bson.RawValue{Type: bsontype.ObjectID, Value: q.After.ID[:]}
+		start = sort.Search(len(rows), func(i int) bool {
+			return compareKeys(rows[i].doc, q.After.Value, id, field, q.Desc) > 0
+		})
+	}
+
+	page := &domain.Page[T]{Items: make([]T, 0)}
+	if q.After == nil {
+		total := int64(len(rows))
+		page.Total = &total
+	}
+	end := start + q.Limit
+	if end < len(rows) {
+		page.Next = q.CursorAt(rows[end-1].doc).Encode()
+	} else {
+		end = len(rows)
+	}
+	for _, r := range rows[start:end] {
+		page.Items = append(page.Items, r.v)
+	}
+	return page, nil
+}
+
+// compareKeys compara la clave de orden (campo, _id) de doc con la indicada,
+// invirtiendo el resultado si el orden es descendente.
+func compareKeys(doc bson.Raw, value, id bson.RawValue, field string, desc bool) int {
+	c := compareValues(lookup(doc, field), value)
+	if c == 0 && field != "_id" {
+		c = compareValues(doc.Lookup("_id"), id)
+	}
+	if desc {
+		return -c
+	}
+	return c
+}
+
+// matchesFilters indica si doc cumple todos los filtros.
+func matchesFilters(doc bson.Raw, filters []domain.Filter) bool {
+	for _, f := range filters {
+		if f.Op == domain.OpWithin {
+			if !within(lookup(doc, f.Field), f.Value) {
+				return false
+			}
+			continue
+		}
+		t, data, err := bson.MarshalValue(f.Value)
+		if err != nil {
+			return false
+		}
+		c := compareValues(lookup(doc, f.Field), bson.RawValue{Type: t, Value: data})
+		switch f.Op {
+		case domain.OpGte:
+			if c < 0 {
+				return false
+			}
+		case domain.OpLte:
+			if c > 0 {
+				return false
+			}
+		default:
+			if c != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// within indica si v es una ubicación dentro del área value, que debe ser un
+// domain.BoundingBox.
+func within(v bson.RawValue, value any) bool {
+	box, ok := value.(domain.BoundingBox)
+	if !ok || v.Type != bsontype.EmbeddedDocument {
+		return false
+	}
+	var loc domain.Location
+	if err := v.Unmarshal(&loc); err != nil {
+		return false
+	}
+	return box.Contains(loc)
+}
+
+// lookup retorna el valor de un campo de doc. Los campos de documentos
+// anidados se indican con puntos ("localizacion.type"), como en MongoDB.
+func lookup(doc bson.Raw, field string) bson.RawValue {
+	v, err := doc.LookupErr(strings.Split(field, ".")...)
+	if err != nil {
+		return bson.RawValue{}
+	}
+	return v
+}
+
+// compareValues compara dos valores BSON de los tipos que se usan en los
+// listados (cadenas, ObjectID, fechas y números). Los valores ausentes van
+// antes que cualquier otro, como en MongoDB.
+func compareValues(a, b bson.RawValue) int {
+	if a.Type != b.Type {
+		return cmp.Compare(a.Type, b.Type)
+	}
+	switch a.Type {
+	case bsontype.String:
+		return strings.Compare(a.StringValue(), b.StringValue())
+	case bsontype.DateTime:
+		return cmp.Compare(a.DateTime(), b.DateTime())
+	case bsontype.Int32:
+		return cmp.Compare(a.Int32(), b.Int32())
+	case bsontype.Int64:
+		return cmp.Compare(a.Int64(), b.Int64())
+	case bsontype.Double:
+		return cmp.Compare(a.Double(), b.Double())
+	}
+	return bytes.Compare(a.Value, b.Value)
+}
@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshTokenRepository implementa domain.RefreshTokenRepository en memoria.
+type RefreshTokenRepository struct {
+	t *table[domain.RefreshToken]
+}
+
+// NewRefreshTokenRepository crea un RefreshTokenRepository vacío.
+func NewRefreshTokenRepository() *RefreshTokenRepository {
+	return &RefreshTokenRepository{t: newTable(
+		func(rt *domain.RefreshToken) primitive.ObjectID { return rt.ID },
+		func(rt domain.RefreshToken) domain.RefreshToken {
+			if rt.RevokedAt != nil {
+				at := *rt.RevokedAt
+				rt.RevokedAt = &at
+			}
+			return rt
+		},
+	)}
+}
+
+func (r *RefreshTokenRepository) Create(_ context.Context, rt *domain.RefreshToken) error {
+	if rt.ID.IsZero() {
+		rt.ID = primitive.NewObjectID()
+	}
+	rt.CreatedAt = time.Now()
+	r.t.insert(*rt)
+	return nil
+}
+
+func (r *RefreshTokenRepository) GetByHash(_ context.Context, tokenHash string) (*domain.RefreshToken, error) {
+	rt, ok := r.t.first(func(rt *domain.RefreshToken) bool { return rt.TokenHash == tokenHash })
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &rt, nil
+}
+
+func (r *RefreshTokenRepository) Revoke(_ context.Context, tokenHash string, replacedBy primitive.ObjectID) (*domain.RefreshToken, error) {
+	now := time.Now()
+	active := func(rt *domain.RefreshToken) bool {
+		return rt.TokenHash == tokenHash && rt.RevokedAt == nil && rt.ExpiresAt.After(now)
+	}
+	before, _, ok := r.t.updateFirst(active, func(rt *domain.RefreshToken) {
+		rt.RevokedAt = &now
+		if !replacedBy.IsZero() {
+			rt.ReplacedBy = replacedBy
+		}
+	})
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &before, nil
+}
+
+func (r *RefreshTokenRepository) RevokeAllForUser(_ context.Context, userID primitive.ObjectID) error {
+	now := time.Now()
+	r.t.updateAll(
+		func(rt *domain.RefreshToken) bool { return rt.UserID == userID && rt.RevokedAt == nil },
+		func(rt *domain.RefreshToken) { rt.RevokedAt = &now },
+	)
+	return nil
+}
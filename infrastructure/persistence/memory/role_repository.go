@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"context"
+	"slices"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RoleRepository implementa domain.RoleRepository en memoria.
+type RoleRepository struct {
+	t *table[domain.Role]
+}
+
+// NewRoleRepository crea un RoleRepository vacío.
+func NewRoleRepository() *RoleRepository {
+	return &RoleRepository{t: newTable(
+		func(r *domain.Role) primitive.ObjectID { return r.ID },
+		func(r domain.Role) domain.Role { r.Permisos = slices.Clone(r.Permisos); return r },
+	)}
+}
+
+func (r *RoleRepository) Create(_ context.Context, role *domain.Role) error {
+	role.ID = primitive.NewObjectID()
+	r.t.insert(*role)
+	return nil
+}
+
+func (r *RoleRepository) Update(_ context.Context, role *domain.Role) (*domain.Role, error) {
+	_, updated, ok := r.t.updateFirst(r.t.byID(role.ID, nil), func(existing *domain.Role) {
+		if role.Nombre != "" {
+			existing.Nombre = role.Nombre
+		}
+		if role.Descripcion != "" {
+			existing.Descripcion = role.Descripcion
+		}
+		if role.Permisos != nil {
+			existing.Permisos = slices.Clone(role.Permisos)
+		}
+	})
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &updated, nil
+}
+
+func (r *RoleRepository) List(_ context.Context, q domain.ListQuery) (*domain.Page[domain.Role], error) {
+	return r.t.page(all[domain.Role], q)
+}
+
+func (r *RoleRepository) GetByID(_ context.Context, id primitive.ObjectID) (*domain.Role, error) {
+	role, ok := r.t.first(r.t.byID(id, nil))
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &role, nil
+}
+
+func (r *RoleRepository) GetByName(_ context.Context, nombre string) ([]domain.Role, error) {
+	return r.t.list(func(role *domain.Role) bool { return role.Nombre == nombre }), nil
+}
+
+func (r *RoleRepository) Delete(_ context.Context, id primitive.ObjectID) error {
+	if !r.t.deleteFirst(r.t.byID(id, nil)) {
+		return domain.ErrNotFound
+	}
+	return nil
+}
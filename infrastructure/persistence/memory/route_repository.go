@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"slices"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RouteRepository implementa domain.RouteRepository en memoria.
+type RouteRepository struct {
+	t *table[domain.Route]
+}
+
+// NewRouteRepository crea un RouteRepository vacío.
+func NewRouteRepository() *RouteRepository {
+	return &RouteRepository{t: newTable(
+		func(r *domain.Route) primitive.ObjectID { return r.ID },
+		func(r domain.Route) domain.Route { r.Waypoints = slices.Clone(r.Waypoints); return r },
+	)}
+}
+
+func (r *RouteRepository) Create(_ context.Context, route *domain.Route) error {
+	route.ID = primitive.NewObjectID()
+	r.t.insert(*route)
+	return nil
+}
+
+func (r *RouteRepository) Update(_ context.Context, scope domain.Scope, route *domain.Route) (*domain.Route, error) {
+	_, updated, ok := r.t.updateFirst(r.t.byID(route.ID, routeInScope(scope)), func(existing *domain.Route) {
+		if route.Nombre != "" {
+			existing.Nombre = route.Nombre
+		}
+		if route.Descripcion != "" {
+			existing.Descripcion = route.Descripcion
+		}
+		if route.ModoTransporte != "" {
+			existing.ModoTransporte = route.ModoTransporte
+		}
+		if route.Origen != (domain.Location{}) {
+			existing.Origen = route.Origen
+		}
+		if route.Destino != (domain.Location{}) {
+			existing.Destino = route.Destino
+		}
+		if len(route.Waypoints) > 0 {
+			existing.Waypoints = slices.Clone(route.Waypoints)
+		}
+		if !route.CompaniaID.IsZero() {
+			existing.CompaniaID = route.CompaniaID
+		}
+	})
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &updated, nil
+}
+
+func (r *RouteRepository) List(_ context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.Route], error) {
+	return r.t.page(routeInScope(scope), q)
+}
+
+func (r *RouteRepository) GetByName(_ context.Context, scope domain.Scope, nombre string) ([]domain.Route, error) {
+	inScope := routeInScope(scope)
+	return r.t.list(func(route *domain.Route) bool { return route.Nombre == nombre && inScope(route) }), nil
+}
+
+func (r *RouteRepository) GetByID(_ context.Context, scope domain.Scope, id primitive.ObjectID) (*domain.Route, error) {
+	route, ok := r.t.first(r.t.byID(id, routeInScope(scope)))
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &route, nil
+}
+
+func (r *RouteRepository) Delete(_ context.Context, scope domain.Scope, id primitive.ObjectID) error {
+	if !r.t.deleteFirst(r.t.byID(id, routeInScope(scope))) {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *RouteRepository) DeleteByCompania(_ context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return int64(r.t.deleteAll(routeInScope(domain.CompanyScope(companiaID)))), nil
+}
+
+func routeInScope(scope domain.Scope) func(*domain.Route) bool {
+	return func(r *domain.Route) bool { return scope.Allows(r.CompaniaID) }
+}
@@ -0,0 +1,160 @@
+// Package memory implementa los repositorios del dominio en memoria.
+// Se usa en las pruebas y para levantar el API sin MongoDB; los datos se
+// pierden al detener el proceso.
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewRepositories crea un conjunto de repositorios en memoria vacíos.
+func NewRepositories() domain.Repositories {
+	return domain.Repositories{
+		Users:         NewUserRepository(),
+		Roles:         NewRoleRepository(),
+		Companies:     NewCompanyRepository(),
+		Buses:         NewBusRepository(),
+		Routes:        NewRouteRepository(),
+		BusLocations:  NewBusLocationRepository(),
+		Rollups:       NewBusLocationRollupRepository(),
+		Devices:       NewDeviceRepository(),
+		Connectivity:  NewConnectivityEventRepository(),
+		Commands:      NewCommandRepository(),
+		RefreshTokens: NewRefreshTokenRepository(),
+		Tx:            Transactor{},
+	}
+}
+
+// Transactor implementa domain.Transactor en memoria. Cada operación es
+// atómica por separado, pero si fn falla a mitad no se deshacen las
+// operaciones anteriores.
+type Transactor struct{}
+
+func (Transactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// table es una colección de documentos protegida por un RWMutex que conserva
+// el orden de inserción, como el orden natural de una colección de MongoDB.
+// Los documentos se copian al entrar y al salir para que quien llama no pueda
+// modificar el estado compartido.
+type table[T any] struct {
+	mu    sync.RWMutex
+	rows  []T
+	id    func(*T) primitive.ObjectID
+	clone func(T) T
+}
+
+func newTable[T any](id func(*T) primitive.ObjectID, clone func(T) T) *table[T] {
+	if clone == nil {
+		clone = func(v T) T { return v }
+	}
+	return &table[T]{id: id, clone: clone}
+}
+
+// insert agrega un documento al final de la colección.
+func (t *table[T]) insert(v T) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows = append(t.rows, t.clone(v))
+}
+
+// byID retorna un filtro que coincide con el documento con el ID indicado y,
+// si match no es nil, que además cumple match.
+func (t *table[T]) byID(id primitive.ObjectID, match func(*T) bool) func(*T) bool {
+	return func(v *T) bool {
+		return t.id(v) == id && (match == nil || match(v))
+	}
+}
+
+// first retorna el primer documento que cumple match.
+func (t *table[T]) first(match func(*T) bool) (T, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for i := range t.rows {
+		if match(&t.rows[i]) {
+			return t.clone(t.rows[i]), true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// list retorna todos los documentos que cumplen match, o nil si no hay ninguno.
+func (t *table[T]) list(match func(*T) bool) []T {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var out []T
+	for i := range t.rows {
+		if match(&t.rows[i]) {
+			out = append(out, t.clone(t.rows[i]))
+		}
+	}
+	return out
+}
+
+// updateFirst aplica apply al primer documento que cumple match y retorna
+// el documento antes y después del cambio.
+func (t *table[T]) updateFirst(match func(*T) bool, apply func(*T)) (before, after T, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.rows {
+		if match(&t.rows[i]) {
+			before = t.clone(t.rows[i])
+			apply(&t.rows[i])
+			return before, t.clone(t.rows[i]), true
+		}
+	}
+	return before, after, false
+}
+
+// updateAll aplica apply a todos los documentos que cumplen match.
+func (t *table[T]) updateAll(match func(*T) bool, apply func(*T)) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for i := range t.rows {
+		if match(&t.rows[i]) {
+			apply(&t.rows[i])
+			n++
+		}
+	}
+	return n
+}
+
+// deleteFirst elimina el primer documento que cumple match.
+func (t *table[T]) deleteFirst(match func(*T) bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.rows {
+		if match(&t.rows[i]) {
+			t.rows = append(t.rows[:i], t.rows[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// deleteAll elimina todos los documentos que cumplen match y retorna cuántos eliminó.
+func (t *table[T]) deleteAll(match func(*T) bool) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	kept := t.rows[:0]
+	for i := range t.rows {
+		if !match(&t.rows[i]) {
+			kept = append(kept, t.rows[i])
+		}
+	}
+	n := len(t.rows) - len(kept)
+	clear(t.rows[len(kept):])
+	t.rows = kept
+	return n
+}
+
+// all coincide con cualquier documento.
+func all[T any](*T) bool { return true }
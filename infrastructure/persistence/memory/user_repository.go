@@ -0,0 +1,102 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserRepository implementa domain.UserRepository en memoria.
+type UserRepository struct {
+	t *table[domain.User]
+}
+
+// NewUserRepository crea un UserRepository vacío.
+func NewUserRepository() *UserRepository {
+	return &UserRepository{t: newTable(func(u *domain.User) primitive.ObjectID { return u.ID }, nil)}
+}
+
+func (r *UserRepository) Create(_ context.Context, user *domain.User) error {
+	user.ID = primitive.NewObjectID()
+	user.CreatedAt = time.Now()
+	r.t.insert(*user)
+	return nil
+}
+
+func (r *UserRepository) Update(_ context.Context, scope domain.Scope, user *domain.User) (*domain.User, error) {
+	_, updated, ok := r.t.updateFirst(r.t.byID(user.ID, userInScope(scope)), func(u *domain.User) {
+		if user.Nombre != "" {
+			u.Nombre = user.Nombre
+		}
+		if user.Password != "" {
+			u.Password = user.Password
+		}
+		if !user.RolID.IsZero() {
+			u.RolID = user.RolID
+		}
+		if !user.Compania.IsZero() {
+			u.Compania = user.Compania
+		}
+	})
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &updated, nil
+}
+
+func (r *UserRepository) UpdatePassword(_ context.Context, id primitive.ObjectID, hash string) error {
+	r.t.updateFirst(r.t.byID(id, nil), func(u *domain.User) { u.Password = hash })
+	return nil
+}
+
+func (r *UserRepository) GetByNombre(_ context.Context, nombre string) (*domain.User, error) {
+	u, ok := r.t.first(func(u *domain.User) bool { return u.Nombre == nombre })
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &u, nil
+}
+
+func (r *UserRepository) GetByID(_ context.Context, id primitive.ObjectID) (*domain.User, error) {
+	u, ok := r.t.first(r.t.byID(id, nil))
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &u, nil
+}
+
+func (r *UserRepository) List(_ context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.User], error) {
+	return r.t.page(userInScope(scope), q)
+}
+
+func (r *UserRepository) Search(_ context.Context, scope domain.Scope, f domain.UserFilter) ([]domain.User, error) {
+	inScope := userInScope(scope)
+	return r.t.list(func(u *domain.User) bool {
+		return inScope(u) &&
+			(f.Nombre == "" || u.Nombre == f.Nombre) &&
+			(f.RolID.IsZero() || u.RolID == f.RolID) &&
+			(f.CompaniaID.IsZero() || u.Compania == f.CompaniaID)
+	}), nil
+}
+
+func (r *UserRepository) Delete(_ context.Context, scope domain.Scope, id primitive.ObjectID) error {
+	if !r.t.deleteFirst(r.t.byID(id, userInScope(scope))) {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) DeleteByRol(_ context.Context, rolID primitive.ObjectID) (int64, error) {
+	return int64(r.t.deleteAll(func(u *domain.User) bool { return u.RolID == rolID })), nil
+}
+
+func (r *UserRepository) DeleteByCompania(_ context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return int64(r.t.deleteAll(func(u *domain.User) bool { return u.Compania == companiaID })), nil
+}
+
+func userInScope(scope domain.Scope) func(*domain.User) bool {
+	return func(u *domain.User) bool { return scope.Allows(u.Compania) }
+}
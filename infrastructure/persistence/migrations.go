@@ -0,0 +1,185 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migrate convierte los documentos guardados con formatos anteriores al
+// actual y asigna la compañía a los buses, rutas y ubicaciones guardados antes
+// de que existiera (ver backfillCompania). Es idempotente y debe ejecutarse
+// antes de EnsureIndexes, porque MongoDB no puede crear un índice 2dsphere
+// sobre ubicaciones {lat, lng}.
+func Migrate(ctx context.Context, db *mongo.Database) error {
+	migrations := []struct {
+		coll   string
+		filter bson.M
+		update mongo.Pipeline
+	}{
+		{
+			coll:   busLocationsCollection,
+			filter: bson.M{"localizacion.lat": bson.M{"$exists": true}},
+			update: mongo.Pipeline{{{Key: "$set", Value: bson.M{"localizacion": geoPointExpr("$localizacion")}}}},
+		},
+		{
+			coll:   routesCollection,
+			filter: bson.M{"origen.lat": bson.M{"$exists": true}},
+			update: mongo.Pipeline{{{Key: "$set", Value: bson.M{
+				"origen":  geoPointExpr("$origen"),
+				"destino": geoPointExpr("$destino"),
+				"waypoints": bson.M{"$map": bson.M{
+					"input": bson.M{"$ifNull": bson.A{"$waypoints", bson.A{}}},
+					"as":    "w",
+					"in":    bson.M{"ubicacion": geoPointExpr("$$w"), "descripcion": "$$w.descripcion"},
+				}},
+			}}}},
+		},
+	}
+
+	for _, m := range migrations {
+		res, err := db.Collection(m.coll).UpdateMany(ctx, m.filter, m.update)
+		if err != nil {
+			return fmt.Errorf("migración de %s: %w", m.coll, err)
+		}
+		if res.ModifiedCount > 0 {
+			log.Printf("Migrados %d documentos de %s a GeoJSON", res.ModifiedCount, m.coll)
+		}
+	}
+	return backfillCompania(ctx, db)
+}
+
+// noCompania son los valores del campo compania de los documentos sin
+// compañía: guardados antes de que existiera el campo o con él vacío.
+var noCompania = bson.A{nil, primitive.NilObjectID}
+
+// withoutCompania coincide con los documentos sin compañía.
+var withoutCompania = bson.M{"compania": bson.M{"$in": noCompania}}
+
+// backfillCompania asigna la compañía a los documentos que no la tienen, que
+// solo ven los super-admins:
+//   - los buses, la de su conductor;
+//   - las rutas, la de los buses que las usan, si es una sola;
+//   - las ubicaciones, la de su bus.
+//
+// Los documentos que no se pueden resolver (p. ej. un bus sin conductor o una
+// ruta sin buses) se dejan como están y se registran en el log para
+// asignarlos a mano.
+func backfillCompania(ctx context.Context, db *mongo.Database) error {
+	buses := db.Collection(busesCollection)
+	steps := []struct {
+		coll     string
+		backfill func() (int64, error)
+	}{
+		{busesCollection, func() (int64, error) {
+			return backfillFrom(ctx, buses, "conductor", db.Collection(usersCollection))
+		}},
+		{routesCollection, func() (int64, error) { return backfillRoutes(ctx, db.Collection(routesCollection), buses) }},
+		{busLocationsCollection, func() (int64, error) {
+			return backfillFrom(ctx, db.Collection(busLocationsCollection), "bus_id", buses)
+		}},
+	}
+	for _, step := range steps {
+		n, err := step.backfill()
+		if err != nil {
+			return fmt.Errorf("asignando la compañía en %s: %w", step.coll, err)
+		}
+		if n > 0 {
+			log.Printf("Asignada la compañía a %d documentos de %s", n, step.coll)
+		}
+		left, err := db.Collection(step.coll).CountDocuments(ctx, withoutCompania)
+		if err != nil {
+			return fmt.Errorf("contando documentos sin compañía en %s: %w", step.coll, err)
+		}
+		if left > 0 {
+			log.Printf("ADVERTENCIA: %d documentos de %s siguen sin compañía y solo los ven los super-admins", left, step.coll)
+		}
+	}
+	return nil
+}
+
+// backfillFrom asigna a los documentos de target sin compañía la del
+// documento de source cuyo _id está en su campo key.
+func backfillFrom(ctx context.Context, target *mongo.Collection, key string, source *mongo.Collection) (int64, error) {
+	refs, err := target.Distinct(ctx, key, withoutCompania)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, ref := range refs {
+		id, ok := ref.(primitive.ObjectID)
+		if !ok || id.IsZero() {
+			continue
+		}
+		var doc struct {
+			Compania primitive.ObjectID `bson:"compania"`
+		}
+		err := source.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"compania": 1})).Decode(&doc)
+		if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && doc.Compania.IsZero()) {
+			continue
+		}
+		if err != nil {
+			return total, err
+		}
+		res, err := target.UpdateMany(ctx,
+			bson.M{key: id, "compania": bson.M{"$in": noCompania}},
+			bson.M{"$set": bson.M{"compania": doc.Compania}})
+		if err != nil {
+			return total, err
+		}
+		total += res.ModifiedCount
+	}
+	return total, nil
+}
+
+// backfillRoutes asigna a las rutas sin compañía la de los buses que las
+// usan. Una ruta usada por buses de varias compañías no se resuelve.
+func backfillRoutes(ctx context.Context, routes, buses *mongo.Collection) (int64, error) {
+	ids, err := routes.Distinct(ctx, "_id", withoutCompania)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, ref := range ids {
+		id, ok := ref.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		companias, err := buses.Distinct(ctx, "compania", bson.M{
+			"ruta":     id,
+			"compania": bson.M{"$nin": noCompania},
+		})
+		if err != nil {
+			return total, err
+		}
+		if len(companias) != 1 {
+			if len(companias) > 1 {
+				log.Printf("ADVERTENCIA: la ruta %s la usan buses de %d compañías; no se le asigna ninguna", id.Hex(), len(companias))
+			}
+			continue
+		}
+		res, err := routes.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"compania": companias[0]}})
+		if err != nil {
+			return total, err
+		}
+		total += res.ModifiedCount
+	}
+	return total, nil
+}
+
+// geoPointExpr construye el punto GeoJSON de una ubicación {lat, lng} en una
+// actualización con pipeline. El documento va dentro de $mergeObjects para que
+// $set lo trate como una expresión y reemplace el campo en lugar de combinarlo
+// con el documento existente.
+func geoPointExpr(path string) bson.M {
+	return bson.M{"$mergeObjects": bson.A{bson.M{
+		"type":        "Point",
+		"coordinates": bson.A{path + ".lng", path + ".lat"},
+	}}}
+}
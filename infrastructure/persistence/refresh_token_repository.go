@@ -0,0 +1,84 @@
+package persistence
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RefreshTokenRepository implementa domain.RefreshTokenRepository sobre la colección "refresh_tokens".
+type RefreshTokenRepository struct {
+	coll *mongo.Collection
+}
+
+// NewRefreshTokenRepository crea un RefreshTokenRepository.
+func NewRefreshTokenRepository(db *mongo.Database) *RefreshTokenRepository {
+	return &RefreshTokenRepository{coll: db.Collection(refreshTokensCollection)}
+}
+
+// Create inserta un nuevo token de refresco en la colección "refresh_tokens".
+func (r *RefreshTokenRepository) Create(ctx context.Context, rt *domain.RefreshToken) error {
+	if rt.ID.IsZero() {
+		rt.ID = primitive.NewObjectID()
+	}
+	rt.CreatedAt = time.Now()
+
+	if _, err := r.coll.InsertOne(ctx, rt); err != nil {
+		log.Println("Error al insertar refresh token:", err)
+		return mongoError(err)
+	}
+	return nil
+}
+
+// GetByHash busca un token de refresco por el hash de su valor.
+func (r *RefreshTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*domain.RefreshToken, error) {
+	var rt domain.RefreshToken
+	if err := r.coll.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&rt); err != nil {
+		return nil, mongoError(err)
+	}
+	return &rt, nil
+}
+
+// Revoke marca como revocado un token activo (no revocado y no expirado)
+// y devuelve el documento tal como estaba antes de revocarlo.
+// Si el token no existe o ya no está activo retorna domain.ErrNotFound.
+func (r *RefreshTokenRepository) Revoke(ctx context.Context, tokenHash string, replacedBy primitive.ObjectID) (*domain.RefreshToken, error) {
+	now := time.Now()
+	filter := bson.M{
+		"token_hash": tokenHash,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+	}
+	set := bson.M{"revoked_at": now}
+	if !replacedBy.IsZero() {
+		set["replaced_by"] = replacedBy
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	var rt domain.RefreshToken
+	if err := r.coll.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}, opts).Decode(&rt); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println("Error al revocar refresh token:", err)
+		}
+		return nil, mongoError(err)
+	}
+	return &rt, nil
+}
+
+// RevokeAllForUser revoca todos los tokens activos de un usuario.
+func (r *RefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID primitive.ObjectID) error {
+	filter := bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revoked_at": time.Now()}}
+	if _, err := r.coll.UpdateMany(ctx, filter, update); err != nil {
+		log.Println("Error al revocar refresh tokens del usuario:", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Nombres de las colecciones de MongoDB.
+const (
+	usersCollection         = "usuarios"
+	rolesCollection         = "roles"
+	companiesCollection     = "Companias"
+	busesCollection         = "buses"
+	routesCollection        = "ruta"
+	busLocationsCollection  = "BusLocations"
+	rollupsCollection       = "BusLocationRollups"
+	devicesCollection       = "devices"
+	connectivityCollection  = "connectivity_events"
+	commandsCollection      = "commands"
+	refreshTokensCollection = "refresh_tokens"
+)
+
+// NewRepositories crea los repositorios de MongoDB sobre la base de datos
+// indicada. allowStandalone permite ejecutar sin transacción las operaciones
+// que la requieren si el servidor no las admite (ver Transactor).
+func NewRepositories(db *mongo.Database, allowStandalone bool) domain.Repositories {
+	return domain.Repositories{
+		Users:         NewUserRepository(db),
+		Roles:         NewRoleRepository(db),
+		Companies:     NewCompanyRepository(db),
+		Buses:         NewBusRepository(db),
+		Routes:        NewRouteRepository(db),
+		BusLocations:  NewBusLocationRepository(db),
+		Rollups:       NewBusLocationRollupRepository(db),
+		Devices:       NewDeviceRepository(db),
+		Connectivity:  NewConnectivityEventRepository(db),
+		Commands:      NewCommandRepository(db),
+		RefreshTokens: NewRefreshTokenRepository(db),
+		Tx:            NewTransactor(db.Client(), allowStandalone),
+	}
+}
+
+// mongoError traduce los errores de MongoDB a los errores del dominio:
+// mongo.ErrNoDocuments a domain.ErrNotFound y las claves duplicadas a
+// domain.ErrConflict.
+func mongoError(err error) error {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return domain.ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return &domain.Error{Kind: domain.KindConflict, Code: string(domain.KindConflict), Message: domain.ErrConflict.Message, Err: err}
+	}
+	return err
+}
+
+// deleteMany elimina los documentos que cumplen filter y retorna cuántos se eliminaron.
+func deleteMany(ctx context.Context, coll *mongo.Collection, filter bson.M, what string) (int64, error) {
+	res, err := coll.DeleteMany(ctx, filter)
+	if err != nil {
+		log.Printf("Error al eliminar %s: %v", what, err)
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
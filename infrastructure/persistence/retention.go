@@ -0,0 +1,67 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Códigos de error de MongoDB al crear un índice que ya existe con otras opciones.
+const (
+	indexOptionsConflict  = 85
+	indexKeySpecsConflict = 86
+)
+
+// EnsureRetention crea los índices TTL que eliminan las localizaciones con más
+// de raw de antigüedad y los resúmenes por minuto con más de rollups. Si los
+// índices ya existen con otra duración la actualiza.
+func EnsureRetention(ctx context.Context, db *mongo.Database, raw, rollups time.Duration) error {
+	if err := ensureTTL(ctx, db, busLocationsCollection, "created_at", raw); err != nil {
+		return err
+	}
+	return ensureTTL(ctx, db, rollupsCollection, "minuto", rollups)
+}
+
+// ensureTTL crea un índice TTL sobre field o, si ya hay un índice sobre ese
+// campo (con o sin TTL), le asigna la duración con collMod.
+func ensureTTL(ctx context.Context, db *mongo.Database, coll, field string, ttl time.Duration) error {
+	seconds := int32(ttl.Seconds())
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	}
+	_, err := db.Collection(coll).Indexes().CreateOne(ctx, model)
+	if err == nil {
+		return nil
+	}
+	var cmdErr mongo.CommandError
+	if !asCommandError(err, &cmdErr) || (cmdErr.Code != indexOptionsConflict && cmdErr.Code != indexKeySpecsConflict) {
+		return fmt.Errorf("índice TTL de %s: %w", coll, err)
+	}
+
+	cmd := bson.D{
+		{Key: "collMod", Value: coll},
+		{Key: "index", Value: bson.D{
+			{Key: "keyPattern", Value: bson.D{{Key: field, Value: 1}}},
+			{Key: "expireAfterSeconds", Value: seconds},
+		}},
+	}
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("actualizando el índice TTL de %s: %w", coll, err)
+	}
+	log.Printf("Retención de %s actualizada a %s", coll, ttl)
+	return nil
+}
+
+func asCommandError(err error, target *mongo.CommandError) bool {
+	ce, ok := err.(mongo.CommandError)
+	if ok {
+		*target = ce
+	}
+	return ok
+}
@@ -0,0 +1,129 @@
+package persistence
+
+import (
+	"context"
+	"log"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RoleRepository implementa domain.RoleRepository sobre la colección "roles".
+type RoleRepository struct {
+	coll *mongo.Collection
+}
+
+// NewRoleRepository crea un RoleRepository.
+func NewRoleRepository(db *mongo.Database) *RoleRepository {
+	return &RoleRepository{coll: db.Collection(rolesCollection)}
+}
+
+// Create inserta un nuevo rol en la colección "roles".
+func (r *RoleRepository) Create(ctx context.Context, role *domain.Role) error {
+	role.ID = primitive.NewObjectID()
+
+	_, err := r.coll.InsertOne(ctx, role)
+	if err != nil {
+		log.Println("Error al insertar rol:", err)
+		return mongoError(err)
+	}
+	return nil
+}
+
+// Update actualiza los campos no vacíos de un Role existente y devuelve el documento actualizado.
+func (r *RoleRepository) Update(ctx context.Context, role *domain.Role) (*domain.Role, error) {
+	updateFields := bson.M{}
+	if role.Nombre != "" {
+		updateFields["nombre"] = role.Nombre
+	}
+	if role.Descripcion != "" {
+		updateFields["descripcion"] = role.Descripcion
+	}
+	if role.Permisos != nil {
+		updateFields["permisos"] = role.Permisos
+	}
+
+	// Si no hay campos para actualizar, devolvemos el documento existente
+	if len(updateFields) == 0 {
+		var existing domain.Role
+		if err := r.coll.FindOne(ctx, bson.M{"_id": role.ID}).Decode(&existing); err != nil {
+			log.Println("Rol no encontrado:", err)
+			return nil, mongoError(err)
+		}
+		return &existing, nil
+	}
+
+	filter := bson.M{"_id": role.ID}
+	update := bson.M{"$set": updateFields}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated domain.Role
+	if err := r.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		if err == mongo.ErrNoDocuments {
+			log.Println("Rol no encontrado")
+		} else {
+			log.Println("Error al editar rol:", err)
+		}
+		return nil, mongoError(err)
+	}
+
+	return &updated, nil
+}
+
+// List retorna una página de los roles de la colección "roles".
+func (r *RoleRepository) List(ctx context.Context, q domain.ListQuery) (*domain.Page[domain.Role], error) {
+	return findPage[domain.Role](ctx, r.coll, bson.M{}, q, "roles")
+}
+
+// GetByID busca un rol por su ObjectID.
+func (r *RoleRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*domain.Role, error) {
+	var role domain.Role
+	if err := r.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&role); err != nil {
+		log.Println("Rol no encontrado:", err)
+		return nil, mongoError(err)
+	}
+	return &role, nil
+}
+
+// GetByName retorna todos los roles cuyo nombre coincide exactamente.
+func (r *RoleRepository) GetByName(ctx context.Context, nombre string) ([]domain.Role, error) {
+	cursor, err := r.coll.Find(ctx, bson.M{"nombre": nombre})
+	if err != nil {
+		log.Println("Error al buscar roles por nombre:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []domain.Role
+	for cursor.Next(ctx) {
+		var role domain.Role
+		if err := cursor.Decode(&role); err != nil {
+			log.Println("Error al decodificar rol:", err)
+			continue
+		}
+		out = append(out, role)
+	}
+	if err := cursor.Err(); err != nil {
+		log.Println("Cursor error en búsqueda de roles:", err)
+		return nil, err
+	}
+	return out, nil
+}
+
+// Delete elimina un rol por su ObjectID.
+// Retorna domain.ErrNotFound si no existe.
+func (r *RoleRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		log.Println("Error al eliminar rol:", err)
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
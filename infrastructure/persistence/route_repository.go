@@ -0,0 +1,151 @@
+package persistence
+
+import (
+	"context"
+	"log"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RouteRepository implementa domain.RouteRepository sobre la colección "ruta".
+type RouteRepository struct {
+	coll *mongo.Collection
+}
+
+// NewRouteRepository crea un RouteRepository.
+func NewRouteRepository(db *mongo.Database) *RouteRepository {
+	return &RouteRepository{coll: db.Collection(routesCollection)}
+}
+
+// Create inserta una nueva ruta en la colección "ruta".
+func (r *RouteRepository) Create(ctx context.Context, route *domain.Route) error {
+	route.ID = primitive.NewObjectID()
+
+	_, err := r.coll.InsertOne(ctx, route)
+	if err != nil {
+		log.Println("Error al insertar ruta:", err)
+		return mongoError(err)
+	}
+	return nil
+}
+
+// Update actualiza los campos no vacíos de una Route existente dentro del
+// Scope y devuelve el documento actualizado.
+func (r *RouteRepository) Update(ctx context.Context, scope domain.Scope, route *domain.Route) (*domain.Route, error) {
+	updateFields := bson.M{}
+	if route.Nombre != "" {
+		updateFields["nombre"] = route.Nombre
+	}
+	if route.Descripcion != "" {
+		updateFields["descripcion"] = route.Descripcion
+	}
+	if route.ModoTransporte != "" {
+		updateFields["modo_transporte"] = route.ModoTransporte
+	}
+	if route.Origen != (domain.Location{}) {
+		updateFields["origen"] = route.Origen
+	}
+	if route.Destino != (domain.Location{}) {
+		updateFields["destino"] = route.Destino
+	}
+	if len(route.Waypoints) > 0 {
+		updateFields["waypoints"] = route.Waypoints
+	}
+	if !route.CompaniaID.IsZero() {
+		updateFields["compania"] = route.CompaniaID
+	}
+
+	if len(updateFields) == 0 {
+		var existing domain.Route
+		if err := r.coll.FindOne(ctx, scope.Filter(bson.M{"_id": route.ID}, "compania")).Decode(&existing); err != nil {
+			log.Println("Ruta no encontrada:", err)
+			return nil, mongoError(err)
+		}
+		return &existing, nil
+	}
+
+	filter := scope.Filter(bson.M{"_id": route.ID}, "compania")
+	update := bson.M{"$set": updateFields}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated domain.Route
+	if err := r.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		if err == mongo.ErrNoDocuments {
+			log.Println("Ruta no encontrada")
+		} else {
+			log.Println("Error al editar ruta:", err)
+		}
+		return nil, mongoError(err)
+	}
+
+	return &updated, nil
+}
+
+// List retorna una página de las rutas de la colección "ruta" visibles en el Scope.
+func (r *RouteRepository) List(ctx context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.Route], error) {
+	return findPage[domain.Route](ctx, r.coll, scope.Filter(bson.M{}, "compania"), q, "rutas")
+}
+
+// GetByName retorna las rutas del Scope cuyo nombre coincide exactamente.
+func (r *RouteRepository) GetByName(ctx context.Context, scope domain.Scope, nombre string) ([]domain.Route, error) {
+	// Filtramos por nombre exacto; si quieres búsqueda parcial o case-insensitive,
+	// podrías usar un regex en lugar de igualdad.
+	filter := scope.Filter(bson.M{"nombre": nombre}, "compania")
+
+	cursor, err := r.coll.Find(ctx, filter)
+	if err != nil {
+		log.Println("Error al buscar rutas por nombre:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var routes []domain.Route
+	for cursor.Next(ctx) {
+		var route domain.Route
+		if err := cursor.Decode(&route); err != nil {
+			log.Println("Error al decodificar ruta:", err)
+			continue
+		}
+		routes = append(routes, route)
+	}
+	if err := cursor.Err(); err != nil {
+		log.Println("Cursor error al buscar rutas:", err)
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+// GetByID busca una ruta por su ObjectID dentro del Scope.
+func (r *RouteRepository) GetByID(ctx context.Context, scope domain.Scope, id primitive.ObjectID) (*domain.Route, error) {
+	var route domain.Route
+	if err := r.coll.FindOne(ctx, scope.Filter(bson.M{"_id": id}, "compania")).Decode(&route); err != nil {
+		log.Println("Ruta no encontrada:", err)
+		return nil, mongoError(err)
+	}
+	return &route, nil
+}
+
+// Delete elimina una ruta por su ObjectID dentro del Scope.
+// Retorna domain.ErrNotFound si no existe o pertenece a otra compañía.
+func (r *RouteRepository) Delete(ctx context.Context, scope domain.Scope, id primitive.ObjectID) error {
+	res, err := r.coll.DeleteOne(ctx, scope.Filter(bson.M{"_id": id}, "compania"))
+	if err != nil {
+		log.Println("Error al eliminar ruta:", err)
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteByCompania elimina todas las rutas de la compañía indicada.
+func (r *RouteRepository) DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return deleteMany(ctx, r.coll, bson.M{"compania": companiaID}, "rutas")
+}
@@ -0,0 +1,74 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// illegalOperationCode es el código que retorna un servidor MongoDB
+// standalone al intentar abrir una transacción.
+const illegalOperationCode = 20
+
+// ErrTransactionsUnsupported se retorna al abrir una transacción en un
+// servidor standalone sin haber permitido ejecutar sin ella.
+var ErrTransactionsUnsupported = errors.New("MongoDB no admite transacciones (¿servidor standalone?)")
+
+// Transactor implementa domain.Transactor con sesiones de MongoDB.
+// Las transacciones requieren un replica set (o un clúster de Atlas). En un
+// servidor standalone fallan con ErrTransactionsUnsupported, salvo que se
+// cree con allowStandalone, en cuyo caso las operaciones se ejecutan sin
+// transacción y sin garantías de atomicidad.
+type Transactor struct {
+	client          *mongo.Client
+	allowStandalone bool
+}
+
+// NewTransactor crea un Transactor sobre el cliente indicado.
+func NewTransactor(client *mongo.Client, allowStandalone bool) *Transactor {
+	return &Transactor{client: client, allowStandalone: allowStandalone}
+}
+
+// WithTransaction ejecuta fn dentro de una transacción. Si fn retorna un
+// error la transacción se aborta y se retorna ese error.
+func (t *Transactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	sess, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(ctx)
+
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		return nil, fn(sc)
+	})
+	if transactionsUnsupported(err) {
+		if !t.allowStandalone {
+			return fmt.Errorf("%w: %v", ErrTransactionsUnsupported, err)
+		}
+		log.Println("MongoDB no admite transacciones (¿servidor standalone?); se ejecuta sin transacción")
+		return fn(ctx)
+	}
+	return err
+}
+
+// SupportsTransactions indica si el servidor admite transacciones: los
+// miembros de un replica set y los mongos de un clúster fragmentado.
+func SupportsTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+func transactionsUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == illegalOperationCode
+}
@@ -0,0 +1,184 @@
+package persistence
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"UbicaBus/UbicaBusBackend/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserRepository implementa domain.UserRepository sobre la colección "usuarios".
+type UserRepository struct {
+	coll *mongo.Collection
+}
+
+// NewUserRepository crea un UserRepository.
+func NewUserRepository(db *mongo.Database) *UserRepository {
+	return &UserRepository{coll: db.Collection(usersCollection)}
+}
+
+// Create inserta un nuevo usuario en la colección "usuarios"
+func (r *UserRepository) Create(ctx context.Context, user *domain.User) error {
+	user.ID = primitive.NewObjectID()
+	user.CreatedAt = time.Now()
+
+	_, err := r.coll.InsertOne(ctx, user)
+	if err != nil {
+		log.Println("Error al insertar usuario:", err)
+		return mongoError(err)
+	}
+	return nil
+}
+
+// Update actualiza los campos no vacíos de un usuario existente dentro del Scope.
+func (r *UserRepository) Update(ctx context.Context, scope domain.Scope, user *domain.User) (*domain.User, error) {
+	// Esto es un set { "Key": value }
+	updateFields := bson.M{}
+
+	if user.Nombre != "" {
+		updateFields["nombre"] = user.Nombre
+	}
+	if user.Password != "" {
+		updateFields["password"] = user.Password
+	}
+	if !user.RolID.IsZero() {
+		updateFields["rol"] = user.RolID
+	}
+	if !user.Compania.IsZero() {
+		updateFields["compania"] = user.Compania
+	}
+
+	if len(updateFields) == 0 {
+		var existing domain.User
+		if err := r.coll.FindOne(ctx, scope.Filter(bson.M{"_id": user.ID}, "compania")).Decode(&existing); err != nil {
+			log.Println("Not Found User", err)
+			return nil, mongoError(err)
+		}
+		return &existing, nil
+	}
+
+	filter := scope.Filter(bson.M{"_id": user.ID}, "compania")
+	update := bson.M{"$set": updateFields}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated domain.User
+	if err := r.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		if err == mongo.ErrNoDocuments {
+			log.Println("User not found")
+		} else {
+			log.Println("Error al editar usuario:", err)
+		}
+		return nil, mongoError(err)
+	}
+
+	return &updated, nil
+}
+
+// UpdatePassword reemplaza el hash de contraseña de un usuario.
+// Se usa para migrar hashes antiguos tras un login correcto.
+func (r *UserRepository) UpdatePassword(ctx context.Context, id primitive.ObjectID, hash string) error {
+	if _, err := r.coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"password": hash}}); err != nil {
+		log.Println("Error al actualizar contraseña:", err)
+		return err
+	}
+	return nil
+}
+
+// GetByNombre busca un usuario por su nombre exacto.
+func (r *UserRepository) GetByNombre(ctx context.Context, nombre string) (*domain.User, error) {
+	var u domain.User
+	if err := r.coll.FindOne(ctx, bson.M{"nombre": nombre}).Decode(&u); err != nil {
+		if err == mongo.ErrNoDocuments {
+			log.Println("Usuario no encontrado:", nombre)
+		}
+		return nil, mongoError(err)
+	}
+	return &u, nil
+}
+
+// GetByID busca un usuario por su ObjectID.
+func (r *UserRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*domain.User, error) {
+	var u domain.User
+	if err := r.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&u); err != nil {
+		if err == mongo.ErrNoDocuments {
+			log.Println("Usuario no encontrado:", err)
+		}
+		return nil, mongoError(err)
+	}
+	return &u, nil
+}
+
+// List retorna una página de los usuarios visibles en el Scope.
+func (r *UserRepository) List(ctx context.Context, scope domain.Scope, q domain.ListQuery) (*domain.Page[domain.User], error) {
+	return findPage[domain.User](ctx, r.coll, scope.Filter(bson.M{}, "compania"), q, "usuarios")
+}
+
+// Search retorna los usuarios del Scope que cumplen todos los criterios del filtro.
+func (r *UserRepository) Search(ctx context.Context, scope domain.Scope, f domain.UserFilter) ([]domain.User, error) {
+	filter := bson.M{}
+	if f.Nombre != "" {
+		filter["nombre"] = f.Nombre
+	}
+	if !f.RolID.IsZero() {
+		filter["rol"] = f.RolID
+	}
+	if !f.CompaniaID.IsZero() {
+		filter["compania"] = f.CompaniaID
+	}
+	return r.find(ctx, scope.Filter(filter, "compania"))
+}
+
+// Delete elimina un usuario por su ObjectID dentro del Scope.
+// Retorna domain.ErrNotFound si no existe o pertenece a otra compañía.
+func (r *UserRepository) Delete(ctx context.Context, scope domain.Scope, id primitive.ObjectID) error {
+	res, err := r.coll.DeleteOne(ctx, scope.Filter(bson.M{"_id": id}, "compania"))
+	if err != nil {
+		log.Println("Error al eliminar usuario:", err)
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) find(ctx context.Context, filter bson.M) ([]domain.User, error) {
+	cursor, err := r.coll.Find(ctx, filter)
+	if err != nil {
+		log.Println("Error al obtener usuarios:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	out := make([]domain.User, 0)
+	for cursor.Next(ctx) {
+		var u domain.User
+		if err := cursor.Decode(&u); err != nil {
+			log.Println("Error al decodificar usuario:", err)
+			continue
+		}
+		out = append(out, u)
+	}
+	if err := cursor.Err(); err != nil {
+		log.Println("Cursor error en usuarios:", err)
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeleteByRol elimina todos los usuarios con el rol indicado.
+func (r *UserRepository) DeleteByRol(ctx context.Context, rolID primitive.ObjectID) (int64, error) {
+	return deleteMany(ctx, r.coll, bson.M{"rol": rolID}, "usuarios")
+}
+
+// DeleteByCompania elimina todos los usuarios de la compañía indicada.
+func (r *UserRepository) DeleteByCompania(ctx context.Context, companiaID primitive.ObjectID) (int64, error) {
+	return deleteMany(ctx, r.coll, bson.M{"compania": companiaID}, "usuarios")
+}